@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes reported by 'concierge doctor' for the worst outcome of its checks. A
+// failed check is not reported with 1, which is the exit code of any other error, such
+// that scripts can tell an unhealthy machine from concierge failing to check it.
+const (
+	doctorExitPassed      = 0
+	doctorExitWarned      = 2
+	doctorExitNotPrepared = 3
+	doctorExitFailed      = 4
+)
+
+// doctorCmd checks the health of what concierge provisioned on a machine.
+func doctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the health of what `concierge` provisioned on the machine.",
+		Long: `Check the health of what 'concierge' provisioned on the machine.
+
+Runs a suite of checks for each provider and Juju controller prepared by the most recent
+'prepare', such as whether LXD's socket is still open to the user, whether K8s or
+MicroK8s is ready, whether the user's kubeconfig file reaches the cluster, whether the
+user is in the provider's group in their current session, and whether each controller
+and its 'testing' model respond. Each check passes, warns or fails, and those that do
+not pass are reported with how to fix them.
+
+The exit code reflects the worst outcome: 0 if every check passed, 2 if any warned,
+3 if concierge has not prepared the machine and 4 if any check failed. Other errors
+exit with 1.
+		`,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+			return checkUser()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// pflag's Get* methods only return an error for unregistered flag
+			// names; "format" is registered below, so the error is unreachable.
+			format, _ := flags.GetString("format")
+
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unsupported output format '%s'", format)
+			}
+
+			conf, err := config.NewConfig(cmd, flags)
+			if err != nil {
+				return fmt.Errorf("failed to configure concierge: %w", err)
+			}
+
+			mgr, err := concierge.NewManager(conf)
+			if err != nil {
+				return err
+			}
+
+			checks, err := mgr.Doctor()
+			if concierge.ErrNotPrepared(err) {
+				return &exitError{code: doctorExitNotPrepared, err: err}
+			} else if err != nil {
+				return err
+			}
+
+			err = printChecks(os.Stdout, checks, format)
+			if err != nil {
+				return err
+			}
+
+			if code := doctorExitCode(checks); code != doctorExitPassed {
+				return &exitError{code: code}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().String("format", "text", "output format (text | json)")
+
+	return cmd
+}
+
+// doctorExitCode returns the exit code for the worst outcome of the checks.
+func doctorExitCode(checks []system.Check) int {
+	code := doctorExitPassed
+	for _, c := range checks {
+		switch c.Status {
+		case system.CheckFail:
+			return doctorExitFailed
+		case system.CheckWarn:
+			code = doctorExitWarned
+		}
+	}
+	return code
+}
+
+// printChecks writes the outcome of each check, followed by how to fix those that did
+// not pass.
+func printChecks(w io.Writer, checks []system.Check, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string][]system.Check{"checks": checks})
+	}
+
+	if len(checks) == 0 {
+		_, err := fmt.Fprintln(w, "Nothing to check.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "COMPONENT\tCHECK\tSTATUS\tDETAIL")
+	for _, c := range checks {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Component, c.Name, c.Status, c.Detail)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	remedies := []system.Check{}
+	for _, c := range checks {
+		if c.Status != system.CheckPass && c.Remedy != "" {
+			remedies = append(remedies, c)
+		}
+	}
+	if len(remedies) == 0 {
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "To fix the checks that did not pass:")
+	for _, c := range remedies {
+		_, _ = fmt.Fprintf(w, "  %s/%s: %s\n", c.Component, c.Name, c.Remedy)
+	}
+	return nil
+}
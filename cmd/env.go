@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// envCmd describes the environment provisioned by 'concierge prepare'.
+func envCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "env",
+		Short: "Describe the environment provisioned by `concierge prepare`.",
+		Long: `Describe the environment provisioned by the most recent successful 'prepare'.
+
+Prints the name of each Juju controller and its 'testing' model, the paths of the
+kubeconfig file and of Juju's data directory, and the version of each snap installed,
+such that test harnesses need not re-derive concierge's naming conventions.
+
+The default format is shell export statements, which can be loaded with:
+
+    eval "$(sudo concierge env)"
+
+Use '--format dotenv' for a .env file, or '--format json' for a JSON document.
+		`,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+			return checkUser()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// pflag's Get* methods only return an error for unregistered flag
+			// names; "format" is registered below, so the error is unreachable.
+			format, _ := flags.GetString("format")
+
+			if !slices.Contains(concierge.EnvFormats, format) {
+				return fmt.Errorf("unsupported output format '%s'", format)
+			}
+
+			conf, err := config.NewConfig(cmd, flags)
+			if err != nil {
+				return fmt.Errorf("failed to configure concierge: %w", err)
+			}
+
+			mgr, err := concierge.NewManager(conf)
+			if err != nil {
+				return err
+			}
+
+			env, err := mgr.Environment()
+			if err != nil {
+				return err
+			}
+
+			content, err := env.Format(format)
+			if err != nil {
+				return err
+			}
+
+			_, err = os.Stdout.Write(content)
+			return err
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.String("format", "shell", "output format ("+strings.Join(concierge.EnvFormats, " | ")+")")
+
+	return cmd
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/state"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd lists the 'prepare' and 'restore' runs recorded on a machine.
+func historyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history [run-id]",
+		Short: "List the `concierge` runs recorded on the machine.",
+		Long: `List the 'prepare' and 'restore' runs recorded on the machine.
+
+Each run records who ran it, a hash of the effective configuration, how long it took,
+its outcome and the result of each step. Pass the ID of a run to show it in detail.
+
+The most recent 100 runs are kept in /var/lib/concierge/history.yaml.
+		`,
+		Args:          cobra.MaximumNArgs(1),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+			return checkUser()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// pflag's Get* methods only return an error for unregistered flag
+			// names; "format" is registered below, so the error is unreachable.
+			format, _ := flags.GetString("format")
+
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unsupported output format '%s'", format)
+			}
+
+			conf, err := config.NewConfig(cmd, flags)
+			if err != nil {
+				return fmt.Errorf("failed to configure concierge: %w", err)
+			}
+
+			mgr, err := concierge.NewManager(conf)
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 1 {
+				id, err := strconv.Atoi(args[0])
+				if err != nil {
+					return fmt.Errorf("invalid run ID '%s'", args[0])
+				}
+
+				run, err := mgr.Run(id)
+				if errors.Is(err, state.ErrRunNotFound) {
+					return fmt.Errorf("no run with ID %d in history", id)
+				} else if err != nil {
+					return err
+				}
+
+				return printRun(os.Stdout, run, format)
+			}
+
+			runs, err := mgr.History()
+			if err != nil {
+				return err
+			}
+
+			return printHistory(os.Stdout, runs, format)
+		},
+	}
+
+	cmd.Flags().String("format", "text", "output format (text | json)")
+
+	return cmd
+}
+
+// printHistory writes a summary of each run, oldest first.
+func printHistory(w io.Writer, runs []*state.Run, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string][]*state.Run{"runs": runs})
+	}
+
+	if len(runs) == 0 {
+		_, err := fmt.Fprintln(w, "No runs recorded.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tACTION\tUSER\tOUTCOME\tSTARTED\tDURATION\tCONFIG")
+	for _, r := range runs {
+		_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.ID, r.Action, r.User, r.Outcome, formatTime(r.Started), r.Duration().Round(time.Second), shortHash(r.ConfigHash))
+	}
+	return tw.Flush()
+}
+
+// printRun writes the details of a single run, including each of its steps.
+func printRun(w io.Writer, run *state.Run, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(run)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "ID:\t%d\n", run.ID)
+	_, _ = fmt.Fprintf(tw, "Action:\t%s\n", run.Action)
+	_, _ = fmt.Fprintf(tw, "User:\t%s (ran as %s)\n", run.User, run.RanAs)
+	_, _ = fmt.Fprintf(tw, "Outcome:\t%s\n", run.Outcome)
+	_, _ = fmt.Fprintf(tw, "Version:\t%s\n", valueOrDash(run.ConciergeVersion))
+	_, _ = fmt.Fprintf(tw, "Config:\t%s\n", valueOrDash(run.ConfigSource))
+	_, _ = fmt.Fprintf(tw, "Config hash:\t%s\n", valueOrDash(run.ConfigHash))
+	_, _ = fmt.Fprintf(tw, "Started:\t%s\n", formatTime(run.Started))
+	_, _ = fmt.Fprintf(tw, "Finished:\t%s\n", formatTime(run.Finished))
+	_, _ = fmt.Fprintf(tw, "Duration:\t%s\n", run.Duration().Round(time.Second))
+	if run.Error != "" {
+		_, _ = fmt.Fprintf(tw, "Error:\t%s\n", run.Error)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(run.Steps) == 0 {
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "STEP\tSTATE\tSTARTED\tDURATION\tERROR")
+	for _, s := range run.Steps {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.State, formatTime(s.Started), stepDuration(s), valueOrDash(s.Error))
+	}
+	return tw.Flush()
+}
+
+// stepDuration returns how long a step took, or a dash if it did not finish.
+func stepDuration(c *state.Component) string {
+	if c.Finished.IsZero() {
+		return "-"
+	}
+	return c.Finished.Sub(c.Started).Round(time.Second).String()
+}
+
+// shortHash abbreviates a config hash for display.
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return valueOrDash(hash)
+}
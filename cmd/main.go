@@ -1,12 +1,24 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/runlog"
 	"github.com/canonical/concierge/internal/securitylog"
+	"github.com/canonical/concierge/internal/state"
+	"github.com/canonical/concierge/internal/system"
+	"github.com/canonical/concierge/internal/telemetry"
 	"github.com/spf13/pflag"
 )
 
@@ -23,16 +35,51 @@ func Execute() {
 	// audit stream stays separate from concierge's stderr; falls back to stderr
 	// if syslog is unreachable.
 	securitylog.ConfigureDefault(fmt.Sprintf("concierge@%s", version))
+	concierge.Version = version
+
+	// Trace runs to an OpenTelemetry collector if one is configured by the standard
+	// OTEL_EXPORTER_OTLP_* environment variables. Remaining spans are exported before
+	// exiting, including on failure.
+	shutdownTelemetry, err := telemetry.Setup(version)
+	if err != nil {
+		slog.Warn("Tracing disabled", "error", err.Error())
+		shutdownTelemetry = func() {}
+	}
 
 	cmd := rootCmd()
 
-	err := cmd.Execute()
+	err = cmd.Execute()
+	shutdownTelemetry()
 	if err != nil {
+		var exitErr *exitError
+		if errors.As(err, &exitErr) {
+			if exitErr.err != nil {
+				slog.Error("concierge failed", "error", exitErr.err.Error())
+			}
+			os.Exit(exitErr.code)
+		}
+
 		slog.Error("concierge failed", "error", err.Error())
 		os.Exit(1)
 	}
 }
 
+// exitError is returned by commands that need to exit with a specific code. If err is
+// nil, the command has already reported its outcome and nothing further is logged.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error { return e.err }
+
 func parseLoggingFlags(flags *pflag.FlagSet) {
 	// pflag's Get* methods only return an error for unregistered flag names;
 	// "verbose", "trace", and "dry-run" are all registered as persistent
@@ -40,6 +87,8 @@ func parseLoggingFlags(flags *pflag.FlagSet) {
 	verbose, _ := flags.GetBool("verbose")
 	trace, _ := flags.GetBool("trace")
 	dryRun, _ := flags.GetBool("dry-run")
+	// Only registered on commands that make changes; elsewhere it is left empty.
+	output, _ := flags.GetString("output")
 
 	// Determine log level: --verbose/--trace take precedence, then --dry-run defaults to error
 	level := slog.LevelInfo
@@ -50,7 +99,11 @@ func parseLoggingFlags(flags *pflag.FlagSet) {
 	}
 
 	// Setup the TextHandler and ensure our configured logger is the default.
-	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})
+	if output == "json" {
+		// Warnings are part of the event stream, whatever the log level.
+		h = progress.WarningHandler(h)
+	}
 	logger := slog.New(h)
 	slog.SetDefault(logger)
 }
@@ -67,3 +120,143 @@ func checkUser() error {
 
 	return nil
 }
+
+// addRecordFlag registers the hidden flag used to record a run's interactions with the
+// system into a cassette file, for replay in unit tests.
+func addRecordFlag(flags *pflag.FlagSet) {
+	flags.String("record", "", "record interactions with the system into the specified cassette file")
+	_ = flags.MarkHidden("record") // Only fails if the flag is not registered
+}
+
+// addOutputFlag registers the flag selecting the format of concierge's output on stdout.
+func addOutputFlag(flags *pflag.FlagSet) {
+	flags.String("output", "text", "format of the output on stdout (text | json); json emits a stream of progress events")
+}
+
+// addReportFlags registers the flags requesting a report of the run.
+func addReportFlags(flags *pflag.FlagSet) {
+	flags.String("report", "", "write a JSON report of each step of the run to the specified path")
+	flags.String("junit", "", "write a JUnit XML report, with each step as a testcase, to the specified path")
+	flags.String("metrics-textfile", "", "write metrics of the run to the specified path as a Prometheus textfile, for node_exporter")
+}
+
+// addEnvFileFlags registers the flags requesting a description of the provisioned
+// environment be written to a file.
+func addEnvFileFlags(flags *pflag.FlagSet) {
+	flags.String("env-file", "", "after a successful run, write the controllers, models, paths and tool versions provisioned to the specified path")
+	flags.String("env-format", "shell", "format of the environment file ("+strings.Join(concierge.EnvFormats, " | ")+")")
+}
+
+// startRunLog starts the full debug log of a run of the specified action, under the
+// state directory, unless this is a dry-run. The returned function stops the log and,
+// if the run failed, reports where the log can be found. It is safe to call more than
+// once; only the first call has any effect.
+func startRunLog(flags *pflag.FlagSet, action string) (stop func(runErr error)) {
+	// pflag's Get* methods only return an error for unregistered flag names; "dry-run"
+	// is registered on each command that makes changes.
+	dryRun, _ := flags.GetBool("dry-run")
+	if dryRun {
+		return func(error) {}
+	}
+
+	log, err := runlog.Open(filepath.Join(state.DefaultDir, "logs"), action)
+	if err != nil {
+		slog.Warn("Failed to start run log", "error", err.Error())
+		return func(error) {}
+	}
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(log.Handler(logger.Handler())))
+	slog.Debug("Run started", "version", version, "args", os.Args)
+
+	var once sync.Once
+	return func(runErr error) {
+		once.Do(func() { stopRunLog(log, logger, runErr) })
+	}
+}
+
+// stopRunLog records the outcome of the run, restores the previous logger and closes
+// the run log.
+func stopRunLog(log *runlog.Log, logger *slog.Logger, runErr error) {
+	if runErr != nil {
+		slog.Debug("Run failed", "error", runErr.Error())
+	} else {
+		slog.Debug("Run succeeded")
+	}
+
+	slog.SetDefault(logger)
+	if err := log.Close(); err != nil {
+		slog.Warn("Failed to close run log", "path", log.Path(), "error", err.Error())
+	}
+
+	if runErr != nil {
+		slog.Error("See the run log for details", "path", log.Path())
+	}
+}
+
+// startOutput checks the requested output format and starts writing progress to
+// stdout. For json, each progress event is written as a line of JSON. For text, a live
+// display of each step is drawn if stdout is a terminal, unless NO_COLOR is set or
+// logs, traces or a dry-run are requested, which are printed as they are instead.
+//
+// The returned function stops the output, and is safe to call more than once. While a
+// display is drawn, console is where anything else bound for stdout must be written;
+// otherwise it is nil.
+func startOutput(flags *pflag.FlagSet) (stop func(), console io.Writer, err error) {
+	// pflag's Get* methods only return an error for unregistered flag names; these
+	// are registered on each command that makes changes.
+	output, _ := flags.GetString("output")
+	verbose, _ := flags.GetBool("verbose")
+	trace, _ := flags.GetBool("trace")
+	dryRun, _ := flags.GetBool("dry-run")
+
+	switch output {
+	case "text":
+		if verbose || trace || dryRun || !system.Interactive() {
+			return func() {}, nil, nil
+		}
+
+		display := progress.NewDisplay(os.Stdout, system.TerminalWidth)
+		logger := slog.Default()
+		slog.SetDefault(slog.New(display.Handler(logger.Handler())))
+		stopDisplay := display.Start()
+
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				stopDisplay()
+				slog.SetDefault(logger)
+			})
+		}, display, nil
+	case "json":
+		return progress.WriteJSON(os.Stdout), nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported output '%s'", output)
+	}
+}
+
+// addDryRunFormatFlag registers the flag selecting the format of the dry-run output.
+func addDryRunFormatFlag(flags *pflag.FlagSet) {
+	flags.String("dry-run-format", "text", "format of the dry-run output (text | script)")
+}
+
+// checkDryRunFlags checks that the requested dry-run format is one of those supported,
+// and that the script format and offline mode are only used for a dry-run.
+func checkDryRunFlags(flags *pflag.FlagSet) error {
+	// pflag's Get* methods only return an error for unregistered flag names; these
+	// are registered on each command that makes changes.
+	format, _ := flags.GetString("dry-run-format")
+	dryRun, _ := flags.GetBool("dry-run")
+	offline, _ := flags.GetBool("offline")
+
+	if !slices.Contains([]string{"text", "script"}, format) {
+		return fmt.Errorf("unsupported dry-run format '%s'", format)
+	}
+	if format == "script" && !dryRun {
+		return fmt.Errorf("the script dry-run format can only be used with '--dry-run'")
+	}
+	if offline && !dryRun {
+		return fmt.Errorf("offline mode can only be used with '--dry-run'")
+	}
+	return nil
+}
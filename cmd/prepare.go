@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/canonical/concierge/internal/concierge"
@@ -37,24 +38,43 @@ More information at https://github.com/canonical/concierge.
 			parseLoggingFlags(cmd.Flags())
 			return checkUser()
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			flags := cmd.Flags()
 
 			// pflag's Get* methods only return an error for unregistered flag
-			// names; "config" and "preset" are both registered on this command
+			// names; "config", "preset" and "env-format" are registered on this command
 			// below, so the error is unreachable.
 			configFile, _ := flags.GetString("config")
 			preset, _ := flags.GetString("preset")
+			envFormat, _ := flags.GetString("env-format")
 
 			// Concierge cannot merge a preset & manual configuration
 			if len(preset) > 0 && len(configFile) > 0 {
 				return fmt.Errorf("cannot proceed with both preset and configuration file specified")
 			}
 
+			if err := checkDryRunFlags(flags); err != nil {
+				return err
+			}
+
+			if !slices.Contains(concierge.EnvFormats, envFormat) {
+				return fmt.Errorf("unsupported environment file format '%s'", envFormat)
+			}
+
+			stop, console, err := startOutput(flags)
+			if err != nil {
+				return err
+			}
+			defer stop()
+
+			stopRunLog := startRunLog(flags, concierge.PrepareAction)
+			defer func() { stopRunLog(err) }()
+
 			conf, err := config.NewConfig(cmd, flags)
 			if err != nil {
 				return fmt.Errorf("failed to configure concierge: %w", err)
 			}
+			conf.Console = console
 
 			mgr, err := concierge.NewManager(conf)
 			if err != nil {
@@ -94,6 +114,14 @@ More information at https://github.com/canonical/concierge.
 	)
 
 	flags.Bool("dry-run", false, "show what would be done without making changes")
+	addDryRunFormatFlag(flags)
+	flags.Bool("explain", false, "show the configuration, and where it came from, that caused each step")
+	flags.Bool("offline", false, "plan the dry-run for a fresh machine, without querying snapd or the snap store")
+	flags.Bool("skip-preflight", false, "skip the checks of the machine's resources, OS and conflicting services")
+	addOutputFlag(flags)
+	addReportFlags(flags)
+	addEnvFileFlags(flags)
+	addRecordFlag(flags)
 
 	return cmd
 }
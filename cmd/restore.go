@@ -1,8 +1,17 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"text/tabwriter"
+
 	"github.com/canonical/concierge/internal/concierge"
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +26,14 @@ If the machine already had a given snap or configuration
 prior to running 'prepare', this will not be taken into account during 'restore'.
 Running 'restore' is the literal opposite of 'prepare', so any packages,
 files or configuration that would normally be created during 'prepare' will be removed.
+
+Once complete, 'restore' checks the machine for anything concierge touched that still
+exists, and reports it. Use '--format json' for a machine-readable report.
+
+Group memberships and the firewall's FORWARD chain are recorded during 'prepare',
+and are put back exactly as they were. On machines prepared without such a record,
+'--thorough' removes the user from concierge's groups and reinstates Docker's
+firewall rules.
 		`,
 		SilenceErrors: true,
 		SilenceUsage:  true,
@@ -24,7 +41,7 @@ files or configuration that would normally be created during 'prepare' will be r
 			parseLoggingFlags(cmd.Flags())
 			return checkUser()
 		},
-		RunE: func(cmd *cobra.Command, args []string) error {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			flags := cmd.Flags()
 
 			// Restore uses the cached config from prepare, not a config file.
@@ -35,11 +52,48 @@ files or configuration that would normally be created during 'prepare' will be r
 			dryRun, _ := flags.GetBool("dry-run")
 			verbose, _ := flags.GetBool("verbose")
 			trace, _ := flags.GetBool("trace")
+			thorough, _ := flags.GetBool("thorough")
+			format, _ := flags.GetString("format")
+			dryRunFormat, _ := flags.GetString("dry-run-format")
+			record, _ := flags.GetString("record")
+
+			offline, _ := flags.GetBool("offline")
+			explain, _ := flags.GetBool("explain")
+			output, _ := flags.GetString("output")
+			reportPath, _ := flags.GetString("report")
+			junitPath, _ := flags.GetString("junit")
+			metricsPath, _ := flags.GetString("metrics-textfile")
+
+			if !slices.Contains([]string{"text", "json"}, format) {
+				return fmt.Errorf("unsupported output format '%s'", format)
+			}
+			if err := checkDryRunFlags(flags); err != nil {
+				return err
+			}
+
+			stop, console, err := startOutput(flags)
+			if err != nil {
+				return err
+			}
+			defer stop()
+
+			stopRunLog := startRunLog(flags, concierge.RestoreAction)
+			defer func() { stopRunLog(err) }()
 
 			conf := &config.Config{
-				DryRun:  dryRun,
-				Verbose: verbose,
-				Trace:   trace,
+				DryRun:       dryRun,
+				Verbose:      verbose,
+				Trace:        trace,
+				Thorough:     thorough,
+				Record:       record,
+				DryRunFormat: dryRunFormat,
+				Offline:      offline,
+				Explain:      explain,
+				Output:       output,
+				Console:      console,
+				Report:       reportPath,
+				JUnit:        junitPath,
+				Metrics:      metricsPath,
 			}
 
 			mgr, err := concierge.NewManager(conf)
@@ -47,7 +101,25 @@ files or configuration that would normally be created during 'prepare' will be r
 				return err
 			}
 
-			return mgr.Restore()
+			err = mgr.Restore()
+			stopRunLog(err)
+			stop()
+
+			// Report leftovers even if the restore failed part way through, since
+			// that is when they are most useful.
+			if mgr.Plan != nil && !dryRun {
+				// Stdout is reserved for the event stream when emitting JSON.
+				var w io.Writer = os.Stdout
+				if output == "json" {
+					w = os.Stderr
+				}
+				printErr := printLeftovers(w, mgr.Leftovers(), format)
+				if printErr != nil {
+					slog.Error("failed to report leftovers", "error", printErr.Error())
+				}
+			}
+
+			return err
 		},
 	}
 
@@ -55,6 +127,33 @@ files or configuration that would normally be created during 'prepare' will be r
 	flags.Bool("dry-run", false, "show what would be done without making changes")
 	flags.Bool("verbose", false, "enable verbose logging")
 	flags.Bool("trace", false, "enable trace logging")
+	flags.Bool("thorough", false, "revert firewall changes and group memberships even if no snapshot was recorded")
+	flags.String("format", "text", "format of the leftovers report (text | json)")
+	addDryRunFormatFlag(flags)
+	flags.Bool("explain", false, "show the configuration, and where it came from, that caused each step")
+	flags.Bool("offline", false, "plan the dry-run without querying snapd or the snap store")
+	addOutputFlag(flags)
+	addReportFlags(flags)
+	addRecordFlag(flags)
 
 	return cmd
 }
+
+// printLeftovers writes a report of the state left on the machine after 'restore'.
+func printLeftovers(w io.Writer, leftovers []system.Leftover, format string) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(map[string]any{"leftovers": leftovers})
+	}
+
+	if len(leftovers) == 0 {
+		_, err := fmt.Fprintln(w, "Restore complete, nothing left behind.")
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "The following state still exists on the machine:")
+	for _, l := range leftovers {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\t%s\n", l.Kind, l.Name, l.Detail)
+	}
+	return tw.Flush()
+}
@@ -34,6 +34,10 @@ tools from the [snap store](https://snapcraft.io) or the Ubuntu archive.
 	cmd.AddCommand(restoreCmd())
 	cmd.AddCommand(prepareCmd())
 	cmd.AddCommand(statusCmd())
+	cmd.AddCommand(historyCmd())
+	cmd.AddCommand(envCmd())
+	cmd.AddCommand(doctorCmd())
+	cmd.AddCommand(serveCmd())
 
 	return cmd
 }
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/server"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd serves the concierge API on a unix socket.
+func serveCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the `concierge` API on a unix socket.",
+		Long: `Serve the 'concierge' API on a unix socket, so that tools on a shared machine can
+query or trigger provisioning without each running as root.
+
+Like the LXD socket opened up by concierge, the socket is readable and writable by every
+user, and requests are authorised by the credentials of the process that made them.
+Anybody may read the status, plan and history, but only root and members of the group
+specified with '--group' may prepare or restore the machine. Since they can, membership
+of the group is equivalent to root access, as it is for the 'lxd' group.
+
+Runs prepare the configuration given to 'serve' with '-c' or '--preset', unless the
+request specifies its own, and stream their progress as newline-delimited JSON events.
+
+More information at https://github.com/canonical/concierge.
+		`,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			parseLoggingFlags(cmd.Flags())
+			return checkUser()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flags := cmd.Flags()
+
+			// pflag's Get* methods only return an error for unregistered flag
+			// names; all of these are registered below, so the error is unreachable.
+			configFile, _ := flags.GetString("config")
+			preset, _ := flags.GetString("preset")
+			socket, _ := flags.GetString("socket")
+			group, _ := flags.GetString("group")
+
+			if len(preset) > 0 && len(configFile) > 0 {
+				return fmt.Errorf("cannot proceed with both preset and configuration file specified")
+			}
+
+			conf, err := config.NewConfig(cmd, flags)
+			if err != nil {
+				return fmt.Errorf("failed to configure concierge: %w", err)
+			}
+
+			// Warnings are part of the event stream of each run.
+			slog.SetDefault(slog.New(progress.WarningHandler(slog.Default().Handler())))
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return server.NewServer(conf, server.WithGroup(group)).Serve(ctx, socket)
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringP("config", "c", "", "path to a specific config file to prepare")
+	flags.StringP("preset", "p", "", "config preset to prepare")
+	flags.String("socket", server.DefaultSocket, "path of the unix socket to serve the API on")
+	flags.String("group", server.DefaultGroup, "group whose members may prepare or restore the machine")
+
+	return cmd
+}
@@ -1,21 +1,49 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/canonical/concierge/internal/concierge"
 	"github.com/canonical/concierge/internal/config"
 	"github.com/spf13/cobra"
 )
 
+// Exit codes reported by 'concierge status' for each overall state. Exit code 1 is
+// left for errors which prevent the status being determined at all.
+const (
+	statusExitSucceeded    = 0
+	statusExitProvisioning = 2
+	statusExitNotPrepared  = 3
+	statusExitFailed       = 4
+)
+
 // statusCmd reports the status of concierge provisioning on a machine.
 func statusCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "status",
 		Short: "Report the status of `concierge` on the machine.",
 		Long: `Report the status of 'concierge' on the machine.
 
-Reports one of 'provisioning', 'succeeded' or 'failed'.
+Reports one of 'provisioning', 'succeeded' or 'failed'. Use '--format long' to also
+report each snap, deb, provider and Juju controller, with the time at which it started
+and finished and any error, alongside the concierge version and config source used.
+'--format json' reports the same as a JSON document.
+
+The exit code reflects the overall state: 0 for succeeded, 2 for provisioning, 3 if
+concierge has not prepared the machine and 4 for failed. If the status cannot be
+determined, the exit code is 1.
+
+Use '--wait' to block until an in-progress 'prepare' finishes, including one which
+has not yet started, such as when concierge is run by cloud-init.
+
+State is recorded system-wide in /var/lib/concierge, per user that concierge ran on
+behalf of. If concierge has not run for the current user, the status of its most
+recent run for any user is reported. Use '--all-users' to list every user.
 		`,
 		SilenceErrors: true,
 		SilenceUsage:  true,
@@ -26,6 +54,17 @@ Reports one of 'provisioning', 'succeeded' or 'failed'.
 		RunE: func(cmd *cobra.Command, args []string) error {
 			flags := cmd.Flags()
 
+			// pflag's Get* methods only return an error for unregistered flag
+			// names; all of these are registered below, so the error is unreachable.
+			allUsers, _ := flags.GetBool("all-users")
+			format, _ := flags.GetString("format")
+			wait, _ := flags.GetBool("wait")
+			timeout, _ := flags.GetDuration("timeout")
+
+			if format != "short" && format != "long" && format != "json" {
+				return fmt.Errorf("unsupported output format '%s', must be one of 'short', 'long' or 'json'", format)
+			}
+
 			conf, err := config.NewConfig(cmd, flags)
 			if err != nil {
 				return fmt.Errorf("failed to configure concierge: %w", err)
@@ -36,13 +75,124 @@ Reports one of 'provisioning', 'succeeded' or 'failed'.
 				return err
 			}
 
-			status, err := mgr.Status()
+			if allUsers {
+				reports, err := mgr.StatusAll()
+				if err != nil {
+					return err
+				}
+
+				if len(reports) == 0 {
+					return &exitError{code: statusExitNotPrepared, err: fmt.Errorf("concierge has not prepared this machine and cannot report its status")}
+				}
+
+				return printStatusAll(os.Stdout, reports, format)
+			}
+
+			var report *concierge.StatusReport
+			if wait {
+				report, err = mgr.WaitStatus(timeout)
+			} else {
+				report, err = mgr.Status()
+			}
+			if err != nil {
+				if concierge.ErrNotPrepared(err) {
+					return &exitError{code: statusExitNotPrepared, err: err}
+				}
+				return err
+			}
+
+			err = printStatus(os.Stdout, report, format)
 			if err != nil {
 				return err
 			}
 
-			fmt.Printf("%s\n", status)
+			switch report.Status {
+			case config.Failed:
+				return &exitError{code: statusExitFailed}
+			case config.Provisioning:
+				return &exitError{code: statusExitProvisioning}
+			}
 			return nil
 		},
 	}
+
+	flags := cmd.Flags()
+	flags.Bool("all-users", false, "list the status for every user concierge has prepared the machine for")
+	flags.String("format", "short", "output format: 'short', 'long' or 'json'")
+	flags.Bool("wait", false, "wait for an in-progress 'prepare' to finish before reporting")
+	flags.Duration("timeout", 0, "maximum time to wait with '--wait'; zero waits indefinitely")
+
+	return cmd
+}
+
+// printStatus writes a status report in the specified format.
+func printStatus(w io.Writer, report *concierge.StatusReport, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "short":
+		_, err := fmt.Fprintf(w, "%s\n", report.Status)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Status:\t%s\n", report.Status)
+	_, _ = fmt.Fprintf(tw, "User:\t%s\n", report.User)
+	_, _ = fmt.Fprintf(tw, "Version:\t%s\n", valueOrDash(report.ConciergeVersion))
+	_, _ = fmt.Fprintf(tw, "Config:\t%s\n", valueOrDash(report.ConfigSource))
+	_, _ = fmt.Fprintf(tw, "Started:\t%s\n", formatTime(report.Started))
+	_, _ = fmt.Fprintf(tw, "Finished:\t%s\n", formatTime(report.Finished))
+	if report.Error != "" {
+		_, _ = fmt.Fprintf(tw, "Error:\t%s\n", report.Error)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(report.Components) == 0 {
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "COMPONENT\tSTATE\tSTARTED\tDURATION\tERROR")
+	for _, c := range report.Components {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.ID, c.State, formatTime(c.Started), stepDuration(c), valueOrDash(c.Error))
+	}
+	return tw.Flush()
+}
+
+// printStatusAll writes the status reports for all users in the specified format.
+func printStatusAll(w io.Writer, reports []*concierge.StatusReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string][]*concierge.StatusReport{"users": reports})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "USER\tSTATUS\tUPDATED")
+	for _, r := range reports {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", r.User, r.Status, formatTime(r.Updated))
+	}
+	return tw.Flush()
+}
+
+// formatTime formats a timestamp for display, or a dash if it is unset.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// valueOrDash returns the value, or a dash if it is empty.
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
@@ -0,0 +1,115 @@
+package concierge
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+// PlanDescription describes what a plan installs and configures, such that it can be
+// inspected without changing the machine.
+type PlanDescription struct {
+	// Snaps holds every snap the plan installs, whether listed in the config or
+	// installed by a provider or by Juju.
+	Snaps []*PlannedSnap `json:"snaps"`
+	// Debs holds every deb the plan installs.
+	Debs []*PlannedDeb `json:"debs"`
+	// Providers holds each provider the plan prepares, in the order they are prepared.
+	Providers []*PlannedProvider `json:"providers"`
+	// Juju reports whether the plan installs Juju and bootstraps controllers.
+	Juju bool `json:"juju"`
+	// Environment describes what the plan provisions, once prepared.
+	Environment *Environment `json:"environment"`
+}
+
+// PlannedSnap describes a snap installed by the plan.
+type PlannedSnap struct {
+	Name     string `json:"name"`
+	Channel  string `json:"channel,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// Reasons holds the configuration values that caused the snap to be installed.
+	Reasons []string `json:"reasons"`
+}
+
+// PlannedDeb describes a deb installed by the plan.
+type PlannedDeb struct {
+	Name    string   `json:"name"`
+	Reasons []string `json:"reasons"`
+}
+
+// PlannedProvider describes a provider prepared by the plan.
+type PlannedProvider struct {
+	Name      string   `json:"name"`
+	Bootstrap bool     `json:"bootstrap"`
+	Reasons   []string `json:"reasons"`
+}
+
+// Describe validates the plan and describes what it installs and configures.
+func (p *Plan) Describe() (*PlanDescription, error) {
+	if err := p.validate(""); err != nil {
+		return nil, fmt.Errorf("failed to validate plan: %w", err)
+	}
+
+	d := &PlanDescription{
+		Snaps:       []*PlannedSnap{},
+		Debs:        []*PlannedDeb{},
+		Providers:   []*PlannedProvider{},
+		Juju:        !p.config.Juju.Disable,
+		Environment: p.Environment(),
+	}
+
+	// A snap may be both listed in the config and installed by a provider, in which case
+	// it is installed once.
+	seen := map[string]bool{}
+	for _, s := range p.allSnaps() {
+		if seen[s.Name] {
+			continue
+		}
+		seen[s.Name] = true
+		d.Snaps = append(d.Snaps, &PlannedSnap{
+			Name:     s.Name,
+			Channel:  s.Channel,
+			Revision: s.Revision,
+			Reasons:  p.reasons(progress.StepID(progress.KindSnap, s.Name)),
+		})
+	}
+
+	debs := slices.Clone(p.Debs)
+	for _, provider := range p.Providers {
+		if installer, ok := provider.(DebInstaller); ok {
+			debs = append(debs, installer.Debs()...)
+		}
+	}
+	seen = map[string]bool{}
+	for _, deb := range debs {
+		if seen[deb.Name] {
+			continue
+		}
+		seen[deb.Name] = true
+		d.Debs = append(d.Debs, &PlannedDeb{
+			Name:    deb.Name,
+			Reasons: p.reasons(progress.StepID(progress.KindDeb, deb.Name)),
+		})
+	}
+
+	for _, provider := range p.Providers {
+		d.Providers = append(d.Providers, &PlannedProvider{
+			Name:      provider.Name(),
+			Bootstrap: provider.Bootstrap() && !p.config.Juju.Disable,
+			Reasons:   p.reasons(progress.StepID(progress.KindProvider, provider.Name())),
+		})
+	}
+
+	return d, nil
+}
+
+// reasons returns the descriptions of the configuration values that caused a step to be
+// part of the plan.
+func (p *Plan) reasons(step string) []string {
+	reasons := []string{}
+	for _, r := range p.Explain(step) {
+		reasons = append(reasons, r.String())
+	}
+	return reasons
+}
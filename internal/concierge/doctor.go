@@ -0,0 +1,23 @@
+package concierge
+
+import (
+	"fmt"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// Doctor checks the health of what the most recent 'prepare' provisioned, using the
+// configuration it recorded. A machine whose 'prepare' failed is still checked, since
+// the checks may point to the cause.
+func (m *Manager) Doctor() ([]system.Check, error) {
+	record, err := m.readRuntimeConfig()
+	if err != nil {
+		return nil, errNotPrepared
+	}
+	if m.newStatusReport(record).Status == config.Provisioning {
+		return nil, fmt.Errorf("concierge is still provisioning this machine")
+	}
+
+	return NewPlan(record.Config, m.system).Checks(), nil
+}
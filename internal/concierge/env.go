@@ -0,0 +1,238 @@
+package concierge
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// EnvFormats are the formats in which an Environment can be written.
+var EnvFormats = []string{"shell", "dotenv", "json"}
+
+// Environment describes what concierge provisioned on the machine, so that test
+// harnesses need not re-derive concierge's naming conventions.
+type Environment struct {
+	// Controllers holds each Juju controller bootstrapped, in provider order.
+	Controllers []*Controller `json:"controllers"`
+	// Kubeconfig is the path of the kubeconfig file, if a Kubernetes provider was
+	// prepared.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// JujuData is the path of Juju's data directory, if Juju was installed.
+	JujuData string `json:"juju-data,omitempty"`
+	// Versions holds the version of each snap installed by concierge, by name.
+	Versions map[string]string `json:"versions"`
+}
+
+// Controller describes a Juju controller bootstrapped by concierge.
+type Controller struct {
+	// Provider is the name of the provider the controller was bootstrapped on.
+	Provider string `json:"provider"`
+	// Name is the name of the controller, such as "concierge-lxd".
+	Name string `json:"name"`
+	// Model is the qualified name of the model added for testing, such as
+	// "concierge-lxd:testing".
+	Model string `json:"model"`
+}
+
+// Environment describes what the plan provisions. The versions of the snaps are left
+// empty, since they are only known once installed; see Versions.
+func (p *Plan) Environment() *Environment {
+	env := &Environment{Controllers: []*Controller{}, Versions: map[string]string{}}
+	home := p.system.User().HomeDir
+
+	if !p.config.Juju.Disable {
+		env.JujuData = path.Join(home, ".local", "share", "juju")
+
+		for _, provider := range p.Providers {
+			if !provider.Bootstrap() {
+				continue
+			}
+			// Named as by the Juju handler when bootstrapping the provider.
+			name := fmt.Sprintf("concierge-%s", provider.Name())
+			env.Controllers = append(env.Controllers, &Controller{
+				Provider: provider.Name(),
+				Name:     name,
+				Model:    name + ":testing",
+			})
+		}
+	}
+
+	for _, provider := range p.Providers {
+		if provider.Name() == "k8s" || provider.Name() == "microk8s" {
+			env.Kubeconfig = path.Join(home, ".kube", "config")
+			break
+		}
+	}
+
+	return env
+}
+
+// Versions returns the version of each snap installed by the plan, by name, as reported
+// by snapd. Snaps that are not installed are omitted.
+func (p *Plan) Versions() (map[string]string, error) {
+	versions := map[string]string{}
+	for _, s := range p.allSnaps() {
+		if _, ok := versions[s.Name]; ok {
+			continue
+		}
+
+		info, err := p.system.SnapInfo(s.Name, s.Channel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up snap '%s': %w", s.Name, err)
+		}
+		if info.Installed {
+			versions[s.Name] = info.Version
+		}
+	}
+	return versions, nil
+}
+
+// Environment describes the environment provisioned by the most recent successful
+// 'prepare' for the real user.
+func (m *Manager) Environment() (*Environment, error) {
+	record, err := m.readRuntimeConfig()
+	if err != nil {
+		return nil, fmt.Errorf("concierge has not prepared this machine")
+	}
+	if record.Config.Status != config.Succeeded {
+		return nil, fmt.Errorf("concierge has not successfully prepared this machine, status is '%s'", record.Config.Status)
+	}
+
+	return environment(NewPlan(record.Config, m.system))
+}
+
+// environment describes the environment provisioned by a plan, including the versions
+// of the snaps installed.
+func environment(plan *Plan) (*Environment, error) {
+	env := plan.Environment()
+
+	versions, err := plan.Versions()
+	if err != nil {
+		return nil, err
+	}
+	env.Versions = versions
+
+	return env, nil
+}
+
+// saveEnvironment writes a description of the provisioned environment to the env file,
+// if one was requested. Failure to write it is logged rather than failing the run.
+func (m *Manager) saveEnvironment() {
+	if m.config.EnvFile == "" || m.config.DryRun || m.Plan == nil {
+		return
+	}
+
+	env, err := environment(m.Plan)
+	if err == nil {
+		err = env.Save(m.system, m.config.EnvFile, m.config.EnvFormat)
+	}
+	if err != nil {
+		slog.Error("failed to save environment file", "path", m.config.EnvFile, "error", err.Error())
+		return
+	}
+
+	slog.Info("Wrote environment file", "path", m.config.EnvFile)
+}
+
+// Variables returns the environment as variables, by name. KUBECONFIG and JUJU_DATA are
+// those read by kubectl and Juju; the rest are prefixed with CONCIERGE_, such as
+// CONCIERGE_LXD_CONTROLLER and CONCIERGE_JHACK_VERSION.
+func (e *Environment) Variables() map[string]string {
+	variables := map[string]string{}
+
+	controllers := []string{}
+	for _, c := range e.Controllers {
+		controllers = append(controllers, c.Name)
+		variables[variableName(c.Provider, "CONTROLLER")] = c.Name
+		variables[variableName(c.Provider, "MODEL")] = c.Model
+	}
+	variables["CONCIERGE_CONTROLLERS"] = strings.Join(controllers, ",")
+
+	if e.Kubeconfig != "" {
+		variables["KUBECONFIG"] = e.Kubeconfig
+	}
+	if e.JujuData != "" {
+		variables["JUJU_DATA"] = e.JujuData
+	}
+
+	for name, version := range e.Versions {
+		variables[variableName(name, "VERSION")] = version
+	}
+
+	return variables
+}
+
+// Format returns the environment in the specified format: "shell" for export
+// statements, "dotenv" for a .env file, or "json".
+func (e *Environment) Format(format string) ([]byte, error) {
+	if format == "json" {
+		content, err := json.MarshalIndent(e, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal environment as json: %w", err)
+		}
+		return append(content, '\n'), nil
+	}
+
+	var line func(name, value string) string
+	switch format {
+	case "shell":
+		line = func(name, value string) string {
+			return fmt.Sprintf("export %s='%s'", name, strings.ReplaceAll(value, "'", `'\''`))
+		}
+	case "dotenv":
+		line = func(name, value string) string {
+			return fmt.Sprintf(`%s="%s"`, name, strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported environment format '%s'", format)
+	}
+
+	variables := e.Variables()
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(variables)) {
+		b.WriteString(line(name, variables[name]) + "\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// Save writes the environment to the specified path in the specified format, owned by
+// the real user so that test harnesses run as that user can replace it. Paths in the
+// real user's home directory are written as any other file concierge puts there.
+func (e *Environment) Save(w system.Worker, filePath, format string) error {
+	content, err := e.Format(format)
+	if err != nil {
+		return err
+	}
+
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve environment file path: %w", err)
+	}
+
+	if rel, err := filepath.Rel(w.User().HomeDir, absPath); err == nil && filepath.IsLocal(rel) {
+		return system.WriteHomeDirFile(w, rel, content)
+	}
+
+	if err := w.WriteFile(absPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write environment file: %w", err)
+	}
+	if err := w.ChownAll(absPath, w.User()); err != nil {
+		return fmt.Errorf("failed to change ownership of environment file: %w", err)
+	}
+	return nil
+}
+
+// variableName returns the name of a CONCIERGE_ variable describing something, such as
+// the controller of the lxd provider, or the version of the astral-uv snap.
+func variableName(name, suffix string) string {
+	name = strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+	return "CONCIERGE_" + name + "_" + suffix
+}
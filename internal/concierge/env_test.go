@@ -0,0 +1,148 @@
+package concierge
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestManagerEnvironment(t *testing.T) {
+	conf, err := config.Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sys := system.NewMockSystem()
+	sys.MockSnapVersion("core24", "20240920")
+	sys.MockSnapVersion("jhack", "0.4.3.1")
+	sys.MockSnapVersion("juju", "3.6.4")
+	sys.MockSnapVersion("lxd", "5.21.3")
+	m := newTestManager(sys)
+
+	_, err = m.Environment()
+	if err == nil || err.Error() != "concierge has not prepared this machine" {
+		t.Fatalf("expected not prepared error, got: %v", err)
+	}
+
+	m.config = conf
+	m.startRecord()
+	if err := m.recordRuntimeConfig(config.Failed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestManager(sys).Environment(); err == nil || !strings.Contains(err.Error(), "status is 'failed'") {
+		t.Fatalf("expected failed status error, got: %v", err)
+	}
+
+	if err := m.recordRuntimeConfig(config.Succeeded); err != nil {
+		t.Fatal(err)
+	}
+	env, err := newTestManager(sys).Environment()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := sys.User().HomeDir
+	expected := &Environment{
+		Controllers: []*Controller{
+			{Provider: "k8s", Name: "concierge-k8s", Model: "concierge-k8s:testing"},
+			{Provider: "lxd", Name: "concierge-lxd", Model: "concierge-lxd:testing"},
+		},
+		Kubeconfig: filepath.Join(home, ".kube", "config"),
+		JujuData:   filepath.Join(home, ".local", "share", "juju"),
+		// core24 is not installed by concierge itself.
+		Versions: map[string]string{"jhack": "0.4.3.1", "juju": "3.6.4", "lxd": "5.21.3"},
+	}
+
+	got, _ := json.Marshal(env)
+	want, _ := json.Marshal(expected)
+	if string(got) != string(want) {
+		t.Fatalf("expected: %s\ngot: %s", want, got)
+	}
+}
+
+func TestEnvironmentSave(t *testing.T) {
+	sys := system.NewMockSystem()
+	env := &Environment{Controllers: []*Controller{}, Versions: map[string]string{"juju": "3.6.4"}}
+
+	home := sys.User().HomeDir
+	inHome := filepath.Join(home, "charm", ".env")
+	if err := env.Save(sys, inHome, "dotenv"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sys.CreatedFiles[inHome], `CONCIERGE_JUJU_VERSION="3.6.4"`) {
+		t.Fatalf("unexpected environment file: %v", sys.CreatedFiles)
+	}
+	if !slices.Contains(sys.CreatedDirectories, filepath.Join(home, "charm")) {
+		t.Fatalf("expected the directory in the home directory to be created, got: %v", sys.CreatedDirectories)
+	}
+
+	outside := filepath.Join(t.TempDir(), "env.json")
+	if err := env.Save(sys, outside, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sys.CreatedFiles[outside]; !ok {
+		t.Fatalf("expected the environment file to be written, got: %v", sys.CreatedFiles)
+	}
+}
+
+func TestEnvironmentFormat(t *testing.T) {
+	env := &Environment{
+		Controllers: []*Controller{{Provider: "lxd", Name: "concierge-lxd", Model: "concierge-lxd:testing"}},
+		JujuData:    "/home/ubuntu/.local/share/juju",
+		Versions:    map[string]string{"astral-uv": `0.6'"1`},
+	}
+
+	type test struct {
+		format   string
+		expected string
+	}
+
+	tests := []test{
+		{
+			format: "shell",
+			expected: `export CONCIERGE_ASTRAL_UV_VERSION='0.6'\''"1'
+export CONCIERGE_CONTROLLERS='concierge-lxd'
+export CONCIERGE_LXD_CONTROLLER='concierge-lxd'
+export CONCIERGE_LXD_MODEL='concierge-lxd:testing'
+export JUJU_DATA='/home/ubuntu/.local/share/juju'
+`,
+		},
+		{
+			format: "dotenv",
+			expected: `CONCIERGE_ASTRAL_UV_VERSION="0.6'\"1"
+CONCIERGE_CONTROLLERS="concierge-lxd"
+CONCIERGE_LXD_CONTROLLER="concierge-lxd"
+CONCIERGE_LXD_MODEL="concierge-lxd:testing"
+JUJU_DATA="/home/ubuntu/.local/share/juju"
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		content, err := env.Format(tc.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != tc.expected {
+			t.Fatalf("expected %s format:\n%s\ngot:\n%s", tc.format, tc.expected, content)
+		}
+	}
+
+	content, err := env.Format("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Environment
+	if err := json.Unmarshal(content, &decoded); err != nil || decoded.Controllers[0].Model != "concierge-lxd:testing" {
+		t.Fatalf("unexpected json: %s (%v)", content, err)
+	}
+
+	if _, err := env.Format("yaml"); err == nil {
+		t.Fatal("expected an error for an unsupported format")
+	}
+}
@@ -1,6 +1,11 @@
 package concierge
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+)
 
 const (
 	RestoreAction string = "restore"
@@ -13,6 +18,24 @@ type Executable interface {
 	Restore() error
 }
 
+// Inspector is an interface implemented by handlers that can report state they
+// created during 'prepare' which still exists on the machine.
+type Inspector interface {
+	Leftovers() []system.Leftover
+}
+
+// Checker is an interface implemented by handlers that can check the health of what
+// they provisioned during 'prepare'.
+type Checker interface {
+	Checks() []system.Check
+}
+
+// Requirer is an interface implemented by providers that need more of the machine than
+// concierge itself, such as memory, CPUs or the absence of a conflicting service.
+type Requirer interface {
+	Requirements() providers.Requirements
+}
+
 // DoAction takes an Executable, and calls either Prepare() or Restore() according
 // to the action parameter.
 func DoAction(executable Executable, action string) error {
@@ -0,0 +1,127 @@
+package concierge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/packages"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// SnapInstaller is implemented by providers that install snaps of their own.
+type SnapInstaller interface {
+	Snaps() []*system.Snap
+}
+
+// DebInstaller is implemented by providers that install debs of their own.
+type DebInstaller interface {
+	Debs() []*packages.Deb
+}
+
+// Reason ties a step in the plan to a configuration value that caused it.
+type Reason struct {
+	// Key is the dotted path of the configuration value, such as `providers.lxd.channel`.
+	Key string
+	// Value is the resolved value, where relevant, such as the channel of a snap.
+	Value string
+	// Origin is where the value came from.
+	Origin config.Origin
+}
+
+// String returns a description of the reason, such as
+// `providers.lxd.channel=5.21/stable (flag --lxd-channel)`.
+func (r Reason) String() string {
+	if r.Value == "" {
+		return fmt.Sprintf("%s (%s)", r.Key, r.Origin)
+	}
+	return fmt.Sprintf("%s=%s (%s)", r.Key, r.Value, r.Origin)
+}
+
+// Explain returns the configuration values that caused the step, such as `snap:lxd`,
+// to be part of the plan, along with where each value came from.
+func (p *Plan) Explain(step string) []Reason {
+	reasons := []Reason{}
+	add := func(key, value string) {
+		if origin, ok := p.config.Provenance.Get(key); ok {
+			reasons = append(reasons, Reason{Key: key, Value: value, Origin: origin})
+		}
+	}
+
+	kind, name, _ := strings.Cut(step, ":")
+	switch kind {
+	case progress.KindSnap:
+		for _, s := range p.Snaps {
+			if s.Name == name {
+				add("host.snaps."+name, "")
+				add("host.snaps."+name+".channel", s.Channel)
+			}
+		}
+		for _, provider := range p.Providers {
+			installer, ok := provider.(SnapInstaller)
+			if !ok {
+				continue
+			}
+			for _, s := range installer.Snaps() {
+				if s.Name != name {
+					continue
+				}
+				add("providers."+provider.Name()+".enable", "true")
+				if s.Name == provider.Name() {
+					add("providers."+provider.Name()+".channel", s.Channel)
+				}
+			}
+		}
+		if name == "juju" && !p.config.Juju.Disable {
+			add("juju.channel", "")
+			add("juju.revision", "")
+		}
+
+	case progress.KindDeb:
+		for _, d := range p.Debs {
+			if d.Name == name {
+				add("host.packages."+name, "")
+			}
+		}
+		for _, provider := range p.Providers {
+			installer, ok := provider.(DebInstaller)
+			if !ok {
+				continue
+			}
+			for _, d := range installer.Debs() {
+				if d.Name == name {
+					add("providers."+provider.Name()+".enable", "true")
+				}
+			}
+		}
+
+	case progress.KindProvider:
+		add("providers."+name+".enable", "true")
+		if installer, ok := p.provider(name).(SnapInstaller); ok {
+			for _, s := range installer.Snaps() {
+				if s.Name == name {
+					add("providers."+name+".channel", s.Channel)
+				}
+			}
+		}
+
+	case progress.KindJuju:
+		// Juju steps are named for the action and provider, such as `bootstrap:lxd`.
+		_, provider, _ := strings.Cut(name, ":")
+		add("providers."+provider+".bootstrap", "true")
+		add("juju.disable", "false")
+	}
+
+	return reasons
+}
+
+// provider returns the provider in the plan with the specified name, or nil.
+func (p *Plan) provider(name string) any {
+	for _, provider := range p.Providers {
+		if provider.Name() == name {
+			return provider
+		}
+	}
+	return nil
+}
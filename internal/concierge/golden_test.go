@@ -0,0 +1,192 @@
+package concierge
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestGolden runs 'prepare' then 'restore' against a MockSystem for every preset, and
+// for each config in testdata/configs, comparing everything the plan did with the
+// system against a golden file in testdata/golden. Run with -update to regenerate
+// the golden files after an intended change in behaviour.
+func TestGolden(t *testing.T) {
+	cases := map[string]*config.Config{}
+
+	for _, name := range config.ValidPresets() {
+		conf, err := config.Preset(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases["preset-"+name] = conf
+	}
+
+	configFiles, err := filepath.Glob(filepath.Join("testdata", "configs", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range configFiles {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conf := &config.Config{}
+		if err := yaml.Unmarshal(content, conf); err != nil {
+			t.Fatalf("failed to parse %s: %v", f, err)
+		}
+		cases["config-"+strings.TrimSuffix(filepath.Base(f), ".yaml")] = conf
+	}
+
+	for name, conf := range cases {
+		t.Run(name, func(t *testing.T) {
+			sys := system.NewMockSystem()
+
+			// As in the Manager, the machine's original state is recorded during
+			// 'prepare' and used during 'restore'.
+			conf.Snapshot = config.NewSnapshot()
+
+			for _, action := range []string{PrepareAction, RestoreAction} {
+				mockMachineState(sys, action)
+
+				recorder := system.NewRecordingWorker(sys)
+				err := NewPlan(conf, recorder).Execute(action)
+
+				got := formatGolden(recorder.Cassette(), err)
+				goldenPath := filepath.Join("testdata", "golden", fmt.Sprintf("%s-%s.golden", name, action))
+
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(goldenPath), 0755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(goldenPath, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+
+				want, err := os.ReadFile(goldenPath)
+				if err != nil {
+					t.Fatalf("failed to read golden file (run with -update to create it): %v", err)
+				}
+
+				if got != string(want) {
+					t.Errorf("%s does not match; run with -update if the change is intended.\n%s", goldenPath, lineDiff(string(want), got))
+				}
+			}
+		})
+	}
+}
+
+// mockMachineState makes the MockSystem resemble a fresh machine before 'prepare', such
+// that providers and controllers are bootstrapped, and a prepared machine before
+// 'restore', such that they are torn down.
+func mockMachineState(sys *system.MockSystem, action string) {
+	user := sys.User().Username
+	mockPreflight(sys)
+
+	for _, p := range providers.SupportedProviders {
+		controller := "concierge-" + p
+		if action == PrepareAction {
+			sys.MockCommandReturn(fmt.Sprintf("sudo -u %s juju show-controller %s", user, controller),
+				[]byte(fmt.Sprintf("ERROR controller %s not found", controller)), fmt.Errorf("exit status 1"))
+		} else {
+			sys.MockCommandReturn(fmt.Sprintf("sudo -u %s juju show-controller %s", user, controller), []byte{}, nil)
+		}
+	}
+
+	if action == PrepareAction {
+		sys.MockCommandReturn("k8s status", []byte("Error: The node is not part of a Kubernetes cluster."), fmt.Errorf("exit status 1"))
+	} else {
+		sys.MockCommandReturn("k8s status", []byte{}, nil)
+	}
+}
+
+// formatGolden renders the interactions in a cassette as a golden file. Handlers run
+// concurrently, so the interactions within each section are sorted. The mock user's
+// home directory is replaced with $HOME, since it depends on the test environment.
+func formatGolden(cassette *system.Cassette, execErr error) string {
+	sections := map[string][]string{}
+	files := map[string]string{}
+
+	for _, i := range cassette.Interactions {
+		entry := i.Key
+		if i.Error != "" {
+			entry += " (error: " + i.Error + ")"
+		}
+
+		switch i.Op {
+		case system.OpRun:
+			sections["commands"] = append(sections["commands"], entry)
+		case system.OpWriteFile:
+			files[i.Key] = i.Output
+		case system.OpSnapInfo, system.OpSnapChannels:
+			sections["snap queries"] = append(sections["snap queries"], i.Op+" "+entry)
+		case system.OpReadFile:
+			sections["probes"] = append(sections["probes"], i.Op+" "+entry)
+		case system.OpPathExists:
+			sections["probes"] = append(sections["probes"], fmt.Sprintf("%s %s (%t)", i.Op, entry, i.Exists))
+		default:
+			sections["filesystem"] = append(sections["filesystem"], i.Op+" "+entry)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by TestGolden; regenerate with: go test ./internal/concierge -run TestGolden -update\n")
+
+	if execErr != nil {
+		fmt.Fprintf(&b, "\n## error\n%s\n", execErr)
+	}
+
+	for _, name := range []string{"commands", "filesystem", "snap queries", "probes"} {
+		lines := sections[name]
+		if len(lines) == 0 {
+			continue
+		}
+		slices.Sort(lines)
+		fmt.Fprintf(&b, "\n## %s\n%s\n", name, strings.Join(lines, "\n"))
+	}
+
+	for _, p := range slices.Sorted(func(yield func(string) bool) {
+		for p := range files {
+			if !yield(p) {
+				return
+			}
+		}
+	}) {
+		fmt.Fprintf(&b, "\n## file %s\n%s\n", p, strings.TrimSuffix(files[p], "\n"))
+	}
+
+	return strings.ReplaceAll(b.String(), os.TempDir(), "$HOME")
+}
+
+// lineDiff returns the lines removed from want and added in got.
+func lineDiff(want, got string) string {
+	wantLines := strings.Split(want, "\n")
+	gotLines := strings.Split(got, "\n")
+
+	var b strings.Builder
+	for _, l := range wantLines {
+		if !slices.Contains(gotLines, l) {
+			fmt.Fprintf(&b, "- %s\n", l)
+		}
+	}
+	for _, l := range gotLines {
+		if !slices.Contains(wantLines, l) {
+			fmt.Fprintf(&b, "+ %s\n", l)
+		}
+	}
+	return b.String()
+}
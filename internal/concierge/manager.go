@@ -1,31 +1,119 @@
 package concierge
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"os/user"
 	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/ghactions"
+	"github.com/canonical/concierge/internal/metrics"
+	"github.com/canonical/concierge/internal/notify"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/report"
 	"github.com/canonical/concierge/internal/securitylog"
+	"github.com/canonical/concierge/internal/state"
 	"github.com/canonical/concierge/internal/system"
 	"gopkg.in/yaml.v3"
 )
 
+// Version is the version of concierge, recorded alongside the runtime state. It is set
+// by the CLI at startup.
+var Version = "dev"
+
+// Option configures a Manager.
+type Option func(*managerOptions)
+
+// managerOptions holds the settings applied by each Option.
+type managerOptions struct {
+	ctx  context.Context
+	user *user.User
+}
+
+// WithContext configures the Manager to stop the commands it runs, and the retries it
+// makes, once ctx is done, failing the run.
+func WithContext(ctx context.Context) Option {
+	return func(o *managerOptions) { o.ctx = ctx }
+}
+
+// WithUser configures the Manager to provision the machine for u, rather than for the
+// user that ran concierge with `sudo`.
+func WithUser(u *user.User) Option {
+	return func(o *managerOptions) { o.user = u }
+}
+
 // NewManager constructs a new instance of the concierge manager.
-func NewManager(config *config.Config) (*Manager, error) {
-	sys, err := system.NewSystem(config.Trace)
+func NewManager(config *config.Config, opts ...Option) (*Manager, error) {
+	options := &managerOptions{ctx: context.Background()}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	// When emitting JSON, stdout carries only the event stream, so anything else
+	// concierge would print there goes to stderr instead.
+	var output io.Writer = os.Stdout
+	if config.Output == "json" {
+		output = os.Stderr
+	} else if config.Console != nil {
+		output = config.Console
+	}
+
+	systemOpts := []system.Option{system.WithTraceOutput(output), system.WithContext(options.ctx)}
+	if options.user != nil {
+		systemOpts = append(systemOpts, system.WithUser(options.user))
+	}
+
+	sys, err := system.NewSystem(config.Trace, systemOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialise system: %w", err)
 	}
 
 	var worker system.Worker = sys
+
+	// Record interactions with the system beneath the dry-run worker, such that only
+	// what really happened is captured.
+	var recorder *system.RecordingWorker
+	if config.Record != "" {
+		recorder = system.NewRecordingWorker(sys)
+		for _, p := range []string{config.Providers.Google.CredentialsFile, config.Overrides.GoogleCredentialFile} {
+			if p != "" {
+				recorder.RedactPath(p)
+			}
+		}
+		worker = recorder
+	}
+
+	var dryRunWorker *system.DryRunWorker
 	if config.DryRun {
-		worker = system.NewDryRunWorker(sys)
+		opts := []system.DryRunOption{system.WithOutput(output)}
+		if config.DryRunFormat == "script" {
+			opts = append(opts, system.WithScript())
+		}
+		if config.Offline {
+			opts = append(opts, system.WithOffline())
+		}
+
+		dryRunWorker = system.NewDryRunWorker(worker, opts...)
+		worker = dryRunWorker
 	}
 
 	return &Manager{
-		config: config,
-		system: worker,
+		config:       config,
+		system:       worker,
+		state:        state.NewStore(worker, state.DefaultDir),
+		recorder:     recorder,
+		cassettePath: config.Record,
+		dryRun:       dryRunWorker,
+		output:       output,
 	}, nil
 }
 
@@ -34,6 +122,32 @@ type Manager struct {
 	Plan   *Plan
 	system system.Worker
 	config *config.Config
+	state  *state.Store
+
+	// recorder, if set, records every interaction with the system into a cassette
+	// at cassettePath, for replay in tests.
+	recorder     *system.RecordingWorker
+	cassettePath string
+
+	// dryRun, if set, is the worker printing what would be done in dry-run mode. Steps
+	// are annotated with comments in its output as they start.
+	dryRun *system.DryRunWorker
+
+	// output is where concierge prints anything other than logs, such as the log groups
+	// of each step when running in GitHub Actions.
+	output io.Writer
+
+	// report, if set, collects a report of the run, to be written to the paths in the
+	// config. result is the report of the most recent run, once finished.
+	report *report.Collector
+	result *report.Report
+
+	// record is the runtime state of the most recent 'prepare', and run is the history
+	// entry for the current run. Both are guarded by recordMu, since progress events
+	// arrive concurrently from the handlers.
+	record   *state.Record
+	run      *state.Run
+	recordMu sync.Mutex
 }
 
 // Prepare runs the steps required for provisioning the machine according to
@@ -46,21 +160,33 @@ func (m *Manager) Prepare() error {
 			"action", PrepareAction, "user", m.system.User().Username)
 	}
 
+	// Record the state of each component as the plan progresses, so that it can be
+	// observed with 'concierge status' while provisioning is underway.
+	m.startRun(PrepareAction)
+	unsubscribe := progress.Subscribe(m.recordProgress)
+	unsubscribeSteps := m.subscribeSteps()
+	unsubscribeReport := m.startReport(PrepareAction)
+	unsubscribeGroups := m.startGroups()
 	err := m.execute(PrepareAction)
+	unsubscribeGroups()
+	unsubscribeReport()
+	unsubscribeSteps()
+	unsubscribe()
+	m.finishRun(err)
+	m.saveReport(err)
+	if err == nil {
+		m.saveEnvironment()
+	}
 
 	// Record the status of the provisioning process in the cached plan.
-	var recordErr error
-	if err != nil {
-		recordErr = m.recordRuntimeConfig(config.Failed)
-	} else {
-		recordErr = m.recordRuntimeConfig(config.Succeeded)
-	}
+	recordErr := m.recordOutcome(err)
 
 	// If the recording of the status failed, log the error and move on.
 	if recordErr != nil {
 		slog.Error("failed to record concierge status", "error", recordErr.Error())
 	}
 
+	m.saveCassette()
 	return err
 }
 
@@ -73,13 +199,274 @@ func (m *Manager) Restore() error {
 			"action", RestoreAction, "user", m.system.User().Username)
 	}
 
-	return m.execute(RestoreAction)
+	m.startRun(RestoreAction)
+	unsubscribe := progress.Subscribe(m.recordProgress)
+	unsubscribeSteps := m.subscribeSteps()
+	unsubscribeReport := m.startReport(RestoreAction)
+	unsubscribeGroups := m.startGroups()
+	err := m.execute(RestoreAction)
+	unsubscribeGroups()
+	unsubscribeReport()
+	unsubscribeSteps()
+	unsubscribe()
+	m.finishRun(err)
+	m.saveReport(err)
+	m.saveCassette()
+
+	if err != nil {
+		return err
+	}
+
+	// The machine has been put back as it was, so the snapshots taken during prepare
+	// no longer apply.
+	if m.config.Snapshot != nil {
+		m.config.Snapshot = nil
+		if err := m.recordRuntimeConfig(m.config.Status); err != nil {
+			slog.Error("failed to clear concierge snapshot", "error", err.Error())
+		}
+	}
+	if !m.config.DryRun {
+		if err := m.state.SaveMachine(nil); err != nil {
+			slog.Error("failed to clear concierge machine snapshot", "error", err.Error())
+		}
+	}
+
+	return nil
+}
+
+// subscribeSteps reports the start of each step, along with the configuration that
+// caused it: in trace output, in the dry-run output if '--explain' was specified, and
+// in the log otherwise. Steps are marked with a comment when writing a dry-run script.
+func (m *Manager) subscribeSteps() (unsubscribe func()) {
+	return progress.Subscribe(func(e progress.Event) {
+		if e.Type != progress.EventStart || m.Plan == nil {
+			return
+		}
+
+		reasons := []string{}
+		for _, r := range m.Plan.Explain(e.Step) {
+			reasons = append(reasons, r.String())
+		}
+		slog.Debug("Starting step", "step", e.Step, "because", strings.Join(reasons, "; "))
+
+		if m.dryRun != nil && m.config.DryRunFormat == "script" {
+			m.dryRun.Comment(fmt.Sprintf("--- %s ---", e.Step))
+		}
+
+		if !m.config.Explain {
+			return
+		}
+
+		if len(reasons) == 0 {
+			reasons = append(reasons, "required by concierge")
+		}
+
+		if m.dryRun == nil {
+			slog.Info("Starting step", "step", e.Step, "because", strings.Join(reasons, "; "))
+			return
+		}
+
+		for _, r := range reasons {
+			m.dryRun.Comment(fmt.Sprintf("%s: %s", e.Step, r))
+		}
+	})
+}
+
+// saveCassette writes the interactions recorded with the system to the cassette file,
+// if recording was requested.
+func (m *Manager) saveCassette() {
+	if m.recorder == nil {
+		return
+	}
+
+	err := m.recorder.Save(m.cassettePath)
+	if err != nil {
+		slog.Error("failed to save cassette", "path", m.cassettePath, "error", err.Error())
+		return
+	}
+
+	slog.Info("Recorded system interactions", "path", m.cassettePath)
+}
+
+// startReport starts collecting a report of the run. Whether the report is written
+// anywhere is only known once the run finishes, since 'restore' loads the configuration
+// of the 'prepare' it reverses, which may configure webhooks.
+func (m *Manager) startReport(action string) (unsubscribe func()) {
+	m.report = report.NewCollector(action, Version, m.config.DryRun)
+	return progress.Subscribe(m.report.Handle)
+}
+
+// saveReport writes the report of the run to each requested path. Failure to write a
+// report is logged rather than failing the run itself.
+func (m *Manager) saveReport(runErr error) {
+	if m.report == nil {
+		return
+	}
+
+	r := m.report.Finish(runErr)
+	m.result = r
+
+	if m.config.Report != "" {
+		if err := m.saveForUser(m.config.Report, r.Save); err != nil {
+			slog.Error("failed to save report", "path", m.config.Report, "error", err.Error())
+		} else {
+			slog.Info("Wrote run report", "path", m.config.Report)
+		}
+	}
+
+	if m.config.JUnit != "" {
+		if err := m.saveForUser(m.config.JUnit, r.SaveJUnit); err != nil {
+			slog.Error("failed to save junit report", "path", m.config.JUnit, "error", err.Error())
+		} else {
+			slog.Info("Wrote JUnit report", "path", m.config.JUnit)
+		}
+	}
+
+	// Metrics of a dry-run would be mistaken for those of a real run.
+	if m.config.Metrics != "" && !m.config.DryRun {
+		if err := metrics.WriteTextfile(m.config.Metrics, r, m.inventory()); err != nil {
+			slog.Error("failed to save metrics textfile", "path", m.config.Metrics, "error", err.Error())
+		} else {
+			slog.Info("Wrote metrics textfile", "path", m.config.Metrics)
+		}
+	}
+
+	if m.githubActions() {
+		m.saveGitHubActions(r)
+	}
+
+	if len(m.config.Notify.Webhooks) > 0 && !m.config.DryRun {
+		m.notify(r)
+	}
+}
+
+// saveForUser saves a file with save, and gives its ownership to the real user, since
+// reports are written into their workspace by concierge running as root. The file is
+// not written through the Worker, since reports of a dry-run are written too.
+func (m *Manager) saveForUser(filePath string, save func(filePath string) error) error {
+	if err := save(filePath); err != nil {
+		return err
+	}
+
+	u := m.system.User()
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("failed to convert user id string to int: %w", err)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("failed to convert group id string to int: %w", err)
+	}
+
+	if err := os.Chown(filePath, uid, gid); err != nil {
+		return fmt.Errorf("failed to change ownership of '%s': %w", filePath, err)
+	}
+	return nil
+}
+
+// Result returns the report of the most recent 'prepare' or 'restore' performed by the
+// manager, or nil if there has been none.
+func (m *Manager) Result() *report.Report {
+	return m.result
+}
+
+// Describe describes what 'prepare' would install and configure, without changing the
+// machine.
+func (m *Manager) Describe() (*PlanDescription, error) {
+	return NewPlan(m.config, m.system).Describe()
+}
+
+// notify posts the outcome of the run to each configured webhook. Failure to notify is
+// logged rather than failing the run itself.
+func (m *Manager) notify(r *report.Report) {
+	host, err := os.Hostname()
+	if err != nil {
+		slog.Debug("Failed to look up hostname", "error", err.Error())
+	}
+
+	payload := notify.NewPayload(r, host, m.system.User().Username)
+	if err := notify.Send(m.config.Notify.Webhooks, payload); err != nil {
+		slog.Error("failed to send notification", "error", err.Error())
+		return
+	}
+
+	slog.Info("Sent notification", "webhooks", len(m.config.Notify.Webhooks))
+}
+
+// inventory counts the snaps, debs and Juju controllers handled by the plan.
+func (m *Manager) inventory() metrics.Inventory {
+	if m.Plan == nil {
+		return metrics.Inventory{}
+	}
+
+	debs := len(m.Plan.Debs)
+	for _, provider := range m.Plan.Providers {
+		if installer, ok := provider.(DebInstaller); ok {
+			debs += len(installer.Debs())
+		}
+	}
+
+	return metrics.Inventory{
+		Snaps:       len(m.Plan.allSnaps()),
+		Debs:        debs,
+		Controllers: len(m.Plan.Environment().Controllers),
+	}
+}
+
+// githubActions reports whether to integrate with GitHub Actions: when running in a
+// workflow, other than for a dry-run, in which nothing is provisioned.
+func (m *Manager) githubActions() bool {
+	return ghactions.Enabled() && !m.config.DryRun
+}
+
+// startGroups starts writing the output of each step to a log group when running in
+// GitHub Actions. Groups are not written when emitting JSON, since stdout carries only
+// the event stream.
+func (m *Manager) startGroups() (unsubscribe func()) {
+	if !m.githubActions() || m.config.Output == "json" {
+		return func() {}
+	}
+	return progress.SubscribeWithOutput(ghactions.NewGroups(m.output).Handle)
+}
+
+// saveGitHubActions writes the report of the run to the GitHub Actions job summary and
+// sets the step outputs. Outputs describing the machine are only set once it has been
+// prepared successfully. Failure to write either is logged rather than failing the run.
+func (m *Manager) saveGitHubActions(r *report.Report) {
+	var version func(step string) string
+	if m.Plan != nil {
+		version = m.Plan.StepVersion
+	}
+	if err := ghactions.WriteSummary(ghactions.Summary(r, version)); err != nil {
+		slog.Error("failed to write github actions job summary", "error", err.Error())
+	}
+
+	outputs := map[string]string{}
+	if r.Action == PrepareAction && r.Status == report.StatusSucceeded && m.Plan != nil {
+		outputs = m.Plan.Outputs()
+	}
+	outputs["status"] = r.Status
+	if err := ghactions.SetOutputs(outputs); err != nil {
+		slog.Error("failed to set github actions outputs", "error", err.Error())
+	}
+}
+
+// Leftovers reports any state created by 'prepare' that still exists on the machine.
+// It should be called after Restore; if no plan has been executed, or in dry-run mode
+// where nothing was removed, nil is returned.
+func (m *Manager) Leftovers() []system.Leftover {
+	if m.Plan == nil || m.config.DryRun {
+		return nil
+	}
+	return m.Plan.Leftovers()
 }
 
 // execute runs the overlord with a specified action.
 func (m *Manager) execute(action string) error {
 	switch action {
 	case PrepareAction:
+		m.config.Snapshot = m.previousSnapshot()
+		m.startRecord()
 		err := m.recordRuntimeConfig(config.Provisioning)
 		if err != nil {
 			return fmt.Errorf("failed to record config file: %w", err)
@@ -98,72 +485,412 @@ func (m *Manager) execute(action string) error {
 	return m.Plan.Execute(action)
 }
 
-// recordRuntimeConfig dumps the current manager config into a file in the user's home
-// directory, such that it can be read later and used to restore the machine.
-// In dry-run mode, this is a no-op.
+// runtimeConfigPath is the legacy location of the runtime configuration, relative to
+// the real user's home directory. It is still written for compatibility with tools
+// that read it, and read when the state store has no record for the user.
+var runtimeConfigPath = path.Join(".cache", "concierge", "concierge.yaml")
+
+// startRecord begins a fresh record of the runtime state for a 'prepare' run.
+func (m *Manager) startRecord() {
+	m.recordMu.Lock()
+	defer m.recordMu.Unlock()
+
+	m.record = &state.Record{
+		User:             m.system.User().Username,
+		ConciergeVersion: Version,
+		PID:              os.Getpid(),
+		Started:          time.Now().UTC(),
+	}
+}
+
+// startRun begins a history entry for the current run.
+func (m *Manager) startRun(action string) {
+	m.recordMu.Lock()
+	defer m.recordMu.Unlock()
+
+	m.run = &state.Run{
+		Action:           action,
+		User:             m.system.User().Username,
+		RanAs:            currentUsername(),
+		ConciergeVersion: Version,
+		Started:          time.Now().UTC(),
+	}
+}
+
+// finishRun completes the history entry for the current run and appends it to the
+// history. In dry-run mode, nothing is recorded. Failure to record the run is logged
+// rather than failing the run itself.
+func (m *Manager) finishRun(runErr error) {
+	if m.config.DryRun {
+		return
+	}
+
+	m.recordMu.Lock()
+	defer m.recordMu.Unlock()
+
+	if m.run == nil {
+		return
+	}
+
+	run := m.run
+	m.run = nil
+
+	run.Finished = time.Now().UTC()
+	run.Outcome = state.OutcomeSucceeded
+	if runErr != nil {
+		run.Outcome = state.OutcomeFailed
+		run.Error = runErr.Error()
+	}
+
+	// The config is only known once 'restore' has loaded it, so it is recorded last.
+	run.ConfigSource = m.config.Source
+	hash, err := state.ConfigHash(m.config)
+	if err != nil {
+		slog.Warn("Failed to hash configuration for history", "error", err.Error())
+	}
+	run.ConfigHash = hash
+
+	err = m.state.AppendRun(run)
+	if err != nil {
+		slog.Error("failed to record run in history", "error", err.Error())
+	}
+}
+
+// currentUsername returns the name of the user concierge is running as, or its UID if
+// the name cannot be determined.
+func currentUsername() string {
+	u, err := user.Current()
+	if err != nil {
+		return strconv.Itoa(os.Getuid())
+	}
+	return u.Username
+}
+
+// History returns the recorded 'prepare' and 'restore' runs, oldest first.
+func (m *Manager) History() ([]*state.Run, error) {
+	return m.state.History()
+}
+
+// Run returns the recorded run with the specified ID.
+func (m *Manager) Run(id int) (*state.Run, error) {
+	return m.state.Run(id)
+}
+
+// recordProgress applies a progress event to the history entry for the current run
+// and, during 'prepare', to the runtime state, which is saved so that progress can be
+// observed. Failure to save is logged rather than interrupting provisioning.
+func (m *Manager) recordProgress(e progress.Event) {
+	// Only the start and end of steps are recorded. Other events, such as warnings,
+	// may be emitted while the record is being saved.
+	if m.config.DryRun || (e.Type != progress.EventStart && e.Type != progress.EventEnd) {
+		return
+	}
+
+	m.recordMu.Lock()
+	defer m.recordMu.Unlock()
+
+	if m.run != nil {
+		m.run.Apply(e)
+	}
+
+	if m.record == nil || m.run == nil || m.run.Action != PrepareAction {
+		return
+	}
+
+	m.record.Apply(e)
+	err := m.state.Save(m.record)
+	if err != nil {
+		slog.Warn("Failed to record progress", "step", e.Step, "error", err.Error())
+	}
+}
+
+// recordOutcome records the final status of a 'prepare' run, along with the time at
+// which it finished and the error it failed with, if any.
+func (m *Manager) recordOutcome(runErr error) error {
+	m.recordMu.Lock()
+	if m.record != nil {
+		m.record.Finished = time.Now().UTC()
+		m.record.Error = ""
+		if runErr != nil {
+			m.record.Error = runErr.Error()
+		}
+	}
+	m.recordMu.Unlock()
+
+	if runErr != nil {
+		return m.recordRuntimeConfig(config.Failed)
+	}
+	return m.recordRuntimeConfig(config.Succeeded)
+}
+
+// recordRuntimeConfig saves the current manager config into the system-wide state
+// store, and into a file in the user's home directory, such that it can be read later
+// and used to restore the machine. In dry-run mode, this is a no-op.
 func (m *Manager) recordRuntimeConfig(status config.Status) error {
 	if m.config.DryRun {
 		return nil
 	}
+
+	m.recordMu.Lock()
+	defer m.recordMu.Unlock()
+
 	m.config.Status = status
-	configYaml, err := yaml.Marshal(m.config)
+
+	if m.record == nil {
+		m.record = &state.Record{User: m.system.User().Username}
+	}
+	m.record.Config = m.config
+
+	err := m.state.Save(m.record)
+	if err != nil {
+		return fmt.Errorf("failed to save runtime state: %w", err)
+	}
+
+	err = m.recordMachineSnapshot()
+	if err != nil {
+		return fmt.Errorf("failed to save machine state: %w", err)
+	}
+
+	// The copy in the home directory is readable by anyone, so webhooks, whose URLs and
+	// headers often carry secrets, are kept to the state store.
+	homeConfig := *m.config
+	homeConfig.Notify.Webhooks = nil
+
+	configYaml, err := yaml.Marshal(&homeConfig)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config file as yaml: %w", err)
 	}
 
-	filepath := path.Join(".cache", "concierge", "concierge.yaml")
-	err = system.WriteHomeDirFile(m.system, filepath, configYaml)
+	err = system.WriteHomeDirFile(m.system, runtimeConfigPath, configYaml)
 	if err != nil {
 		return fmt.Errorf("failed to write runtime config file: %w", err)
 	}
 
-	slog.Debug("Merged runtime configuration saved", "path", filepath)
-
+	slog.Debug("Merged runtime configuration saved", "path", runtimeConfigPath)
 	return nil
 }
 
-// loadRuntimeConfig loads a previously cached concierge runtime configuration.
-// CLI flags (DryRun, Trace, Verbose) are preserved from the current config.
-func (m *Manager) loadRuntimeConfig() error {
-	recordPath := path.Join(".cache", "concierge", "concierge.yaml")
+// readRuntimeConfig reads the runtime state recorded for the real user, preferring
+// the system-wide state store and falling back to the legacy file in the user's home
+// directory, which holds only the configuration.
+func (m *Manager) readRuntimeConfig() (*state.Record, error) {
+	record, err := m.state.Load(m.system.User().Username)
+	if err == nil {
+		return record, nil
+	}
+	if !errors.Is(err, state.ErrNotFound) {
+		return nil, err
+	}
 
-	contents, err := system.ReadHomeDirFile(m.system, recordPath)
+	contents, err := system.ReadHomeDirFile(m.system, runtimeConfigPath)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	var loadedConfig config.Config
 	err = yaml.Unmarshal(contents, &loadedConfig)
 	if err != nil {
-		return fmt.Errorf("failed to parse file: %w", err)
+		return nil, fmt.Errorf("failed to parse file: %w", err)
 	}
 
+	slog.Debug("Loaded runtime configuration from legacy location", "path", runtimeConfigPath)
+	return &state.Record{User: m.system.User().Username, Config: &loadedConfig}, nil
+}
+
+// previousSnapshot returns the snapshot recorded by an earlier 'prepare' which has not
+// since been restored, so that re-running 'prepare' does not mistake concierge's own
+// changes for the original state of the machine. If there is none, a new snapshot is
+// returned.
+func (m *Manager) previousSnapshot() *config.Snapshot {
+	snapshot := config.NewSnapshot()
+
+	previous, err := m.readRuntimeConfig()
+	if err == nil && previous.Config.Snapshot != nil {
+		slog.Debug("Loaded snapshot from previous run")
+		snapshot = previous.Config.Snapshot
+	}
+
+	m.loadMachineSnapshot(snapshot)
+	return snapshot
+}
+
+// loadMachineSnapshot adds the machine-wide state recorded by an earlier 'prepare',
+// such as the firewall, to the snapshot. The firewall belongs to the machine rather
+// than any one user, so it is kept in the machine-scoped part of the state store.
+func (m *Manager) loadMachineSnapshot(snapshot *config.Snapshot) {
+	machine, err := m.state.LoadMachine()
+	if err != nil {
+		slog.Warn("Failed to load machine state", "error", err.Error())
+		return
+	}
+
+	if machine.Firewall != nil {
+		snapshot.RecordFirewall(machine.Firewall)
+	}
+}
+
+// recordMachineSnapshot saves the machine-wide state in the snapshot, such as the
+// firewall, to the machine-scoped part of the state store.
+func (m *Manager) recordMachineSnapshot() error {
+	fw := m.config.Snapshot.FirewallState()
+	if fw == nil {
+		return nil
+	}
+
+	return m.state.SaveMachine(&state.Machine{Firewall: fw})
+}
+
+// loadRuntimeConfig loads a previously recorded concierge runtime configuration.
+// CLI flags (DryRun, Trace, Verbose, Thorough) are preserved from the current config.
+func (m *Manager) loadRuntimeConfig() error {
+	record, err := m.readRuntimeConfig()
+	if err != nil {
+		return err
+	}
+	loadedConfig := record.Config
+
 	// Preserve CLI flags from current config
 	loadedConfig.DryRun = m.config.DryRun
 	loadedConfig.Trace = m.config.Trace
 	loadedConfig.Verbose = m.config.Verbose
+	loadedConfig.Thorough = m.config.Thorough
+	loadedConfig.Offline = m.config.Offline
+	loadedConfig.DryRunFormat = m.config.DryRunFormat
+	loadedConfig.Explain = m.config.Explain
+	loadedConfig.Output = m.config.Output
+	loadedConfig.Console = m.config.Console
+	loadedConfig.Report = m.config.Report
+	loadedConfig.JUnit = m.config.JUnit
+	loadedConfig.Metrics = m.config.Metrics
 
-	m.config = &loadedConfig
+	if loadedConfig.Snapshot == nil {
+		loadedConfig.Snapshot = config.NewSnapshot()
+	}
+	m.loadMachineSnapshot(loadedConfig.Snapshot)
 
-	slog.Debug("Loaded previous runtime configuration", "path", recordPath)
+	m.config = loadedConfig
 
+	m.recordMu.Lock()
+	m.record = record
+	m.recordMu.Unlock()
+
+	slog.Debug("Loaded previous runtime configuration")
 	return nil
 }
 
-// Status reads the concierge status on the machine.
-func (m *Manager) Status() (config.Status, error) {
-	recordPath := path.Join(".cache", "concierge", "concierge.yaml")
+// errNotPrepared is returned when there is no recorded state to report.
+var errNotPrepared = errors.New("concierge has not prepared this machine and cannot report its status")
 
-	contents, err := system.ReadHomeDirFile(m.system, recordPath)
-	if err != nil {
-		return 0, fmt.Errorf("concierge has not prepared this machine and cannot report its status")
+// ErrNotPrepared reports whether the error indicates that concierge has not prepared
+// the machine.
+func ErrNotPrepared(err error) bool { return errors.Is(err, errNotPrepared) }
+
+// StatusReport describes the outcome of the most recent 'prepare' for a user.
+type StatusReport struct {
+	User             string             `json:"user"`
+	Status           config.Status      `json:"status"`
+	ConciergeVersion string             `json:"concierge-version,omitempty"`
+	ConfigSource     string             `json:"config-source,omitempty"`
+	Started          time.Time          `json:"started,omitzero"`
+	Finished         time.Time          `json:"finished,omitzero"`
+	Updated          time.Time          `json:"updated,omitzero"`
+	Error            string             `json:"error,omitempty"`
+	Components       []*state.Component `json:"components"`
+}
+
+// newStatusReport builds a status report from a recorded run. A run that is still
+// recorded as provisioning, but whose concierge process has exited, was interrupted
+// and is reported as failed.
+func (m *Manager) newStatusReport(r *state.Record) *StatusReport {
+	report := &StatusReport{
+		User:             r.User,
+		Status:           r.Config.Status,
+		ConciergeVersion: r.ConciergeVersion,
+		ConfigSource:     r.Config.Source,
+		Started:          r.Started,
+		Finished:         r.Finished,
+		Updated:          r.Updated,
+		Error:            r.Error,
+		Components:       r.Components,
+	}
+
+	if report.Components == nil {
+		report.Components = []*state.Component{}
+	}
+
+	if report.Status == config.Provisioning && r.PID != 0 && !m.system.PathExists(fmt.Sprintf("/proc/%d", r.PID)) {
+		report.Status = config.Failed
+		report.Error = fmt.Sprintf("concierge process %d exited before provisioning finished", r.PID)
+	}
+
+	return report
+}
+
+// Status reports the state of concierge on the machine. If concierge has not run on
+// behalf of the real user, the state of its most recent run for any user is reported
+// instead, since provisioning affects the whole machine.
+func (m *Manager) Status() (*StatusReport, error) {
+	record, err := m.readRuntimeConfig()
+	if err == nil {
+		return m.newStatusReport(record), nil
+	}
+
+	records, listErr := m.state.List()
+	if listErr != nil || len(records) == 0 {
+		return nil, errNotPrepared
 	}
 
-	var config config.Config
-	err = yaml.Unmarshal(contents, &config)
+	slog.Info("Reporting status of concierge run for another user", "user", records[0].User)
+	return m.newStatusReport(records[0]), nil
+}
+
+// StatusAll reports the state recorded for every user concierge has run on behalf of,
+// most recently updated first.
+func (m *Manager) StatusAll() ([]*StatusReport, error) {
+	records, err := m.state.List()
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse file: %w", err)
+		return nil, err
+	}
+
+	reports := []*StatusReport{}
+	for _, r := range records {
+		reports = append(reports, m.newStatusReport(r))
+	}
+	return reports, nil
+}
+
+// statusPollInterval is how often WaitStatus checks the recorded state.
+var statusPollInterval = 5 * time.Second
+
+// WaitStatus blocks until concierge is no longer provisioning the machine, then reports
+// its state. If 'prepare' has not yet started, for example because it is yet to be run
+// by cloud-init, it is waited for too. A timeout of zero waits indefinitely.
+func (m *Manager) WaitStatus(timeout time.Duration) (*StatusReport, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
 	}
 
-	return config.Status, nil
+	for {
+		report, err := m.Status()
+		if err != nil && !ErrNotPrepared(err) {
+			return nil, err
+		}
+
+		if report != nil && report.Status != config.Provisioning {
+			return report, nil
+		}
+
+		wait := statusPollInterval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return nil, fmt.Errorf("timed out after %s waiting for concierge to finish provisioning", timeout)
+			}
+			wait = min(wait, remaining)
+		}
+
+		slog.Debug("Waiting for concierge to finish provisioning")
+		time.Sleep(wait)
+	}
 }
@@ -0,0 +1,317 @@
+package concierge
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/state"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func newTestManager(sys *system.MockSystem) *Manager {
+	return &Manager{
+		config: &config.Config{Source: "preset:dev"},
+		system: sys,
+		state:  state.NewStore(sys, "/var/lib/concierge"),
+	}
+}
+
+func TestManagerRecordsComponentProgress(t *testing.T) {
+	sys := system.NewMockSystem()
+	m := newTestManager(sys)
+
+	m.startRun(PrepareAction)
+	m.startRecord()
+	if err := m.recordRuntimeConfig(config.Provisioning); err != nil {
+		t.Fatal(err)
+	}
+
+	m.recordProgress(progress.Event{Type: progress.EventStart, Step: "snap:jhack"})
+	m.recordProgress(progress.Event{Type: progress.EventEnd, Step: "snap:jhack", Err: fmt.Errorf("boom")})
+
+	if err := m.recordOutcome(fmt.Errorf("failed to install snap")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Status != config.Failed || report.Error != "failed to install snap" || report.ConfigSource != "preset:dev" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	if report.ConciergeVersion != Version || report.Started.IsZero() || report.Finished.IsZero() {
+		t.Fatalf("expected version and timestamps to be recorded: %+v", report)
+	}
+
+	if len(report.Components) != 1 || report.Components[0].State != state.ComponentFailed || report.Components[0].Error != "boom" {
+		t.Fatalf("unexpected components: %+v", report.Components)
+	}
+}
+
+func TestManagerKeepsWebhookSecretsOutOfHomeDirectory(t *testing.T) {
+	t.Setenv("SECRET", "s3cret-token")
+
+	conf, err := config.Parse([]byte(`
+notify:
+  webhooks:
+    - url: https://hooks.example.com/services/T0/B0
+      headers:
+        Authorization: Bearer $SECRET
+`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sys := system.NewMockSystem()
+	m := newTestManager(sys)
+	m.config = conf
+
+	if err := m.recordRuntimeConfig(config.Provisioning); err != nil {
+		t.Fatal(err)
+	}
+
+	homeFile := filepath.Join(sys.User().HomeDir, runtimeConfigPath)
+	content, ok := sys.CreatedFiles[homeFile]
+	if !ok {
+		t.Fatalf("expected the runtime config to be written to %s, got: %v", homeFile, sys.CreatedFiles)
+	}
+	if strings.Contains(content, "s3cret-token") || strings.Contains(content, "hooks.example.com") {
+		t.Fatalf("expected webhooks to be kept out of the home directory, got:\n%s", content)
+	}
+
+	// The state store, which only root can read, still records them.
+	record, err := m.state.Load(sys.User().Username)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Config.Notify.Webhooks) != 1 || record.Config.Notify.Webhooks[0].Headers["Authorization"] != "Bearer s3cret-token" {
+		t.Fatalf("expected the webhook to be recorded in the state store, got: %+v", record.Config.Notify)
+	}
+}
+
+func TestManagerRecordsOnlyStepTransitions(t *testing.T) {
+	sys := system.NewMockSystem()
+	m := newTestManager(sys)
+
+	m.startRun(PrepareAction)
+	m.startRecord()
+	if err := m.recordRuntimeConfig(config.Provisioning); err != nil {
+		t.Fatal(err)
+	}
+
+	statePath := m.state.Path()
+	delete(sys.CreatedFiles, statePath)
+
+	// Commands, retries and output within a step do not change the record.
+	m.recordProgress(progress.Event{Type: progress.EventCommand, Step: "snap:jhack", Command: "snap install jhack"})
+	m.recordProgress(progress.Event{Type: progress.EventRetry, Step: "snap:jhack", Command: "snap install jhack", Attempt: 1})
+	m.recordProgress(progress.Event{Type: progress.EventOutput, Step: "snap:jhack", Message: "jhack installed"})
+
+	if _, ok := sys.CreatedFiles[statePath]; ok {
+		t.Fatal("expected the state store not to be rewritten within a step")
+	}
+
+	m.recordProgress(progress.Event{Type: progress.EventStart, Step: "snap:jhack"})
+
+	if _, ok := sys.CreatedFiles[statePath]; !ok {
+		t.Fatal("expected the state store to be rewritten when a step starts")
+	}
+}
+
+func TestManagerRecordsRunHistory(t *testing.T) {
+	sys := system.NewMockSystem()
+	m := newTestManager(sys)
+
+	m.startRun(PrepareAction)
+	m.recordProgress(progress.Event{Type: progress.EventStart, Step: "provider:lxd"})
+	m.recordProgress(progress.Event{Type: progress.EventEnd, Step: "provider:lxd"})
+	m.finishRun(nil)
+
+	m.startRun(RestoreAction)
+	m.recordProgress(progress.Event{Type: progress.EventStart, Step: "provider:lxd"})
+	m.recordProgress(progress.Event{Type: progress.EventEnd, Step: "provider:lxd", Err: fmt.Errorf("boom")})
+	m.finishRun(fmt.Errorf("failed to restore"))
+
+	runs, err := m.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != 2 {
+		t.Fatalf("expected 2 runs, got %d", len(runs))
+	}
+
+	prepare, restore := runs[0], runs[1]
+	if prepare.ID != 1 || prepare.Action != PrepareAction || prepare.Outcome != state.OutcomeSucceeded || prepare.User != "test-user" {
+		t.Fatalf("unexpected prepare run: %+v", prepare)
+	}
+
+	if restore.ID != 2 || restore.Outcome != state.OutcomeFailed || restore.Error != "failed to restore" {
+		t.Fatalf("unexpected restore run: %+v", restore)
+	}
+
+	if len(restore.Steps) != 1 || restore.Steps[0].State != state.ComponentFailed {
+		t.Fatalf("unexpected restore steps: %+v", restore.Steps)
+	}
+
+	if prepare.ConfigHash == "" || prepare.ConfigHash != restore.ConfigHash {
+		t.Fatalf("expected runs with the same config to share a hash: %s, %s", prepare.ConfigHash, restore.ConfigHash)
+	}
+}
+
+func TestManagerWritesReports(t *testing.T) {
+	sys := system.NewMockSystem()
+	m := newTestManager(sys)
+	dir := t.TempDir()
+	m.config.Report = filepath.Join(dir, "report.json")
+	m.config.JUnit = filepath.Join(dir, "junit.xml")
+	m.config.Metrics = filepath.Join(dir, "concierge.prom")
+	// Keep the test's own run out of any workflow it runs in.
+	t.Setenv("GITHUB_ACTIONS", "false")
+
+	unsubscribe := m.startReport(PrepareAction)
+	progress.Track("provider:lxd", func() error { return fmt.Errorf("boom") })
+	unsubscribe()
+	m.saveReport(fmt.Errorf("failed to prepare"))
+
+	content, err := os.ReadFile(m.config.Report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(m.config.Report); err != nil {
+		t.Fatal(err)
+	} else if stat, ok := info.Sys().(*syscall.Stat_t); ok && os.Geteuid() == 0 && stat.Uid != 666 {
+		t.Fatalf("expected the report to be owned by the real user, got uid %d", stat.Uid)
+	}
+	if !strings.Contains(string(content), `"id": "provider:lxd"`) || !strings.Contains(string(content), `"error": "boom"`) {
+		t.Fatalf("unexpected report: %s", content)
+	}
+
+	content, err = os.ReadFile(m.config.JUnit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `<testcase name="provider:lxd"`) {
+		t.Fatalf("unexpected junit report: %s", content)
+	}
+
+	content, err = os.ReadFile(m.config.Metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), `concierge_runs_total{action="prepare",status="failed"} 1`) ||
+		!strings.Contains(string(content), `concierge_step_success{action="prepare",step="provider:lxd",kind="provider"} 0`) {
+		t.Fatalf("unexpected metrics textfile: %s", content)
+	}
+}
+
+func TestManagerWritesGitHubActions(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GITHUB_ACTIONS", "true")
+	t.Setenv("GITHUB_STEP_SUMMARY", filepath.Join(dir, "summary.md"))
+	t.Setenv("GITHUB_OUTPUT", filepath.Join(dir, "output"))
+
+	conf, err := config.Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Overrides.LXDChannel = "5.21/stable"
+
+	sys := system.NewMockSystem()
+	m := newTestManager(sys)
+	m.config = conf
+	m.output = &strings.Builder{}
+	m.Plan = NewPlan(conf, sys)
+
+	unsubscribeReport := m.startReport(PrepareAction)
+	unsubscribeGroups := m.startGroups()
+	progress.Track("provider:lxd", func() error { return nil })
+	unsubscribeGroups()
+	unsubscribeReport()
+	m.saveReport(nil)
+
+	if !strings.Contains(m.output.(*strings.Builder).String(), "::group::provider:lxd (succeeded in 0s)\n") {
+		t.Fatalf("unexpected log groups: %s", m.output)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "summary.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "| `provider:lxd` | ✅ | 0s | 5.21/stable |") {
+		t.Fatalf("unexpected job summary: %s", content)
+	}
+
+	content, err = os.ReadFile(filepath.Join(dir, "output"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"controllers=concierge-k8s,concierge-lxd\n",
+		"kubeconfig=" + filepath.Join(sys.User().HomeDir, ".kube", "config") + "\n",
+		"status=succeeded\n",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("expected outputs to contain %q, got: %s", expected, content)
+		}
+	}
+}
+
+func TestManagerStatusReportsInterruptedRunAsFailed(t *testing.T) {
+	sys := system.NewMockSystem()
+	m := newTestManager(sys)
+
+	m.startRecord()
+	if err := m.recordRuntimeConfig(config.Provisioning); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recording process is still running.
+	sys.MockPath(fmt.Sprintf("/proc/%d", os.Getpid()))
+	report, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != config.Provisioning {
+		t.Fatalf("expected provisioning, got: %s", report.Status)
+	}
+
+	// The recording process has gone away without finishing.
+	sys = system.NewMockSystem()
+	sys.MockFile("/var/lib/concierge/state.yaml", []byte(readState(t, m)))
+	report, err = newTestManager(sys).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != config.Failed || report.Error == "" {
+		t.Fatalf("expected interrupted run to be reported as failed, got: %+v", report)
+	}
+}
+
+func TestManagerStatusNotPrepared(t *testing.T) {
+	m := newTestManager(system.NewMockSystem())
+
+	_, err := m.Status()
+	if !ErrNotPrepared(err) {
+		t.Fatalf("expected not prepared error, got: %v", err)
+	}
+}
+
+// readState returns the contents of the manager's state store.
+func readState(t *testing.T, m *Manager) string {
+	t.Helper()
+	content, err := m.system.ReadFile(m.state.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
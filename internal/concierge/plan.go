@@ -3,12 +3,18 @@ package concierge
 import (
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/juju"
 	"github.com/canonical/concierge/internal/packages"
+	"github.com/canonical/concierge/internal/progress"
 	"github.com/canonical/concierge/internal/providers"
 	"github.com/canonical/concierge/internal/system"
+	"github.com/canonical/concierge/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -26,7 +32,9 @@ type Plan struct {
 func NewPlan(cfg *config.Config, worker system.Worker) *Plan {
 	plan := &Plan{config: cfg, system: worker}
 
-	for name, snapConfig := range cfg.Host.Snaps {
+	// Snaps are added in name order, so that the plan is the same on every run.
+	for _, name := range slices.Sorted(maps.Keys(cfg.Host.Snaps)) {
+		snapConfig := cfg.Host.Snaps[name]
 		snap := system.NewSnap(name, snapConfig.Channel, snapConfig.Connections)
 		// Check if the channel has been overridden by a CLI argument/env var
 		channelOverride := getSnapChannelOverride(cfg, snap.Name)
@@ -52,7 +60,9 @@ func NewPlan(cfg *config.Config, worker system.Worker) *Plan {
 	}
 
 	for _, providerName := range providers.SupportedProviders {
-		if p := providers.NewProvider(providerName, worker, cfg); p != nil {
+		// Everything a provider runs is reported against its step.
+		step := progress.StepID(progress.KindProvider, providerName)
+		if p := providers.NewProvider(providerName, worker.WithStep(step), cfg); p != nil {
 			plan.Providers = append(plan.Providers, p)
 
 			// Warn if the configuration specifies to bootstrap the provider, but the config or
@@ -71,27 +81,33 @@ func NewPlan(cfg *config.Config, worker system.Worker) *Plan {
 }
 
 // Execute either prepares or restores a given plan
-func (p *Plan) Execute(action string) error {
-	err := p.validate()
+func (p *Plan) Execute(action string) (err error) {
+	endRun := telemetry.StartRun(action, p.stepAttributes)
+	defer func() { endRun(err) }()
+
+	err = p.validate(action)
 	if err != nil {
 		return fmt.Errorf("failed to validate plan: %w", err)
 	}
 
-	var eg errgroup.Group
+	eg := system.NewGroup(p.system)
 
 	snapHandler := packages.NewSnapHandler(p.system, p.Snaps)
 	debHandler := packages.NewDebHandler(p.system, p.Debs)
 
 	// Prepare/restore package handlers concurrently
-	eg.Go(func() error { return DoAction(snapHandler, action) })
-	eg.Go(func() error { return DoAction(debHandler, action) })
+	eg.Go(func() error { return doTracedAction(progress.KindSnap, "SnapHandler", snapHandler, action) })
+	eg.Go(func() error { return doTracedAction(progress.KindDeb, "DebHandler", debHandler, action) })
 	if err := eg.Wait(); err != nil {
 		return err
 	}
 
 	// Prepare/restore providers concurrently
 	for _, provider := range p.Providers {
-		eg.Go(func() error { return DoAction(provider, action) })
+		eg.Go(func() error {
+			step := progress.StepID(progress.KindProvider, provider.Name())
+			return progress.Track(step, func() error { return DoAction(provider, action) })
+		})
 	}
 	if err := eg.Wait(); err != nil {
 		return err
@@ -104,7 +120,7 @@ func (p *Plan) Execute(action string) error {
 
 	// Prepare/Restore juju controllers
 	jujuHandler := juju.NewJujuHandler(p.config, p.system, p.Providers)
-	err = DoAction(jujuHandler, action)
+	err = doTracedAction(progress.KindJuju, "JujuHandler", jujuHandler, action)
 	if err != nil {
 		return fmt.Errorf("failed to prepare Juju: %w", err)
 	}
@@ -112,14 +128,167 @@ func (p *Plan) Execute(action string) error {
 	return nil
 }
 
+// doTracedAction performs the action with a handler, recording a span named for the
+// handler and action, such as "SnapHandler.Prepare", beneath which the handler's steps
+// of the specified kind are recorded.
+func doTracedAction(kind, name string, executable Executable, action string) error {
+	end := telemetry.StartHandler(kind, name+"."+strings.ToUpper(action[:1])+action[1:])
+	err := DoAction(executable, action)
+	end(err)
+	return err
+}
+
+// stepAttributes describes a step of the plan for tracing: the channel of a snap, the
+// provider set up by a provider step, or the provider and action of a Juju step.
+func (p *Plan) stepAttributes(step string) []attribute.KeyValue {
+	kind, name, _ := strings.Cut(step, ":")
+	switch kind {
+	case progress.KindSnap:
+		attributes := []attribute.KeyValue{attribute.String("snap.name", name)}
+		if s := p.snap(name); s != nil && s.Channel != "" {
+			return append(attributes, attribute.String("snap.channel", s.Channel))
+		}
+		return attributes
+	case progress.KindDeb:
+		return []attribute.KeyValue{attribute.String("deb.name", name)}
+	case progress.KindProvider:
+		return []attribute.KeyValue{attribute.String("provider", name)}
+	case progress.KindJuju:
+		jujuAction, provider, _ := strings.Cut(name, ":")
+		return []attribute.KeyValue{attribute.String("juju.action", jujuAction), attribute.String("provider", provider)}
+	}
+	return nil
+}
+
+// StepVersion describes the version a step of the plan installs, for the GitHub Actions
+// job summary: the channel or revision of a snap, or of the snap of a provider or of
+// Juju. It returns "" for steps with no version to report, such as debs.
+func (p *Plan) StepVersion(step string) string {
+	kind, name, _ := strings.Cut(step, ":")
+	switch kind {
+	case progress.KindProvider:
+		// Providers installed by a snap, such as lxd, share its name.
+	case progress.KindJuju:
+		name = "juju"
+	case progress.KindSnap:
+	default:
+		return ""
+	}
+
+	s := p.snap(name)
+	switch {
+	case s == nil:
+		return ""
+	case s.Revision != "":
+		return "revision " + s.Revision
+	default:
+		return s.Channel
+	}
+}
+
+// Outputs describes what the plan provisions for the GitHub Actions step outputs: the
+// name of each Juju controller bootstrapped, and the path of the kubeconfig file if a
+// Kubernetes provider was prepared.
+func (p *Plan) Outputs() map[string]string {
+	env := p.Environment()
+
+	controllers := []string{}
+	outputs := map[string]string{}
+	for _, c := range env.Controllers {
+		controllers = append(controllers, c.Name)
+		outputs["controller-"+c.Provider] = c.Name
+	}
+	outputs["controllers"] = strings.Join(controllers, ",")
+
+	if env.Kubeconfig != "" {
+		outputs["kubeconfig"] = env.Kubeconfig
+	}
+
+	return outputs
+}
+
+// snap returns the snap with the specified name installed by the plan, or nil if there
+// is none.
+func (p *Plan) snap(name string) *system.Snap {
+	for _, s := range p.allSnaps() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// allSnaps returns every snap installed by the plan, whether listed in the config or
+// installed by a provider or by Juju.
+func (p *Plan) allSnaps() []*system.Snap {
+	snaps := slices.Clone(p.Snaps)
+	for _, provider := range p.Providers {
+		if installer, ok := provider.(SnapInstaller); ok {
+			snaps = append(snaps, installer.Snaps()...)
+		}
+	}
+	if !p.config.Juju.Disable {
+		snaps = append(snaps, juju.NewJujuHandler(p.config, p.system, p.Providers).Snaps()...)
+	}
+	return snaps
+}
+
+// Leftovers inspects the machine for any state that the plan creates during 'prepare'
+// which still exists. It is intended to be run after the plan has been restored.
+func (p *Plan) Leftovers() []system.Leftover {
+	inspectors := []Inspector{
+		packages.NewSnapHandler(p.system, p.Snaps),
+		packages.NewDebHandler(p.system, p.Debs),
+	}
+
+	for _, provider := range p.Providers {
+		if i, ok := provider.(Inspector); ok {
+			inspectors = append(inspectors, i)
+		}
+	}
+
+	if !p.config.Juju.Disable {
+		inspectors = append(inspectors, juju.NewJujuHandler(p.config, p.system, p.Providers))
+	}
+
+	leftovers := []system.Leftover{}
+	for _, i := range inspectors {
+		leftovers = append(leftovers, i.Leftovers()...)
+	}
+
+	return leftovers
+}
+
+// Checks checks the health of each provider, and of each Juju controller, that the plan
+// provisions. Providers with nothing to check, such as Google, are skipped.
+func (p *Plan) Checks() []system.Check {
+	checkers := []Checker{}
+	for _, provider := range p.Providers {
+		if c, ok := provider.(Checker); ok {
+			checkers = append(checkers, c)
+		}
+	}
+
+	if !p.config.Juju.Disable {
+		checkers = append(checkers, juju.NewJujuHandler(p.config, p.system, p.Providers))
+	}
+
+	checks := []system.Check{}
+	for _, c := range checkers {
+		checks = append(checks, c.Checks()...)
+	}
+	return checks
+}
+
 // validate returns an error if the generated plan contains errors that would prevent a successful
-// configuration of the machine.
-func (p *Plan) validate() error {
+// configuration of the machine when executed for the action. An empty action validates a
+// plan that is only being described.
+func (p *Plan) validate(action string) error {
 	var eg errgroup.Group
 
 	// Run the validators in parallel in an errgroup
 	for _, v := range planValidators {
-		eg.Go(func() error { return v(p) })
+		eg.Go(func() error { return v(p, action) })
 	}
 	if err := eg.Wait(); err != nil {
 		return err
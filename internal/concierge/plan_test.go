@@ -1,10 +1,13 @@
 package concierge
 
 import (
+	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
 )
 
 func TestGetSnapChannelOverride(t *testing.T) {
@@ -32,3 +35,134 @@ func TestGetSnapChannelOverride(t *testing.T) {
 		}
 	}
 }
+
+func TestPlanDryRunOffline(t *testing.T) {
+	for _, name := range config.ValidPresets() {
+		t.Run(name, func(t *testing.T) {
+			conf, err := config.Preset(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conf.Snapshot = config.NewSnapshot()
+			// As in the Manager, the plan is told it is offline.
+			conf.Offline = true
+
+			sys := system.NewMockSystem()
+			var out bytes.Buffer
+			worker := system.NewDryRunWorker(sys, system.WithOutput(&out), system.WithOffline(), system.WithScript())
+
+			if err := NewPlan(conf, worker).Execute(PrepareAction); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(sys.ExecutedCommands) != 0 {
+				t.Fatalf("expected no commands to reach the machine offline, got: %v", sys.ExecutedCommands)
+			}
+
+			// Each enabled provider is bootstrapped once, since the simulated machine
+			// has no controllers.
+			bootstraps := strings.Count(out.String(), " juju bootstrap ")
+			expected := 0
+			for _, p := range []bool{conf.Providers.LXD.Bootstrap, conf.Providers.K8s.Bootstrap, conf.Providers.MicroK8s.Bootstrap, conf.Providers.Google.Bootstrap} {
+				if p && !conf.Juju.Disable {
+					expected++
+				}
+			}
+			if bootstraps != expected {
+				t.Fatalf("expected %d bootstraps, got %d:\n%s", expected, bootstraps, out.String())
+			}
+
+			if conf.Providers.K8s.Enable && strings.Count(out.String(), "\nk8s bootstrap") != 1 {
+				t.Fatalf("expected k8s to be bootstrapped once, got:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestPlanExplain(t *testing.T) {
+	conf, err := config.Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Overrides.LXDChannel = "5.21/stable"
+	conf.Provenance.Set("providers.lxd.channel", config.Origin{Kind: config.OriginFlag, Name: "lxd-channel"})
+
+	plan := NewPlan(conf, system.NewMockSystem())
+
+	explain := func(step string) []string {
+		reasons := []string{}
+		for _, r := range plan.Explain(step) {
+			reasons = append(reasons, r.String())
+		}
+		return reasons
+	}
+
+	type test struct {
+		step     string
+		expected []string
+	}
+
+	tests := []test{
+		{step: "snap:jhack", expected: []string{"host.snaps.jhack (preset dev.yaml:34)"}},
+		{step: "deb:python3-pip", expected: []string{"host.packages.python3-pip (preset dev.yaml:25)"}},
+		{step: "snap:lxd", expected: []string{
+			"providers.lxd.enable=true (preset dev.yaml:8)",
+			"providers.lxd.channel=5.21/stable (flag --lxd-channel)",
+		}},
+		{step: "provider:k8s", expected: []string{
+			"providers.k8s.enable=true (preset dev.yaml:11)",
+			"providers.k8s.channel=1.32-classic/stable (default: defaultK8sChannel)",
+		}},
+		{step: "deb:iptables", expected: []string{"providers.k8s.enable=true (preset dev.yaml:11)"}},
+		{step: "juju:bootstrap:lxd", expected: []string{"providers.lxd.bootstrap=true (preset dev.yaml:9)"}},
+	}
+
+	for _, tc := range tests {
+		if got := explain(tc.step); !reflect.DeepEqual(got, tc.expected) {
+			t.Fatalf("unexpected reasons for %s:\nwant: %v\ngot:  %v", tc.step, tc.expected, got)
+		}
+	}
+}
+
+func TestPlanDescribe(t *testing.T) {
+	conf, err := config.Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewPlan(conf, system.NewMockSystem()).Describe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.Juju || len(d.Environment.Controllers) != 2 {
+		t.Fatalf("expected juju and 2 controllers, got: %+v", d)
+	}
+
+	snaps := map[string]*PlannedSnap{}
+	for _, s := range d.Snaps {
+		if snaps[s.Name] != nil {
+			t.Fatalf("expected snap %s to be described once", s.Name)
+		}
+		snaps[s.Name] = s
+	}
+	for _, name := range []string{"jhack", "lxd", "k8s", "juju"} {
+		if snaps[name] == nil {
+			t.Fatalf("expected snap %s to be described, got: %v", name, d.Snaps)
+		}
+	}
+	if expected := []string{"host.snaps.jhack (preset dev.yaml:34)"}; !reflect.DeepEqual(snaps["jhack"].Reasons, expected) {
+		t.Fatalf("unexpected reasons for jhack: %v", snaps["jhack"].Reasons)
+	}
+
+	providers := []string{}
+	for _, p := range d.Providers {
+		if !p.Bootstrap {
+			t.Fatalf("expected provider %s to be bootstrapped", p.Name)
+		}
+		providers = append(providers, p.Name)
+	}
+	if !reflect.DeepEqual(providers, []string{"k8s", "lxd"}) {
+		t.Fatalf("unexpected providers: %v", providers)
+	}
+}
@@ -2,17 +2,23 @@ package concierge
 
 import (
 	"fmt"
+	"net/url"
 	"slices"
+
+	"github.com/canonical/concierge/internal/notify"
 )
 
-// planValidators is a list of planValidators used to verify a plan
-var planValidators = []func(p *Plan) error{
+// planValidators is a list of planValidators used to verify a plan, along with the
+// action it is executed for, or an empty action if it is only being described.
+var planValidators = []func(p *Plan, action string) error{
 	validateSingleLocalKubernetesInstance,
+	validateWebhooks,
+	validatePreflight,
 }
 
 // validateSingleLocalKubernetesInstance ensures the plan won't try and install multiple
 // local Kubernetes providers, which would conflict.
-func validateSingleLocalKubernetesInstance(plan *Plan) error {
+func validateSingleLocalKubernetesInstance(plan *Plan, _ string) error {
 	providerNames := []string{}
 
 	for _, p := range plan.Providers {
@@ -25,3 +31,16 @@ func validateSingleLocalKubernetesInstance(plan *Plan) error {
 
 	return nil
 }
+
+// validateWebhooks ensures that each webhook to notify has an HTTP or HTTPS URL, such
+// that a typo is reported before a long run rather than after it.
+func validateWebhooks(plan *Plan, _ string) error {
+	for _, webhook := range plan.config.Notify.Webhooks {
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid webhook url '%s', must be an http or https url", notify.Redact(webhook.URL))
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package concierge
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/canonical/concierge/internal/config"
@@ -15,7 +16,7 @@ func TestSingleK8sValidator(t *testing.T) {
 	twoK8s.Providers.MicroK8s.Enable = true
 
 	plan := NewPlan(twoK8s, system)
-	err := plan.validate()
+	err := plan.validate(PrepareAction)
 	if err == nil {
 		t.Fatalf("should not allow enabling two local kubernetes providers")
 	}
@@ -23,7 +24,7 @@ func TestSingleK8sValidator(t *testing.T) {
 	justK8s := &config.Config{}
 	justK8s.Providers.K8s.Enable = true
 	plan = NewPlan(justK8s, system)
-	err = plan.validate()
+	err = plan.validate(PrepareAction)
 	if err != nil {
 		t.Fatalf("single kubernetes provider should be permitted")
 	}
@@ -31,9 +32,28 @@ func TestSingleK8sValidator(t *testing.T) {
 	justMicroK8s := &config.Config{}
 	justMicroK8s.Providers.MicroK8s.Enable = true
 	plan = NewPlan(justMicroK8s, system)
-	err = plan.validate()
+	err = plan.validate(PrepareAction)
 	if err != nil {
 		t.Fatalf("single kubernetes provider should be permitted")
 	}
 
 }
+
+func TestWebhooksValidator(t *testing.T) {
+	system := system.NewMockSystem()
+
+	conf := &config.Config{}
+	conf.Notify.Webhooks = []config.WebhookConfig{{URL: "https://hooks.example.com/services/T0/B0/secret"}}
+	if err := NewPlan(conf, system).validate(PrepareAction); err != nil {
+		t.Fatalf("https webhook should be permitted: %v", err)
+	}
+
+	conf.Notify.Webhooks = append(conf.Notify.Webhooks, config.WebhookConfig{URL: "hooks.example.com/secret"})
+	err := NewPlan(conf, system).validate(PrepareAction)
+	if err == nil {
+		t.Fatalf("webhook without a scheme should not be permitted")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("webhook url should be redacted, got: %v", err)
+	}
+}
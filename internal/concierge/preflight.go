@@ -0,0 +1,381 @@
+package concierge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+)
+
+const (
+	// minUbuntuRelease is the oldest release of Ubuntu that concierge supports.
+	minUbuntuRelease = "22.04"
+	// minKernelVersion is the oldest kernel the providers are known to work with, being
+	// that of Ubuntu 22.04.
+	minKernelVersion = "5.15"
+	// minSnapdVersion is the oldest release of snapd that concierge supports.
+	minSnapdVersion = "2.58"
+)
+
+// baseRequirements is what concierge needs of the machine to install its snaps and
+// debs, whatever the providers.
+var baseRequirements = providers.Requirements{Disk: 2 << 30, CPUs: 1}
+
+// supportedArchitectures are the machine architectures, as reported by `uname -m`, for
+// which snaps of the providers and Juju are published.
+var supportedArchitectures = []string{"x86_64", "aarch64", "ppc64le", "s390x", "riscv64"}
+
+// snapPaths are the directories whose filesystems hold snaps and their data.
+var snapPaths = []string{"/var/lib", "/var/snap"}
+
+// validatePreflight checks, before 'prepare' makes any changes, that the machine has
+// the disk, memory and CPUs the providers need, runs a supported OS, architecture and
+// release of snapd, and is not running services that conflict with the providers.
+// Failed checks are reported together; warnings are logged. The checks are skipped
+// when planning offline, since the machine being planned for is not this one.
+func validatePreflight(plan *Plan, action string) error {
+	if action != PrepareAction || plan.config.SkipPreflight || plan.config.Offline {
+		return nil
+	}
+
+	failed := []string{}
+	for _, c := range plan.preflight() {
+		switch c.Status {
+		case system.CheckFail:
+			failed = append(failed, fmt.Sprintf("%s: %s", c.Name, c.Detail))
+		case system.CheckWarn:
+			slog.Warn("Preflight check did not pass", "check", c.Name, "detail", c.Detail)
+		default:
+			slog.Debug("Preflight check passed", "check", c.Name, "detail", c.Detail)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("preflight checks failed: %s; use '--skip-preflight' to prepare the machine regardless",
+			strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// requirement is a minimum, along with the name of what needs it.
+type requirement struct {
+	value uint64
+	by    string
+}
+
+// preflight checks the machine against what the plan needs of it, reading each fact
+// through the plan's worker. The snaps of every provider are installed on the same
+// filesystems, so the machine is checked against the sum of their disk requirements.
+// Where several providers need memory or CPUs, the machine is checked against the
+// largest of their minimums.
+func (p *Plan) preflight() []system.Check {
+	disk := requirement{baseRequirements.Disk, "concierge"}
+	diskUsers := []string{"concierge"}
+	memory := requirement{baseRequirements.Memory, "concierge"}
+	cpus := requirement{uint64(baseRequirements.CPUs), "concierge"}
+	conflicts := []providers.Conflict{}
+
+	for _, provider := range p.Providers {
+		r, ok := provider.(Requirer)
+		if !ok {
+			continue
+		}
+		reqs := r.Requirements()
+		if reqs.Disk > 0 {
+			disk.value += reqs.Disk
+			diskUsers = append(diskUsers, provider.Name())
+		}
+		if reqs.Memory > memory.value {
+			memory = requirement{reqs.Memory, provider.Name()}
+		}
+		if uint64(reqs.CPUs) > cpus.value {
+			cpus = requirement{uint64(reqs.CPUs), provider.Name()}
+		}
+		for _, c := range reqs.Conflicts {
+			if !slices.ContainsFunc(conflicts, func(o providers.Conflict) bool { return o.Service == c.Service }) {
+				conflicts = append(conflicts, c)
+			}
+		}
+	}
+
+	disk.by = joinNames(diskUsers)
+
+	checks := []system.Check{}
+	for _, dir := range snapPaths {
+		// /var/snap is created when snapd is installed.
+		if p.system.PathExists(dir) {
+			checks = append(checks, p.checkDisk(dir, disk))
+		}
+	}
+	checks = append(checks,
+		p.checkMemory(memory),
+		p.checkCPUs(cpus),
+		p.checkOS(),
+		p.checkArch(),
+		p.checkKernel(),
+		p.checkSnapd(),
+	)
+	for _, c := range conflicts {
+		checks = append(checks, p.checkConflict(c))
+	}
+
+	return checks
+}
+
+// preflightCheck returns a check with the specified name and outcome.
+func preflightCheck(name, status, format string, args ...any) system.Check {
+	return system.Check{Component: "preflight", Name: name, Status: status, Detail: fmt.Sprintf(format, args...)}
+}
+
+// runProbe runs a read-only command, returning its trimmed output.
+func (p *Plan) runProbe(executable string, args ...string) (string, error) {
+	cmd := system.NewCommand(executable, args)
+	cmd.ReadOnly = true
+	output, err := p.system.Run(cmd)
+	return strings.TrimSpace(string(output)), err
+}
+
+// checkDisk checks the free space on the filesystem holding the specified directory.
+func (p *Plan) checkDisk(dir string, req requirement) system.Check {
+	name := "disk:" + dir
+
+	// The output is the number of blocks available to unprivileged users, and the size
+	// of each block.
+	output, err := p.runProbe("stat", "-f", "-c", "%a %S", dir)
+	if err != nil {
+		return preflightCheck(name, system.CheckWarn, "failed to determine the free space on %s: %s", dir, err)
+	}
+
+	var blocks, size uint64
+	if _, err := fmt.Sscanf(output, "%d %d", &blocks, &size); err != nil {
+		return preflightCheck(name, system.CheckWarn, "failed to determine the free space on %s: %s", dir, err)
+	}
+	avail := blocks * size
+
+	if avail < req.value {
+		return preflightCheck(name, system.CheckFail, "%s has %s free, but %s is needed by %s",
+			dir, formatGiB(avail), formatGiB(req.value), req.by)
+	}
+	return preflightCheck(name, system.CheckPass, "%s has %s free", dir, formatGiB(avail))
+}
+
+// checkMemory checks the total memory of the machine. The kernel reserves some memory
+// for itself before reporting the total, so a shortfall of up to 10% is allowed.
+func (p *Plan) checkMemory(req requirement) system.Check {
+	contents, err := p.system.ReadFile("/proc/meminfo")
+	if err != nil {
+		return preflightCheck("memory", system.CheckWarn, "failed to determine the memory of the machine: %s", err)
+	}
+
+	// The total is reported on a line of the form `MemTotal:   16303868 kB`.
+	var total uint64
+	for line := range strings.SplitSeq(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "MemTotal:" && fields[2] == "kB" {
+			total, err = strconv.ParseUint(fields[1], 10, 64)
+			total *= 1024
+			break
+		}
+	}
+	if total == 0 || err != nil {
+		return preflightCheck("memory", system.CheckWarn, "failed to determine the memory of the machine")
+	}
+
+	if total*10 < req.value*9 {
+		return preflightCheck("memory", system.CheckFail, "the machine has %s of memory, but %s needs at least %s",
+			formatGiB(total), req.by, formatGiB(req.value))
+	}
+	return preflightCheck("memory", system.CheckPass, "the machine has %s of memory", formatGiB(total))
+}
+
+// checkCPUs checks the number of CPUs available to concierge.
+func (p *Plan) checkCPUs(req requirement) system.Check {
+	output, err := p.runProbe("nproc")
+	if err != nil {
+		return preflightCheck("cpus", system.CheckWarn, "failed to determine the CPUs of the machine: %s", err)
+	}
+
+	cpus, err := strconv.ParseUint(output, 10, 64)
+	if err != nil {
+		return preflightCheck("cpus", system.CheckWarn, "failed to determine the CPUs of the machine: %s", err)
+	}
+
+	if cpus < req.value {
+		return preflightCheck("cpus", system.CheckFail, "the machine has %d CPUs, but %s needs at least %d",
+			cpus, req.by, req.value)
+	}
+	return preflightCheck("cpus", system.CheckPass, "the machine has %d CPUs", cpus)
+}
+
+// checkOS checks that the machine runs a supported release of Ubuntu. Other
+// distributions may work, since the providers are installed as snaps, so they are
+// only warned about.
+func (p *Plan) checkOS() system.Check {
+	contents, err := p.system.ReadFile("/etc/os-release")
+	if err != nil {
+		return preflightCheck("os", system.CheckWarn, "failed to determine the OS of the machine: %s", err)
+	}
+
+	release := map[string]string{}
+	for line := range strings.SplitSeq(string(contents), "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			release[key] = strings.Trim(value, `"'`)
+		}
+	}
+
+	name := release["PRETTY_NAME"]
+	if name == "" {
+		name = release["ID"]
+	}
+
+	if release["ID"] != "ubuntu" {
+		return preflightCheck("os", system.CheckWarn, "%s is not Ubuntu, on which concierge is supported", name)
+	}
+
+	ok, err := versionAtLeast(release["VERSION_ID"], minUbuntuRelease)
+	switch {
+	case err != nil:
+		return preflightCheck("os", system.CheckWarn, "failed to determine the release of Ubuntu: %s", err)
+	case !ok:
+		return preflightCheck("os", system.CheckFail, "%s is not supported, concierge needs Ubuntu %s or later",
+			name, minUbuntuRelease)
+	}
+	return preflightCheck("os", system.CheckPass, "%s is supported", name)
+}
+
+// checkArch checks that snaps of the providers and Juju are published for the machine's
+// architecture.
+func (p *Plan) checkArch() system.Check {
+	arch, err := p.runProbe("uname", "-m")
+	if err != nil {
+		return preflightCheck("arch", system.CheckWarn, "failed to determine the architecture of the machine: %s", err)
+	}
+	if arch == "" {
+		return preflightCheck("arch", system.CheckWarn, "failed to determine the architecture of the machine")
+	}
+
+	if !slices.Contains(supportedArchitectures, arch) {
+		return preflightCheck("arch", system.CheckFail, "%s is not supported, concierge supports %s",
+			arch, strings.Join(supportedArchitectures, ", "))
+	}
+	return preflightCheck("arch", system.CheckPass, "%s is supported", arch)
+}
+
+// checkKernel checks the version of the running kernel. Older kernels may lack features
+// the providers rely on, so they are only warned about.
+func (p *Plan) checkKernel() system.Check {
+	release, err := p.runProbe("uname", "-r")
+	if err != nil {
+		return preflightCheck("kernel", system.CheckWarn, "failed to determine the kernel version: %s", err)
+	}
+
+	ok, err := versionAtLeast(release, minKernelVersion)
+	switch {
+	case err != nil:
+		return preflightCheck("kernel", system.CheckWarn, "failed to determine the kernel version: %s", err)
+	case !ok:
+		return preflightCheck("kernel", system.CheckWarn, "kernel %s is older than %s, which the providers are known to work with",
+			release, minKernelVersion)
+	}
+	return preflightCheck("kernel", system.CheckPass, "kernel %s is supported", release)
+}
+
+// checkSnapd checks that snapd is installed, and is recent enough.
+func (p *Plan) checkSnapd() system.Check {
+	output, err := p.runProbe("snap", "version")
+	if errors.Is(err, system.ErrNotInstalled) {
+		return preflightCheck("snapd", system.CheckFail, "snapd is not installed, install it with 'sudo apt install snapd'")
+	} else if err != nil {
+		return preflightCheck("snapd", system.CheckWarn, "failed to determine the version of snapd: %s", err)
+	}
+
+	// The output has a line of the form `snapd    2.66.1+24.04`.
+	version := ""
+	for line := range strings.SplitSeq(output, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "snapd" {
+			version = fields[1]
+		}
+	}
+
+	ok, err := versionAtLeast(version, minSnapdVersion)
+	switch {
+	case err != nil:
+		return preflightCheck("snapd", system.CheckWarn, "failed to determine the version of snapd: %s", err)
+	case !ok:
+		return preflightCheck("snapd", system.CheckFail, "snapd %s is not supported, concierge needs %s or later",
+			version, minSnapdVersion)
+	}
+	return preflightCheck("snapd", system.CheckPass, "snapd %s is supported", version)
+}
+
+// checkConflict checks that a service that conflicts with a provider is not running.
+func (p *Plan) checkConflict(conflict providers.Conflict) system.Check {
+	name := "conflict:" + conflict.Service
+
+	// `systemctl is-active` exits non-zero for inactive and unknown units, printing
+	// their state; any other failure means the state of the service is not known.
+	cmd := system.NewCommand("systemctl", []string{"is-active", conflict.Service})
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `^(inactive|failed|unknown|activating|deactivating|maintenance)\s*$`
+	output, err := p.system.Run(cmd)
+	if err != nil && !cmd.IsExpectedError(output) {
+		return preflightCheck(name, system.CheckWarn, "failed to determine whether %s is running: %s", conflict.Service, err)
+	}
+
+	if strings.TrimSpace(string(output)) == "active" {
+		return preflightCheck(name, conflict.Status, "%s is running: %s; stop it with 'sudo systemctl disable --now %s'",
+			conflict.Service, conflict.Reason, conflict.Service)
+	}
+	return preflightCheck(name, system.CheckPass, "%s is not running", conflict.Service)
+}
+
+// versionAtLeast reports whether a dotted version, such as "2.66.1+24.04" or
+// "6.8.0-45-generic", is at least the minimum. Only the leading numeric components are
+// compared.
+func versionAtLeast(version, minimum string) (bool, error) {
+	v, err := versionComponents(version)
+	if err != nil {
+		return false, err
+	}
+	m, err := versionComponents(minimum)
+	if err != nil {
+		return false, err
+	}
+	return slices.Compare(v, m) >= 0, nil
+}
+
+// versionComponents returns the leading numeric components of a dotted version.
+func versionComponents(version string) ([]int, error) {
+	end := strings.IndexFunc(version, func(r rune) bool { return r != '.' && (r < '0' || r > '9') })
+	if end == -1 {
+		end = len(version)
+	}
+
+	components := []int{}
+	for part := range strings.SplitSeq(strings.Trim(version[:end], "."), ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version '%s'", version)
+		}
+		components = append(components, n)
+	}
+	return components, nil
+}
+
+// joinNames joins names into a list of the form "a, b and c".
+func joinNames(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// formatGiB formats a number of bytes in gibibytes.
+func formatGiB(bytes uint64) string {
+	return fmt.Sprintf("%.1f GiB", float64(bytes)/(1<<30))
+}
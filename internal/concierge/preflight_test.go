@@ -0,0 +1,192 @@
+package concierge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+const snapVersion = `snap    2.66.1+24.04
+snapd   2.66.1+24.04
+series  16
+ubuntu  24.04
+kernel  6.8.0-45-generic
+`
+
+const osRelease = `PRETTY_NAME="Ubuntu 24.04.1 LTS"
+NAME="Ubuntu"
+VERSION_ID="24.04"
+ID=ubuntu
+`
+
+// mockPreflight makes the MockSystem resemble a machine that passes the preflight
+// checks of every preset: an up-to-date Ubuntu machine with 50 GiB of free disk, 16 GiB
+// of memory and 4 CPUs.
+func mockPreflight(sys *system.MockSystem) {
+	for _, dir := range snapPaths {
+		sys.MockPath(dir)
+		sys.MockCommandReturn("stat -f -c '%a %S' "+dir, []byte("13107200 4096\n"), nil)
+	}
+	sys.MockFile("/proc/meminfo", []byte("MemTotal:       16303868 kB\nMemFree:         8151934 kB\n"))
+	sys.MockFile("/etc/os-release", []byte(osRelease))
+	sys.MockCommandReturn("nproc", []byte("4\n"), nil)
+	sys.MockCommandReturn("uname -m", []byte("x86_64\n"), nil)
+	sys.MockCommandReturn("uname -r", []byte("6.8.0-45-generic\n"), nil)
+	sys.MockCommandReturn("snap version", []byte(snapVersion), nil)
+}
+
+func TestPreflight(t *testing.T) {
+	conf, err := config.Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sys := system.NewMockSystem()
+	mockPreflight(sys)
+
+	checks := NewPlan(conf, sys).preflight()
+
+	names := []string{}
+	for _, c := range checks {
+		if c.Status != system.CheckPass {
+			t.Errorf("expected check %s to pass, got: %+v", c.Name, c)
+		}
+		names = append(names, c.Name)
+	}
+
+	expected := "disk:/var/lib disk:/var/snap memory cpus os arch kernel snapd " +
+		"conflict:snap.microk8s.daemon-kubelite.service conflict:k3s.service conflict:docker.service"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("expected checks: %s, got: %s", expected, strings.Join(names, " "))
+	}
+}
+
+func TestPreflightValidator(t *testing.T) {
+	conf, err := config.Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sys := system.NewMockSystem()
+	mockPreflight(sys)
+	sys.MockCommandReturn("stat -f -c '%a %S' /var/snap", []byte("2097152 4096\n"), nil)
+	sys.MockFile("/proc/meminfo", []byte("MemTotal:        2015436 kB\n"))
+	sys.MockCommandReturn("systemctl is-active docker.service", []byte("active\n"), nil)
+
+	plan := NewPlan(conf, sys)
+
+	err = validatePreflight(plan, PrepareAction)
+	if err == nil {
+		t.Fatal("expected the preflight checks to fail")
+	}
+
+	for _, expected := range []string{
+		"disk:/var/snap: /var/snap has 8.0 GiB free, but 17.0 GiB is needed by concierge, k8s and lxd",
+		"memory: the machine has 1.9 GiB of memory, but k8s needs at least 4.0 GiB",
+		"--skip-preflight",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got: %s", expected, err)
+		}
+	}
+	// Docker only warns, since concierge works around its firewall rules.
+	if strings.Contains(err.Error(), "docker") {
+		t.Errorf("expected docker not to fail the checks, got: %s", err)
+	}
+
+	conf.SkipPreflight = true
+	if err := validatePreflight(plan, PrepareAction); err != nil {
+		t.Fatalf("expected the checks to be skipped, got: %s", err)
+	}
+
+	conf.SkipPreflight = false
+	if err := validatePreflight(plan, RestoreAction); err != nil {
+		t.Fatalf("expected the checks to be skipped when restoring, got: %s", err)
+	}
+}
+
+func TestPreflightUnsupportedMachine(t *testing.T) {
+	conf := &config.Config{}
+
+	sys := system.NewMockSystem()
+	mockPreflight(sys)
+	sys.MockFile("/etc/os-release", []byte("PRETTY_NAME=\"Ubuntu 20.04.6 LTS\"\nVERSION_ID=\"20.04\"\nID=ubuntu\n"))
+	sys.MockCommandReturn("uname -m", []byte("i686\n"), nil)
+	sys.MockCommandReturn("uname -r", []byte("5.4.0-200-generic\n"), nil)
+	sys.MockCommandReturn("snap version", []byte("snap    2.57\nsnapd   2.57\n"), nil)
+
+	statuses := map[string]string{}
+	for _, c := range NewPlan(conf, sys).preflight() {
+		statuses[c.Name] = c.Status
+	}
+
+	expected := map[string]string{
+		"os":     system.CheckFail,
+		"arch":   system.CheckFail,
+		"kernel": system.CheckWarn,
+		"snapd":  system.CheckFail,
+	}
+	for name, status := range expected {
+		if statuses[name] != status {
+			t.Errorf("expected check %s to %s, got: %s", name, status, statuses[name])
+		}
+	}
+}
+
+func TestPreflightConflicts(t *testing.T) {
+	conf := &config.Config{}
+	conf.Providers.K8s.Enable = true
+
+	sys := system.NewMockSystem()
+	mockPreflight(sys)
+	sys.MockCommandReturn("systemctl is-active k3s.service", []byte("inactive\n"), fmt.Errorf("exit status 3"))
+	sys.MockCommandReturn("systemctl is-active snap.microk8s.daemon-kubelite.service", []byte("System has not been booted with systemd\n"), fmt.Errorf("exit status 1"))
+
+	statuses := map[string]string{}
+	for _, c := range NewPlan(conf, sys).preflight() {
+		statuses[c.Name] = c.Status
+	}
+
+	// An inactive service passes, but a failure to ask systemd is only warned about.
+	expected := map[string]string{
+		"conflict:k3s.service":                           system.CheckPass,
+		"conflict:snap.microk8s.daemon-kubelite.service": system.CheckWarn,
+	}
+	for name, status := range expected {
+		if statuses[name] != status {
+			t.Errorf("expected check %s to %s, got: %s", name, status, statuses[name])
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	type test struct {
+		version  string
+		minimum  string
+		expected bool
+	}
+
+	tests := []test{
+		{version: "2.66.1+24.04", minimum: "2.58", expected: true},
+		{version: "2.58", minimum: "2.58", expected: true},
+		{version: "2.57.6", minimum: "2.58", expected: false},
+		{version: "6.8.0-45-generic", minimum: "5.15", expected: true},
+		{version: "5.4.0-200-generic", minimum: "5.15", expected: false},
+		{version: "24.04", minimum: "22.04", expected: true},
+		{version: "20.04", minimum: "22.04", expected: false},
+	}
+
+	for _, tc := range tests {
+		ok, err := versionAtLeast(tc.version, tc.minimum)
+		if err != nil || ok != tc.expected {
+			t.Fatalf("versionAtLeast(%q, %q): expected %v, got: %v, %v", tc.version, tc.minimum, tc.expected, ok, err)
+		}
+	}
+
+	if _, err := versionAtLeast("unavailable", "2.58"); err == nil {
+		t.Fatal("expected an error for an invalid version")
+	}
+}
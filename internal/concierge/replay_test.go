@@ -0,0 +1,56 @@
+package concierge
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// TestPlanReplay replays a cassette of a 'prepare' with the k8s preset on a fresh
+// machine, ensuring that the plan still makes the calls it made when the cassette was
+// recorded. To refresh the cassette, record a run on a fresh Ubuntu machine with:
+//
+//	sudo concierge prepare -p k8s --record internal/concierge/testdata/cassettes/prepare-k8s.yaml
+//
+// A recorded run also includes concierge's own state files, which the plan does not
+// touch, so only unused commands are reported.
+func TestPlanReplay(t *testing.T) {
+	conf, err := config.Preset("k8s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cassette, err := system.LoadCassette(filepath.Join("testdata", "cassettes", "prepare-k8s.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := system.NewReplayWorker(cassette)
+	err = NewPlan(conf, replay).Execute(PrepareAction)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(replay.Unexpected) > 0 {
+		t.Fatalf("unexpected calls during replay: %v", replay.Unexpected)
+	}
+
+	for _, i := range replay.Unused() {
+		if i.Op == system.OpRun {
+			t.Errorf("recorded command was not replayed: %s", i.Key)
+		}
+	}
+
+	for _, cmd := range []string{"k8s bootstrap", "sudo -u ubuntu juju bootstrap k8s concierge-k8s --verbose --model-default automatically-retry-hooks=false --model-default test-mode=true --bootstrap-constraints root-disk=2G --config bootstrap-timeout=1800"} {
+		if !slices.Contains(replay.ExecutedCommands, cmd) {
+			t.Errorf("expected '%s' to be replayed, got: %v", cmd, replay.ExecutedCommands)
+		}
+	}
+
+	if replay.WrittenFiles["/home/ubuntu/.kube/config"] != system.RedactedContent {
+		t.Errorf("expected the redacted kubeconfig to be written, got: %q", replay.WrittenFiles["/home/ubuntu/.kube/config"])
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -42,6 +43,7 @@ func NewConfig(cmd *cobra.Command, flags *pflag.FlagSet) (*Config, error) {
 			return nil, fmt.Errorf("failed to load configuration preset: %w", err)
 		}
 		slog.Info("Preset selected", "preset", preset)
+		conf.Source = "preset:" + preset
 	} else {
 		// Load and validate the configuration file
 		conf, err = parseConfig(configFile)
@@ -51,11 +53,37 @@ func NewConfig(cmd *cobra.Command, flags *pflag.FlagSet) (*Config, error) {
 	}
 
 	dryRun, _ := flags.GetBool("dry-run")
+	// Only registered on commands that make changes; elsewhere it is left empty.
+	record, _ := flags.GetString("record")
+	// Only registered on 'prepare' and 'restore'; elsewhere it is left empty.
+	dryRunFormat, _ := flags.GetString("dry-run-format")
+	offline, _ := flags.GetBool("offline")
+	// Only registered on 'prepare'; elsewhere it is left unset.
+	skipPreflight, _ := flags.GetBool("skip-preflight")
+	explain, _ := flags.GetBool("explain")
+	output, _ := flags.GetString("output")
+	reportPath, _ := flags.GetString("report")
+	junitPath, _ := flags.GetString("junit")
+	metricsPath, _ := flags.GetString("metrics-textfile")
+	envFile, _ := flags.GetString("env-file")
+	envFormat, _ := flags.GetString("env-format")
 
 	conf.Overrides = getOverrides(flags)
+	recordOverrideProvenance(&conf.Provenance, flags, conf.Overrides)
 	conf.Verbose = verbose
 	conf.Trace = trace
 	conf.DryRun = dryRun
+	conf.Record = record
+	conf.DryRunFormat = dryRunFormat
+	conf.Offline = offline
+	conf.SkipPreflight = skipPreflight
+	conf.Explain = explain
+	conf.Output = output
+	conf.Report = reportPath
+	conf.JUnit = junitPath
+	conf.Metrics = metricsPath
+	conf.EnvFile = envFile
+	conf.EnvFormat = envFormat
 
 	return conf, nil
 }
@@ -63,6 +91,7 @@ func NewConfig(cmd *cobra.Command, flags *pflag.FlagSet) (*Config, error) {
 // parseConfig locates and parses the concierge configuration.
 func parseConfig(configFile string) (*Config, error) {
 	var data []byte
+	var source string
 
 	if len(configFile) > 0 {
 		// If the user specified a path to the config file manually, load that file
@@ -71,6 +100,7 @@ func parseConfig(configFile string) (*Config, error) {
 			return nil, fmt.Errorf("unable to read specified config file: %w", err)
 		}
 		data = b
+		source = configFile
 
 		slog.Info("Configuration file found", "path", configFile)
 	} else {
@@ -84,6 +114,7 @@ func parseConfig(configFile string) (*Config, error) {
 				if err != nil {
 					return nil, fmt.Errorf("failed to load configuration preset: %w", err)
 				}
+				conf.Source = "default:dev"
 
 				return conf, nil
 			}
@@ -91,17 +122,56 @@ func parseConfig(configFile string) (*Config, error) {
 			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
 		data = b
+		source = defaultConfigFileName
 
 		slog.Info("Configuration file found", "path", defaultConfigFileName)
 	}
 
+	// Record where the config came from, using an absolute path where possible since
+	// the working directory isn't recorded.
+	if abs, err := filepath.Abs(source); err == nil {
+		source = abs
+	}
+
+	return Parse(data, source)
+}
+
+// Parse parses the contents of a configuration file, expanding environment variables in
+// the values that support them. The source names where the contents came from, such as
+// the path of the file, and is recorded as the origin of each value.
+func Parse(data []byte, source string) (*Config, error) {
+	return parse(data, source, true)
+}
+
+// ParseVerbatim parses the contents of a configuration file as Parse does, but without
+// expanding environment variables, for configurations supplied by other users, which
+// must not be able to read concierge's environment.
+func ParseVerbatim(data []byte, source string) (*Config, error) {
+	return parse(data, source, false)
+}
+
+// parse parses the contents of a configuration file, expanding environment variables
+// in the values that support them if expand is set.
+func parse(data []byte, source string, expand bool) (*Config, error) {
 	conf, err := unmarshalYAMLConfig(data)
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
 	// Expand environment variables in config values
-	expandConfigEnvVars(conf)
+	if expand {
+		expandConfigEnvVars(conf)
+	}
+
+	conf.Source = "file:" + source
+
+	conf.Provenance, err = yamlProvenance(data, func(line int) Origin {
+		return Origin{Kind: OriginFile, Name: source, Line: line}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	}
+	recordDefaultProvenance(&conf.Provenance, conf)
 
 	return conf, nil
 }
@@ -223,4 +293,13 @@ func expandConfigEnvVars(conf *Config) {
 	conf.Providers.K8s.ImageRegistry.URL = expandEnvVars(conf.Providers.K8s.ImageRegistry.URL)
 	conf.Providers.K8s.ImageRegistry.Username = expandEnvVars(conf.Providers.K8s.ImageRegistry.Username)
 	conf.Providers.K8s.ImageRegistry.Password = expandEnvVars(conf.Providers.K8s.ImageRegistry.Password)
+
+	// Expand in webhook URLs and headers, which often carry secrets
+	for i := range conf.Notify.Webhooks {
+		webhook := &conf.Notify.Webhooks[i]
+		webhook.URL = expandEnvVars(webhook.URL)
+		for name, value := range webhook.Headers {
+			webhook.Headers[name] = expandEnvVars(value)
+		}
+	}
 }
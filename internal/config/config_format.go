@@ -1,17 +1,56 @@
 package config
 
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
 // Config represents concierge's configuration format.
 type Config struct {
 	Juju      jujuConfig     `yaml:"juju"`
 	Providers providerConfig `yaml:"providers"`
 	Host      hostConfig     `yaml:"host"`
+	Notify    notifyConfig   `yaml:"notify"`
 
 	// The following are added at runtime according to CLI flags
 	Overrides ConfigOverrides `yaml:"overrides"`
 	Status    Status          `yaml:"status"`
-	Verbose   bool            `yaml:"-"`
-	Trace     bool            `yaml:"-"`
-	DryRun    bool            `yaml:"-"`
+	Snapshot  *Snapshot       `yaml:"snapshot,omitempty"`
+	Source    string          `yaml:"source,omitempty"`
+	// Provenance records where each value in the configuration came from.
+	Provenance Provenance `yaml:"-"`
+	Verbose    bool       `yaml:"-"`
+	Trace      bool       `yaml:"-"`
+	DryRun     bool       `yaml:"-"`
+	Thorough   bool       `yaml:"-"`
+	Record     string     `yaml:"-"`
+	// DryRunFormat is the format of the dry-run output: "text" or "script".
+	DryRunFormat string `yaml:"-"`
+	// Offline plans a dry-run without querying snapd, the store or the machine's tools.
+	Offline bool `yaml:"-"`
+	// SkipPreflight skips the checks of the machine's resources, OS and conflicting
+	// services made before 'prepare'.
+	SkipPreflight bool `yaml:"-"`
+	// Explain reports the configuration that caused each step of the plan.
+	Explain bool `yaml:"-"`
+	// Output is the format of concierge's output on stdout: "text", or "json" for a
+	// stream of newline-delimited JSON progress events.
+	Output string `yaml:"-"`
+	// Console, if set, receives anything concierge would otherwise print to stdout,
+	// such as command traces, while a live progress display is drawn there.
+	Console io.Writer `yaml:"-"`
+	// Report and JUnit are paths to which a report of the run is written, as JSON and
+	// as JUnit XML respectively, if set.
+	Report string `yaml:"-"`
+	JUnit  string `yaml:"-"`
+	// Metrics is a path to which metrics of the run are written as a Prometheus
+	// textfile, if set.
+	Metrics string `yaml:"-"`
+	// EnvFile is a path to which a description of the provisioned environment is
+	// written after a successful 'prepare', in EnvFormat, if set.
+	EnvFile   string `yaml:"-"`
+	EnvFormat string `yaml:"-"`
 }
 
 // Status represents the status of concierge on a given machine.
@@ -28,6 +67,11 @@ func (s Status) String() string {
 	return [...]string{"provisioning", "succeeded", "failed"}[s]
 }
 
+// MarshalJSON represents the status by its name in JSON output.
+func (s Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
 // jujuConfig represents the configuration for juju, including the desired version,
 // and defaults/constraints for the bootstrap process.
 type jujuConfig struct {
@@ -120,3 +164,23 @@ type hostConfig struct {
 	// Snaps is a map of snaps to be installed.
 	Snaps map[string]SnapConfig `yaml:"snaps"`
 }
+
+// notifyConfig represents who is notified when a run of concierge completes.
+type notifyConfig struct {
+	// Webhooks is a list of HTTP endpoints to which the outcome of each run is posted.
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// WebhookConfig represents an HTTP endpoint to which the outcome of each run is posted
+// as JSON.
+type WebhookConfig struct {
+	// URL is the address to which the outcome is posted.
+	URL string `yaml:"url"`
+	// Headers are additional HTTP headers sent with each request, such as
+	// Authorization.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Timeout bounds each attempt to deliver the notification. If omitted, 10 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Retries is the number of times a failed delivery is retried. If omitted, 3.
+	Retries *int `yaml:"retries,omitempty"`
+}
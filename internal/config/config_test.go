@@ -2,8 +2,10 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -368,6 +370,53 @@ providers:
 	}
 }
 
+func TestNotifyConfigFromYAML(t *testing.T) {
+	t.Setenv("WEBHOOK_TOKEN", "s3cret")
+
+	yamlConfig := `
+notify:
+  webhooks:
+    - url: https://hooks.example.com/concierge
+      headers:
+        Authorization: Bearer ${WEBHOOK_TOKEN}
+      timeout: 30s
+      retries: 0
+    - url: http://localhost:8080/
+`
+
+	tmpFile, err := os.CreateTemp("", "concierge-test-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+
+	if _, err := tmpFile.Write([]byte(yamlConfig)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := parseConfig(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	webhooks := cfg.Notify.Webhooks
+	if len(webhooks) != 2 {
+		t.Fatalf("expected 2 webhooks, got: %d", len(webhooks))
+	}
+	if webhooks[0].Headers["Authorization"] != "Bearer s3cret" {
+		t.Fatalf("expected header to be expanded from env var, got: %v", webhooks[0].Headers)
+	}
+	if webhooks[0].Timeout != 30*time.Second || webhooks[0].Retries == nil || *webhooks[0].Retries != 0 {
+		t.Fatalf("unexpected webhook: %+v", webhooks[0])
+	}
+	if webhooks[1].Timeout != 0 || webhooks[1].Retries != nil {
+		t.Fatalf("expected defaults to be left unset, got: %+v", webhooks[1])
+	}
+}
+
 func TestEnvOrFlagBool(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -510,6 +559,7 @@ func TestParseConfigDefaultFileFallsBackToDevPreset(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Preset(dev): %v", err)
 	}
+	dev.Source = "default:dev"
 	if !reflect.DeepEqual(cfg, dev) {
 		t.Fatalf("fallback config does not match dev preset")
 	}
@@ -539,6 +589,9 @@ providers:
 	if !cfg.Providers.LXD.Enable {
 		t.Fatalf("want LXD enabled from default-path file")
 	}
+	if want := "file:" + filepath.Join(dir, "concierge.yaml"); cfg.Source != want {
+		t.Fatalf("want source %q, got %q", want, cfg.Source)
+	}
 }
 
 func TestParseConfigExplicitFileMissing(t *testing.T) {
@@ -563,3 +616,30 @@ func TestBindFlagsNoEnvLeavesDefault(t *testing.T) {
 		t.Fatalf("want flag left at default %q, got %q", "stable", got)
 	}
 }
+
+func TestParseVerbatimDoesNotExpandEnvVars(t *testing.T) {
+	t.Setenv("REGISTRY_PASS", "envpass")
+
+	yamlConfig := []byte(`
+providers:
+  k8s:
+    enable: true
+    image-registry:
+      url: https://registry.example.com
+      password: ${REGISTRY_PASS}
+notify:
+  webhooks:
+    - url: https://example.com/$REGISTRY_PASS
+`)
+
+	cfg, err := ParseVerbatim(yamlConfig, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Providers.K8s.ImageRegistry.Password != "${REGISTRY_PASS}" {
+		t.Fatalf("expected password to be left as written, got: %v", cfg.Providers.K8s.ImageRegistry.Password)
+	}
+	if cfg.Notify.Webhooks[0].URL != "https://example.com/$REGISTRY_PASS" {
+		t.Fatalf("expected webhook URL to be left as written, got: %v", cfg.Notify.Webhooks[0].URL)
+	}
+}
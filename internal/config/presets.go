@@ -38,7 +38,19 @@ func Preset(preset string) (*Config, error) {
 		}
 		return nil, fmt.Errorf("failed to read preset '%s': %w", preset, err)
 	}
-	return loadPreset(data)
+	conf, err := loadPreset(data)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.Provenance, err = yamlProvenance(data, func(line int) Origin {
+		return Origin{Kind: OriginPreset, Name: preset, Line: line}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse preset: %w", err)
+	}
+	recordDefaultProvenance(&conf.Provenance, conf)
+	return conf, nil
 }
 
 // unmarshalYAMLConfig parses YAML config data into a Config.
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Kinds of origin for a configuration value.
+const (
+	OriginFile    = "file"
+	OriginPreset  = "preset"
+	OriginFlag    = "flag"
+	OriginEnv     = "env"
+	OriginDefault = "default"
+)
+
+// Origin describes where a resolved configuration value came from.
+type Origin struct {
+	// Kind is the kind of origin, such as OriginFile or OriginFlag.
+	Kind string `yaml:"kind"`
+	// Name identifies the origin: the path of a config file, the name of a preset, flag
+	// or environment variable, or a description of a built-in default.
+	Name string `yaml:"name"`
+	// Line is the line of the config file or preset that set the value, if any.
+	Line int `yaml:"line,omitempty"`
+}
+
+// String returns a description of the origin, such as `flag --lxd-channel`.
+func (o Origin) String() string {
+	switch o.Kind {
+	case OriginFile:
+		return fmt.Sprintf("%s:%d", o.Name, o.Line)
+	case OriginPreset:
+		return fmt.Sprintf("preset %s.yaml:%d", o.Name, o.Line)
+	case OriginFlag:
+		return "flag --" + o.Name
+	case OriginEnv:
+		return "env " + o.Name
+	default:
+		return "default: " + o.Name
+	}
+}
+
+// Provenance records the origin of each resolved configuration value, keyed by its
+// dotted path in the configuration format, such as `providers.lxd.channel`. Items
+// of the host snaps and packages are keyed by name, such as `host.snaps.jhack` and
+// `host.packages.make`.
+type Provenance map[string]Origin
+
+// Set records the origin of the value at the specified key, replacing any origin
+// previously recorded.
+func (p *Provenance) Set(key string, origin Origin) {
+	if *p == nil {
+		*p = Provenance{}
+	}
+	(*p)[key] = origin
+}
+
+// SetDefault records that the value at the specified key is a built-in default,
+// described by detail.
+func (p *Provenance) SetDefault(key string, detail string) {
+	p.Set(key, Origin{Kind: OriginDefault, Name: detail})
+}
+
+// Get returns the origin of the value at the specified key, if known.
+func (p Provenance) Get(key string) (Origin, bool) {
+	origin, ok := p[key]
+	return origin, ok
+}
+
+// yamlProvenance records the line of each value in a YAML config document, with an
+// origin constructed by origin.
+func yamlProvenance(data []byte, origin func(line int) Origin) (Provenance, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	p := Provenance{}
+	if len(doc.Content) > 0 {
+		recordNode(p, doc.Content[0], "", origin)
+	}
+	return p, nil
+}
+
+// recordNode records the origin of each key beneath a YAML node, recursively.
+func recordNode(p Provenance, node *yaml.Node, prefix string, origin func(line int) Origin) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := prefix + node.Content[i].Value
+			p.Set(key, origin(node.Content[i].Line))
+			recordNode(p, node.Content[i+1], key+".", origin)
+		}
+	case yaml.SequenceNode:
+		// Lists of names, such as host.packages, are keyed by each item.
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode {
+				p.Set(prefix+item.Value, origin(item.Line))
+			}
+		}
+	}
+}
+
+// recordDefaultProvenance records the built-in default behind each channel left empty
+// in the configuration. Overrides are recorded afterwards, and replace these origins.
+func recordDefaultProvenance(p *Provenance, conf *Config) {
+	defaults := []struct {
+		key    string
+		value  string
+		detail string
+	}{
+		{"juju.channel", conf.Juju.Channel, "snapd's default channel"},
+		{"providers.lxd.channel", conf.Providers.LXD.Channel, "snapd's default channel"},
+		{"providers.k8s.channel", conf.Providers.K8s.Channel, "defaultK8sChannel"},
+		{"providers.microk8s.channel", conf.Providers.MicroK8s.Channel,
+			"computeDefaultChannel: latest strict stable channel in the store, or 1.32-strict/stable"},
+	}
+	for _, d := range defaults {
+		if d.value == "" {
+			p.SetDefault(d.key, d.detail)
+		}
+	}
+}
+
+// overrideKeys maps each override flag to the key of the config value it replaces.
+var overrideKeys = map[string]string{
+	"disable-juju":           "juju.disable",
+	"juju-channel":           "juju.channel",
+	"juju-revision":          "juju.revision",
+	"k8s-channel":            "providers.k8s.channel",
+	"microk8s-channel":       "providers.microk8s.channel",
+	"lxd-channel":            "providers.lxd.channel",
+	"charmcraft-channel":     "host.snaps.charmcraft.channel",
+	"snapcraft-channel":      "host.snaps.snapcraft.channel",
+	"rockcraft-channel":      "host.snaps.rockcraft.channel",
+	"google-credential-file": "providers.google.credentials-file",
+}
+
+// recordOverrideProvenance records the origin of each override set by flag or
+// environment variable. The environment variable takes priority, as in getOverrides.
+func recordOverrideProvenance(p *Provenance, flags *pflag.FlagSet, overrides ConfigOverrides) {
+	for name, key := range overrideKeys {
+		// Juju can only be disabled by override, never re-enabled.
+		if name == "disable-juju" && !overrides.DisableJuju {
+			continue
+		}
+		if origin, ok := overrideOrigin(flags, name); ok {
+			p.Set(key, origin)
+		}
+	}
+
+	extras := map[string][]string{
+		"extra-snaps": overrides.ExtraSnaps,
+		"extra-debs":  overrides.ExtraDebs,
+	}
+	for name, items := range extras {
+		prefix := "host.snaps."
+		if name == "extra-debs" {
+			prefix = "host.packages."
+		}
+
+		fromEnv := strings.Split(os.Getenv(flagToEnvVar(name)), ",")
+		for _, item := range items {
+			itemName, channel, _ := strings.Cut(item, "/")
+			origin := Origin{Kind: OriginFlag, Name: name}
+			if slices.Contains(fromEnv, item) {
+				origin = Origin{Kind: OriginEnv, Name: flagToEnvVar(name)}
+			}
+			p.Set(prefix+itemName, origin)
+			if channel != "" {
+				p.Set(prefix+itemName+".channel", origin)
+			}
+		}
+	}
+}
+
+// overrideOrigin reports whether the override flag was set, and if so whether by
+// environment variable or on the command line.
+func overrideOrigin(flags *pflag.FlagSet, name string) (Origin, bool) {
+	if v, ok := os.LookupEnv(flagToEnvVar(name)); ok && v != "" {
+		return Origin{Kind: OriginEnv, Name: flagToEnvVar(name)}, true
+	}
+	if f := flags.Lookup(name); f != nil && f.Changed {
+		return Origin{Kind: OriginFlag, Name: name}, true
+	}
+	return Origin{}, false
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestParseConfigRecordsProvenance(t *testing.T) {
+	dir := t.TempDir()
+	yamlConfig := `juju:
+  channel: 3.6/stable
+providers:
+  lxd:
+    enable: true
+host:
+  packages:
+    - make
+  snaps:
+    jhack:
+      channel: latest/edge
+`
+	configFile := filepath.Join(dir, "concierge.yaml")
+	if err := os.WriteFile(configFile, []byte(yamlConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := parseConfig(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{
+		"juju.channel":             2,
+		"providers.lxd.enable":     5,
+		"host.packages.make":       8,
+		"host.snaps.jhack":         10,
+		"host.snaps.jhack.channel": 11,
+		"providers.lxd":            4,
+	}
+	for key, line := range expected {
+		origin, ok := conf.Provenance.Get(key)
+		if !ok {
+			t.Fatalf("expected provenance for %s", key)
+		}
+		if origin.Kind != OriginFile || origin.Name != configFile || origin.Line != line {
+			t.Fatalf("unexpected origin for %s: %+v", key, origin)
+		}
+	}
+
+	if got := conf.Provenance["juju.channel"].String(); got != configFile+":2" {
+		t.Fatalf("unexpected origin description: %s", got)
+	}
+
+	origin, ok := conf.Provenance.Get("providers.lxd.channel")
+	if !ok || origin.Kind != OriginDefault {
+		t.Fatalf("expected a default origin for the unset LXD channel, got: %+v", origin)
+	}
+}
+
+func TestPresetRecordsProvenance(t *testing.T) {
+	conf, err := Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin, ok := conf.Provenance.Get("providers.lxd.enable")
+	if !ok || origin.Kind != OriginPreset || origin.Name != "dev" || origin.Line == 0 {
+		t.Fatalf("unexpected origin for preset value: %+v", origin)
+	}
+}
+
+func TestRecordOverrideProvenance(t *testing.T) {
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("juju-channel", "", "")
+	flags.String("lxd-channel", "", "")
+	flags.Bool("disable-juju", false, "")
+	flags.StringSlice("extra-snaps", nil, "")
+	flags.StringSlice("extra-debs", nil, "")
+
+	if err := flags.Set("juju-channel", "3.6/edge"); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Set("extra-snaps", "astral-uv/latest/edge"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONCIERGE_LXD_CHANNEL", "5.21/stable")
+	t.Setenv("CONCIERGE_EXTRA_DEBS", "make")
+
+	p := Provenance{}
+	recordOverrideProvenance(&p, flags, getOverrides(flags))
+
+	expected := map[string]string{
+		"juju.channel":                 "flag --juju-channel",
+		"providers.lxd.channel":        "env CONCIERGE_LXD_CHANNEL",
+		"host.snaps.astral-uv":         "flag --extra-snaps",
+		"host.snaps.astral-uv.channel": "flag --extra-snaps",
+		"host.packages.make":           "env CONCIERGE_EXTRA_DEBS",
+	}
+	for key, description := range expected {
+		if got := p[key].String(); got != description {
+			t.Fatalf("unexpected origin for %s: want %q, got %q", key, description, got)
+		}
+	}
+
+	if _, ok := p.Get("juju.disable"); ok {
+		t.Fatal("expected no provenance for an override that was not set")
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"maps"
+	"sync"
+)
+
+// Firewall backends that concierge knows how to snapshot and restore.
+const (
+	// FirewallIptables is used where the `iptables` command is available, whether it
+	// is the legacy variant or the nf_tables compatibility layer.
+	FirewallIptables = "iptables"
+	// FirewallNftables is used on hosts with no `iptables` command, where the
+	// firewall is managed directly with `nft`.
+	FirewallNftables = "nftables"
+)
+
+// Snapshot records details of the machine captured during 'prepare', before concierge
+// changed them, such that 'restore' can put them back exactly. Where 'prepare' is run
+// more than once, the first recorded value for each item is kept, so the snapshot
+// reflects the machine before concierge first touched it.
+//
+// All methods are safe to call on a nil Snapshot, in which case nothing is recorded.
+type Snapshot struct {
+	// Groups maps the name of each POSIX group concierge adds the user to, to whether
+	// the user was already a member before 'prepare'.
+	Groups map[string]bool `yaml:"groups,omitempty"`
+	// Firewall records the FORWARD chain before it was deconflicted for LXD. The chain
+	// belongs to the machine rather than the user, so it is kept in the machine-scoped
+	// part of the state store rather than with the user's configuration.
+	Firewall *FirewallSnapshot `yaml:"-"`
+
+	mu sync.Mutex
+}
+
+// FirewallSnapshot records the state of the FORWARD chain of the filter table.
+type FirewallSnapshot struct {
+	// Backend is the tool used to manage the chain; one of FirewallIptables or
+	// FirewallNftables.
+	Backend string `yaml:"backend"`
+	// Rules is the chain as printed by `iptables -S FORWARD` or `nft list chain`.
+	// It is empty if the chain did not exist.
+	Rules string `yaml:"rules"`
+}
+
+// NewSnapshot constructs an empty Snapshot.
+func NewSnapshot() *Snapshot {
+	return &Snapshot{Groups: map[string]bool{}}
+}
+
+// RecordGroup records whether the user was a member of the group before 'prepare',
+// unless a value has already been recorded.
+func (s *Snapshot) RecordGroup(group string, member bool) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Groups == nil {
+		s.Groups = map[string]bool{}
+	}
+	if _, ok := s.Groups[group]; !ok {
+		s.Groups[group] = member
+	}
+}
+
+// GroupMembership reports whether the user was a member of the group before 'prepare',
+// and whether that was recorded at all.
+func (s *Snapshot) GroupMembership(group string) (member bool, recorded bool) {
+	if s == nil {
+		return false, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	member, recorded = s.Groups[group]
+	return member, recorded
+}
+
+// RecordFirewall records the FORWARD chain before 'prepare', unless it has already
+// been recorded.
+func (s *Snapshot) RecordFirewall(firewall *FirewallSnapshot) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Firewall == nil {
+		s.Firewall = firewall
+	}
+}
+
+// FirewallState returns the recorded FORWARD chain, or nil if none was recorded.
+func (s *Snapshot) FirewallState() *FirewallSnapshot {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.Firewall
+}
+
+// MarshalYAML marshals the snapshot while holding its lock, since handlers may record
+// into it while the runtime state is being saved.
+func (s *Snapshot) MarshalYAML() (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return struct {
+		Groups map[string]bool `yaml:"groups,omitempty"`
+	}{
+		Groups: maps.Clone(s.Groups),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSnapshotKeepsFirstRecordedValues(t *testing.T) {
+	s := NewSnapshot()
+	s.RecordGroup("lxd", false)
+	s.RecordGroup("lxd", true)
+	s.RecordFirewall(&FirewallSnapshot{Backend: FirewallIptables, Rules: "-P FORWARD DROP\n"})
+	s.RecordFirewall(&FirewallSnapshot{Backend: FirewallIptables, Rules: "-P FORWARD ACCEPT\n"})
+
+	if member, recorded := s.GroupMembership("lxd"); member || !recorded {
+		t.Fatalf("expected first group membership to be kept, got member=%v recorded=%v", member, recorded)
+	}
+
+	if s.FirewallState().Rules != "-P FORWARD DROP\n" {
+		t.Fatalf("expected first firewall state to be kept, got: %v", s.FirewallState())
+	}
+}
+
+func TestSnapshotNilIsSafe(t *testing.T) {
+	var s *Snapshot
+	s.RecordGroup("lxd", true)
+	s.RecordFirewall(&FirewallSnapshot{})
+
+	if _, recorded := s.GroupMembership("lxd"); recorded {
+		t.Fatal("nil snapshot should not record group membership")
+	}
+	if s.FirewallState() != nil {
+		t.Fatal("nil snapshot should not record firewall state")
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	conf := &Config{Snapshot: NewSnapshot()}
+	conf.Snapshot.RecordGroup("microk8s", true)
+	conf.Snapshot.RecordFirewall(&FirewallSnapshot{Backend: FirewallNftables, Rules: ""})
+
+	out, err := yaml.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded Config
+	if err := yaml.Unmarshal(out, &loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(conf.Snapshot.Groups, loaded.Snapshot.Groups) {
+		t.Fatalf("expected: %v, got: %v", conf.Snapshot.Groups, loaded.Snapshot.Groups)
+	}
+	if loaded.Snapshot.Firewall != nil {
+		t.Fatalf("expected the machine-wide firewall not to be kept with the config, got: %v", loaded.Snapshot.Firewall)
+	}
+}
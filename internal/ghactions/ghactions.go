@@ -0,0 +1,242 @@
+// Package ghactions integrates concierge with GitHub Actions, where most of its runs
+// happen. When running in a workflow, the output of each step is folded into a log
+// group titled with its outcome, failed steps are annotated as errors, a table of the
+// steps is written to the job summary, and details of the provisioned machine are set
+// as step outputs.
+//
+// See https://docs.github.com/en/actions/reference/workflow-commands-for-github-actions.
+package ghactions
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/report"
+)
+
+// Enabled reports whether concierge is running in a GitHub Actions workflow.
+func Enabled() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// Groups writes the output of each step to the workflow log once the step finishes,
+// folded into a group titled with the step and its outcome. A failed step's group is
+// followed by an error annotation. Workflow commands are stopped while the output is
+// written, such that a line of output is never run as one.
+type Groups struct {
+	out io.Writer
+
+	mu    sync.Mutex
+	steps []*groupStep
+}
+
+// groupStep holds the output of a running step until it finishes.
+type groupStep struct {
+	id    string
+	lines []string
+}
+
+// NewGroups constructs Groups that write to out.
+func NewGroups(out io.Writer) *Groups {
+	return &Groups{out: out}
+}
+
+// Handle updates the groups according to a progress event. Output is collected
+// against the step that ran the command, and written when that step ends, since steps
+// run concurrently and groups cannot be nested. Commands marked as sensitive never emit
+// their output, so it is never collected.
+func (g *Groups) Handle(e progress.Event) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	switch e.Type {
+	case progress.EventStart:
+		g.steps = append(g.steps, &groupStep{id: e.Step})
+	case progress.EventOutput:
+		if s := g.step(e.Step); s != nil {
+			s.lines = append(s.lines, e.Message)
+		}
+	case progress.EventEnd:
+		s := g.step(e.Step)
+		if s == nil {
+			return
+		}
+		g.steps = slices.DeleteFunc(g.steps, func(other *groupStep) bool { return other == s })
+		g.write(s, e)
+	}
+}
+
+// step returns the most recently started running step with the specified ID, or nil.
+// It must be called with mu held.
+func (g *Groups) step(id string) *groupStep {
+	for i := len(g.steps) - 1; i >= 0; i-- {
+		if g.steps[i].id == id {
+			return g.steps[i]
+		}
+	}
+	return nil
+}
+
+// write prints the group of a finished step's output, followed by an error annotation
+// if it failed. It must be called with mu held.
+func (g *Groups) write(s *groupStep, e progress.Event) {
+	outcome := "succeeded"
+	if e.Err != nil {
+		outcome = "failed"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "::group::%s (%s in %s)\n", escapeData(s.id), outcome, e.Duration.Round(time.Second))
+	if len(s.lines) > 0 {
+		// The token that resumes workflow commands cannot occur in the output.
+		token := rand.Text()
+		fmt.Fprintf(&b, "::stop-commands::%s\n", token)
+		for _, line := range s.lines {
+			b.WriteString(line + "\n")
+		}
+		fmt.Fprintf(&b, "::%s::\n", token)
+	}
+	b.WriteString("::endgroup::\n")
+	if e.Err != nil {
+		fmt.Fprintf(&b, "::error title=%s::%s\n", escapeProperty(s.id), escapeData(e.Err.Error()))
+	}
+
+	// Failing to write the log must never fail the run itself.
+	_, _ = io.WriteString(g.out, b.String())
+}
+
+// Summary returns a Markdown table of the steps of a run, their outcome, duration and
+// the version each installed, for the job summary. The version function, if not nil,
+// returns the version of a step, such as the channel of a snap, or "".
+func Summary(r *report.Report, version func(step string) string) string {
+	outcome := "✅ succeeded"
+	if r.Status == report.StatusFailed {
+		outcome = "❌ failed"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "### concierge %s %s\n\n", r.Action, outcome)
+	fmt.Fprintf(&b, "concierge `%s` took %s.\n\n", r.ConciergeVersion, formatDuration(r.DurationMS))
+	if r.Error != "" {
+		fmt.Fprintf(&b, "> %s\n\n", escapeMarkdown(r.Error))
+	}
+
+	if len(r.Steps) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("| Step | Status | Duration | Version |\n")
+	b.WriteString("| --- | --- | --- | --- |\n")
+	for _, s := range r.Steps {
+		status := map[string]string{
+			report.StatusSucceeded: "✅",
+			report.StatusFailed:    "❌",
+			report.StatusRunning:   "⏳",
+		}[s.Status]
+		if s.Error != "" {
+			status += " " + escapeMarkdown(s.Error)
+		}
+
+		v := ""
+		if version != nil {
+			v = version(s.ID)
+		}
+		if v == "" {
+			v = "-"
+		}
+
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", s.ID, status, formatDuration(s.DurationMS), escapeMarkdown(v))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// WriteSummary appends Markdown to the job summary, if the workflow provides one in
+// GITHUB_STEP_SUMMARY.
+func WriteSummary(markdown string) error {
+	return appendFile("GITHUB_STEP_SUMMARY", markdown)
+}
+
+// SetOutputs sets the outputs of the workflow step running concierge, if the workflow
+// provides an outputs file in GITHUB_OUTPUT. Outputs are written in name order.
+func SetOutputs(outputs map[string]string) error {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		value := outputs[name]
+		if !strings.ContainsAny(value, "\r\n") {
+			fmt.Fprintf(&b, "%s=%s\n", name, value)
+			continue
+		}
+
+		// Multiline values are enclosed by a delimiter that cannot occur in the value.
+		delimiter, err := newDelimiter()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s<<%s\n%s\n%s\n", name, delimiter, value, delimiter)
+	}
+
+	return appendFile("GITHUB_OUTPUT", b.String())
+}
+
+// appendFile appends content to the file named by the environment variable, if set.
+func appendFile(variable, content string) error {
+	filePath := os.Getenv(variable)
+	if filePath == "" || content == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644) //nolint:gosec // G302,G304: the file is provided by the workflow runner for it to read
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", variable, err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		return fmt.Errorf("failed to write %s: %w", variable, err)
+	}
+	return nil
+}
+
+// newDelimiter returns a random delimiter for a multiline output value.
+func newDelimiter() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate output delimiter: %w", err)
+	}
+	return "ghadelimiter_" + hex.EncodeToString(b), nil
+}
+
+// formatDuration formats a duration in milliseconds, rounded to the second.
+func formatDuration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Round(time.Second).String()
+}
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property of a workflow command, such as its title.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// escapeMarkdown keeps text on a single line of a Markdown table.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("|", `\|`, "\r", " ", "\n", " ").Replace(s)
+}
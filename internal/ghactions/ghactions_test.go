@@ -0,0 +1,147 @@
+package ghactions
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/report"
+)
+
+func TestGroups(t *testing.T) {
+	var out strings.Builder
+	g := NewGroups(&out)
+
+	for _, e := range []progress.Event{
+		{Type: progress.EventStart, Step: "snap:jhack"},
+		{Type: progress.EventStart, Step: "juju:bootstrap:lxd"},
+		{Type: progress.EventStart, Step: "deb:make"},
+		{Type: progress.EventOutput, Step: "snap:jhack", Command: "snap install jhack", Message: "jhack installed"},
+		{Type: progress.EventOutput, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd", Message: "Creating controller"},
+		{Type: progress.EventOutput, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd", Message: "::add-mask::secret"},
+		{Type: progress.EventOutput, Command: "apt-get update", Message: "Reading package lists"},
+		{Type: progress.EventEnd, Step: "snap:jhack", Duration: 2 * time.Second},
+		{Type: progress.EventEnd, Step: "deb:make", Duration: time.Second},
+		{Type: progress.EventEnd, Step: "juju:bootstrap:lxd", Duration: time.Minute, Err: errors.New("failed:\n100% broken")},
+	} {
+		g.Handle(e)
+	}
+
+	// Each token that stops workflow commands is random, so they are replaced before
+	// comparing, having checked that each is used to resume them.
+	stop := regexp.MustCompile(`::stop-commands::(\w+)\n`)
+	got := out.String()
+	for _, m := range stop.FindAllStringSubmatch(got, -1) {
+		if !strings.Contains(got, "::"+m[1]+"::\n") {
+			t.Fatalf("expected workflow commands to be resumed with %s, got:\n%s", m[1], got)
+		}
+		got = strings.ReplaceAll(got, m[1], "TOKEN")
+	}
+
+	expected := `::group::snap:jhack (succeeded in 2s)
+::stop-commands::TOKEN
+jhack installed
+::TOKEN::
+::endgroup::
+::group::deb:make (succeeded in 1s)
+::endgroup::
+::group::juju:bootstrap:lxd (failed in 1m0s)
+::stop-commands::TOKEN
+Creating controller
+::add-mask::secret
+::TOKEN::
+::endgroup::
+::error title=juju%3Abootstrap%3Alxd::failed:%0A100%25 broken
+`
+	if got != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	finished := time.Now()
+	r := &report.Report{
+		Action:           "prepare",
+		ConciergeVersion: "1.2.3",
+		DurationMS:       61500,
+		Status:           report.StatusFailed,
+		Error:            "failed to prepare Juju",
+		Steps: []*report.Step{
+			{ID: "snap:jhack", Status: report.StatusSucceeded, DurationMS: 2000, Finished: &finished},
+			{ID: "juju:bootstrap:lxd", Status: report.StatusFailed, DurationMS: 59000, Error: "a | b"},
+		},
+	}
+
+	versions := map[string]string{"snap:jhack": "latest/edge"}
+	summary := Summary(r, func(step string) string { return versions[step] })
+
+	expected := "### concierge prepare ❌ failed\n\n" +
+		"concierge `1.2.3` took 1m2s.\n\n" +
+		"> failed to prepare Juju\n\n" +
+		"| Step | Status | Duration | Version |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| `snap:jhack` | ✅ | 2s | latest/edge |\n" +
+		"| `juju:bootstrap:lxd` | ❌ a \\| b | 59s | - |\n\n"
+	if summary != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, summary)
+	}
+}
+
+func TestWriteSummaryAndSetOutputs(t *testing.T) {
+	dir := t.TempDir()
+	summaryPath := filepath.Join(dir, "summary.md")
+	outputPath := filepath.Join(dir, "output")
+	t.Setenv("GITHUB_STEP_SUMMARY", summaryPath)
+	t.Setenv("GITHUB_OUTPUT", outputPath)
+
+	// The workflow runner may already have written to the files.
+	if err := os.WriteFile(outputPath, []byte("earlier=1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteSummary("### concierge\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetOutputs(map[string]string{"status": "succeeded", "controllers": "concierge-lxd", "notes": "a\nb"}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(summaryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "### concierge\n" {
+		t.Fatalf("unexpected summary: %q", content)
+	}
+
+	content, err = os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := regexp.MustCompile(`^earlier=1\ncontrollers=concierge-lxd\nnotes<<(ghadelimiter_[0-9a-f]+)\na\nb\n(ghadelimiter_[0-9a-f]+)\nstatus=succeeded\n$`)
+	matches := expected.FindStringSubmatch(string(content))
+	if matches == nil || matches[1] != matches[2] {
+		t.Fatalf("unexpected outputs: %q", content)
+	}
+}
+
+func TestNotInWorkflow(t *testing.T) {
+	t.Setenv("GITHUB_ACTIONS", "")
+	t.Setenv("GITHUB_STEP_SUMMARY", "")
+	t.Setenv("GITHUB_OUTPUT", "")
+
+	if Enabled() {
+		t.Fatal("expected GitHub Actions to be disabled")
+	}
+	if err := WriteSummary("### concierge\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetOutputs(map[string]string{"status": "succeeded"}); err != nil {
+		t.Fatal(err)
+	}
+}
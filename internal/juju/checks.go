@@ -0,0 +1,65 @@
+package juju
+
+import (
+	"fmt"
+
+	"github.com/canonical/concierge/internal/providers"
+	"github.com/canonical/concierge/internal/system"
+)
+
+// checkRetries is how many times Checks retries a controller that does not respond.
+// It is fewer than when bootstrapping, so that an unresponsive controller is reported
+// promptly.
+const checkRetries = 2
+
+// Checks checks that each controller bootstrapped by concierge responds, and that its
+// testing model is reachable.
+func (j *JujuHandler) Checks() []system.Check {
+	checks := []system.Check{}
+	for _, provider := range j.providers {
+		if provider.Bootstrap() {
+			checks = append(checks, j.checkController(provider)...)
+		}
+	}
+	return checks
+}
+
+// checkController checks the controller bootstrapped on a provider, and its testing
+// model. The model is only checked if the controller responds.
+func (j *JujuHandler) checkController(provider providers.Provider) []system.Check {
+	controllerName := fmt.Sprintf("concierge-%s", provider.Name())
+	controller := system.Check{Component: "juju", Name: "controller:" + provider.Name()}
+
+	bootstrapped, err := j.checkBootstrapped(controllerName, checkRetries)
+	if err != nil {
+		controller.Status = system.CheckFail
+		controller.Detail = fmt.Sprintf("controller '%s' is not responding: %s", controllerName, err)
+		controller.Remedy = fmt.Sprintf("Check that the %s provider is healthy, then run 'juju show-controller %s'",
+			provider.Name(), controllerName)
+		return []system.Check{controller}
+	}
+	if !bootstrapped {
+		controller.Status = system.CheckFail
+		controller.Detail = fmt.Sprintf("controller '%s' not found", controllerName)
+		controller.Remedy = "Run 'sudo concierge prepare' to bootstrap it again"
+		return []system.Check{controller}
+	}
+	controller.Status = system.CheckPass
+	controller.Detail = fmt.Sprintf("controller '%s' is responding", controllerName)
+
+	modelName := fmt.Sprintf("%s:testing", controllerName)
+	model := system.Check{Component: "juju", Name: "model:" + provider.Name()}
+
+	cmd := system.NewCommandAs(j.system.User().Username, "", "juju", []string{"show-model", modelName})
+	cmd.ReadOnly = true
+	if _, err := j.system.Run(cmd); err != nil {
+		model.Status = system.CheckFail
+		model.Detail = fmt.Sprintf("model '%s' is not reachable: %s", modelName, err)
+		model.Remedy = fmt.Sprintf("If the model was removed, run 'juju add-model -c %s testing'", controllerName)
+	} else {
+		model.Status = system.CheckPass
+		model.Detail = fmt.Sprintf("model '%s' is reachable", modelName)
+	}
+
+	return []system.Check{controller, model}
+}
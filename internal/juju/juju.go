@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"os"
 	"path"
 	"runtime"
 	"slices"
@@ -13,12 +14,12 @@ import (
 
 	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/packages"
+	"github.com/canonical/concierge/internal/progress"
 	"github.com/canonical/concierge/internal/providers"
 	"github.com/canonical/concierge/internal/securitylog"
 	"github.com/canonical/concierge/internal/system"
 	"github.com/canonical/x-go/strutil/shlex"
 	"github.com/sethvargo/go-retry"
-	"golang.org/x/sync/errgroup"
 	"gopkg.in/yaml.v3"
 )
 
@@ -64,6 +65,17 @@ type JujuHandler struct {
 	snaps                []*system.Snap
 }
 
+// forStep returns a copy of the handler that does its work as part of the step, such
+// as `juju:bootstrap:lxd`.
+func (j *JujuHandler) forStep(step string) *JujuHandler {
+	h := *j
+	h.system = j.system.WithStep(step)
+	return &h
+}
+
+// Snaps returns the snaps installed to provide Juju.
+func (j *JujuHandler) Snaps() []*system.Snap { return j.snaps }
+
 // Prepare bootstraps Juju on the configured providers.
 func (j *JujuHandler) Prepare() error {
 	err := j.install()
@@ -99,17 +111,32 @@ func (j *JujuHandler) Restore() error {
 			continue
 		}
 
-		err := j.killProvider(p)
+		step := progress.StepID(progress.KindJuju, "destroy", p.Name())
+		err := progress.Track(step, func() error { return j.forStep(step).killProvider(p) })
 		if err != nil {
 			return err
 		}
 	}
 
-	err := j.system.RemovePath(path.Join(j.system.User().HomeDir, ".local", "share", "juju"))
+	// Keep hold of any credentials the user added themselves, so they can be
+	// written back once Juju's data directory has been removed.
+	preservedCredentials, err := j.removeCredentials()
+	if err != nil {
+		return fmt.Errorf("failed to remove concierge credentials: %w", err)
+	}
+
+	err = j.system.RemovePath(path.Join(j.system.User().HomeDir, ".local", "share", "juju"))
 	if err != nil {
 		return fmt.Errorf("failed to remove '.local/share/juju' subdirectory from user's home directory: %w", err)
 	}
 
+	if preservedCredentials != nil {
+		err = system.WriteHomeDirFile(j.system, credentialsPath, preservedCredentials)
+		if err != nil {
+			return fmt.Errorf("failed to restore credentials.yaml: %w", err)
+		}
+	}
+
 	snapHandler := packages.NewSnapHandler(j.system, j.snaps)
 
 	err = snapHandler.Restore()
@@ -122,6 +149,11 @@ func (j *JujuHandler) Restore() error {
 	return nil
 }
 
+// Leftovers reports state created by 'prepare' that still exists on the machine.
+func (j *JujuHandler) Leftovers() []system.Leftover {
+	return packages.NewSnapHandler(j.system, j.snaps).Leftovers()
+}
+
 // install ensures that Juju is installed.
 func (j *JujuHandler) install() error {
 	snapHandler := packages.NewSnapHandler(j.system, j.snaps)
@@ -134,39 +166,60 @@ func (j *JujuHandler) install() error {
 	return nil
 }
 
-// writeCredentials iterates over any provided cloud credentials and authors Juju's
-// credentials.yaml
-func (j *JujuHandler) writeCredentials() error {
-	credMap := map[string]any{}
-	credentials := map[string]any{"credentials": credMap}
-	addedCredentials := false
+// credentialName is the name under which concierge stores credentials for each
+// provider cloud in Juju's credentials.yaml.
+const credentialName = "concierge"
 
-	// Iterate over the providers
+// credentialsPath is the location of Juju's credentials.yaml, relative to the
+// real user's home directory.
+var credentialsPath = path.Join(".local", "share", "juju", "credentials.yaml")
+
+// writeCredentials iterates over any provided cloud credentials and merges them into
+// Juju's credentials.yaml. Credentials the user already had for other clouds, or under
+// other names for the same cloud, are preserved.
+func (j *JujuHandler) writeCredentials() error {
+	var credentialed []providers.Provider
 	for _, p := range j.providers {
 		// If the provider doesn't specify any credentials, move on to the next.
-		if p.Credentials() == nil {
-			continue
-		}
-
-		// Set the credentials for the provider, under the credential name "concierge".
-		credMap[p.CloudName()] = map[string]any{
-			"concierge": p.Credentials(),
+		if p.Credentials() != nil {
+			credentialed = append(credentialed, p)
 		}
-		addedCredentials = true
 	}
 
-	// Don't write the file if there are no credentials to add
-	if !addedCredentials {
+	// Don't touch the file if there are no credentials to add
+	if len(credentialed) == 0 {
 		return nil
 	}
 
+	credentials, err := j.readCredentials()
+	if err != nil {
+		return err
+	}
+
+	credMap := credentialsMap(credentials)
+
+	for _, p := range credentialed {
+		cloud, ok := credMap[p.CloudName()].(map[string]any)
+		if !ok {
+			cloud = map[string]any{}
+		}
+
+		// Set the credentials for the provider under the credential name "concierge",
+		// and make it the default for the cloud only if the user hasn't chosen one.
+		cloud[credentialName] = p.Credentials()
+		if _, ok := cloud["default-credential"]; !ok {
+			cloud["default-credential"] = credentialName
+		}
+
+		credMap[p.CloudName()] = cloud
+	}
+
 	// Marshall the credentials map and write it to the credentials.yaml file.
 	content, err := yaml.Marshal(credentials)
 	if err != nil {
 		return fmt.Errorf("failed to marshal juju credentials to yaml: %w", err)
 	}
 
-	credentialsPath := path.Join(".local", "share", "juju", "credentials.yaml")
 	err = system.WriteHomeDirFile(j.system, credentialsPath, content)
 	if err != nil {
 		return fmt.Errorf("failed to write credentials.yaml: %w", err)
@@ -176,18 +229,109 @@ func (j *JujuHandler) writeCredentials() error {
 	// deliberately not included in the event.
 	securitylog.Emit(securitylog.EventAuthzAdmin, securitylog.UserID()+",write_credentials",
 		"wrote Juju cloud credentials file",
-		"path", path.Join(j.system.User().HomeDir, credentialsPath), "clouds", len(credMap))
+		"path", path.Join(j.system.User().HomeDir, credentialsPath), "clouds", len(credentialed))
 
 	return nil
 }
 
+// removeCredentials strips the credentials added by writeCredentials from Juju's
+// credentials.yaml, returning the contents of the file that should be preserved.
+// If no credentials remain that the user added themselves, nil is returned.
+func (j *JujuHandler) removeCredentials() ([]byte, error) {
+	credentials, err := j.readCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	credMap := credentialsMap(credentials)
+
+	for _, p := range j.providers {
+		if p.Credentials() == nil {
+			continue
+		}
+
+		cloud, ok := credMap[p.CloudName()].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		delete(cloud, credentialName)
+		if cloud["default-credential"] == credentialName {
+			delete(cloud, "default-credential")
+		}
+
+		if len(cloud) == 0 {
+			delete(credMap, p.CloudName())
+		}
+	}
+
+	if len(credMap) == 0 {
+		delete(credentials, "credentials")
+	}
+
+	if len(credentials) == 0 {
+		return nil, nil
+	}
+
+	content, err := yaml.Marshal(credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal juju credentials to yaml: %w", err)
+	}
+
+	return content, nil
+}
+
+// readCredentials parses the user's existing Juju credentials.yaml. If the file
+// does not exist, an empty document is returned.
+func (j *JujuHandler) readCredentials() (map[string]any, error) {
+	credentials := map[string]any{}
+
+	content, err := system.ReadHomeDirFile(j.system, credentialsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return credentials, nil
+		}
+		return nil, fmt.Errorf("failed to read credentials.yaml: %w", err)
+	}
+
+	err = yaml.Unmarshal(content, &credentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse credentials.yaml: %w", err)
+	}
+
+	// An empty file unmarshals to a nil map.
+	if credentials == nil {
+		credentials = map[string]any{}
+	}
+
+	return credentials, nil
+}
+
+// credentialsMap returns the top-level "credentials" section of a parsed
+// credentials.yaml, creating it if it does not exist.
+func credentialsMap(credentials map[string]any) map[string]any {
+	credMap, ok := credentials["credentials"].(map[string]any)
+	if !ok {
+		credMap = map[string]any{}
+		credentials["credentials"] = credMap
+	}
+	return credMap
+}
+
 // bootstrap iterates over the set of configured providers, and bootstraps each of
 // them in parallel with a unique controller name.
 func (j *JujuHandler) bootstrap() error {
-	var eg errgroup.Group
+	eg := system.NewGroup(j.system)
 
 	for _, provider := range j.providers {
-		eg.Go(func() error { return j.bootstrapProvider(provider) })
+		if !provider.Bootstrap() {
+			continue
+		}
+
+		eg.Go(func() error {
+			step := progress.StepID(progress.KindJuju, "bootstrap", provider.Name())
+			return progress.Track(step, func() error { return j.forStep(step).bootstrapProvider(provider) })
+		})
 	}
 
 	if err := eg.Wait(); err != nil {
@@ -205,7 +349,7 @@ func (j *JujuHandler) bootstrapProvider(provider providers.Provider) error {
 
 	controllerName := fmt.Sprintf("concierge-%s", provider.Name())
 
-	bootstrapped, err := j.checkBootstrapped(controllerName)
+	bootstrapped, err := j.checkBootstrapped(controllerName, bootstrapCheckRetries)
 	if err != nil {
 		return fmt.Errorf("error checking bootstrap status for provider '%s'", provider.Name())
 	}
@@ -285,7 +429,7 @@ func (j *JujuHandler) bootstrapProvider(provider providers.Provider) error {
 func (j *JujuHandler) killProvider(provider providers.Provider) error {
 	controllerName := fmt.Sprintf("concierge-%s", provider.Name())
 
-	bootstrapped, err := j.checkBootstrapped(controllerName)
+	bootstrapped, err := j.checkBootstrapped(controllerName, bootstrapCheckRetries)
 	if err != nil {
 		return fmt.Errorf("error checking bootstrap status for provider '%s'", provider.Name())
 	}
@@ -309,21 +453,27 @@ func (j *JujuHandler) killProvider(provider providers.Provider) error {
 	return nil
 }
 
-// checkBootstrapped checks whether concierge has already been bootstrapped on a given provider.
-func (j *JujuHandler) checkBootstrapped(controllerName string) (bool, error) {
+// bootstrapCheckRetries is how many times checkBootstrapped retries a controller that
+// does not respond before bootstrapping or destroying it.
+const bootstrapCheckRetries = 10
+
+// checkBootstrapped checks whether concierge has already been bootstrapped on a given
+// provider, retrying up to the specified number of times if the controller does not
+// respond.
+func (j *JujuHandler) checkBootstrapped(controllerName string, retries uint64) (bool, error) {
 	user := j.system.User().Username
 	cmd := system.NewCommandAs(user, "", "juju", []string{"show-controller", controllerName})
 	cmd.ReadOnly = true
 	cmd.ExpectedError = `controller \S+ not found`
 
 	// Configure a back-off for retrying the assessment of controller status.
-	backoff := retry.WithMaxRetries(10, retry.NewExponential(1*time.Second))
+	backoff := retry.WithMaxRetries(retries, retry.NewExponential(1*time.Second))
 
 	// Run a function, with retries/backoff, to assess whether the controller exists.
 	// This retry works around an issue where a given controller may not respond, causing the
 	// tool to conclude that the controller doesn't exist, rather than the controller simply
 	// not responding.
-	return retry.DoValue(context.Background(), backoff, func(ctx context.Context) (bool, error) {
+	return retry.DoValue(system.Context(j.system), backoff, func(ctx context.Context) (bool, error) {
 		output, err := j.system.Run(cmd)
 		if err != nil {
 			// If juju is not installed, the controller can't be bootstrapped.
@@ -182,6 +182,7 @@ func TestJujuHandlerWithCredentialedProvider(t *testing.T) {
                 deadbeef
                 -----END PRIVATE KEY-----
             project-id: concierge
+        default-credential: concierge
 `)
 
 	system, handler, err := setupHandlerWithGoogleProvider()
@@ -268,6 +269,112 @@ func TestJujuHandlerMergesMultipleCredentialedProviders(t *testing.T) {
 	}
 }
 
+func TestJujuHandlerMergesExistingCredentials(t *testing.T) {
+	existing := []byte(`credentials:
+    google:
+        default-credential: mine
+        mine:
+            auth-type: oauth2
+    aws:
+        default-credential: work
+        work:
+            auth-type: access-key
+`)
+
+	system, handler, err := setupHandlerWithGoogleProvider()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	credsFile := path.Join(os.TempDir(), ".local", "share", "juju", "credentials.yaml")
+	system.MockFile(credsFile, existing)
+
+	err = handler.Prepare()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var parsed map[string]map[string]map[string]any
+	if err := yaml.Unmarshal([]byte(system.CreatedFiles[credsFile]), &parsed); err != nil {
+		t.Fatalf("failed to parse credentials.yaml: %v", err)
+	}
+
+	google := parsed["credentials"]["google"]
+	if _, ok := google["mine"]; !ok {
+		t.Fatalf("existing google credential was overwritten: %v", google)
+	}
+	if _, ok := google["concierge"]; !ok {
+		t.Fatalf("concierge google credential was not added: %v", google)
+	}
+	if google["default-credential"] != "mine" {
+		t.Fatalf("expected existing default credential to be kept, got: %v", google["default-credential"])
+	}
+	if _, ok := parsed["credentials"]["aws"]["work"]; !ok {
+		t.Fatalf("credentials for other clouds were not preserved: %v", parsed)
+	}
+}
+
+func TestJujuRestorePreservesExistingCredentials(t *testing.T) {
+	existing := []byte(`credentials:
+    aws:
+        default-credential: work
+        work:
+            auth-type: access-key
+    google:
+        concierge:
+            auth-type: oauth2
+        default-credential: concierge
+`)
+
+	expected := `credentials:
+    aws:
+        default-credential: work
+        work:
+            auth-type: access-key
+`
+
+	system, handler, err := setupHandlerWithGoogleProvider()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	credsFile := path.Join(os.TempDir(), ".local", "share", "juju", "credentials.yaml")
+	system.MockFile(credsFile, existing)
+
+	if err := handler.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if system.CreatedFiles[credsFile] != expected {
+		t.Fatalf("expected: %v, got: %v", expected, system.CreatedFiles[credsFile])
+	}
+}
+
+func TestJujuRestoreRemovesConciergeOnlyCredentials(t *testing.T) {
+	existing := []byte(`credentials:
+    google:
+        concierge:
+            auth-type: oauth2
+        default-credential: concierge
+`)
+
+	system, handler, err := setupHandlerWithGoogleProvider()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	credsFile := path.Join(os.TempDir(), ".local", "share", "juju", "credentials.yaml")
+	system.MockFile(credsFile, existing)
+
+	if err := handler.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(system.CreatedFiles) > 0 {
+		t.Fatalf("expected no files to be created, got: %v", system.CreatedFiles)
+	}
+}
+
 func TestJujuRestoreNoKillController(t *testing.T) {
 	system, handler, err := setupHandlerWithPreset("machine")
 	if err != nil {
@@ -495,3 +602,44 @@ func TestGoArchToJujuArch(t *testing.T) {
 		}
 	}
 }
+
+func TestJujuHandlerChecks(t *testing.T) {
+	sys, handler, err := setupHandlerWithGoogleProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.MockCommandReturn("sudo -u test-user juju show-model concierge-google:testing", []byte{}, fmt.Errorf("model not found"))
+
+	expected := []system.Check{
+		{Component: "juju", Name: "controller:google", Status: system.CheckPass, Detail: "controller 'concierge-google' is responding"},
+		{
+			Component: "juju", Name: "model:google", Status: system.CheckFail,
+			Detail: "model 'concierge-google:testing' is not reachable: model not found",
+			Remedy: "If the model was removed, run 'juju add-model -c concierge-google testing'",
+		},
+	}
+
+	checks := handler.Checks()
+	if !reflect.DeepEqual(expected, checks) {
+		t.Fatalf("expected: %+v, got: %+v", expected, checks)
+	}
+}
+
+func TestJujuHandlerChecksControllerNotFound(t *testing.T) {
+	sys, handler, err := setupHandlerWithGoogleProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.MockCommandReturn(
+		"sudo -u test-user juju show-controller concierge-google",
+		[]byte("ERROR controller concierge-google not found"), fmt.Errorf("exit status 1"),
+	)
+
+	checks := handler.Checks()
+	if len(checks) != 1 || checks[0].Status != system.CheckFail || checks[0].Detail != "controller 'concierge-google' not found" {
+		t.Fatalf("expected only the controller check to fail, got: %+v", checks)
+	}
+	if slices.Contains(sys.ExecutedCommands, "sudo -u test-user juju show-model concierge-google:testing") {
+		t.Fatal("expected the model not to be checked")
+	}
+}
@@ -0,0 +1,278 @@
+// Package metrics writes the outcome of concierge's runs as a Prometheus textfile, to
+// be exposed by node_exporter's textfile collector, such that provisioning time and
+// failure rate can be monitored across a fleet of machines.
+//
+// The textfile holds the metrics of the most recent run of each action, alongside
+// counters of every run which are carried over from the previous textfile.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/concierge/internal/report"
+	"github.com/canonical/x-go/strutil/shlex"
+)
+
+// Inventory counts what a run installed or removed.
+type Inventory struct {
+	Snaps       int
+	Debs        int
+	Controllers int
+}
+
+// family describes a metric family written to the textfile.
+type family struct {
+	help string
+	kind string
+}
+
+// Names of the metrics written to the textfile.
+const (
+	runsTotal        = "concierge_runs_total"
+	lastRunTimestamp = "concierge_last_run_timestamp_seconds"
+	lastRunSuccess   = "concierge_last_run_success"
+	lastRunDuration  = "concierge_last_run_duration_seconds"
+	stepDuration     = "concierge_step_duration_seconds"
+	stepSuccess      = "concierge_step_success"
+	stepRetries      = "concierge_step_retries"
+	commandRetries   = "concierge_command_retries"
+	snaps            = "concierge_snaps"
+	debs             = "concierge_debs"
+	controllers      = "concierge_controllers"
+)
+
+var families = map[string]family{
+	runsTotal:        {"Number of runs of concierge, by action and status.", "counter"},
+	lastRunTimestamp: {"Time at which the most recent run of each action finished.", "gauge"},
+	lastRunSuccess:   {"Whether the most recent run of each action succeeded.", "gauge"},
+	lastRunDuration:  {"Duration of the most recent run of each action.", "gauge"},
+	stepDuration:     {"Duration of each step of the most recent run of each action.", "gauge"},
+	stepSuccess:      {"Whether each step of the most recent run succeeded; -1 if it did not finish.", "gauge"},
+	stepRetries:      {"Number of retries made by each step of the most recent run.", "gauge"},
+	commandRetries:   {"Number of retries of commands or requests in the most recent run, by step and executable.", "gauge"},
+	snaps:            {"Number of snaps handled by the most recent run of each action.", "gauge"},
+	debs:             {"Number of debs handled by the most recent run of each action.", "gauge"},
+	controllers:      {"Number of Juju controllers handled by the most recent run of each action.", "gauge"},
+}
+
+// sample is a single sample of a metric, with its labels already formatted.
+type sample struct {
+	name   string
+	labels string
+	value  float64
+}
+
+// WriteTextfile records a run in the textfile at the specified path. The metrics of
+// earlier runs of other actions are kept, and counters are carried over. The file is
+// replaced atomically, such that node_exporter never reads it partially written.
+func WriteTextfile(filePath string, r *report.Report, inventory Inventory) error {
+	previous, err := readSamples(filePath)
+	if err != nil {
+		return err
+	}
+
+	samples := []sample{}
+	runs := map[string]float64{}
+	for _, s := range previous {
+		action := labelValue(s.labels, "action")
+		switch {
+		case s.name == runsTotal && action == r.Action:
+			runs[labelValue(s.labels, "status")] = s.value
+		case action != r.Action:
+			samples = append(samples, s)
+		}
+	}
+
+	runs[r.Status]++
+	for _, status := range slices.Sorted(maps.Keys(runs)) {
+		samples = append(samples, sample{runsTotal, labels("action", r.Action, "status", status), runs[status]})
+	}
+
+	success := 0.0
+	if r.Status == report.StatusSucceeded {
+		success = 1
+	}
+
+	action := labels("action", r.Action)
+	samples = append(samples,
+		sample{lastRunTimestamp, action, float64(r.Finished.UnixMilli()) / 1000},
+		sample{lastRunSuccess, action, success},
+		sample{lastRunDuration, action, seconds(r.DurationMS)},
+		sample{snaps, action, float64(inventory.Snaps)},
+		sample{debs, action, float64(inventory.Debs)},
+		sample{controllers, action, float64(inventory.Controllers)},
+	)
+
+	for _, s := range r.Steps {
+		step := labels("action", r.Action, "step", s.ID, "kind", s.Kind)
+
+		success := map[string]float64{
+			report.StatusSucceeded: 1,
+			report.StatusFailed:    0,
+			report.StatusRunning:   -1,
+		}[s.Status]
+
+		samples = append(samples,
+			sample{stepDuration, step, seconds(s.DurationMS)},
+			sample{stepSuccess, step, success},
+			sample{stepRetries, step, float64(s.Retries)},
+		)
+
+		// Commands are labelled by executable rather than in full, since their
+		// arguments would make a new series of nearly every command.
+		retries := map[string]int{}
+		for command, n := range s.RetriesByCommand {
+			retries[executable(command)] += n
+		}
+		for _, name := range slices.Sorted(maps.Keys(retries)) {
+			samples = append(samples, sample{commandRetries,
+				labels("action", r.Action, "step", s.ID, "executable", name), float64(retries[name])})
+		}
+	}
+
+	return writeAtomically(filePath, format(samples))
+}
+
+// format returns the samples in the Prometheus text exposition format, grouped by
+// metric family in name order.
+func format(samples []sample) string {
+	byName := map[string][]sample{}
+	for _, s := range samples {
+		byName[s.name] = append(byName[s.name], s)
+	}
+
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(byName)) {
+		f := families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+		for _, s := range byName[name] {
+			fmt.Fprintf(&b, "%s{%s} %s\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	return b.String()
+}
+
+// samplePattern matches a sample in the text exposition format, without a timestamp.
+var samplePattern = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)\{(.*)\} (\S+)$`)
+
+// readSamples reads the samples of the known metric families from an earlier
+// textfile. Anything else in the file is dropped.
+func readSamples(filePath string) ([]sample, error) {
+	f, err := os.Open(filePath) //nolint:gosec // G304: the path is provided by the user via CLI flag
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read metrics textfile: %w", err)
+	}
+	defer func() { _ = f.Close() }() // Read-only file; close error is not actionable
+
+	samples := []sample{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := samplePattern.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		if _, known := families[m[1]]; !known {
+			continue
+		}
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			continue
+		}
+		samples = append(samples, sample{m[1], m[2], value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read metrics textfile: %w", err)
+	}
+	return samples, nil
+}
+
+// writeAtomically writes content to a temporary file alongside filePath, and renames it
+// into place. The temporary file does not end in .prom, so is not read by node_exporter.
+func writeAtomically(filePath, content string) error {
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create metrics textfile: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.WriteString(content); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write metrics textfile: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics textfile: %w", err)
+	}
+
+	// node_exporter commonly runs as an unprivileged user, so the file must be
+	// readable by others.
+	if err := os.Chmod(f.Name(), 0644); err != nil { //nolint:gosec // G302: metrics are meant to be read by node_exporter
+		return fmt.Errorf("failed to write metrics textfile: %w", err)
+	}
+	if err := os.Rename(f.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to write metrics textfile: %w", err)
+	}
+	return nil
+}
+
+// labels formats pairs of label names and values.
+func labels(pairs ...string) string {
+	formatted := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(pairs[i+1])
+		formatted = append(formatted, fmt.Sprintf(`%s="%s"`, pairs[i], value))
+	}
+	return strings.Join(formatted, ",")
+}
+
+// labelPattern matches a label in formatted labels, capturing its name and value.
+var labelPattern = regexp.MustCompile(`(?:^|,)([a-zA-Z_][a-zA-Z0-9_]*)="((?:[^"\\]|\\.)*)"`)
+
+// labelValue returns the value of the named label in formatted labels, or "".
+func labelValue(formatted, name string) string {
+	for _, m := range labelPattern.FindAllStringSubmatch(formatted, -1) {
+		if m[1] == name {
+			return strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\n`, "\n").Replace(m[2])
+		}
+	}
+	return ""
+}
+
+// executable returns the name of the executable run by a command, such as "juju" for
+// `sudo -u ubuntu juju bootstrap lxd`, or of the service called by a request, such as
+// "snapd" for `snapd: find jhack`.
+func executable(command string) string {
+	words, err := shlex.Split(command)
+	if err != nil {
+		words = strings.Fields(command)
+	}
+
+	for i := 0; i < len(words); i++ {
+		switch word := words[i]; {
+		case word == "sudo":
+		case word == "-u" || word == "-g":
+			i++
+		case strings.Contains(word, "=") && !strings.Contains(word, "/"):
+			// An environment variable set for the command.
+		default:
+			return strings.TrimSuffix(path.Base(word), ":")
+		}
+	}
+	return ""
+}
+
+// seconds converts a duration in milliseconds to seconds.
+func seconds(ms int64) float64 {
+	return float64(ms) / 1000
+}
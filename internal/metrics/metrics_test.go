@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/report"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	textfile := filepath.Join(t.TempDir(), "concierge.prom")
+	finished := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	prepare := &report.Report{
+		Action:     "prepare",
+		Finished:   finished,
+		DurationMS: 95500,
+		Status:     report.StatusSucceeded,
+		Steps: []*report.Step{
+			{ID: "snap:jhack", Kind: "snap", Status: report.StatusSucceeded, DurationMS: 2000},
+			{ID: "juju:bootstrap:lxd", Kind: "juju", Status: report.StatusSucceeded, DurationMS: 90000, Retries: 2,
+				RetriesByCommand: map[string]int{`juju bootstrap lxd concierge-lxd --config "a=b"`: 2}},
+		},
+	}
+
+	if err := WriteTextfile(textfile, prepare, Inventory{Snaps: 3, Debs: 2, Controllers: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	restore := &report.Report{Action: "restore", Finished: finished, DurationMS: 1000, Status: report.StatusFailed}
+	if err := WriteTextfile(textfile, restore, Inventory{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second, failed prepare replaces the metrics of the first, but is counted
+	// alongside it.
+	prepare.Status = report.StatusFailed
+	prepare.Steps = prepare.Steps[:1]
+	prepare.Steps[0].Status = report.StatusRunning
+	if err := WriteTextfile(textfile, prepare, Inventory{Snaps: 3, Debs: 2, Controllers: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(textfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		"# TYPE concierge_runs_total counter\n",
+		`concierge_runs_total{action="prepare",status="failed"} 1` + "\n",
+		`concierge_runs_total{action="prepare",status="succeeded"} 1` + "\n",
+		`concierge_runs_total{action="restore",status="failed"} 1` + "\n",
+		"# TYPE concierge_last_run_success gauge\n",
+		`concierge_last_run_success{action="prepare"} 0` + "\n",
+		`concierge_last_run_duration_seconds{action="prepare"} 95.5` + "\n",
+		`concierge_last_run_duration_seconds{action="restore"} 1` + "\n",
+		`concierge_last_run_timestamp_seconds{action="prepare"} 1.7672688e+09` + "\n",
+		`concierge_step_success{action="prepare",step="snap:jhack",kind="snap"} -1` + "\n",
+		`concierge_snaps{action="prepare"} 3` + "\n",
+		`concierge_debs{action="restore"} 0` + "\n",
+		`concierge_controllers{action="prepare"} 1` + "\n",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("expected textfile to contain %q, got:\n%s", expected, content)
+		}
+	}
+
+	if strings.Contains(string(content), "juju:bootstrap:lxd") {
+		t.Fatalf("expected metrics of the earlier prepare to be replaced, got:\n%s", content)
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(textfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the textfile to remain, got: %v", entries)
+	}
+}
+
+func TestWriteTextfileCommandRetries(t *testing.T) {
+	textfile := filepath.Join(t.TempDir(), "concierge.prom")
+
+	r := &report.Report{
+		Action: "prepare",
+		Status: report.StatusSucceeded,
+		Steps: []*report.Step{{ID: "juju:bootstrap:lxd", Kind: "juju", Status: report.StatusSucceeded, Retries: 6,
+			RetriesByCommand: map[string]int{
+				"sudo -u ubuntu juju bootstrap lxd concierge-lxd": 2,
+				"sudo -u ubuntu juju add-model testing":           1,
+				"snapd: find juju":                                3,
+			}}},
+	}
+	if err := WriteTextfile(textfile, r, Inventory{}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(textfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`concierge_command_retries{action="prepare",step="juju:bootstrap:lxd",executable="juju"} 3` + "\n",
+		`concierge_command_retries{action="prepare",step="juju:bootstrap:lxd",executable="snapd"} 3` + "\n",
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("expected textfile to contain %q, got:\n%s", expected, content)
+		}
+	}
+}
+
+func TestWriteTextfileEscapesLabels(t *testing.T) {
+	textfile := filepath.Join(t.TempDir(), "concierge.prom")
+
+	r := &report.Report{
+		Action: "prepare",
+		Status: report.StatusSucceeded,
+		Steps: []*report.Step{{ID: "juju:bootstrap:lxd", Kind: "juju\"\\", Status: report.StatusSucceeded, Retries: 2,
+			RetriesByCommand: map[string]int{`juju bootstrap --config "a=b\c"`: 2}}},
+	}
+	if err := WriteTextfile(textfile, r, Inventory{}); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(textfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `concierge_step_retries{action="prepare",step="juju:bootstrap:lxd",kind="juju\"\\"} 2`
+	if !strings.Contains(string(content), expected) {
+		t.Fatalf("expected textfile to contain %s, got:\n%s", expected, content)
+	}
+
+	// Labels are read back as they were written.
+	samples, err := readSamples(textfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range samples {
+		if s.name == stepRetries && labelValue(s.labels, "kind") != `juju"\` {
+			t.Fatalf("unexpected kind label: %s", labelValue(s.labels, "kind"))
+		}
+	}
+}
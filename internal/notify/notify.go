@@ -0,0 +1,164 @@
+// Package notify posts the outcome of a run of concierge to HTTP webhooks, so that
+// nobody needs to watch a long 'prepare' on a remote machine until it finishes.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/report"
+	retry "github.com/sethvargo/go-retry"
+)
+
+// Defaults for webhooks that do not configure their own timeout or retries.
+const (
+	DefaultTimeout = 10 * time.Second
+	DefaultRetries = 3
+)
+
+// retryBackoff is the initial delay before retrying a failed delivery, doubling after
+// each attempt.
+var retryBackoff = time.Second
+
+// Payload is the JSON document posted to each webhook.
+type Payload struct {
+	// Action is the action performed; either "prepare" or "restore".
+	Action string `json:"action"`
+	// Status is either "succeeded" or "failed".
+	Status string `json:"status"`
+	// Host is the hostname of the machine, and User the user it was prepared for.
+	Host string `json:"host"`
+	User string `json:"user"`
+	// ConciergeVersion is the version of concierge that performed the run.
+	ConciergeVersion string `json:"concierge-version"`
+	// Started and Finished are the times at which the run started and finished.
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// DurationMS is how long the run took, in milliseconds.
+	DurationMS int64 `json:"duration-ms"`
+	// Error is the error that the run failed with, if any.
+	Error string `json:"error,omitempty"`
+	// FailedStep is the ID of the first step that failed, if any, such as
+	// `juju:bootstrap:lxd`.
+	FailedStep string `json:"failed-step,omitempty"`
+}
+
+// NewPayload describes the outcome of a run from its report.
+func NewPayload(r *report.Report, host, user string) *Payload {
+	p := &Payload{
+		Action:           r.Action,
+		Status:           r.Status,
+		Host:             host,
+		User:             user,
+		ConciergeVersion: r.ConciergeVersion,
+		Started:          r.Started,
+		Finished:         r.Finished,
+		DurationMS:       r.DurationMS,
+		Error:            r.Error,
+	}
+
+	for _, s := range r.Steps {
+		if s.Status == report.StatusFailed {
+			p.FailedStep = s.ID
+			break
+		}
+	}
+	return p
+}
+
+// Send posts the payload to each webhook concurrently, retrying failed deliveries. The
+// errors of any webhooks that could not be notified are returned together.
+func Send(webhooks []config.WebhookConfig, p *Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	errs := make([]error, len(webhooks))
+	var wg sync.WaitGroup
+	for i, webhook := range webhooks {
+		wg.Go(func() {
+			if err := send(webhook, body); err != nil {
+				errs[i] = fmt.Errorf("failed to notify %s: %w", Redact(webhook.URL), err)
+			}
+		})
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// send posts the body to a single webhook. Network errors, and responses indicating
+// that the server is unavailable or rate limiting, are retried.
+func send(webhook config.WebhookConfig, body []byte) error {
+	timeout := webhook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	retries := DefaultRetries
+	if webhook.Retries != nil {
+		retries = max(*webhook.Retries, 0)
+	}
+
+	backoff := retry.WithMaxRetries(uint64(retries), retry.NewExponential(retryBackoff))
+	return retry.Do(context.Background(), backoff, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+		if err != nil {
+			return withoutURL(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "concierge")
+		for name, value := range webhook.Headers {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return retry.RetryableError(withoutURL(err))
+		}
+		// The response body is drained so that the connection can be reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return retry.RetryableError(fmt.Errorf("unexpected response: %s", resp.Status))
+		default:
+			return fmt.Errorf("unexpected response: %s", resp.Status)
+		}
+	})
+}
+
+// Redact returns a webhook URL with only its scheme and host, since the path or query
+// of many webhook URLs is itself a secret.
+func Redact(webhookURL string) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil || u.Host == "" {
+		return "webhook"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// withoutURL removes the URL from an error returned by package http or url, since it may
+// hold a secret.
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/report"
+)
+
+func init() {
+	retryBackoff = time.Millisecond
+}
+
+func TestNewPayload(t *testing.T) {
+	r := &report.Report{
+		Action:     "prepare",
+		Status:     report.StatusFailed,
+		DurationMS: 1500,
+		Error:      "failed to prepare Juju",
+		Steps: []*report.Step{
+			{ID: "snap:jhack", Status: report.StatusSucceeded},
+			{ID: "juju:bootstrap:lxd", Status: report.StatusFailed},
+			{ID: "juju:bootstrap:k8s", Status: report.StatusFailed},
+		},
+	}
+
+	p := NewPayload(r, "devvm", "ubuntu")
+	if p.Action != "prepare" || p.Status != report.StatusFailed || p.Host != "devvm" || p.User != "ubuntu" ||
+		p.DurationMS != 1500 || p.Error != r.Error || p.FailedStep != "juju:bootstrap:lxd" {
+		t.Fatalf("unexpected payload: %+v", p)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var requests atomic.Int32
+	var received Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first attempt fails, as though the server were briefly unavailable.
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	webhooks := []config.WebhookConfig{{URL: server.URL + "/hook", Headers: map[string]string{"Authorization": "Bearer token"}}}
+	err := Send(webhooks, &Payload{Action: "restore", Status: report.StatusSucceeded, Host: "devvm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if requests.Load() != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests.Load())
+	}
+	if received.Action != "restore" || received.Host != "devvm" {
+		t.Fatalf("unexpected payload received: %+v", received)
+	}
+}
+
+func TestSendFailures(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/slow/secret":
+			time.Sleep(100 * time.Millisecond)
+		case "/missing/secret":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	retries := 1
+	webhooks := []config.WebhookConfig{
+		{URL: server.URL + "/slow/secret", Timeout: 10 * time.Millisecond, Retries: &retries},
+		// Client errors are not retried.
+		{URL: server.URL + "/missing/secret"},
+	}
+
+	err := Send(webhooks, &Payload{Action: "prepare"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "context deadline exceeded") || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("expected webhook urls to be redacted, got: %v", err)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("expected 3 requests, got %d", requests.Load())
+	}
+}
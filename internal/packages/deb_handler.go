@@ -3,7 +3,9 @@ package packages
 import (
 	"fmt"
 	"log/slog"
+	"strings"
 
+	"github.com/canonical/concierge/internal/progress"
 	"github.com/canonical/concierge/internal/system"
 )
 
@@ -59,7 +61,10 @@ func (h *DebHandler) Prepare() error {
 	}
 
 	for _, deb := range h.Debs {
-		err := h.installDeb(deb)
+		step := progress.StepID(progress.KindDeb, deb.Name)
+		err := progress.Track(step, func() error {
+			return h.installDeb(h.system.WithStep(step), deb)
+		})
 		if err != nil {
 			return fmt.Errorf("failed to install deb: %w", err)
 		}
@@ -70,7 +75,10 @@ func (h *DebHandler) Prepare() error {
 // Restore removes a set of debs from the machine.
 func (h *DebHandler) Restore() error {
 	for _, deb := range h.Debs {
-		err := h.removeDeb(deb)
+		step := progress.StepID(progress.KindDeb, deb.Name)
+		err := progress.Track(step, func() error {
+			return h.removeDeb(h.system.WithStep(step), deb)
+		})
 		if err != nil {
 			return fmt.Errorf("failed to remove deb: %w", err)
 		}
@@ -86,14 +94,30 @@ func (h *DebHandler) Restore() error {
 	return nil
 }
 
-// installDeb uses `apt` to install the package on the system from the archives.
-func (h *DebHandler) installDeb(d *Deb) error {
+// Leftovers reports any of the debs that are still installed on the machine.
+func (h *DebHandler) Leftovers() []system.Leftover {
+	leftovers := []system.Leftover{}
+	for _, deb := range h.Debs {
+		cmd := system.NewCommand("dpkg-query", []string{"-W", "-f=${Status}", deb.Name})
+		cmd.ReadOnly = true
+		cmd.ExpectedError = `no packages found`
+		output, err := h.system.Run(cmd)
+		if err == nil && strings.Contains(string(output), "ok installed") {
+			leftovers = append(leftovers, system.Leftover{Kind: system.LeftoverDeb, Name: deb.Name})
+		}
+	}
+	return leftovers
+}
+
+// installDeb uses `apt` to install the package on the system from the archives, using
+// the Worker w.
+func (h *DebHandler) installDeb(w system.Worker, d *Deb) error {
 	cmd := aptCommand("install",
 		"-o", "Dpkg::Options::=--force-confdef",
 		"-o", "Dpkg::Options::=--force-confold",
 		d.Name)
 
-	_, err := system.RunExclusive(h.system, cmd)
+	_, err := system.RunExclusive(w, cmd)
 	if err != nil {
 		return fmt.Errorf("failed to install apt package '%s': %w", d.Name, err)
 	}
@@ -102,11 +126,11 @@ func (h *DebHandler) installDeb(d *Deb) error {
 	return nil
 }
 
-// Remove uninstalls the deb from the system with `apt`.
-func (h *DebHandler) removeDeb(d *Deb) error {
+// Remove uninstalls the deb from the system with `apt`, using the Worker w.
+func (h *DebHandler) removeDeb(w system.Worker, d *Deb) error {
 	cmd := aptCommand("remove", d.Name)
 
-	_, err := system.RunExclusive(h.system, cmd)
+	_, err := system.RunExclusive(w, cmd)
 	if err != nil {
 		return fmt.Errorf("failed to remove apt package '%s': %w", d.Name, err)
 	}
@@ -1,6 +1,7 @@
 package packages
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -46,3 +47,18 @@ func TestDebHandlerCommands(t *testing.T) {
 		}
 	}
 }
+
+func TestDebHandlerLeftovers(t *testing.T) {
+	r := system.NewMockSystem()
+	r.MockCommandReturn("dpkg-query -W '-f=${Status}' make", []byte("install ok installed"), nil)
+	r.MockCommandReturn("dpkg-query -W '-f=${Status}' tox", []byte("dpkg-query: no packages found matching tox"), fmt.Errorf("exit status 1"))
+
+	handler := NewDebHandler(r, []*Deb{{Name: "make"}, {Name: "tox"}})
+
+	expected := []system.Leftover{{Kind: system.LeftoverDeb, Name: "make"}}
+	leftovers := handler.Leftovers()
+
+	if !reflect.DeepEqual(expected, leftovers) {
+		t.Fatalf("expected: %v, got: %v", expected, leftovers)
+	}
+}
@@ -3,8 +3,10 @@ package packages
 import (
 	"fmt"
 	"log/slog"
+	"path"
 	"strings"
 
+	"github.com/canonical/concierge/internal/progress"
 	"github.com/canonical/concierge/internal/system"
 )
 
@@ -25,14 +27,23 @@ type SnapHandler struct {
 // Prepare installs a set of snaps on the machine.
 func (h *SnapHandler) Prepare() error {
 	for _, snap := range h.Snaps {
-		err := h.installSnap(snap)
-		if err != nil {
-			return fmt.Errorf("failed to install snap: %w", err)
-		}
+		step := progress.StepID(progress.KindSnap, snap.Name)
+		err := progress.Track(step, func() error {
+			w := h.system.WithStep(step)
 
-		err = h.connectSnap(snap)
+			err := h.installSnap(w, snap)
+			if err != nil {
+				return fmt.Errorf("failed to install snap: %w", err)
+			}
+
+			err = h.connectSnap(w, snap)
+			if err != nil {
+				return fmt.Errorf("failed to create snap connections: %w", err)
+			}
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("failed to create snap connections: %w", err)
+			return err
 		}
 	}
 	return nil
@@ -41,7 +52,10 @@ func (h *SnapHandler) Prepare() error {
 // Restore removes a set of snaps from the machine.
 func (h *SnapHandler) Restore() error {
 	for _, snap := range h.Snaps {
-		err := h.removeSnap(snap)
+		step := progress.StepID(progress.KindSnap, snap.Name)
+		err := progress.Track(step, func() error {
+			return h.removeSnap(h.system.WithStep(step), snap)
+		})
 		if err != nil {
 			return fmt.Errorf("failed to remove snap: %w", err)
 		}
@@ -49,13 +63,25 @@ func (h *SnapHandler) Restore() error {
 	return nil
 }
 
-// installSnap ensures that the specified snap is installed at the specified channel.
-// If already installed, but on the wrong channel, the snap is refreshed.
-func (h *SnapHandler) installSnap(s *system.Snap) error {
+// Leftovers reports any of the snaps, or their data directories, that still exist
+// on the machine.
+func (h *SnapHandler) Leftovers() []system.Leftover {
+	leftovers := []system.Leftover{}
+	for _, snap := range h.Snaps {
+		leftovers = append(leftovers, system.PathLeftovers(h.system, system.LeftoverSnap, path.Join("/snap", snap.Name))...)
+		leftovers = append(leftovers, system.PathLeftovers(h.system, system.LeftoverPath, path.Join("/var/snap", snap.Name))...)
+	}
+	return leftovers
+}
+
+// installSnap ensures that the specified snap is installed at the specified channel,
+// using the Worker w. If already installed, but on the wrong channel, the snap is
+// refreshed.
+func (h *SnapHandler) installSnap(w system.Worker, s *system.Snap) error {
 	slog.Debug("Installing snap", "snap", s.Name)
 	var action, logAction string
 
-	snapInfo, err := h.system.SnapInfo(s.Name, s.Channel)
+	snapInfo, err := w.SnapInfo(s.Name, s.Channel)
 	if err != nil {
 		return fmt.Errorf("failed to lookup snap details: %w", err)
 	}
@@ -64,7 +90,7 @@ func (h *SnapHandler) installSnap(s *system.Snap) error {
 		// A disabled snap must be enabled before it can be refreshed.
 		if !snapInfo.Active {
 			enableCmd := system.NewCommand("snap", []string{"enable", s.Name})
-			if _, err := system.RunExclusive(h.system, enableCmd); err != nil {
+			if _, err := system.RunExclusive(w, enableCmd); err != nil {
 				return fmt.Errorf("failed to enable snap %q: %w", s.Name, err)
 			}
 			slog.Info("Enabled disabled snap", "snap", s.Name)
@@ -91,7 +117,7 @@ func (h *SnapHandler) installSnap(s *system.Snap) error {
 	}
 
 	cmd := system.NewCommand("snap", args)
-	_, err = system.RunExclusive(h.system, cmd)
+	_, err = system.RunExclusive(w, cmd)
 	if err != nil {
 		return fmt.Errorf("command failed: %w", err)
 	}
@@ -100,8 +126,9 @@ func (h *SnapHandler) installSnap(s *system.Snap) error {
 	return nil
 }
 
-// connectSnap ensures that the specified snap interfaces are connected.
-func (h *SnapHandler) connectSnap(s *system.Snap) error {
+// connectSnap ensures that the specified snap interfaces are connected, using the
+// Worker w.
+func (h *SnapHandler) connectSnap(w system.Worker, s *system.Snap) error {
 	for _, connection := range s.Connections {
 		parts := strings.Split(connection, " ")
 		if len(parts) > 2 {
@@ -111,7 +138,7 @@ func (h *SnapHandler) connectSnap(s *system.Snap) error {
 		args := append([]string{"connect"}, parts...)
 
 		cmd := system.NewCommand("snap", args)
-		_, err := system.RunExclusive(h.system, cmd)
+		_, err := system.RunExclusive(w, cmd)
 		if err != nil {
 			return fmt.Errorf("command failed: %w", err)
 		}
@@ -119,13 +146,14 @@ func (h *SnapHandler) connectSnap(s *system.Snap) error {
 	return nil
 }
 
-// removeSnap uninstalls the specified snap from the system, optionally purging its data.
-func (h *SnapHandler) removeSnap(s *system.Snap) error {
+// removeSnap uninstalls the specified snap from the system using the Worker w,
+// optionally purging its data.
+func (h *SnapHandler) removeSnap(w system.Worker, s *system.Snap) error {
 	slog.Debug("Removing snap", "snap", s.Name)
 	args := []string{"remove", s.Name, "--purge"}
 
 	cmd := system.NewCommand("snap", args)
-	_, err := system.RunExclusive(h.system, cmd)
+	_, err := system.RunExclusive(w, cmd)
 	if err != nil {
 		return fmt.Errorf("failed to remove snap '%s': %w", s.Name, err)
 	}
@@ -2,8 +2,11 @@ package packages
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/canonical/concierge/internal/snapd"
+	"github.com/canonical/concierge/internal/snapd/snapdtest"
 	"github.com/canonical/concierge/internal/system"
 )
 
@@ -84,3 +87,53 @@ func TestSnapHandlerWithRevision(t *testing.T) {
 		}
 	}
 }
+
+func TestSnapHandlerAgainstSnapd(t *testing.T) {
+	server := snapdtest.NewServer(t)
+	server.AddStoreSnap(snapd.Snap{Name: "charmcraft", Confinement: "classic"})
+	server.AddStoreSnap(snapd.Snap{Name: "jq", Confinement: "strict"})
+	server.AddStoreSnap(snapd.Snap{Name: "lxd", Confinement: "strict"})
+	server.AddInstalledSnap(snapd.Snap{Name: "charmcraft", TrackingChannel: "latest/stable"})
+	server.AddInstalledSnap(snapd.Snap{Name: "lxd", Status: snapd.StatusInstalled, TrackingChannel: "5.21/stable"})
+
+	r := system.NewMockSystem()
+	r.MockSnapd(server.Socket())
+
+	snaps := []*system.Snap{
+		system.NewSnap("charmcraft", "latest/stable", []string{}),
+		system.NewSnap("jq", "latest/stable", []string{}),
+		system.NewSnap("lxd", "5.21/stable", []string{}),
+	}
+
+	err := NewSnapHandler(r, snaps).Prepare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"snap refresh charmcraft --channel latest/stable --classic",
+		"snap install jq --channel latest/stable",
+		"snap enable lxd",
+		"snap refresh lxd --channel 5.21/stable",
+	}
+
+	if !reflect.DeepEqual(expected, r.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expected, r.ExecutedCommands)
+	}
+}
+
+func TestSnapHandlerSnapNotInStore(t *testing.T) {
+	server := snapdtest.NewServer(t)
+
+	r := system.NewMockSystem()
+	r.MockSnapd(server.Socket())
+
+	err := NewSnapHandler(r, []*system.Snap{system.NewSnap("nonexistent", "", []string{})}).Prepare()
+	if err == nil || !strings.Contains(err.Error(), "snap not found") {
+		t.Fatalf("expected snap not found error, got: %v", err)
+	}
+
+	if len(r.ExecutedCommands) != 0 {
+		t.Fatalf("expected no commands to be run, got: %v", r.ExecutedCommands)
+	}
+}
@@ -0,0 +1,249 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spinner holds the frames drawn beside each running step.
+var spinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// refreshInterval is how often a Display redraws, to advance spinners and timers.
+const refreshInterval = 100 * time.Millisecond
+
+// stepStatus is what a Display knows of a single step.
+type stepStatus struct {
+	id       string
+	started  time.Time
+	finished time.Time
+	err      error
+	retries  int
+	lastLine string
+}
+
+// Display draws a live view of each step on a terminal: a spinner while it runs, its
+// elapsed time, how many times it has been retried, and the last line of output from
+// the command it is running. Finished steps are marked with their outcome.
+type Display struct {
+	out   io.Writer
+	width func() int
+	now   func() time.Time
+
+	mu    sync.Mutex
+	steps []*stepStatus
+	frame int
+	// drawn is the number of lines drawn by the last redraw, which are erased before
+	// the next.
+	drawn int
+}
+
+// NewDisplay constructs a Display that draws to out, which must be a terminal. The
+// width function reports the width of the terminal, such that lines can be truncated
+// rather than wrapped.
+func NewDisplay(out io.Writer, width func() int) *Display {
+	return &Display{out: out, width: width, now: time.Now}
+}
+
+// Start subscribes the Display to all subsequent events, and redraws it periodically
+// until the returned function is called. Stopping draws the final state of each step,
+// and is safe to call more than once.
+func (d *Display) Start() (stop func()) {
+	unsubscribe := SubscribeWithOutput(d.handle)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				d.redraw()
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+			<-finished
+			d.redraw()
+		})
+	}
+}
+
+// handle updates the status of the step an event relates to.
+func (d *Display) handle(e Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch e.Type {
+	case EventStart:
+		d.steps = slices.DeleteFunc(d.steps, func(s *stepStatus) bool { return s.id == e.Step })
+		d.steps = append(d.steps, &stepStatus{id: e.Step, started: e.Time})
+	case EventEnd:
+		if s := d.step(e.Step); s != nil {
+			s.finished = e.Time
+			s.err = e.Err
+		}
+	case EventRetry:
+		if s := d.running(e.Step); s != nil {
+			s.retries++
+		}
+	case EventOutput:
+		if s := d.running(e.Step); s != nil {
+			s.lastLine = e.Message
+		}
+	}
+}
+
+// step returns the status of the step with the specified ID, or nil. It must be called
+// with mu held.
+func (d *Display) step(id string) *stepStatus {
+	for _, s := range d.steps {
+		if s.id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+// running returns the status of the step with the specified ID if it is still running,
+// or nil. It must be called with mu held.
+func (d *Display) running(id string) *stepStatus {
+	if s := d.step(id); s != nil && s.finished.IsZero() {
+		return s
+	}
+	return nil
+}
+
+// redraw erases the lines drawn previously, and draws the current state of each step.
+func (d *Display) redraw() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.frame++
+	lines := d.render(d.now(), d.width())
+	fmt.Fprint(d.out, d.erase()+strings.Join(lines, ""))
+	d.drawn = len(lines)
+}
+
+// erase returns the sequence that moves the cursor to the start of the first line
+// drawn previously, and clears everything beneath it. It must be called with mu held.
+func (d *Display) erase() string {
+	if d.drawn == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\033[%dA\r\033[J", d.drawn)
+}
+
+// render returns a line, including its newline, for each step as of now, truncated to
+// width. It must be called with mu held.
+func (d *Display) render(now time.Time, width int) []string {
+	lines := []string{}
+	for _, s := range d.steps {
+		symbol := spinner[d.frame%len(spinner)]
+		elapsed := now.Sub(s.started)
+		switch {
+		case !s.finished.IsZero() && s.err != nil:
+			symbol = "✗"
+			elapsed = s.finished.Sub(s.started)
+		case !s.finished.IsZero():
+			symbol = "✓"
+			elapsed = s.finished.Sub(s.started)
+		}
+
+		line := fmt.Sprintf("%s %s  %s", symbol, s.id, elapsed.Round(time.Second))
+		if s.retries == 1 {
+			line += "  (1 retry)"
+		} else if s.retries > 1 {
+			line += fmt.Sprintf("  (%d retries)", s.retries)
+		}
+		if s.finished.IsZero() && s.lastLine != "" {
+			line += "  " + s.lastLine
+		}
+		if s.err != nil {
+			line += "  " + s.err.Error()
+		}
+
+		lines = append(lines, truncate(line, width)+"\n")
+	}
+	return lines
+}
+
+// truncate shortens a line such that it fits within width columns on the terminal.
+func truncate(line string, width int) string {
+	line = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return ' '
+		}
+		return r
+	}, line)
+
+	runes := []rune(line)
+	if width <= 1 || len(runes) <= width-1 {
+		return line
+	}
+	// Leave the last column free, since some terminals wrap on writing to it.
+	return string(runes[:width-2]) + "…"
+}
+
+// Handler wraps a slog.Handler so that records logged while the Display is drawn
+// appear above it, rather than corrupting it.
+func (d *Display) Handler(next slog.Handler) slog.Handler {
+	return &displayHandler{next: next, display: d}
+}
+
+type displayHandler struct {
+	next    slog.Handler
+	display *Display
+}
+
+// Enabled reports whether the wrapped handler handles records at the level.
+func (h *displayHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle erases the Display, passes the record on to the wrapped handler, and leaves
+// the Display to be drawn again beneath it on the next redraw.
+func (h *displayHandler) Handle(ctx context.Context, r slog.Record) error {
+	d := h.display
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprint(d.out, d.erase())
+	d.drawn = 0
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler whose records include the attributes.
+func (h *displayHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &displayHandler{next: h.next.WithAttrs(attrs), display: h.display}
+}
+
+// WithGroup returns a handler whose records are grouped under name.
+func (h *displayHandler) WithGroup(name string) slog.Handler {
+	return &displayHandler{next: h.next.WithGroup(name), display: h.display}
+}
+
+// Write prints p above the Display, such that it is drawn again beneath on the next
+// redraw.
+func (d *Display) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fmt.Fprint(d.out, d.erase())
+	d.drawn = 0
+	return d.out.Write(p)
+}
@@ -0,0 +1,92 @@
+package progress
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDisplayRender(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	d := NewDisplay(&bytes.Buffer{}, func() int { return 80 })
+
+	d.handle(Event{Type: EventStart, Step: "snap:jhack", Time: start})
+	d.handle(Event{Type: EventStart, Step: "provider:lxd", Time: start})
+	d.handle(Event{Type: EventStart, Step: "juju:bootstrap:lxd", Time: start.Add(time.Second)})
+
+	// Output and retries are shown against the step that ran the command.
+	d.handle(Event{Type: EventOutput, Step: "snap:jhack", Command: "snap install jhack --channel latest/edge", Message: "Downloading snap \"jhack\""})
+	d.handle(Event{Type: EventRetry, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd", Attempt: 1})
+	d.handle(Event{Type: EventRetry, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd", Attempt: 2})
+	d.handle(Event{Type: EventOutput, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd", Message: "Creating Juju controller"})
+	d.handle(Event{Type: EventEnd, Step: "provider:lxd", Time: start.Add(3 * time.Second), Err: errors.New("lxd failed")})
+
+	lines := d.render(start.Add(10*time.Second), 80)
+	expected := []string{
+		"⠋ snap:jhack  10s  Downloading snap \"jhack\"\n",
+		"✗ provider:lxd  3s  lxd failed\n",
+		"⠋ juju:bootstrap:lxd  9s  (2 retries)  Creating Juju controller\n",
+	}
+	if strings.Join(lines, "") != strings.Join(expected, "") {
+		t.Fatalf("unexpected display:\n%s\nexpected:\n%s", strings.Join(lines, ""), strings.Join(expected, ""))
+	}
+
+	d.handle(Event{Type: EventEnd, Step: "snap:jhack", Time: start.Add(20 * time.Second)})
+	lines = d.render(start.Add(time.Minute), 20)
+	if lines[0] != "✓ snap:jhack  20s\n" {
+		t.Fatalf("unexpected line for finished step: %q", lines[0])
+	}
+	if lines[2] != "⠋ juju:bootstrap:l…\n" {
+		t.Fatalf("expected line to be truncated to the terminal width, got: %q", lines[2])
+	}
+}
+
+func TestDisplayIgnoresStepsNotRunning(t *testing.T) {
+	d := NewDisplay(&bytes.Buffer{}, func() int { return 80 })
+
+	d.handle(Event{Type: EventStart, Step: "provider:k8s", Time: time.Now()})
+	d.handle(Event{Type: EventOutput, Step: "provider:k8s", Command: "k8s bootstrap", Message: "Bootstrapping the cluster"})
+
+	if d.steps[0].lastLine != "Bootstrapping the cluster" {
+		t.Fatalf("expected output to be shown against its step, got: %+v", d.steps[0])
+	}
+
+	// Output from outside any step, or from a step that is not running, is not shown.
+	d.handle(Event{Type: EventOutput, Command: "apt-get update", Message: "Reading package lists"})
+	d.handle(Event{Type: EventRetry, Step: "snap:jq", Command: "snap install jq", Attempt: 1})
+	d.handle(Event{Type: EventEnd, Step: "provider:k8s", Time: time.Now()})
+	d.handle(Event{Type: EventRetry, Step: "provider:k8s", Command: "k8s status", Attempt: 1})
+
+	if len(d.steps) != 1 || d.steps[0].lastLine != "Bootstrapping the cluster" || d.steps[0].retries != 0 {
+		t.Fatalf("expected events for steps not running to be ignored, got: %+v", d.steps)
+	}
+}
+
+func TestDisplayLogsAboveDisplay(t *testing.T) {
+	var out bytes.Buffer
+	d := NewDisplay(&out, func() int { return 80 })
+	d.handle(Event{Type: EventStart, Step: "snap:jhack", Time: time.Now()})
+
+	d.redraw()
+	if !strings.Contains(out.String(), "snap:jhack") {
+		t.Fatalf("expected the step to be drawn, got: %q", out.String())
+	}
+
+	out.Reset()
+	logger := slog.New(d.Handler(slog.NewTextHandler(&out, nil)))
+	logger.Info("Installed snap")
+
+	if !strings.HasPrefix(out.String(), "\033[1A\r\033[J") || !strings.Contains(out.String(), "Installed snap") {
+		t.Fatalf("expected the display to be erased before logging, got: %q", out.String())
+	}
+
+	// Having been erased, the display is drawn again without moving the cursor.
+	out.Reset()
+	d.redraw()
+	if strings.Contains(out.String(), "\033[") {
+		t.Fatalf("expected nothing to be erased, got: %q", out.String())
+	}
+}
@@ -0,0 +1,78 @@
+package progress
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Outcomes reported in JSON events.
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// jsonEvent is the newline-delimited JSON representation of an event, whose field
+// names form a stable format for dashboards and test-result collectors.
+type jsonEvent struct {
+	Time       time.Time      `json:"time"`
+	Type       string         `json:"type"`
+	Step       string         `json:"step,omitempty"`
+	Kind       string         `json:"kind,omitempty"`
+	Name       string         `json:"name,omitempty"`
+	DurationMS *int64         `json:"duration_ms,omitempty"`
+	Outcome    string         `json:"outcome,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Command    string         `json:"command,omitempty"`
+	Attempt    int            `json:"attempt,omitempty"`
+	Message    string         `json:"message,omitempty"`
+	Attrs      map[string]any `json:"attrs,omitempty"`
+}
+
+// MarshalJSON encodes the event in the format written by WriteJSON.
+func (e Event) MarshalJSON() ([]byte, error) {
+	j := jsonEvent{
+		Time:    e.Time.UTC(),
+		Type:    e.Type,
+		Step:    e.Step,
+		Kind:    e.Kind(),
+		Name:    e.Name(),
+		Command: e.Command,
+		Attempt: e.Attempt,
+		Message: e.Message,
+		Attrs:   e.Attrs,
+	}
+	if e.Step == "" {
+		j.Kind, j.Name = "", ""
+	}
+	if e.Type == EventEnd || e.Type == EventCommand {
+		ms := e.Duration.Milliseconds()
+		j.DurationMS = &ms
+		j.Outcome = OutcomeSucceeded
+		if e.Err != nil {
+			j.Outcome = OutcomeFailed
+		}
+	}
+	if e.Err != nil {
+		j.Error = e.Err.Error()
+	}
+	return json.Marshal(j)
+}
+
+// WriteJSON subscribes to all subsequent events and writes each one to w as a line of
+// JSON. Lines of output from commands are not written, since they are too numerous to
+// be useful in the stream. Lines are never interleaved, even when events are emitted
+// concurrently. The returned function stops writing.
+func WriteJSON(w io.Writer) (unsubscribe func()) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		// Failures to write are ignored: there is nowhere left to report them without
+		// corrupting the stream, and logging them would emit further events.
+		_ = enc.Encode(e)
+	})
+}
@@ -0,0 +1,235 @@
+// Package progress reports the start and end of each step concierge takes while
+// preparing or restoring a machine, so that other parts of concierge can observe a
+// run without the handlers that perform it knowing who is watching.
+//
+// Each step has a stable identifier made up of its kind and name, such as
+// `snap:jhack`, `deb:make`, `provider:k8s` or `juju:bootstrap:lxd`. Handlers call
+// Start and End around the work for a step; observers register with Subscribe.
+// Like the security event logger, the emitter is process-wide, since concierge
+// performs a single run per invocation.
+package progress
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	// EventStart is emitted when a step begins.
+	EventStart = "start"
+	// EventEnd is emitted when a step finishes, successfully or otherwise.
+	EventEnd = "end"
+	// EventCommand is emitted when a command has been run on the machine.
+	EventCommand = "command"
+	// EventRetry is emitted when an operation failed and is about to be retried.
+	EventRetry = "retry"
+	// EventWarning is emitted when concierge logs a warning.
+	EventWarning = "warning"
+	// EventOutput is emitted for each line of output from a command as it runs. It is
+	// only delivered to handlers registered with SubscribeWithOutput.
+	EventOutput = "output"
+)
+
+// Kinds of step.
+const (
+	KindSnap     = "snap"
+	KindDeb      = "deb"
+	KindProvider = "provider"
+	KindJuju     = "juju"
+)
+
+// Event describes something that happened to a step.
+type Event struct {
+	// Type is the type of event, such as EventStart or EventEnd.
+	Type string
+	// Step is the stable identifier of the step, such as `snap:jhack`. For commands
+	// and retries, it is the step whose work they are part of, or empty for work
+	// outside of any step, such as checking the plan.
+	Step string
+	// Time is the time at which the event occurred.
+	Time time.Time
+	// Duration is the time since the step started, or the time a command took. It is
+	// only set on EventEnd and EventCommand.
+	Duration time.Duration
+	// Err is the error the step failed with, if any. It is only set on EventEnd,
+	// EventCommand and EventRetry.
+	Err error
+
+	// Command is the command that was run, or the operation being retried. It is
+	// only set on EventCommand, EventRetry and EventOutput.
+	Command string
+	// Attempt is the number of the attempt that failed. It is only set on EventRetry.
+	Attempt int
+	// Message is the message logged with a warning, or the line of output from a
+	// command. It is only set on EventWarning and EventOutput. Attrs holds the
+	// attributes of a warning.
+	Message string
+	Attrs   map[string]any
+}
+
+// Kind returns the kind of the step the event relates to, such as "snap".
+func (e Event) Kind() string {
+	kind, _, _ := strings.Cut(e.Step, ":")
+	return kind
+}
+
+// Name returns the name of the step the event relates to, without its kind, such as
+// "jhack" for `snap:jhack` or "bootstrap:lxd" for `juju:bootstrap:lxd`.
+func (e Event) Name() string {
+	_, name, _ := strings.Cut(e.Step, ":")
+	return name
+}
+
+// Handler is a function that observes events.
+type Handler func(Event)
+
+// subscription is a handler registered with Subscribe or SubscribeWithOutput.
+type subscription struct {
+	handler Handler
+	output  bool
+}
+
+var (
+	mu       sync.Mutex
+	handlers = map[int]subscription{}
+	nextID   int
+	started  = map[string]time.Time{}
+	// outputHandlers is the number of handlers that observe lines of output.
+	outputHandlers int
+)
+
+// StepID constructs a stable step identifier from its kind and name parts, for
+// example StepID("juju", "bootstrap", "lxd") returns `juju:bootstrap:lxd`.
+func StepID(kind string, name ...string) string {
+	return strings.Join(append([]string{kind}, name...), ":")
+}
+
+// Subscribe registers a handler to observe all subsequent events, other than lines of
+// output from commands, which are too numerous to deliver to handlers that ignore them.
+// Handlers may be called concurrently from multiple goroutines. The returned function
+// removes the handler.
+func Subscribe(h Handler) (unsubscribe func()) {
+	return subscribe(subscription{handler: h})
+}
+
+// SubscribeWithOutput registers a handler to observe all subsequent events, including
+// each line of output from commands, as Subscribe.
+func SubscribeWithOutput(h Handler) (unsubscribe func()) {
+	return subscribe(subscription{handler: h, output: true})
+}
+
+// subscribe registers a subscription, returning the function that removes it.
+func subscribe(s subscription) (unsubscribe func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	id := nextID
+	nextID++
+	handlers[id] = s
+	if s.output {
+		outputHandlers++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			defer mu.Unlock()
+			delete(handlers, id)
+			if s.output {
+				outputHandlers--
+			}
+		})
+	}
+}
+
+// WantsOutput reports whether any handler observes lines of output from commands, such
+// that output need only be split into lines while one does.
+func WantsOutput() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return outputHandlers > 0
+}
+
+// Start records that a step has begun.
+func Start(step string) {
+	now := time.Now()
+
+	mu.Lock()
+	started[step] = now
+	mu.Unlock()
+
+	emit(Event{Type: EventStart, Step: step, Time: now})
+}
+
+// End records that a step has finished. If err is non-nil, the step failed.
+func End(step string, err error) {
+	now := time.Now()
+
+	mu.Lock()
+	var duration time.Duration
+	if s, ok := started[step]; ok {
+		duration = now.Sub(s)
+		delete(started, step)
+	}
+	mu.Unlock()
+
+	emit(Event{Type: EventEnd, Step: step, Time: now, Duration: duration, Err: err})
+}
+
+// Track runs f as the specified step, emitting its start and end events, and
+// returns the error from f.
+func Track(step string, f func() error) error {
+	Start(step)
+	err := f()
+	End(step, err)
+	return err
+}
+
+// Command records that a command was run as part of the step, taking the specified
+// duration. If err is non-nil, the command failed.
+func Command(step string, command string, duration time.Duration, err error) {
+	emit(Event{Type: EventCommand, Step: step, Time: time.Now(), Command: command, Duration: duration, Err: err})
+}
+
+// Retry records that an operation failed on the specified attempt of the step and
+// will be retried.
+func Retry(step string, operation string, attempt int, err error) {
+	emit(Event{Type: EventRetry, Step: step, Time: time.Now(), Command: operation, Attempt: attempt, Err: err})
+}
+
+// Output records a line of output from a command that is still running as part of
+// the step. Nothing is emitted unless a handler observes output.
+func Output(step string, command string, line string) {
+	if !WantsOutput() {
+		return
+	}
+	emit(Event{Type: EventOutput, Step: step, Time: time.Now(), Command: command, Message: line})
+}
+
+// Emit delivers an arbitrary event to all handlers. If the event has no time set,
+// the current time is used.
+func Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	emit(e)
+}
+
+// emit delivers an event to all handlers that observe it.
+func emit(e Event) {
+	mu.Lock()
+	hs := make([]Handler, 0, len(handlers))
+	for _, s := range handlers {
+		if e.Type != EventOutput || s.output {
+			hs = append(hs, s.handler)
+		}
+	}
+	mu.Unlock()
+
+	for _, h := range hs {
+		h(e)
+	}
+}
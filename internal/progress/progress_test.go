@@ -0,0 +1,182 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrackEmitsStartAndEnd(t *testing.T) {
+	var mu sync.Mutex
+	events := []Event{}
+
+	unsubscribe := Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+
+	failure := errors.New("boom")
+	err := Track(StepID(KindSnap, "jhack"), func() error { return failure })
+	if !errors.Is(err, failure) {
+		t.Fatalf("expected Track to return the step's error, got: %v", err)
+	}
+
+	unsubscribe()
+	Track(StepID(KindDeb, "make"), func() error { return nil })
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d: %+v", len(events), events)
+	}
+
+	if events[0].Type != EventStart || events[1].Type != EventEnd {
+		t.Fatalf("unexpected event types: %s, %s", events[0].Type, events[1].Type)
+	}
+
+	if events[1].Kind() != KindSnap || events[1].Name() != "jhack" || !errors.Is(events[1].Err, failure) {
+		t.Fatalf("unexpected end event: %+v", events[1])
+	}
+}
+
+func TestEventName(t *testing.T) {
+	e := Event{Step: StepID(KindJuju, "bootstrap", "lxd")}
+
+	if e.Step != "juju:bootstrap:lxd" {
+		t.Fatalf("unexpected step ID: %s", e.Step)
+	}
+
+	if e.Kind() != KindJuju || e.Name() != "bootstrap:lxd" {
+		t.Fatalf("unexpected kind and name: %s, %s", e.Kind(), e.Name())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	stop := WriteJSON(&buf)
+
+	Track(StepID(KindProvider, "k8s"), func() error { return nil })
+	Command("snap:jhack", "snap install jhack", 1500*time.Millisecond, errors.New("exit status 1"))
+	Retry("", "snapd: find jhack", 1, errors.New("connection refused"))
+
+	stop()
+	Track(StepID(KindDeb, "make"), func() error { return nil })
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d: %s", len(lines), buf.String())
+	}
+
+	events := []map[string]any{}
+	for _, line := range lines {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line is not JSON: %s: %v", line, err)
+		}
+		events = append(events, e)
+	}
+
+	if events[0]["type"] != EventStart || events[0]["step"] != "provider:k8s" || events[0]["kind"] != KindProvider || events[0]["name"] != "k8s" {
+		t.Fatalf("unexpected start event: %v", events[0])
+	}
+	if _, ok := events[0]["duration_ms"]; ok {
+		t.Fatalf("expected no duration on start event: %v", events[0])
+	}
+
+	if events[1]["type"] != EventEnd || events[1]["outcome"] != OutcomeSucceeded || events[1]["duration_ms"] == nil {
+		t.Fatalf("unexpected end event: %v", events[1])
+	}
+
+	if events[2]["type"] != EventCommand || events[2]["command"] != "snap install jhack" ||
+		events[2]["duration_ms"] != float64(1500) || events[2]["outcome"] != OutcomeFailed || events[2]["error"] != "exit status 1" {
+		t.Fatalf("unexpected command event: %v", events[2])
+	}
+	if events[2]["step"] != "snap:jhack" || events[2]["kind"] != KindSnap {
+		t.Fatalf("expected the step on command event: %v", events[2])
+	}
+
+	if events[3]["type"] != EventRetry || events[3]["attempt"] != float64(1) {
+		t.Fatalf("unexpected retry event: %v", events[3])
+	}
+	if _, ok := events[3]["step"]; ok {
+		t.Fatalf("expected no step on retry event made outside a step: %v", events[3])
+	}
+}
+
+func TestWarningHandler(t *testing.T) {
+	var mu sync.Mutex
+	events := []Event{}
+	unsubscribe := Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, e)
+	})
+	defer unsubscribe()
+
+	var buf bytes.Buffer
+	logger := slog.New(WarningHandler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	logger.Info("Not a warning")
+	logger.With("step", "snap:jhack").Warn("Failed to record progress", "error", errors.New("disk full"))
+
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d: %+v", len(events), events)
+	}
+
+	e := events[0]
+	if e.Type != EventWarning || e.Message != "Failed to record progress" {
+		t.Fatalf("unexpected warning event: %+v", e)
+	}
+	if e.Attrs["step"] != "snap:jhack" || e.Attrs["error"] != "disk full" {
+		t.Fatalf("unexpected warning attributes: %v", e.Attrs)
+	}
+
+	// The wrapped handler only logs errors, so the warning is not written.
+	if buf.Len() != 0 {
+		t.Fatalf("expected nothing to be logged, got: %s", buf.String())
+	}
+}
+
+func TestOutputOnlyDeliveredToHandlersThatWantIt(t *testing.T) {
+	var mu sync.Mutex
+	plain, withOutput := 0, 0
+	unsubscribe := Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == EventOutput {
+			plain++
+		}
+	})
+	defer unsubscribe()
+
+	if WantsOutput() {
+		t.Fatal("expected no handler to want output")
+	}
+
+	unsubscribeOutput := SubscribeWithOutput(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == EventOutput {
+			withOutput++
+		}
+	})
+	if !WantsOutput() {
+		t.Fatal("expected a handler to want output")
+	}
+
+	Output("snap:jhack", "snap install jhack", "Downloading snap")
+	unsubscribeOutput()
+	unsubscribeOutput()
+	Output("snap:jhack", "snap install jhack", "Installed")
+
+	if plain != 0 || withOutput != 1 {
+		t.Fatalf("expected output only for the handler that wants it, got %d and %d", plain, withOutput)
+	}
+	if WantsOutput() {
+		t.Fatal("expected no handler to want output once unsubscribed")
+	}
+}
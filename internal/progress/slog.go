@@ -0,0 +1,68 @@
+package progress
+
+import (
+	"context"
+	"log/slog"
+)
+
+// WarningHandler wraps a slog.Handler so that each record logged at warning level or
+// above is also emitted as an EventWarning, with the record's attributes.
+func WarningHandler(next slog.Handler) slog.Handler {
+	return &warningHandler{next: next}
+}
+
+type warningHandler struct {
+	next  slog.Handler
+	attrs []slog.Attr
+}
+
+// Enabled reports whether the wrapped handler handles records at the level, or the
+// level is high enough to be emitted as a warning.
+func (h *warningHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn || h.next.Enabled(ctx, level)
+}
+
+// Handle emits warnings as events, and passes the record on to the wrapped handler if
+// it is enabled for the record's level.
+func (h *warningHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelWarn {
+		attrs := map[string]any{}
+		for _, a := range h.attrs {
+			attrs[a.Key] = attrValue(a)
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			attrs[a.Key] = attrValue(a)
+			return true
+		})
+		if len(attrs) == 0 {
+			attrs = nil
+		}
+		Emit(Event{Type: EventWarning, Time: r.Time, Message: r.Message, Attrs: attrs})
+	}
+
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler whose records, and warnings, include the attributes.
+func (h *warningHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &warningHandler{next: h.next.WithAttrs(attrs), attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+// WithGroup returns a handler whose records are grouped by the wrapped handler.
+// Warnings are emitted with their attributes ungrouped.
+func (h *warningHandler) WithGroup(name string) slog.Handler {
+	return &warningHandler{next: h.next.WithGroup(name), attrs: h.attrs}
+}
+
+// attrValue returns the value of an attribute for a warning event. Errors are replaced
+// by their message, since they do not otherwise encode to JSON.
+func attrValue(a slog.Attr) any {
+	v := a.Value.Resolve().Any()
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+	return v
+}
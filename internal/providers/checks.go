@@ -0,0 +1,222 @@
+package providers
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+// lxdSocket is the path of the socket that concierge opens up to non-root users.
+const lxdSocket = "/var/snap/lxd/common/lxd/unix.socket"
+
+// Checks checks that LXD is ready, and that the user can interact with it without sudo.
+func (l *LXD) Checks() []system.Check {
+	checks := []system.Check{
+		commandCheck(l.system, l.Name(), "daemon",
+			system.NewCommand("lxd", []string{"waitready", "--timeout", "30"}),
+			"LXD is ready",
+			"Check the LXD daemon with 'sudo snap logs lxd', and restart it with 'sudo snap restart lxd'"),
+		l.checkSocket(),
+	}
+	return append(checks, groupChecks(l.system, l.Name(), l.GroupName())...)
+}
+
+// checkSocket checks that the LXD socket is still readable and writable by everyone, as
+// set by enableNonRootUserControl. LXD resets its permissions when it restarts, after
+// which only members of its group can use the socket, and only if the socket is still
+// owned by that group and readable and writable by it.
+func (l *LXD) checkSocket() system.Check {
+	check := system.Check{Component: l.Name(), Name: "socket"}
+	remedy := fmt.Sprintf("Run 'sudo chmod a+wr %s'", lxdSocket)
+
+	cmd := system.NewCommand("stat", []string{"-c", "%a %G", lxdSocket})
+	cmd.ReadOnly = true
+	output, err := l.system.Run(cmd)
+	if err != nil {
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("failed to read permissions of %s: %s", lxdSocket, err)
+		check.Remedy = remedy
+		return check
+	}
+
+	fields := strings.Fields(string(output))
+	var mode uint64
+	if len(fields) == 2 {
+		mode, err = strconv.ParseUint(fields[0], 8, 32)
+	}
+	if len(fields) != 2 || err != nil {
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("failed to parse permissions of %s: %q", lxdSocket, strings.TrimSpace(string(output)))
+		check.Remedy = remedy
+		return check
+	}
+	group := fields[1]
+
+	switch {
+	case mode&0o006 == 0o006:
+		check.Status = system.CheckPass
+		check.Detail = fmt.Sprintf("%s is readable and writable by everyone", lxdSocket)
+	case group == l.GroupName() && mode&0o060 == 0o060:
+		check.Status = system.CheckWarn
+		check.Detail = fmt.Sprintf("%s has mode %s, so only root and members of the '%s' group can use it",
+			lxdSocket, fields[0], group)
+		check.Remedy = remedy
+	default:
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("%s has mode %s and is owned by the '%s' group, so only root can use it",
+			lxdSocket, fields[0], group)
+		check.Remedy = remedy
+	}
+	return check
+}
+
+// Checks checks that K8s is ready, and that the user's kubeconfig file can reach it.
+func (k *K8s) Checks() []system.Check {
+	return []system.Check{
+		commandCheck(k.system, k.Name(), "status",
+			system.NewCommand("k8s", []string{"status", "--wait-ready", "--timeout", "30s"}),
+			"K8s is ready",
+			"Inspect the cluster with 'sudo k8s status' and 'sudo snap logs k8s'"),
+		kubeconfigCheck(k.system, k.Name(), "sudo k8s kubectl config view --raw"),
+	}
+}
+
+// Checks checks that MicroK8s is ready, that the user can interact with it without sudo,
+// and that the user's kubeconfig file can reach it.
+func (m *MicroK8s) Checks() []system.Check {
+	checks := []system.Check{
+		commandCheck(m.system, m.Name(), "status",
+			system.NewCommand("microk8s", []string{"status", "--wait-ready", "--timeout", "30"}),
+			"MicroK8s is ready",
+			"Inspect the cluster with 'sudo microk8s inspect'"),
+		kubeconfigCheck(m.system, m.Name(), "sudo microk8s config"),
+	}
+	return append(checks, groupChecks(m.system, m.Name(), m.GroupName())...)
+}
+
+// commandCheck runs a read-only command, passing if it succeeds.
+func commandCheck(w system.Worker, component, name string, cmd *system.Command, detail, remedy string) system.Check {
+	check := system.Check{Component: component, Name: name}
+
+	cmd.ReadOnly = true
+	if _, err := w.Run(cmd); err != nil {
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("'%s' failed: %s", cmd.CommandString(), err)
+		check.Remedy = remedy
+		return check
+	}
+
+	check.Status = system.CheckPass
+	check.Detail = detail
+	return check
+}
+
+// groupChecks checks that the user is a member of the group that allows them to
+// interact with a provider without sudo, and that their current session holds it.
+// Membership only applies to sessions started after the user was added to the group.
+func groupChecks(w system.Worker, component, group string) []system.Check {
+	username := w.User().Username
+	member := system.Check{Component: component, Name: "group"}
+
+	groups, err := system.UserGroups(w, username)
+	if err != nil {
+		member.Status = system.CheckWarn
+		member.Detail = fmt.Sprintf("failed to look up the groups of user '%s': %s", username, err)
+		return []system.Check{member}
+	}
+	if !slices.Contains(groups, group) {
+		member.Status = system.CheckFail
+		member.Detail = fmt.Sprintf("user '%s' is not a member of the '%s' group", username, group)
+		member.Remedy = fmt.Sprintf("Run 'sudo usermod -a -G %s %s', then log out and back in", group, username)
+		return []system.Check{member}
+	}
+	member.Status = system.CheckPass
+	member.Detail = fmt.Sprintf("user '%s' is a member of the '%s' group", username, group)
+
+	gids, ok := system.SessionGroupIDs(w)
+	if !ok {
+		// There is no session to check, such as when concierge was run by root.
+		return []system.Check{member}
+	}
+
+	session := system.Check{Component: component, Name: "session"}
+	gid, err := system.GroupID(w, group)
+	switch {
+	case err != nil:
+		session.Status = system.CheckWarn
+		session.Detail = err.Error()
+	case !slices.Contains(gids, gid):
+		session.Status = system.CheckWarn
+		session.Detail = fmt.Sprintf("the current session of user '%s' does not hold the '%s' group", username, group)
+		session.Remedy = fmt.Sprintf("Log out and back in, or run 'newgrp %s'", group)
+	default:
+		session.Status = system.CheckPass
+		session.Detail = fmt.Sprintf("the current session of user '%s' holds the '%s' group", username, group)
+	}
+
+	return []system.Check{member, session}
+}
+
+// kubeconfig holds the parts of a kubeconfig file that are checked.
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server string `yaml:"server"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+}
+
+// kubeconfigCheck checks that the user's kubeconfig file, written by setupKubectl,
+// describes a cluster, and that the cluster can be reached with it. The remedy is to
+// regenerate the file with the specified command.
+func kubeconfigCheck(w system.Worker, component, regenerate string) system.Check {
+	check := system.Check{Component: component, Name: "kubeconfig"}
+	kubeconfigPath := path.Join(w.User().HomeDir, ".kube", "config")
+	check.Remedy = fmt.Sprintf("Regenerate it with '%s > %s'", regenerate, kubeconfigPath)
+
+	contents, err := w.ReadFile(kubeconfigPath)
+	if err != nil {
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("failed to read %s: %s", kubeconfigPath, err)
+		return check
+	}
+
+	kc := kubeconfig{}
+	if err := yaml.Unmarshal(contents, &kc); err != nil {
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("%s is not valid: %s", kubeconfigPath, err)
+		return check
+	}
+	if len(kc.Clusters) == 0 || kc.Clusters[0].Cluster.Server == "" || kc.CurrentContext == "" {
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("%s does not describe a cluster and a current context", kubeconfigPath)
+		return check
+	}
+
+	cmd := system.NewCommandAs(w.User().Username, "", "kubectl",
+		[]string{"--kubeconfig", kubeconfigPath, "get", "--raw", "/readyz"})
+	cmd.ReadOnly = true
+	_, err = w.Run(cmd)
+	switch {
+	case errors.Is(err, system.ErrNotInstalled):
+		// Without kubectl, only the file itself can be checked.
+	case err != nil:
+		check.Status = system.CheckFail
+		check.Detail = fmt.Sprintf("cluster at %s cannot be reached with %s: %s",
+			kc.Clusters[0].Cluster.Server, kubeconfigPath, err)
+		return check
+	}
+
+	check.Status = system.CheckPass
+	check.Detail = fmt.Sprintf("%s describes the cluster at %s", kubeconfigPath, kc.Clusters[0].Cluster.Server)
+	check.Remedy = ""
+	return check
+}
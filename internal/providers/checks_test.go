@@ -0,0 +1,180 @@
+package providers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/system"
+)
+
+const testKubeconfig = `apiVersion: v1
+clusters:
+- cluster:
+    server: https://10.0.0.1:6443
+  name: k8s
+current-context: k8s
+`
+
+// mockSession mocks a session of the test user that holds the specified groups, from
+// which concierge was run with sudo.
+func mockSession(sys *system.MockSystem, gids string) {
+	sys.MockFile("/proc/self/status", []byte("Name:\tconcierge\nPPid:\t100\nUid:\t0\t0\t0\t0\n"))
+	sys.MockFile("/proc/100/status", []byte("Name:\tbash\nPPid:\t1\nUid:\t666\t666\t666\t666\nGroups:\t"+gids+"\n"))
+}
+
+func TestLXDChecks(t *testing.T) {
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("stat -c '%a %G' /var/snap/lxd/common/lxd/unix.socket", []byte("660 lxd\n"), nil)
+	sys.MockCommandReturn("id -nG test-user", []byte("test-user lxd\n"), nil)
+	sys.MockCommandReturn("getent group lxd", []byte("lxd:x:999:test-user\n"), nil)
+	mockSession(sys, "666")
+
+	lxd := NewLXD(sys, &config.Config{})
+
+	expected := []system.Check{
+		{Component: "lxd", Name: "daemon", Status: system.CheckPass, Detail: "LXD is ready"},
+		{
+			Component: "lxd", Name: "socket", Status: system.CheckWarn,
+			Detail: "/var/snap/lxd/common/lxd/unix.socket has mode 660, so only root and members of the 'lxd' group can use it",
+			Remedy: "Run 'sudo chmod a+wr /var/snap/lxd/common/lxd/unix.socket'",
+		},
+		{Component: "lxd", Name: "group", Status: system.CheckPass, Detail: "user 'test-user' is a member of the 'lxd' group"},
+		{
+			Component: "lxd", Name: "session", Status: system.CheckWarn,
+			Detail: "the current session of user 'test-user' does not hold the 'lxd' group",
+			Remedy: "Log out and back in, or run 'newgrp lxd'",
+		},
+	}
+
+	checks := lxd.Checks()
+	if !reflect.DeepEqual(expected, checks) {
+		t.Fatalf("expected: %+v, got: %+v", expected, checks)
+	}
+}
+
+func TestLXDSocketCheck(t *testing.T) {
+	type test struct {
+		output string
+		status string
+		detail string
+	}
+
+	tests := []test{
+		{"666 lxd", system.CheckPass, "/var/snap/lxd/common/lxd/unix.socket is readable and writable by everyone"},
+		{"777 root", system.CheckPass, "/var/snap/lxd/common/lxd/unix.socket is readable and writable by everyone"},
+		{"664 lxd", system.CheckWarn, "/var/snap/lxd/common/lxd/unix.socket has mode 664, so only root and members of the 'lxd' group can use it"},
+		{"660 root", system.CheckFail, "/var/snap/lxd/common/lxd/unix.socket has mode 660 and is owned by the 'root' group, so only root can use it"},
+		{"640 lxd", system.CheckFail, "/var/snap/lxd/common/lxd/unix.socket has mode 640 and is owned by the 'lxd' group, so only root can use it"},
+		{"603 lxd", system.CheckFail, "/var/snap/lxd/common/lxd/unix.socket has mode 603 and is owned by the 'lxd' group, so only root can use it"},
+		{"garbage", system.CheckFail, `failed to parse permissions of /var/snap/lxd/common/lxd/unix.socket: "garbage"`},
+	}
+
+	for _, tc := range tests {
+		sys := system.NewMockSystem()
+		sys.MockCommandReturn("stat -c '%a %G' /var/snap/lxd/common/lxd/unix.socket", []byte(tc.output+"\n"), nil)
+
+		check := NewLXD(sys, &config.Config{}).checkSocket()
+		if check.Status != tc.status || check.Detail != tc.detail {
+			t.Fatalf("for %q, expected %s: %s, got %s: %s", tc.output, tc.status, tc.detail, check.Status, check.Detail)
+		}
+	}
+}
+
+func TestMicroK8sChecks(t *testing.T) {
+	kubeconfigPath := path.Join(os.TempDir(), ".kube", "config")
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("microk8s status --wait-ready --timeout 30", []byte{}, fmt.Errorf("microk8s is not running"))
+	sys.MockCommandReturn("id -nG test-user", []byte("test-user\n"), nil)
+	sys.MockFile(kubeconfigPath, []byte(testKubeconfig))
+
+	microk8s := NewMicroK8s(sys, &config.Config{})
+
+	expected := []system.Check{
+		{
+			Component: "microk8s", Name: "status", Status: system.CheckFail,
+			Detail: "'microk8s status --wait-ready --timeout 30' failed: microk8s is not running",
+			Remedy: "Inspect the cluster with 'sudo microk8s inspect'",
+		},
+		{
+			Component: "microk8s", Name: "kubeconfig", Status: system.CheckPass,
+			Detail: fmt.Sprintf("%s describes the cluster at https://10.0.0.1:6443", kubeconfigPath),
+		},
+		{
+			Component: "microk8s", Name: "group", Status: system.CheckFail,
+			Detail: "user 'test-user' is not a member of the 'snap_microk8s' group",
+			Remedy: "Run 'sudo usermod -a -G snap_microk8s test-user', then log out and back in",
+		},
+	}
+
+	checks := microk8s.Checks()
+	if !reflect.DeepEqual(expected, checks) {
+		t.Fatalf("expected: %+v, got: %+v", expected, checks)
+	}
+
+	expectedCommand := fmt.Sprintf("sudo -u test-user kubectl --kubeconfig %s get --raw /readyz", kubeconfigPath)
+	if sys.ExecutedCommands[1] != expectedCommand {
+		t.Fatalf("expected the cluster to be reached with %q, got: %v", expectedCommand, sys.ExecutedCommands)
+	}
+}
+
+func TestKubeconfigCheck(t *testing.T) {
+	kubeconfigPath := path.Join(os.TempDir(), ".kube", "config")
+	remedy := fmt.Sprintf("Regenerate it with 'sudo k8s kubectl config view --raw > %s'", kubeconfigPath)
+
+	type test struct {
+		contents string
+		err      error
+		expected system.Check
+	}
+
+	tests := []test{
+		{
+			contents: "",
+			expected: system.Check{
+				Component: "k8s", Name: "kubeconfig", Status: system.CheckFail, Remedy: remedy,
+				Detail: fmt.Sprintf("%s does not describe a cluster and a current context", kubeconfigPath),
+			},
+		},
+		{
+			contents: testKubeconfig,
+			err:      fmt.Errorf("connection refused"),
+			expected: system.Check{
+				Component: "k8s", Name: "kubeconfig", Status: system.CheckFail, Remedy: remedy,
+				Detail: fmt.Sprintf("cluster at https://10.0.0.1:6443 cannot be reached with %s: connection refused", kubeconfigPath),
+			},
+		},
+		{
+			contents: testKubeconfig,
+			err:      system.ErrNotInstalled,
+			expected: system.Check{
+				Component: "k8s", Name: "kubeconfig", Status: system.CheckPass,
+				Detail: fmt.Sprintf("%s describes the cluster at https://10.0.0.1:6443", kubeconfigPath),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		sys := system.NewMockSystem()
+		sys.MockFile(kubeconfigPath, []byte(tc.contents))
+		sys.MockCommandReturn(
+			fmt.Sprintf("sudo -u test-user kubectl --kubeconfig %s get --raw /readyz", kubeconfigPath),
+			[]byte{}, tc.err,
+		)
+
+		check := kubeconfigCheck(sys, "k8s", "sudo k8s kubectl config view --raw")
+		if !reflect.DeepEqual(tc.expected, check) {
+			t.Fatalf("expected: %+v, got: %+v", tc.expected, check)
+		}
+	}
+
+	sys := system.NewMockSystem()
+	check := kubeconfigCheck(sys, "k8s", "sudo k8s kubectl config view --raw")
+	if check.Status != system.CheckFail || check.Remedy != remedy {
+		t.Fatalf("expected a missing kubeconfig to fail, got: %+v", check)
+	}
+}
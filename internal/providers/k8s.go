@@ -8,8 +8,6 @@ import (
 	"strings"
 	"time"
 
-	"golang.org/x/sync/errgroup"
-
 	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/packages"
 	"github.com/canonical/concierge/internal/system"
@@ -101,6 +99,12 @@ func (k *K8s) Prepare() error {
 // Name reports the name of the provider for Concierge's purposes.
 func (k *K8s) Name() string { return "k8s" }
 
+// Snaps reports the snaps installed by the provider.
+func (k *K8s) Snaps() []*system.Snap { return k.snaps }
+
+// Debs reports the debs installed by the provider.
+func (k *K8s) Debs() []*packages.Deb { return k.debs }
+
 // Bootstrap reports whether a Juju controller should be bootstrapped onto the provider.
 func (k *K8s) Bootstrap() bool { return k.bootstrap }
 
@@ -142,6 +146,16 @@ func (k *K8s) Restore() error {
 	return nil
 }
 
+// Leftovers reports state created by 'prepare' that still exists on the machine.
+func (k *K8s) Leftovers() []system.Leftover {
+	leftovers := packages.NewSnapHandler(k.system, k.snaps).Leftovers()
+	leftovers = append(leftovers, system.PathLeftovers(k.system, system.LeftoverPath,
+		path.Join(k.system.User().HomeDir, ".kube"),
+		"/etc/containerd/hosts.d/docker.io",
+	)...)
+	return leftovers
+}
+
 // restoreImageRegistry removes the containerd hosts.d configuration that
 // configureImageRegistry wrote. The k8s snap uses host paths under
 // /etc/containerd/hosts.d/ that are not cleared when the snap is removed, so
@@ -162,7 +176,7 @@ func (k *K8s) restoreImageRegistry() {
 
 // install ensures that K8s is installed.
 func (k *K8s) install() error {
-	var eg errgroup.Group
+	eg := system.NewGroup(k.system)
 
 	// Prepare/restore package handlers concurrently
 	debHandler := packages.NewDebHandler(k.system, k.debs)
@@ -243,6 +257,7 @@ func (k *K8s) configureFeatures() error {
 // file to the user's home directory such that kubectl works with K8s.
 func (k *K8s) setupKubectl() error {
 	cmd := system.NewCommand("k8s", []string{"kubectl", "config", "view", "--raw"})
+	cmd.Sensitive = true
 	result, err := k.system.Run(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to fetch K8s configuration: %w", err)
@@ -3,10 +3,12 @@ package providers
 import (
 	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/canonical/concierge/internal/config"
 	"github.com/canonical/concierge/internal/packages"
 	"github.com/canonical/concierge/internal/system"
+	"github.com/canonical/x-go/strutil/shlex"
 )
 
 // NewLXD constructs a new LXD provider instance.
@@ -24,6 +26,8 @@ func NewLXD(r system.Worker, config *config.Config) *LXD {
 		bootstrap:            config.Providers.LXD.Bootstrap,
 		modelDefaults:        config.Providers.LXD.ModelDefaults,
 		bootstrapConstraints: config.Providers.LXD.BootstrapConstraints,
+		thorough:             config.Thorough,
+		snapshot:             config.Snapshot,
 		snaps:                []*system.Snap{{Name: "lxd", Channel: channel}},
 	}
 }
@@ -35,6 +39,8 @@ type LXD struct {
 	bootstrap            bool
 	modelDefaults        map[string]string
 	bootstrapConstraints map[string]string
+	thorough             bool
+	snapshot             *config.Snapshot
 
 	system system.Worker
 	snaps  []*system.Snap
@@ -71,6 +77,9 @@ func (l *LXD) Prepare() error {
 // Name reports the name of the provider for Concierge's purposes.
 func (l *LXD) Name() string { return "lxd" }
 
+// Snaps reports the snaps installed by the provider.
+func (l *LXD) Snaps() []*system.Snap { return l.snaps }
+
 // Bootstrap reports whether a Juju controller should be bootstrapped on LXD.
 func (l *LXD) Bootstrap() bool { return l.bootstrap }
 
@@ -89,7 +98,8 @@ func (l *LXD) ModelDefaults() map[string]string { return l.modelDefaults }
 // BootstrapConstraints reports the Juju bootstrap-constraints specific to the provider.
 func (l *LXD) BootstrapConstraints() map[string]string { return l.bootstrapConstraints }
 
-// Remove uninstalls LXD.
+// Remove uninstalls LXD, and reverts the user's membership of the `lxd` group and the
+// firewall changes using the snapshot recorded during 'prepare'.
 func (l *LXD) Restore() error {
 	snapHandler := packages.NewSnapHandler(l.system, l.snaps)
 
@@ -98,10 +108,50 @@ func (l *LXD) Restore() error {
 		return err
 	}
 
+	restoreGroupMembership(l.system, l.snapshot, l.GroupName(), l.thorough)
+	l.restoreFirewall()
+
 	slog.Info("Restored provider", "provider", l.Name())
 	return nil
 }
 
+// Leftovers reports state created by 'prepare' that still exists on the machine.
+func (l *LXD) Leftovers() []system.Leftover {
+	leftovers := packages.NewSnapHandler(l.system, l.snaps).Leftovers()
+	leftovers = append(leftovers, system.PathLeftovers(l.system, system.LeftoverNetwork, "/sys/class/net/lxdbr0")...)
+	leftovers = append(leftovers, groupLeftovers(l.system, l.snapshot, l.GroupName())...)
+
+	// Where the FORWARD chain was recorded during 'prepare', compare against that.
+	if fw := l.snapshot.FirewallState(); fw != nil {
+		rules, err := l.forwardChain(fw.Backend)
+		if err == nil && rules != fw.Rules {
+			leftovers = append(leftovers, system.Leftover{
+				Kind:   system.LeftoverFirewall,
+				Name:   "FORWARD",
+				Detail: "chain differs from before prepare",
+			})
+		}
+		return leftovers
+	}
+
+	// Otherwise the FORWARD policy is only of interest where Docker is installed, since
+	// that is the only case in which deconflictFirewall changes it from the default.
+	if l.dockerInstalled() {
+		cmd := system.NewCommand("iptables", []string{"-S", "FORWARD"})
+		cmd.ReadOnly = true
+		output, err := l.system.Run(cmd)
+		if err == nil && strings.Contains(string(output), "-P FORWARD ACCEPT") {
+			leftovers = append(leftovers, system.Leftover{
+				Kind:   system.LeftoverFirewall,
+				Name:   "FORWARD",
+				Detail: "policy is ACCEPT",
+			})
+		}
+	}
+
+	return leftovers
+}
+
 // install ensures that LXD is installed.
 func (l *LXD) install() error {
 	// Check if LXD is already installed, and stop the snap if it is.
@@ -142,24 +192,201 @@ func (l *LXD) init() error {
 
 // enableNonRootUserControl ensures the current user is in the `lxd` group.
 func (l *LXD) enableNonRootUserControl() error {
-	username := l.system.User().Username
+	cmd := system.NewCommand("chmod", []string{"a+wr", "/var/snap/lxd/common/lxd/unix.socket"})
+	_, err := l.system.Run(cmd)
+	if err != nil {
+		return err
+	}
 
-	return system.RunMany(l.system,
-		system.NewCommand("chmod", []string{"a+wr", "/var/snap/lxd/common/lxd/unix.socket"}),
-		system.NewCommand("usermod", []string{"-a", "-G", "lxd", username}),
-	)
+	return addUserToGroup(l.system, l.snapshot, l.GroupName())
 }
 
 // deconflictFirewall ensures that LXD containers can talk out to the internet.
 // This is to avoid a conflict with the default iptables rules that ship with
-// docker on Ubuntu.
+// docker on Ubuntu. The FORWARD chain is recorded in the snapshot beforehand.
 func (l *LXD) deconflictFirewall() error {
+	backend := l.firewallBackend()
+	if backend == "" {
+		slog.Debug("Neither iptables nor nft is installed, skipping firewall changes")
+		return nil
+	}
+
+	rules, err := l.forwardChain(backend)
+	if err != nil {
+		return fmt.Errorf("failed to read FORWARD chain: %w", err)
+	}
+
+	l.snapshot.RecordFirewall(&config.FirewallSnapshot{Backend: backend, Rules: rules})
+
+	if backend == config.FirewallNftables {
+		// There is nothing to deconflict if the chain doesn't exist.
+		if rules == "" {
+			return nil
+		}
+
+		return system.RunMany(l.system,
+			system.NewCommand("nft", []string{"flush", "chain", "ip", "filter", "FORWARD"}),
+			system.NewCommand("nft", []string{"chain", "ip", "filter", "FORWARD", "{ policy accept; }"}),
+		)
+	}
+
 	return system.RunMany(l.system,
 		system.NewCommand("iptables", []string{"-F", "FORWARD"}),
 		system.NewCommand("iptables", []string{"-P", "FORWARD", "ACCEPT"}),
 	)
 }
 
+// firewallBackend reports which tool manages the firewall on the host. The `iptables`
+// command is preferred where present, since both its legacy and nf_tables variants
+// understand the same arguments. Hosts without it are managed with `nft` directly.
+// An empty string is returned if neither is installed.
+func (l *LXD) firewallBackend() string {
+	for _, backend := range []string{"iptables", "nft"} {
+		cmd := system.NewCommand(backend, []string{"--version"})
+		cmd.ReadOnly = true
+		cmd.ExpectedError = `.*`
+		output, err := l.system.Run(cmd)
+		if err != nil {
+			continue
+		}
+
+		if backend == "nft" {
+			return config.FirewallNftables
+		}
+
+		slog.Debug("Detected iptables", "version", strings.TrimSpace(string(output)))
+		return config.FirewallIptables
+	}
+
+	return ""
+}
+
+// forwardChain returns the current rules of the FORWARD chain in the filter table,
+// using the specified firewall backend. With nftables, an empty string is returned
+// if the chain does not exist.
+func (l *LXD) forwardChain(backend string) (string, error) {
+	var cmd *system.Command
+	if backend == config.FirewallNftables {
+		cmd = system.NewCommand("nft", []string{"list", "chain", "ip", "filter", "FORWARD"})
+		cmd.ExpectedError = `No such file or directory`
+	} else {
+		cmd = system.NewCommand("iptables", []string{"-S", "FORWARD"})
+	}
+	cmd.ReadOnly = true
+
+	output, err := l.system.Run(cmd)
+	if err != nil {
+		if cmd.IsExpectedError(output) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+// restoreFirewall reverses deconflictFirewall. Where the FORWARD chain was recorded
+// during 'prepare', it is restored exactly. Otherwise, nothing is known of the chain
+// before 'prepare', and other users' LXD instances may rely on it, so the Docker rules
+// are only reinstated in thorough mode, if Docker is installed. Failures are logged as
+// warnings.
+func (l *LXD) restoreFirewall() {
+	fw := l.snapshot.FirewallState()
+	if fw == nil {
+		if !l.thorough {
+			slog.Debug("Leaving firewall in place, use '--thorough' to restore it")
+			return
+		}
+		l.restoreDockerFirewall()
+		return
+	}
+
+	var err error
+	if fw.Backend == config.FirewallNftables {
+		err = l.restoreNftablesForwardChain(fw.Rules)
+	} else {
+		err = l.restoreIptablesForwardChain(fw.Rules)
+	}
+
+	if err != nil {
+		slog.Warn("Failed to restore firewall rules", "backend", fw.Backend, "error", err)
+	}
+}
+
+// restoreIptablesForwardChain replaces the FORWARD chain with the rules recorded from
+// `iptables -S FORWARD`, each line of which is a valid set of arguments to iptables.
+func (l *LXD) restoreIptablesForwardChain(rules string) error {
+	commands := []*system.Command{system.NewCommand("iptables", []string{"-F", "FORWARD"})}
+
+	for line := range strings.Lines(rules) {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		args, err := shlex.Split(line)
+		if err != nil {
+			return fmt.Errorf("failed to parse recorded rule '%s': %w", line, err)
+		}
+		commands = append(commands, system.NewCommand("iptables", args))
+	}
+
+	return system.RunMany(l.system, commands...)
+}
+
+// restoreNftablesForwardChain replaces the FORWARD chain with the ruleset recorded
+// from `nft list chain`, which nft loads back from its standard input.
+func (l *LXD) restoreNftablesForwardChain(rules string) error {
+	// The chain didn't exist, so deconflictFirewall didn't change anything.
+	if rules == "" {
+		return nil
+	}
+
+	load := system.NewCommand("nft", []string{"-f", "-"})
+	load.Stdin = []byte(rules)
+
+	return system.RunMany(l.system,
+		system.NewCommand("nft", []string{"flush", "chain", "ip", "filter", "FORWARD"}),
+		load,
+	)
+}
+
+// restoreDockerFirewall reverses deconflictFirewall where Docker is installed, by
+// setting the FORWARD policy back to DROP and restarting Docker so that it reinstates
+// the rules that were flushed.
+func (l *LXD) restoreDockerFirewall() {
+	if !l.dockerInstalled() {
+		slog.Debug("Docker is not installed, skipping firewall restore")
+		return
+	}
+
+	err := system.RunMany(l.system,
+		system.NewCommand("iptables", []string{"-P", "FORWARD", "DROP"}),
+		system.NewCommand("systemctl", []string{"restart", "docker.service"}),
+	)
+	if err != nil {
+		slog.Warn("Failed to restore firewall rules", "error", err)
+	}
+}
+
+// dockerInstalled reports whether the Docker service unit exists on the system.
+// systemctl exits non-zero when no unit file matches, which is expected when Docker
+// is not installed; any other failure is logged.
+func (l *LXD) dockerInstalled() bool {
+	cmd := system.NewCommand("systemctl", []string{"list-unit-files", "docker.service"})
+	cmd.ReadOnly = true
+	cmd.ExpectedError = `0 unit files listed`
+	output, err := l.system.Run(cmd)
+	if err != nil {
+		if !cmd.IsExpectedError(output) {
+			slog.Warn("Failed to check whether Docker is installed", "error", err)
+		}
+		return false
+	}
+
+	return strings.Contains(string(output), "docker.service")
+}
+
 // workaroundRefresh checks if LXD will be refreshed and stops it first.
 // This is a workaround for an issue in the LXD snap sometimes failing
 // on refresh because of a missing snap socket file.
@@ -1,6 +1,7 @@
 package providers
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -55,7 +56,10 @@ func TestLXDPrepareCommands(t *testing.T) {
 		"lxd init --minimal",
 		"lxc network set lxdbr0 ipv6.address none",
 		"chmod a+wr /var/snap/lxd/common/lxd/unix.socket",
+		"id -nG test-user",
 		"usermod -a -G lxd test-user",
+		"iptables --version",
+		"iptables -S FORWARD",
 		"iptables -F FORWARD",
 		"iptables -P FORWARD ACCEPT",
 	}
@@ -81,7 +85,10 @@ func TestLXDPrepareCommandsLXDAlreadyInstalled(t *testing.T) {
 		"lxd init --minimal",
 		"lxc network set lxdbr0 ipv6.address none",
 		"chmod a+wr /var/snap/lxd/common/lxd/unix.socket",
+		"id -nG test-user",
 		"usermod -a -G lxd test-user",
+		"iptables --version",
+		"iptables -S FORWARD",
 		"iptables -F FORWARD",
 		"iptables -P FORWARD ACCEPT",
 	}
@@ -112,7 +119,10 @@ func TestLXDPrepareCommandsLXDChannelChange(t *testing.T) {
 		"lxd init --minimal",
 		"lxc network set lxdbr0 ipv6.address none",
 		"chmod a+wr /var/snap/lxd/common/lxd/unix.socket",
+		"id -nG test-user",
 		"usermod -a -G lxd test-user",
+		"iptables --version",
+		"iptables -S FORWARD",
 		"iptables -F FORWARD",
 		"iptables -P FORWARD ACCEPT",
 	}
@@ -145,3 +155,219 @@ func TestLXDRestore(t *testing.T) {
 		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
 	}
 }
+
+func TestLXDRestoreThorough(t *testing.T) {
+	config := &config.Config{Thorough: true}
+
+	system := system.NewMockSystem()
+	system.MockCommandReturn("systemctl list-unit-files docker.service", []byte("docker.service enabled enabled"), nil)
+
+	lxd := NewLXD(system, config)
+	if err := lxd.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"snap remove lxd --purge",
+		"gpasswd -d test-user lxd",
+		"systemctl list-unit-files docker.service",
+		"iptables -P FORWARD DROP",
+		"systemctl restart docker.service",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+}
+
+func TestLXDRestoreThoroughWithoutDocker(t *testing.T) {
+	config := &config.Config{Thorough: true}
+
+	system := system.NewMockSystem()
+	system.MockCommandReturn("systemctl list-unit-files docker.service", []byte("0 unit files listed.\n"), fmt.Errorf("exit status 1"))
+
+	lxd := NewLXD(system, config)
+	if err := lxd.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"snap remove lxd --purge",
+		"gpasswd -d test-user lxd",
+		"systemctl list-unit-files docker.service",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+}
+
+func TestLXDLeftovers(t *testing.T) {
+	config := &config.Config{}
+
+	sys := system.NewMockSystem()
+	sys.MockPath("/var/snap/lxd")
+	sys.MockPath("/sys/class/net/lxdbr0")
+	sys.MockCommandReturn("id -nG test-user", []byte("test-user adm lxd\n"), nil)
+	sys.MockCommandReturn("systemctl list-unit-files docker.service", []byte("docker.service enabled enabled"), nil)
+	sys.MockCommandReturn("iptables -S FORWARD", []byte("-P FORWARD ACCEPT\n"), nil)
+
+	lxd := NewLXD(sys, config)
+
+	expected := []system.Leftover{
+		{Kind: system.LeftoverPath, Name: "/var/snap/lxd"},
+		{Kind: system.LeftoverNetwork, Name: "/sys/class/net/lxdbr0"},
+		{Kind: system.LeftoverGroup, Name: "lxd", Detail: "user 'test-user' is a member"},
+		{Kind: system.LeftoverFirewall, Name: "FORWARD", Detail: "policy is ACCEPT"},
+	}
+
+	leftovers := lxd.Leftovers()
+	if !reflect.DeepEqual(expected, leftovers) {
+		t.Fatalf("expected: %v, got: %v", expected, leftovers)
+	}
+}
+
+func TestLXDPrepareRecordsSnapshot(t *testing.T) {
+	conf := &config.Config{Snapshot: config.NewSnapshot()}
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("id -nG test-user", []byte("test-user adm\n"), nil)
+	sys.MockCommandReturn("iptables -S FORWARD", []byte("-P FORWARD DROP\n-A FORWARD -j DOCKER-USER\n"), nil)
+
+	lxd := NewLXD(sys, conf)
+	if err := lxd.Prepare(); err != nil {
+		t.Fatal(err)
+	}
+
+	member, recorded := conf.Snapshot.GroupMembership("lxd")
+	if !recorded || member {
+		t.Fatalf("expected lxd group to be recorded as not a member, got member=%v recorded=%v", member, recorded)
+	}
+
+	expected := &config.FirewallSnapshot{
+		Backend: config.FirewallIptables,
+		Rules:   "-P FORWARD DROP\n-A FORWARD -j DOCKER-USER\n",
+	}
+	if !reflect.DeepEqual(expected, conf.Snapshot.Firewall) {
+		t.Fatalf("expected: %v, got: %v", expected, conf.Snapshot.Firewall)
+	}
+}
+
+func TestLXDRestoreFromSnapshot(t *testing.T) {
+	conf := &config.Config{Snapshot: config.NewSnapshot(), Thorough: true}
+	conf.Snapshot.RecordGroup("lxd", false)
+	conf.Snapshot.RecordFirewall(&config.FirewallSnapshot{
+		Backend: config.FirewallIptables,
+		Rules:   "-P FORWARD DROP\n-A FORWARD -j DOCKER-USER\n",
+	})
+
+	system := system.NewMockSystem()
+	lxd := NewLXD(system, conf)
+	if err := lxd.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"snap remove lxd --purge",
+		"gpasswd -d test-user lxd",
+		"iptables -F FORWARD",
+		"iptables -P FORWARD DROP",
+		"iptables -A FORWARD -j DOCKER-USER",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+}
+
+func TestLXDRestoreFromSnapshotWithoutThorough(t *testing.T) {
+	conf := &config.Config{Snapshot: config.NewSnapshot()}
+	conf.Snapshot.RecordGroup("lxd", false)
+	conf.Snapshot.RecordFirewall(&config.FirewallSnapshot{
+		Backend: config.FirewallIptables,
+		Rules:   "-P FORWARD DROP\n-A FORWARD -j DOCKER-USER\n",
+	})
+
+	system := system.NewMockSystem()
+	lxd := NewLXD(system, conf)
+	if err := lxd.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The recorded chain is put back without '--thorough', and Docker is not consulted.
+	expectedCommands := []string{
+		"snap remove lxd --purge",
+		"gpasswd -d test-user lxd",
+		"iptables -F FORWARD",
+		"iptables -P FORWARD DROP",
+		"iptables -A FORWARD -j DOCKER-USER",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+}
+
+func TestLXDRestoreKeepsExistingGroupMembership(t *testing.T) {
+	conf := &config.Config{Snapshot: config.NewSnapshot(), Thorough: true}
+	conf.Snapshot.RecordGroup("lxd", true)
+	conf.Snapshot.RecordFirewall(&config.FirewallSnapshot{Backend: config.FirewallIptables, Rules: "-P FORWARD ACCEPT\n"})
+
+	system := system.NewMockSystem()
+	lxd := NewLXD(system, conf)
+	if err := lxd.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"snap remove lxd --purge",
+		"iptables -F FORWARD",
+		"iptables -P FORWARD ACCEPT",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+}
+
+func TestLXDFirewallNftables(t *testing.T) {
+	conf := &config.Config{Snapshot: config.NewSnapshot(), Thorough: true}
+	rules := "table ip filter {\n\tchain FORWARD {\n\t\ttype filter hook forward priority filter; policy drop;\n\t}\n}\n"
+
+	sys := system.NewMockSystem()
+	sys.MockCommandReturn("iptables --version", nil, system.ErrNotInstalled)
+	sys.MockCommandReturn("nft list chain ip filter FORWARD", []byte(rules), nil)
+
+	lxd := NewLXD(sys, conf)
+	if err := lxd.deconflictFirewall(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"iptables --version",
+		"nft --version",
+		"nft list chain ip filter FORWARD",
+		"nft flush chain ip filter FORWARD",
+		"nft chain ip filter FORWARD '{ policy accept; }'",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	sys.ExecutedCommands = nil
+	lxd.restoreFirewall()
+
+	expectedCommands = []string{
+		"nft flush chain ip filter FORWARD",
+		"nft -f -",
+	}
+
+	if !reflect.DeepEqual(expectedCommands, sys.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, sys.ExecutedCommands)
+	}
+
+	if sys.CommandInputs["nft -f -"] != rules {
+		t.Fatalf("expected recorded ruleset to be loaded, got: %v", sys.CommandInputs)
+	}
+}
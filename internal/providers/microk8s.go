@@ -35,6 +35,8 @@ func NewMicroK8s(r system.Worker, config *config.Config) *MicroK8s {
 		bootstrap:            config.Providers.MicroK8s.Bootstrap,
 		modelDefaults:        config.Providers.MicroK8s.ModelDefaults,
 		bootstrapConstraints: config.Providers.MicroK8s.BootstrapConstraints,
+		thorough:             config.Thorough,
+		snapshot:             config.Snapshot,
 		system:               r,
 		snaps: []*system.Snap{
 			{Name: "microk8s", Channel: channel},
@@ -52,6 +54,8 @@ type MicroK8s struct {
 	bootstrap            bool
 	modelDefaults        map[string]string
 	bootstrapConstraints map[string]string
+	thorough             bool
+	snapshot             *config.Snapshot
 
 	system system.Worker
 	snaps  []*system.Snap
@@ -102,6 +106,9 @@ func (m *MicroK8s) Prepare() error {
 // Name reports the name of the provider for Concierge's purposes.
 func (m *MicroK8s) Name() string { return "microk8s" }
 
+// Snaps reports the snaps installed by the provider.
+func (m *MicroK8s) Snaps() []*system.Snap { return m.snaps }
+
 // Bootstrap reports whether a Juju controller should be bootstrapped onto the provider.
 func (m *MicroK8s) Bootstrap() bool { return m.bootstrap }
 
@@ -140,11 +147,24 @@ func (m *MicroK8s) Restore() error {
 		return fmt.Errorf("failed to remove '.kube' from user's home directory: %w", err)
 	}
 
+	restoreGroupMembership(m.system, m.snapshot, m.GroupName(), m.thorough)
+
 	slog.Info("Removed provider", "provider", m.Name())
 
 	return nil
 }
 
+// Leftovers reports state created by 'prepare' that still exists on the machine.
+func (m *MicroK8s) Leftovers() []system.Leftover {
+	leftovers := packages.NewSnapHandler(m.system, m.snaps).Leftovers()
+	leftovers = append(leftovers, system.PathLeftovers(m.system, system.LeftoverPath,
+		path.Join(m.system.User().HomeDir, ".kube"),
+		"/var/snap/microk8s/current/args/certs.d/docker.io",
+	)...)
+	leftovers = append(leftovers, groupLeftovers(m.system, m.snapshot, m.GroupName())...)
+	return leftovers
+}
+
 // install ensures that MicroK8s is installed.
 func (m *MicroK8s) install() error {
 	snapHandler := packages.NewSnapHandler(m.system, m.snaps)
@@ -240,13 +260,9 @@ func (m *MicroK8s) enableAddons() error {
 // enableNonRootUserControl ensures the current user is in the correct POSIX group
 // that allows them to interact with MicroK8s.
 func (m *MicroK8s) enableNonRootUserControl() error {
-	username := m.system.User().Username
-
-	cmd := system.NewCommand("usermod", []string{"-a", "-G", m.GroupName(), username})
-
-	_, err := m.system.Run(cmd)
+	err := addUserToGroup(m.system, m.snapshot, m.GroupName())
 	if err != nil {
-		return fmt.Errorf("failed to add user '%s' to group 'microk8s': %w", username, err)
+		return fmt.Errorf("failed to add user '%s' to group '%s': %w", m.system.User().Username, m.GroupName(), err)
 	}
 
 	return nil
@@ -256,6 +272,7 @@ func (m *MicroK8s) enableNonRootUserControl() error {
 // file to the user's home directory such that kubectl works with MicroK8s.
 func (m *MicroK8s) setupKubectl() error {
 	cmd := system.NewCommand("microk8s", []string{"config"})
+	cmd.Sensitive = true
 	result, err := m.system.Run(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to fetch MicroK8s configuration: %w", err)
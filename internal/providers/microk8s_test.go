@@ -9,6 +9,8 @@ import (
 	"testing"
 
 	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/snapd"
+	"github.com/canonical/concierge/internal/snapd/snapdtest"
 	"github.com/canonical/concierge/internal/system"
 )
 
@@ -102,6 +104,7 @@ func TestMicroK8sPrepareCommands(t *testing.T) {
 		"microk8s enable dns",
 		"microk8s enable rbac",
 		"microk8s enable metallb:10.64.140.43-10.64.140.49",
+		"id -nG test-user",
 		"usermod -a -G snap_microk8s test-user",
 		"microk8s config",
 	}
@@ -184,6 +187,7 @@ func TestMicroK8sPrepareWithImageRegistry(t *testing.T) {
 		"microk8s enable dns",
 		"microk8s enable rbac",
 		"microk8s enable metallb:10.64.140.43-10.64.140.49",
+		"id -nG test-user",
 		"usermod -a -G snap_microk8s test-user",
 		"microk8s config",
 	}
@@ -266,3 +270,67 @@ capabilities = ["pull", "resolve"]
 		t.Fatalf("expected:\n%v\ngot:\n%v", expectedContent, hostsToml)
 	}
 }
+
+func TestMicroK8sRestoreThorough(t *testing.T) {
+	config := &config.Config{Thorough: true}
+	config.Providers.MicroK8s.Channel = "1.31-strict/stable"
+
+	system := system.NewMockSystem()
+	uk8s := NewMicroK8s(system, config)
+	if err := uk8s.Restore(); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedCommands := []string{
+		"snap remove microk8s --purge",
+		"snap remove kubectl --purge",
+		"gpasswd -d test-user snap_microk8s",
+	}
+
+	if !slices.Equal(expectedCommands, system.ExecutedCommands) {
+		t.Fatalf("expected: %v, got: %v", expectedCommands, system.ExecutedCommands)
+	}
+}
+
+func TestComputeDefaultChannel(t *testing.T) {
+	type test struct {
+		channels map[string]snapd.ChannelInfo
+		expected string
+	}
+
+	tests := []test{
+		{
+			channels: map[string]snapd.ChannelInfo{
+				"1.32/stable":        {},
+				"1.32-strict/stable": {},
+				"1.31-strict/stable": {},
+				"1.33-strict/edge":   {},
+			},
+			expected: "1.32-strict/stable",
+		},
+		{
+			channels: map[string]snapd.ChannelInfo{"1.32/stable": {}},
+			expected: defaultMicroK8sChannel,
+		},
+	}
+
+	for _, tc := range tests {
+		server := snapdtest.NewServer(t)
+		server.AddStoreSnap(snapd.Snap{Name: "microk8s", Channels: tc.channels})
+
+		r := system.NewMockSystem()
+		r.MockSnapd(server.Socket())
+
+		if channel := computeDefaultChannel(r); channel != tc.expected {
+			t.Fatalf("expected: %s, got: %s", tc.expected, channel)
+		}
+	}
+
+	// If the store cannot be reached, the known good channel is used.
+	r := system.NewMockSystem()
+	r.MockSnapd(path.Join(t.TempDir(), "missing.socket"))
+
+	if channel := computeDefaultChannel(r); channel != defaultMicroK8sChannel {
+		t.Fatalf("expected: %s, got: %s", defaultMicroK8sChannel, channel)
+	}
+}
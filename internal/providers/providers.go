@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 
 	"github.com/canonical/concierge/internal/config"
@@ -71,6 +72,67 @@ func buildHostsTomlFromConfig(cfg config.ImageRegistryConfig) string {
 	return sb.String()
 }
 
+// addUserToGroup adds the real user to the specified POSIX group, first recording in
+// the snapshot whether they were already a member so that 'restore' can put things
+// back as they were.
+func addUserToGroup(w system.Worker, snapshot *config.Snapshot, group string) error {
+	username := w.User().Username
+
+	groups, err := system.UserGroups(w, username)
+	if err != nil {
+		slog.Warn("Failed to look up existing group membership", "user", username, "error", err)
+	} else {
+		snapshot.RecordGroup(group, slices.Contains(groups, group))
+	}
+
+	cmd := system.NewCommand("usermod", []string{"-a", "-G", group, username})
+	_, err = w.Run(cmd)
+	return err
+}
+
+// restoreGroupMembership reverses addUserToGroup. If the user was a member of the
+// group before 'prepare', they are left in it. If no membership was recorded, such
+// as for machines prepared by older versions of concierge, the user is only removed
+// from the group in thorough mode.
+func restoreGroupMembership(w system.Worker, snapshot *config.Snapshot, group string, thorough bool) {
+	member, recorded := snapshot.GroupMembership(group)
+	if recorded && member {
+		slog.Debug("User was a member of group before prepare, leaving in place", "group", group)
+		return
+	}
+
+	if recorded || thorough {
+		removeNonRootUserControl(w, group)
+	}
+}
+
+// groupLeftovers reports whether the user is still a member of a group that
+// concierge added them to during 'prepare'.
+func groupLeftovers(w system.Worker, snapshot *config.Snapshot, group string) []system.Leftover {
+	if member, _ := snapshot.GroupMembership(group); member {
+		return []system.Leftover{}
+	}
+	return system.GroupLeftovers(w, w.User().Username, group)
+}
+
+// removeNonRootUserControl removes the real user from the specified POSIX group,
+// reversing the effect of adding them during 'prepare'. Failures are logged as
+// warnings, since the user may never have been a member, or the group may have
+// been removed along with the snap that created it.
+func removeNonRootUserControl(w system.Worker, group string) {
+	username := w.User().Username
+
+	cmd := system.NewCommand("gpasswd", []string{"-d", username, group})
+	cmd.ExpectedError = `is not a member|does not exist`
+	_, err := w.Run(cmd)
+	if err != nil {
+		slog.Warn("Failed to remove user from group", "user", username, "group", group, "error", err)
+		return
+	}
+
+	slog.Debug("Removed user from group", "user", username, "group", group)
+}
+
 // NewProvider returns a newly constructed provider based on a stringified name of the provider.
 func NewProvider(providerName string, system system.Worker, config *config.Config) Provider {
 	if providerName == "lxd" && config.Providers.LXD.Enable {
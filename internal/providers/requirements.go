@@ -0,0 +1,77 @@
+package providers
+
+import "github.com/canonical/concierge/internal/system"
+
+// gib is the number of bytes in a gibibyte.
+const gib = 1 << 30
+
+// Requirements describes what a provider needs of the machine it runs on, as checked
+// before 'prepare' makes any changes.
+type Requirements struct {
+	// Disk is the free space, in bytes, needed on the filesystems holding snaps and
+	// their data.
+	Disk uint64
+	// Memory is the total memory, in bytes, needed by the provider.
+	Memory uint64
+	// CPUs is the number of CPUs needed by the provider.
+	CPUs int
+	// Conflicts are services known to conflict with the provider.
+	Conflicts []Conflict
+}
+
+// Conflict is a service that is known to conflict with a provider if it is running.
+type Conflict struct {
+	// Service is the name of the systemd unit.
+	Service string
+	// Reason describes what goes wrong if the service is running.
+	Reason string
+	// Status is the outcome of the check if the service is running, either
+	// system.CheckWarn if concierge works around the conflict, or system.CheckFail.
+	Status string
+}
+
+// Requirements reports what LXD needs of the machine. Docker's firewall rules drop
+// traffic from LXD instances; concierge adjusts the FORWARD chain to allow it, but
+// Docker restores its rules whenever it restarts.
+func (l *LXD) Requirements() Requirements {
+	return Requirements{
+		Disk:   5 * gib,
+		Memory: 2 * gib,
+		CPUs:   2,
+		Conflicts: []Conflict{
+			{
+				Service: "docker.service",
+				Reason:  "Docker's firewall rules block the network traffic of LXD instances when it restarts",
+				Status:  system.CheckWarn,
+			},
+		},
+	}
+}
+
+// Requirements reports what K8s needs of the machine. Another Kubernetes distribution
+// on the machine binds the same ports as its kubelet and API server.
+func (k *K8s) Requirements() Requirements {
+	return Requirements{
+		Disk:   10 * gib,
+		Memory: 4 * gib,
+		CPUs:   2,
+		Conflicts: []Conflict{
+			{Service: "snap.microk8s.daemon-kubelite.service", Reason: "MicroK8s binds the same ports as K8s", Status: system.CheckFail},
+			{Service: "k3s.service", Reason: "k3s binds the same ports as K8s", Status: system.CheckFail},
+		},
+	}
+}
+
+// Requirements reports what MicroK8s needs of the machine. Another Kubernetes
+// distribution on the machine binds the same ports as its kubelet.
+func (m *MicroK8s) Requirements() Requirements {
+	return Requirements{
+		Disk:   10 * gib,
+		Memory: 4 * gib,
+		CPUs:   2,
+		Conflicts: []Conflict{
+			{Service: "snap.k8s.kubelet.service", Reason: "K8s binds the same ports as MicroK8s", Status: system.CheckFail},
+			{Service: "k3s.service", Reason: "k3s binds the same ports as MicroK8s", Status: system.CheckFail},
+		},
+	}
+}
@@ -0,0 +1,132 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+)
+
+// junitTestSuites is the root element of a JUnit XML report.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Errors   int              `xml:"errors,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Errors     int             `xml:"errors,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnit returns the report as JUnit XML, with each step as a testcase in a single
+// suite named for the action, such as "concierge prepare". Failed steps are failures,
+// and steps the run ended before finishing are errors. If the run failed without any
+// step failing, such as when the configuration is invalid, the failure is reported as
+// an extra testcase named for the action.
+func (r *Report) JUnit() ([]byte, error) {
+	suite := junitTestSuite{
+		Name:      "concierge " + r.Action,
+		Time:      seconds(r.DurationMS),
+		Timestamp: r.Started.Format(time.RFC3339),
+		Properties: []junitProperty{
+			{Name: "concierge-version", Value: r.ConciergeVersion},
+			{Name: "dry-run", Value: fmt.Sprint(r.DryRun)},
+		},
+	}
+
+	stepFailed := false
+	for _, s := range r.Steps {
+		c := junitTestCase{
+			Name:      s.ID,
+			Classname: "concierge." + r.Action + "." + s.Kind,
+			Time:      seconds(s.DurationMS),
+			SystemOut: fmt.Sprintf("commands: %d\nretries: %d\n", s.Commands, s.Retries),
+		}
+		switch s.Status {
+		case StatusFailed:
+			c.Failure = &junitMessage{Message: s.Error, Type: StatusFailed, Text: s.Error}
+			suite.Failures++
+			stepFailed = true
+		case StatusRunning:
+			c.Error = &junitMessage{Message: "step did not finish", Type: StatusRunning}
+			suite.Errors++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	if r.Status == StatusFailed && !stepFailed {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      r.Action,
+			Classname: "concierge." + r.Action,
+			Time:      seconds(r.DurationMS),
+			Failure:   &junitMessage{Message: r.Error, Type: StatusFailed, Text: r.Error},
+		})
+		suite.Failures++
+	}
+	suite.Tests = len(suite.Cases)
+
+	doc := junitTestSuites{
+		Name:     "concierge",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Errors:   suite.Errors,
+		Time:     suite.Time,
+		Suites:   []junitTestSuite{suite},
+	}
+
+	content, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal report as junit xml: %w", err)
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
+
+// SaveJUnit writes the report to the specified path as JUnit XML.
+func (r *Report) SaveJUnit(filePath string) error {
+	content, err := r.JUnit()
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filePath, content, 0644) //nolint:gosec // G306: reports are meant to be read by CI tooling
+	if err != nil {
+		return fmt.Errorf("failed to write junit report: %w", err)
+	}
+	return nil
+}
+
+// seconds formats a duration in milliseconds as seconds, as used by JUnit.
+func seconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
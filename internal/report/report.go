@@ -0,0 +1,176 @@
+// Package report produces a machine-readable report of a single 'prepare' or 'restore'
+// run, for CI dashboards and test-result collectors. The report is built from progress
+// events, and can be written as JSON or as JUnit XML, in which each step is a testcase.
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+// Step statuses.
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// Report describes a single run of concierge.
+type Report struct {
+	// Action is the action performed; either "prepare" or "restore".
+	Action string `json:"action"`
+	// ConciergeVersion is the version of concierge that performed the run.
+	ConciergeVersion string `json:"concierge-version"`
+	// DryRun is set if the run only showed what would be done.
+	DryRun bool `json:"dry-run"`
+	// Started and Finished are the times at which the run started and finished.
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// DurationMS is how long the run took, in milliseconds.
+	DurationMS int64 `json:"duration-ms"`
+	// Status is one of StatusSucceeded or StatusFailed.
+	Status string `json:"status"`
+	// Error is the error that the run failed with, if any.
+	Error string `json:"error,omitempty"`
+	// Steps holds each step of the run, in the order they started.
+	Steps []*Step `json:"steps"`
+}
+
+// Step describes a single step of a run, such as `snap:jhack`.
+type Step struct {
+	// ID is the stable identifier of the step, and Kind and Name its parts.
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Status is one of StatusSucceeded or StatusFailed, or StatusRunning if the run
+	// ended before the step did.
+	Status string `json:"status"`
+	// Started and Finished are the times at which the step started and finished.
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// DurationMS is how long the step took, in milliseconds.
+	DurationMS int64 `json:"duration-ms"`
+	// Retries is the number of times the step retried a failed command or request.
+	Retries int `json:"retries"`
+	// RetriesByCommand is the number of retries of each command or request retried.
+	RetriesByCommand map[string]int `json:"retries-by-command,omitempty"`
+	// Commands is the number of commands the step ran.
+	Commands int `json:"commands"`
+	// Error is the error that the step failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Collector builds a Report from the progress events of a run.
+type Collector struct {
+	mu     sync.Mutex
+	report *Report
+}
+
+// NewCollector constructs a Collector for a run of the specified action, starting now.
+func NewCollector(action, version string, dryRun bool) *Collector {
+	return &Collector{
+		report: &Report{
+			Action:           action,
+			ConciergeVersion: version,
+			DryRun:           dryRun,
+			Started:          time.Now().UTC(),
+			Steps:            []*Step{},
+		},
+	}
+}
+
+// Handle updates the report according to a progress event. Commands and retries are
+// counted against the running step that made them.
+func (c *Collector) Handle(e progress.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch e.Type {
+	case progress.EventStart:
+		c.report.Steps = append(c.report.Steps, &Step{
+			ID:      e.Step,
+			Kind:    e.Kind(),
+			Name:    e.Name(),
+			Status:  StatusRunning,
+			Started: e.Time.UTC(),
+		})
+	case progress.EventEnd:
+		s := c.running(e.Step)
+		if s == nil {
+			return
+		}
+		finished := e.Time.UTC()
+		s.Finished = &finished
+		s.DurationMS = e.Duration.Milliseconds()
+		s.Status = StatusSucceeded
+		if e.Err != nil {
+			s.Status = StatusFailed
+			s.Error = e.Err.Error()
+		}
+	case progress.EventCommand:
+		if s := c.running(e.Step); s != nil {
+			s.Commands++
+		}
+	case progress.EventRetry:
+		if s := c.running(e.Step); s != nil {
+			s.Retries++
+			if s.RetriesByCommand == nil {
+				s.RetriesByCommand = map[string]int{}
+			}
+			s.RetriesByCommand[e.Command]++
+		}
+	}
+}
+
+// Finish completes the report with the outcome of the run, and returns it. Steps that
+// are still running are given the time elapsed so far.
+func (c *Collector) Finish(runErr error) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := c.report
+	r.Finished = time.Now().UTC()
+	r.DurationMS = r.Finished.Sub(r.Started).Milliseconds()
+	r.Status = StatusSucceeded
+	if runErr != nil {
+		r.Status = StatusFailed
+		r.Error = runErr.Error()
+	}
+
+	for _, s := range r.Steps {
+		if s.Status == StatusRunning {
+			s.DurationMS = r.Finished.Sub(s.Started).Milliseconds()
+		}
+	}
+	return r
+}
+
+// running returns the most recent step with the specified ID that has not finished,
+// or nil. It must be called with mu held.
+func (c *Collector) running(id string) *Step {
+	for i := len(c.report.Steps) - 1; i >= 0; i-- {
+		if s := c.report.Steps[i]; s.ID == id && s.Status == StatusRunning {
+			return s
+		}
+	}
+	return nil
+}
+
+// Save writes the report to the specified path as JSON.
+func (r *Report) Save(filePath string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report as json: %w", err)
+	}
+
+	err = os.WriteFile(filePath, append(content, '\n'), 0644) //nolint:gosec // G306: reports are meant to be read by CI tooling
+	if err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package report
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+// collect returns the report of a run made up of the specified events.
+func collect(runErr error, events ...progress.Event) *Report {
+	c := NewCollector("prepare", "1.2.3", false)
+	for _, e := range events {
+		c.Handle(e)
+	}
+	return c.Finish(runErr)
+}
+
+func TestCollector(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("failed to bootstrap")
+
+	r := collect(failure,
+		progress.Event{Type: progress.EventStart, Step: "snap:jhack", Time: start},
+		progress.Event{Type: progress.EventStart, Step: "juju:bootstrap:lxd", Time: start},
+		progress.Event{Type: progress.EventCommand, Step: "snap:jhack", Command: "snap install jhack"},
+		progress.Event{Type: progress.EventRetry, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd", Attempt: 1},
+		progress.Event{Type: progress.EventCommand, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd"},
+		progress.Event{Type: progress.EventCommand, Step: "juju:bootstrap:lxd", Command: "juju bootstrap lxd concierge-lxd"},
+		// Commands made outside a running step are not counted against any.
+		progress.Event{Type: progress.EventCommand, Command: "apt-get update"},
+		progress.Event{Type: progress.EventCommand, Step: "provider:lxd", Command: "lxd waitready"},
+		progress.Event{Type: progress.EventEnd, Step: "snap:jhack", Time: start.Add(2 * time.Second), Duration: 2 * time.Second},
+		progress.Event{Type: progress.EventEnd, Step: "juju:bootstrap:lxd", Time: start.Add(time.Minute), Duration: time.Minute, Err: failure},
+		progress.Event{Type: progress.EventStart, Step: "provider:lxd", Time: start.Add(time.Minute)},
+	)
+
+	if r.Action != "prepare" || r.ConciergeVersion != "1.2.3" || r.Status != StatusFailed || r.Error != failure.Error() {
+		t.Fatalf("unexpected report: %+v", r)
+	}
+
+	if len(r.Steps) != 3 {
+		t.Fatalf("expected 3 steps, got %d", len(r.Steps))
+	}
+
+	jhack, bootstrap, lxd := r.Steps[0], r.Steps[1], r.Steps[2]
+	if jhack.Status != StatusSucceeded || jhack.DurationMS != 2000 || jhack.Commands != 1 || jhack.Retries != 0 {
+		t.Fatalf("unexpected step: %+v", jhack)
+	}
+	if bootstrap.Kind != "juju" || bootstrap.Name != "bootstrap:lxd" || bootstrap.Status != StatusFailed ||
+		bootstrap.Commands != 2 || bootstrap.Retries != 1 || bootstrap.RetriesByCommand["juju bootstrap lxd concierge-lxd"] != 1 ||
+		bootstrap.Error != failure.Error() {
+		t.Fatalf("unexpected step: %+v", bootstrap)
+	}
+	if lxd.Status != StatusRunning || lxd.Finished != nil {
+		t.Fatalf("unexpected step: %+v", lxd)
+	}
+}
+
+func TestReportSave(t *testing.T) {
+	r := collect(nil,
+		progress.Event{Type: progress.EventStart, Step: "deb:make", Time: time.Now()},
+		progress.Event{Type: progress.EventEnd, Step: "deb:make", Time: time.Now()},
+	)
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := r.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var saved map[string]any
+	if err := json.Unmarshal(content, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := saved["steps"].([]any)
+	step := steps[0].(map[string]any)
+	if saved["status"] != StatusSucceeded || step["id"] != "deb:make" || step["status"] != StatusSucceeded {
+		t.Fatalf("unexpected report: %s", content)
+	}
+}
+
+func TestReportJUnit(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	failure := errors.New("failed to install snap")
+
+	r := collect(failure,
+		progress.Event{Type: progress.EventStart, Step: "snap:jhack", Time: start},
+		progress.Event{Type: progress.EventEnd, Step: "snap:jhack", Time: start.Add(1500 * time.Millisecond), Duration: 1500 * time.Millisecond, Err: failure},
+		progress.Event{Type: progress.EventStart, Step: "deb:make", Time: start},
+		progress.Event{Type: progress.EventEnd, Step: "deb:make", Time: start.Add(time.Second), Duration: time.Second},
+		progress.Event{Type: progress.EventStart, Step: "provider:lxd", Time: start},
+	)
+
+	content, err := r.JUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc junitTestSuites
+	if err := xml.Unmarshal(content, &doc); err != nil {
+		t.Fatalf("report is not valid XML: %v\n%s", err, content)
+	}
+
+	if doc.Tests != 3 || doc.Failures != 1 || doc.Errors != 1 || len(doc.Suites) != 1 {
+		t.Fatalf("unexpected totals:\n%s", content)
+	}
+
+	cases := doc.Suites[0].Cases
+	if cases[0].Name != "snap:jhack" || cases[0].Classname != "concierge.prepare.snap" || cases[0].Time != "1.500" ||
+		cases[0].Failure == nil || cases[0].Failure.Message != failure.Error() {
+		t.Fatalf("unexpected failed testcase: %+v", cases[0])
+	}
+	if cases[1].Failure != nil || cases[1].Error != nil {
+		t.Fatalf("unexpected passed testcase: %+v", cases[1])
+	}
+	if cases[2].Error == nil {
+		t.Fatalf("expected unfinished step to be an error: %+v", cases[2])
+	}
+}
+
+func TestReportJUnitRunFailureWithoutFailedStep(t *testing.T) {
+	r := collect(errors.New("invalid configuration"))
+
+	content, err := r.JUnit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), `<testcase name="prepare" classname="concierge.prepare"`) ||
+		!strings.Contains(string(content), `<failure message="invalid configuration" type="failed">`) {
+		t.Fatalf("expected the run's failure to be reported as a testcase:\n%s", content)
+	}
+}
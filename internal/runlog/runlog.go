@@ -0,0 +1,177 @@
+// Package runlog keeps a full debug log of each run of concierge, whatever the log level
+// on stderr, so that the detail of a failed run is still available afterwards. Along
+// with every log record, the log holds the combined output of each command run, which
+// is otherwise only printed with `--trace` or on an unexpected failure.
+package runlog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+// MaxLogs is the number of run logs kept. Older logs are removed when a run starts.
+const MaxLogs = 20
+
+// Log is the debug log of a single run.
+type Log struct {
+	path string
+	file *os.File
+
+	mu sync.Mutex
+	// handler writes records to the file at debug level.
+	handler slog.Handler
+
+	unsubscribe func()
+}
+
+// Open creates the log for a run of the specified action in dir, and removes the
+// oldest logs such that at most MaxLogs remain. The log also records each command run,
+// along with its output, until it is closed.
+func Open(dir, action string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s-%d.log", time.Now().UTC().Format("20060102T150405Z"), action, os.Getpid())
+	logPath := filepath.Join(dir, name)
+
+	// Logs may contain details of the machine and its credentials, so they are only
+	// readable by root.
+	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) //nolint:gosec // G304: the path is constructed from concierge's state directory
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log file: %w", err)
+	}
+
+	l := &Log{
+		path:    logPath,
+		file:    file,
+		handler: slog.NewTextHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	}
+	l.unsubscribe = progress.SubscribeWithOutput(l.handleEvent)
+
+	if err := prune(dir, MaxLogs); err != nil {
+		slog.Warn("Failed to remove old run logs", "error", err.Error())
+	}
+
+	return l, nil
+}
+
+// Path returns the path of the log file.
+func (l *Log) Path() string { return l.path }
+
+// Handler wraps a slog.Handler such that every record is also written to the log, at
+// any level.
+func (l *Log) Handler(next slog.Handler) slog.Handler {
+	return &teeHandler{next: next, log: l.handler, mu: &l.mu}
+}
+
+// Close stops recording commands, and closes the log file.
+func (l *Log) Close() error {
+	l.unsubscribe()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// handleEvent records the output of each command, and each command's outcome.
+func (l *Log) handleEvent(e progress.Event) {
+	var r slog.Record
+	switch e.Type {
+	case progress.EventOutput:
+		r = slog.NewRecord(e.Time, slog.LevelDebug, "Command output", 0)
+		r.AddAttrs(slog.String("command", e.Command), slog.String("line", e.Message))
+	case progress.EventCommand:
+		r = slog.NewRecord(e.Time, slog.LevelDebug, "Command finished", 0)
+		r.AddAttrs(slog.String("command", e.Command), slog.Duration("elapsed", e.Duration))
+		if e.Err != nil {
+			r.AddAttrs(slog.String("error", e.Err.Error()))
+		}
+	case progress.EventStart:
+		r = slog.NewRecord(e.Time, slog.LevelDebug, "Step started", 0)
+		r.AddAttrs(slog.String("step", e.Step))
+	case progress.EventEnd:
+		r = slog.NewRecord(e.Time, slog.LevelDebug, "Step finished", 0)
+		r.AddAttrs(slog.String("step", e.Step), slog.Duration("elapsed", e.Duration))
+		if e.Err != nil {
+			r.AddAttrs(slog.String("error", e.Err.Error()))
+		}
+	default:
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Failing to write the log must never fail the run itself.
+	_ = l.handler.Handle(context.Background(), r)
+}
+
+// prune removes the oldest run logs in dir, such that at most keep remain. Logs are
+// named for the time at which they were created, so sort oldest first.
+func prune(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	logs := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".log") {
+			logs = append(logs, e.Name())
+		}
+	}
+	slices.Sort(logs)
+
+	var errs []error
+	for len(logs) > keep {
+		errs = append(errs, os.Remove(filepath.Join(dir, logs[0])))
+		logs = logs[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// teeHandler passes each record on to the wrapped handler, if enabled for the record's
+// level, and always writes it to the log.
+type teeHandler struct {
+	next slog.Handler
+	log  slog.Handler
+	mu   *sync.Mutex
+}
+
+// Enabled reports true for every level, since the log records everything.
+func (h *teeHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// Handle writes the record to the log, and passes it on to the wrapped handler if it is
+// enabled for the record's level.
+func (h *teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.mu.Lock()
+	_ = h.log.Handle(ctx, r.Clone())
+	h.mu.Unlock()
+
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs returns a handler whose records include the attributes.
+func (h *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &teeHandler{next: h.next.WithAttrs(attrs), log: h.log.WithAttrs(attrs), mu: h.mu}
+}
+
+// WithGroup returns a handler whose records are grouped under name.
+func (h *teeHandler) WithGroup(name string) slog.Handler {
+	return &teeHandler{next: h.next.WithGroup(name), log: h.log.WithGroup(name), mu: h.mu}
+}
@@ -0,0 +1,98 @@
+package runlog
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+func TestLogRecordsEverything(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, "prepare")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stderr bytes.Buffer
+	logger := slog.New(l.Handler(slog.NewTextHandler(&stderr, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	logger.Debug("Starting command", "command", "snap install jhack")
+	logger.Info("Installed snap", "snap", "jhack")
+
+	progress.Output("snap:jhack", "snap install jhack", "jhack (latest/stable) installed")
+	progress.Command("snap:jhack", "snap install jhack", time.Second, fmt.Errorf("exit status 1"))
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	progress.Output("snap:jq", "snap install jq", "after close")
+
+	content, err := os.ReadFile(l.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		`msg="Starting command"`,
+		`msg="Installed snap"`,
+		`line="jhack (latest/stable) installed"`,
+		`msg="Command finished" command="snap install jhack" elapsed=1s error="exit status 1"`,
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("expected log to contain %s, got:\n%s", expected, content)
+		}
+	}
+	if strings.Contains(string(content), "after close") {
+		t.Fatalf("expected nothing to be logged after close, got:\n%s", content)
+	}
+
+	// Only records at the configured level reach the wrapped handler.
+	if strings.Contains(stderr.String(), "Starting command") || !strings.Contains(stderr.String(), "Installed snap") {
+		t.Fatalf("unexpected output from wrapped handler: %s", stderr.String())
+	}
+
+	info, err := os.Stat(l.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected log to be readable only by its owner, got %v", info.Mode().Perm())
+	}
+}
+
+func TestOpenRemovesOldestLogs(t *testing.T) {
+	dir := t.TempDir()
+	for i := range MaxLogs + 5 {
+		name := fmt.Sprintf("20250101T%06dZ-prepare-1.log", i)
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l, err := Open(dir, "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != MaxLogs {
+		t.Fatalf("expected %d logs, got %d", MaxLogs, len(entries))
+	}
+	if entries[0].Name() != "20250101T000006Z-prepare-1.log" {
+		t.Fatalf("expected the oldest logs to be removed, first remaining is %s", entries[0].Name())
+	}
+	if entries[len(entries)-1].Name() != filepath.Base(l.Path()) {
+		t.Fatalf("expected the new log to be kept, last is %s", entries[len(entries)-1].Name())
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/user"
+	"slices"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// peerKey is the context key of the credentials of the process that opened a
+// connection.
+type peerKey struct{}
+
+// peer holds the credentials of the process that opened a connection, or the error
+// with which they could not be read.
+type peer struct {
+	cred *unix.Ucred
+	err  error
+}
+
+// withPeer records the credentials of the process at the other end of a connection in
+// the context of each request made over it.
+func withPeer(ctx context.Context, conn net.Conn) context.Context {
+	cred, err := peerCredentials(conn)
+	return context.WithValue(ctx, peerKey{}, peer{cred, err})
+}
+
+// peerFromContext returns the credentials recorded by withPeer.
+func peerFromContext(ctx context.Context) (*unix.Ucred, error) {
+	p, ok := ctx.Value(peerKey{}).(peer)
+	if !ok {
+		return nil, fmt.Errorf("no peer credentials for connection")
+	}
+	return p.cred, p.err
+}
+
+// peerCredentials reads the credentials of the process at the other end of a unix
+// socket connection with SO_PEERCRED. The kernel records them when the connection is
+// made, so they cannot be forged by the peer.
+func peerCredentials(conn net.Conn) (*unix.Ucred, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("connection is not over a unix socket")
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer credentials: %w", err)
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer credentials: %w", err)
+	}
+	if credErr != nil {
+		return nil, fmt.Errorf("failed to read peer credentials: %w", credErr)
+	}
+	return cred, nil
+}
+
+// memberOf returns a function that reports whether a peer is root, or a member of the
+// named group, either as its primary group or a supplementary group.
+func memberOf(group string) func(cred *unix.Ucred) (bool, error) {
+	return func(cred *unix.Ucred) (bool, error) {
+		if cred.Uid == 0 {
+			return true, nil
+		}
+
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			// Nobody but root is allowed if the group doesn't exist.
+			if _, ok := err.(user.UnknownGroupError); ok {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to look up group '%s': %w", group, err)
+		}
+
+		if strconv.FormatUint(uint64(cred.Gid), 10) == g.Gid {
+			return true, nil
+		}
+
+		u, err := user.LookupId(strconv.FormatUint(uint64(cred.Uid), 10))
+		if err != nil {
+			return false, fmt.Errorf("failed to look up user %d: %w", cred.Uid, err)
+		}
+		gids, err := u.GroupIds()
+		if err != nil {
+			return false, fmt.Errorf("failed to look up groups of user '%s': %w", u.Username, err)
+		}
+		return slices.Contains(gids, g.Gid), nil
+	}
+}
@@ -0,0 +1,427 @@
+// Package server exposes concierge over a REST API on a unix socket, such that tools on
+// a shared machine can query or trigger provisioning without each running as root.
+//
+// The socket is opened up to every user, as concierge opens up the LXD socket, and
+// requests are authorised by the credentials of the process that made them, read with
+// SO_PEERCRED: anybody may read the status, plan and history, but only root and members
+// of the configured group may prepare or restore the machine. Requests are served on
+// behalf of the user that made them, as 'sudo concierge' would be for that user, so that
+// each user's status is their own, and the machine is prepared for the user who asked.
+//
+// The API is versioned under `/1.0`:
+//
+//	GET  /1.0/status        the outcome of the most recent 'prepare'
+//	GET  /1.0/plan          what 'prepare' would install and configure
+//	GET  /1.0/history       the runs recorded on the machine
+//	GET  /1.0/history/{id}  a single recorded run
+//	POST /1.0/prepare       prepare the machine, streaming events
+//	POST /1.0/restore       restore the machine, streaming events
+//
+// Runs stream newline-delimited JSON events, in the format of '--output json', ending
+// with a "result" line describing the outcome of the run.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/report"
+	"github.com/canonical/concierge/internal/state"
+	"github.com/canonical/concierge/internal/system"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSocket is the path of the socket served by default.
+const DefaultSocket = "/run/concierge.socket"
+
+// DefaultGroup is the group whose members may prepare or restore the machine by default.
+const DefaultGroup = "concierge"
+
+// Server serves the concierge API.
+type Server struct {
+	// config is the configuration prepared when a request does not specify its own, and
+	// described by the plan.
+	config *config.Config
+	group  string
+
+	// authorize reports whether a peer may prepare or restore the machine.
+	authorize func(cred *unix.Ucred) (bool, error)
+
+	// running is set while a run is underway, since only one may be at a time.
+	running atomic.Bool
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithGroup sets the group whose members may prepare or restore the machine.
+func WithGroup(group string) Option {
+	return func(s *Server) { s.group = group }
+}
+
+// NewServer constructs a server that prepares the machine with the specified
+// configuration, unless a request specifies its own.
+func NewServer(conf *config.Config, opts ...Option) *Server {
+	s := &Server{config: conf, group: DefaultGroup}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.authorize == nil {
+		s.authorize = memberOf(s.group)
+	}
+	return s
+}
+
+// Handler returns the handler of the API's routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /1.0/status", s.status)
+	mux.HandleFunc("GET /1.0/plan", s.plan)
+	mux.HandleFunc("GET /1.0/history", s.history)
+	mux.HandleFunc("GET /1.0/history/{id}", s.historyRun)
+	mux.HandleFunc("POST /1.0/prepare", s.run(concierge.PrepareAction))
+	mux.HandleFunc("POST /1.0/restore", s.run(concierge.RestoreAction))
+	return mux
+}
+
+// Serve listens on the socket at the specified path, and serves the API until the
+// context is done. Once it is, new connections are refused, and any run underway is
+// waited for, since runs cannot be interrupted.
+func (s *Server) Serve(ctx context.Context, socketPath string) error {
+	listener, err := listen(socketPath)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ConnContext:       withPeer,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		if s.running.Load() {
+			slog.Info("Waiting for the run underway to finish")
+		}
+		if err := srv.Shutdown(context.Background()); err != nil {
+			slog.Error("failed to shut down server", "error", err.Error())
+		}
+	}()
+
+	slog.Info("Serving the concierge API", "socket", socketPath, "group", s.group)
+	err = srv.Serve(listener)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve the concierge API: %w", err)
+	}
+
+	<-done
+	return nil
+}
+
+// listen listens on a unix socket at the specified path, replacing any socket left
+// behind by an earlier server. As with the LXD socket, the socket is readable and
+// writable by everyone; requests are authorised by their peer credentials instead.
+func listen(socketPath string) (net.Listener, error) {
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("refusing to replace '%s', which is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	lc := net.ListenConfig{}
+	listener, err := lc.Listen(context.Background(), "unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket: %w", err)
+	}
+
+	if err := os.Chmod(socketPath, 0666); err != nil { //nolint:gosec // G302: access is controlled by peer credentials
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of socket: %w", err)
+	}
+	return listener, nil
+}
+
+// manager constructs a manager for a single request, on behalf of the user that made
+// it, from a copy of the configuration, since a run records its outcome in the
+// configuration it is given.
+func manager(r *http.Request, conf *config.Config, dryRun bool) (*concierge.Manager, error) {
+	cred, err := peerFromContext(r.Context())
+	if err != nil {
+		return nil, err
+	}
+	u, err := system.LookupUserID(strconv.FormatUint(uint64(cred.Uid), 10))
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %d: %w", cred.Uid, err)
+	}
+
+	c := *conf
+	c.DryRun = c.DryRun || dryRun
+	c.Console = io.Discard
+
+	mgr, err := concierge.NewManager(&c, concierge.WithUser(u))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise concierge: %w", err)
+	}
+	return mgr, nil
+}
+
+// status reports the outcome of the most recent 'prepare'.
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	mgr, err := manager(r, s.config, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	report, err := mgr.Status()
+	if concierge.ErrNotPrepared(err) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// plan describes what 'prepare' would do with the server's configuration.
+func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
+	mgr, err := manager(r, s.config, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	plan, err := mgr.Describe()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// history lists the runs recorded on the machine.
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	runs, err := s.runs(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]*state.Run{"runs": runs})
+}
+
+// historyRun reports a single recorded run.
+func (s *Server) historyRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid run ID '%s'", r.PathValue("id")))
+		return
+	}
+
+	runs, err := s.runs(r)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	for _, run := range runs {
+		if run.ID == id {
+			writeJSON(w, http.StatusOK, run)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("no run with ID %d in history", id))
+}
+
+// runs returns the runs recorded on the machine.
+func (s *Server) runs(r *http.Request) ([]*state.Run, error) {
+	mgr, err := manager(r, s.config, false)
+	if err != nil {
+		return nil, err
+	}
+	return mgr.History()
+}
+
+// runRequest is the body of a request to prepare or restore the machine. Each field is
+// optional; the server's configuration is prepared if neither a preset nor a config is
+// specified.
+type runRequest struct {
+	// Preset is the name of a preset to prepare the machine with.
+	Preset string `json:"preset,omitempty"`
+	// Config is a configuration to prepare the machine with, in the format of
+	// 'concierge.yaml'.
+	Config string `json:"config,omitempty"`
+	// DryRun reports what would be done, without making changes.
+	DryRun bool `json:"dry-run,omitempty"`
+}
+
+// resultEvent is the last line of the stream of a run, describing its outcome.
+type resultEvent struct {
+	Type   string         `json:"type"`
+	Result *report.Report `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// run returns a handler that performs an action, streaming its events to the client.
+func (s *Server) run(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cred, err := peerFromContext(r.Context())
+		if err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+
+		allowed, err := s.authorize(cred)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !allowed {
+			slog.Warn("Refused unauthorised request", "action", action, "uid", cred.Uid, "pid", cred.Pid)
+			writeError(w, http.StatusForbidden,
+				fmt.Errorf("only root and members of the '%s' group may %s the machine", s.group, action))
+			return
+		}
+
+		req := runRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+
+		if !s.running.CompareAndSwap(false, true) {
+			writeError(w, http.StatusConflict, fmt.Errorf("a run of concierge is already underway"))
+			return
+		}
+		defer s.running.Store(false)
+
+		conf, err := s.runConfig(action, req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		mgr, err := manager(r, conf, req.DryRun)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		slog.Info("Starting run requested over the API", "action", action, "uid", cred.Uid, "pid", cred.Pid)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+
+		stream := newEventStream(w)
+		stream.flush()
+
+		// Lines of output from commands are not written, as with '--output json'.
+		unsubscribe := progress.Subscribe(func(e progress.Event) { stream.write(e) })
+		if action == concierge.PrepareAction {
+			err = mgr.Prepare()
+		} else {
+			err = mgr.Restore()
+		}
+		unsubscribe()
+
+		last := resultEvent{Type: "result", Result: mgr.Result()}
+		if err != nil {
+			last.Error = err.Error()
+		}
+		stream.write(last)
+	}
+}
+
+// runConfig returns the configuration to perform a run with.
+func (s *Server) runConfig(action string, req runRequest) (*config.Config, error) {
+	switch {
+	case req.Preset != "" && req.Config != "":
+		return nil, fmt.Errorf("cannot proceed with both preset and configuration specified")
+	case (req.Preset != "" || req.Config != "") && action == concierge.RestoreAction:
+		return nil, fmt.Errorf("restore uses the configuration recorded by prepare, and cannot be configured")
+	case req.Preset != "":
+		conf, err := config.Preset(req.Preset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load configuration preset: %w", err)
+		}
+		conf.Source = "preset:" + req.Preset
+		return conf, nil
+	case req.Config != "":
+		// The configuration comes from another user, so must not be able to read
+		// concierge's environment through references to environment variables.
+		conf, err := config.ParseVerbatim([]byte(req.Config), "api")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse configuration: %w", err)
+		}
+		conf.Source = "api"
+		return conf, nil
+	default:
+		return s.config, nil
+	}
+}
+
+// eventStream writes events to a client as lines of JSON, flushing each line as it is
+// written. Failures to write, such as when the client has gone away, are ignored: runs
+// cannot be interrupted, so the run carries on regardless.
+type eventStream struct {
+	mu  sync.Mutex
+	w   http.ResponseWriter
+	enc *json.Encoder
+}
+
+// newEventStream constructs a stream of events to a client.
+func newEventStream(w http.ResponseWriter) *eventStream {
+	return &eventStream{w: w, enc: json.NewEncoder(w)}
+}
+
+// write writes a line of JSON to the stream.
+func (s *eventStream) write(v any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(v)
+	s.flushLocked()
+}
+
+// flush sends anything written so far to the client.
+func (s *eventStream) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *eventStream) flushLocked() {
+	_ = http.NewResponseController(s.w).Flush()
+}
+
+// writeJSON writes a value as the JSON body of a response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	// Failures to write are ignored, since the response has already begun.
+	_ = enc.Encode(v)
+}
+
+// writeError writes an error as the JSON body of a response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
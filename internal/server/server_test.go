@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/config"
+	"golang.org/x/sys/unix"
+)
+
+// startServer serves the API on a socket in a temporary directory, returning a client
+// that connects to it. The server is stopped when the test completes.
+func startServer(t *testing.T, s *Server) *http.Client {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "concierge.socket")
+	ctx, cancel := context.WithCancel(context.Background())
+
+	served := make(chan error)
+	go func() { served <- s.Serve(ctx, socket) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Error(err)
+		}
+	})
+
+	// Wait for the server to start listening.
+	for i := 0; ; i++ {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		} else if i == 100 {
+			t.Fatalf("server did not start listening: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0666 {
+		t.Fatalf("expected socket to be readable and writable by everyone, got %s", info.Mode().Perm())
+	}
+
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+}
+
+func newTestServer(t *testing.T, allowed bool) *Server {
+	t.Helper()
+
+	conf, err := config.Preset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The plan is made for a fresh machine, without querying snapd.
+	conf.Offline = true
+
+	s := NewServer(conf, WithGroup("testers"))
+	s.authorize = func(cred *unix.Ucred) (bool, error) {
+		if cred.Pid != int32(os.Getpid()) {
+			t.Errorf("expected peer to be the test process, got pid %d", cred.Pid)
+		}
+		return allowed, nil
+	}
+	return s
+}
+
+func TestServerPlan(t *testing.T) {
+	client := startServer(t, newTestServer(t, false))
+
+	resp, err := client.Get("http://concierge/1.0/plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: %s", resp.Status)
+	}
+
+	plan := struct {
+		Providers []struct {
+			Name string `json:"name"`
+		} `json:"providers"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Providers) != 2 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+}
+
+func TestServerRefusesUnauthorisedRuns(t *testing.T) {
+	client := startServer(t, newTestServer(t, false))
+
+	for _, action := range []string{"prepare", "restore"} {
+		resp, err := client.Post("http://concierge/1.0/"+action, "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body := map[string]string{}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden || !strings.Contains(body["error"], "members of the 'testers' group") {
+			t.Fatalf("expected %s to be refused, got %s: %v", action, resp.Status, body)
+		}
+	}
+}
+
+func TestServerStreamsRunEvents(t *testing.T) {
+	client := startServer(t, newTestServer(t, true))
+
+	resp, err := client.Post("http://concierge/1.0/prepare", "application/json", strings.NewReader(`{"dry-run": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("unexpected response: %s %v", resp.Status, resp.Header)
+	}
+
+	types := map[string]int{}
+	var last map[string]any
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		last = map[string]any{}
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		types[last["type"].(string)]++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if types["start"] == 0 || types["start"] != types["end"] {
+		t.Fatalf("expected each step to start and end, got: %v", types)
+	}
+
+	result, _ := last["result"].(map[string]any)
+	if last["type"] != "result" || last["error"] != nil || result["action"] != "prepare" || result["status"] != "succeeded" {
+		t.Fatalf("unexpected result: %v", last)
+	}
+}
+
+func TestServerRejectsConfiguredRestore(t *testing.T) {
+	client := startServer(t, newTestServer(t, true))
+
+	resp, err := client.Post("http://concierge/1.0/restore", "application/json", strings.NewReader(`{"preset": "dev"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected restore with a preset to be rejected, got: %s", resp.Status)
+	}
+}
+
+func TestServerDoesNotExpandRequestConfig(t *testing.T) {
+	t.Setenv("TEST_REGISTRY_PASSWORD", "secret")
+
+	s := newTestServer(t, true)
+	conf, err := s.runConfig("prepare", runRequest{Config: `
+providers:
+  k8s:
+    enable: true
+    image-registry:
+      url: https://registry.example.com
+      password: ${TEST_REGISTRY_PASSWORD}
+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Providers.K8s.ImageRegistry.Password != "${TEST_REGISTRY_PASSWORD}" {
+		t.Fatalf("expected the server's environment not to be read, got: %s", conf.Providers.K8s.ImageRegistry.Password)
+	}
+}
+
+func TestServerRunsForPeer(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, err := strconv.ParseUint(current.Uid, 10, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server itself runs on behalf of somebody else.
+	t.Setenv("SUDO_USER", "nobody")
+
+	s := newTestServer(t, true)
+	r := httptest.NewRequest(http.MethodGet, "/1.0/status", nil)
+	r = r.WithContext(context.WithValue(r.Context(), peerKey{}, peer{cred: &unix.Ucred{Uid: uint32(uid)}}))
+
+	mgr, err := manager(r, s.config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The plan is made for the peer, whose Juju data is in their home directory.
+	plan, err := mgr.Describe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.Environment.JujuData != filepath.Join(current.HomeDir, ".local", "share", "juju") {
+		t.Fatalf("expected the plan to be made for %s, got: %s", current.Username, plan.Environment.JujuData)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/1.0/status", nil)
+	if _, err := manager(r, s.config, false); err == nil {
+		t.Fatal("expected a request without peer credentials to be refused")
+	}
+}
+
+func TestMemberOf(t *testing.T) {
+	allowed, err := memberOf("concierge-test-missing-group")(&unix.Ucred{Uid: 0})
+	if err != nil || !allowed {
+		t.Fatalf("expected root to be allowed, got %v, %v", allowed, err)
+	}
+
+	allowed, err = memberOf("concierge-test-missing-group")(&unix.Ucred{Uid: 4242, Gid: 4242})
+	if err != nil || allowed {
+		t.Fatalf("expected nobody else to be allowed when the group doesn't exist, got %v, %v", allowed, err)
+	}
+}
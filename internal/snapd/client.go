@@ -27,34 +27,54 @@ type Client struct {
 // Config configures the snapd client.
 type Config struct {
 	// Socket is the path to the snapd socket.
-	// If empty, the default "/run/snapd.socket" is used.
+	// If empty, DefaultSocket is used.
 	Socket string
+	// Transport, if set, makes the requests to snapd, such as to trace each request
+	// made through SocketTransport. If nil, SocketTransport is used.
+	Transport http.RoundTripper
 }
 
+// DefaultSocket is the path of the socket snapd listens on.
+const DefaultSocket = "/run/snapd.socket"
+
 // NewClient creates a new snapd API client.
 func NewClient(config *Config) *Client {
-	socketPath := "/run/snapd.socket"
+	socketPath := DefaultSocket
 	if config != nil && config.Socket != "" {
 		socketPath = config.Socket
 	}
 
+	transport := SocketTransport(socketPath)
+	if config != nil && config.Transport != nil {
+		transport = config.Transport
+	}
+
 	return &Client{
 		httpClient: &http.Client{
-			Transport: &http.Transport{
-				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-					dialer := net.Dialer{
-						Timeout:   30 * time.Second,
-						KeepAlive: 30 * time.Second,
-					}
-					return dialer.DialContext(ctx, "unix", socketPath)
-				},
-			},
-			Timeout: 60 * time.Second,
+			Transport: transport,
+			Timeout:   60 * time.Second,
 		},
 		socketPath: socketPath,
 	}
 }
 
+// SocketTransport returns a transport that makes requests to snapd over the socket at
+// the specified path.
+func SocketTransport(socketPath string) http.RoundTripper {
+	return &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialer := net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+}
+
+// Socket returns the path of the snapd socket the client connects to.
+func (c *Client) Socket() string { return c.socketPath }
+
 // response represents the common structure of snapd API responses.
 // See https://snapcraft.io/docs/using-the-api
 type response struct {
@@ -0,0 +1,432 @@
+// Package snapdtest provides an in-process fake of the snapd REST API, served over a
+// unix socket, for testing code that talks to snapd.
+//
+// The fake serves enough of the API for concierge: `/v2/snaps`, `/v2/find`,
+// `/v2/changes` and `/v2/interfaces`. Its store contents, installed snaps, errors
+// and latency are all scriptable. Snap operations requested with POST complete
+// immediately as changes, updating the installed snaps accordingly.
+package snapdtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/snapd"
+)
+
+// Server is a fake snapd server.
+type Server struct {
+	server *httptest.Server
+	socket string
+
+	mu          sync.Mutex
+	store       map[string]snapd.Snap
+	installed   map[string]snapd.Snap
+	connections []Connection
+	changes     map[string]*Change
+	failures    []*failure
+	latency     time.Duration
+	requests    []string
+}
+
+// Connection is an interface connection between a plug and a slot.
+type Connection struct {
+	Plug string `json:"plug"`
+	Slot string `json:"slot"`
+}
+
+// Change is an asynchronous snapd operation.
+type Change struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Summary string `json:"summary"`
+	Status  string `json:"status"`
+	Ready   bool   `json:"ready"`
+	Err     string `json:"err,omitempty"`
+}
+
+// failure is a scripted error response.
+type failure struct {
+	method string
+	path   string
+	status int
+	kind   string
+	times  int
+}
+
+// NewServer starts a fake snapd server listening on a unix socket in a temporary
+// directory. The server is stopped when the test completes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "snapd.socket")
+
+	lc := net.ListenConfig{}
+	listener, err := lc.Listen(context.Background(), "unix", socket)
+	if err != nil {
+		t.Fatalf("failed to listen on fake snapd socket: %v", err)
+	}
+
+	s := &Server{
+		socket:    socket,
+		store:     map[string]snapd.Snap{},
+		installed: map[string]snapd.Snap{},
+		changes:   map[string]*Change{},
+	}
+
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.server.Listener = listener
+	s.server.Start()
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+// Socket returns the path of the server's unix socket.
+func (s *Server) Socket() string { return s.socket }
+
+// AddStoreSnap makes a snap available in the fake store, replacing any existing snap
+// of the same name.
+func (s *Server) AddStoreSnap(snap snapd.Snap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.store[snap.Name] = snap
+}
+
+// AddInstalledSnap marks a snap as installed. If no status is set, the snap is active.
+func (s *Server) AddInstalledSnap(snap snapd.Snap) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snap.Status == "" {
+		snap.Status = snapd.StatusActive
+	}
+	s.installed[snap.Name] = snap
+}
+
+// AddConnection records an interface connection.
+func (s *Server) AddConnection(plug, slot string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connections = append(s.connections, Connection{Plug: plug, Slot: slot})
+}
+
+// Fail makes the next requests matching the method and path fail with the specified
+// HTTP status code and snapd error kind, such as "snap-not-found". The path is
+// matched exactly, without the query string. A count of zero fails every request.
+func (s *Server) Fail(method, path string, status int, kind string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{method: method, path: path, status: status, kind: kind, times: count})
+}
+
+// SetLatency delays every response by the specified duration.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// Installed returns the installed snap of the specified name, if any.
+func (s *Server) Installed(name string) (snapd.Snap, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap, ok := s.installed[name]
+	return snap, ok
+}
+
+// Requests returns the requests served so far, as "METHOD /path?query".
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// serveHTTP routes a request to the relevant endpoint.
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency := s.latency
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	f := s.matchFailure(r)
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if f != nil {
+		writeError(w, f.status, f.kind, fmt.Sprintf("scripted failure for %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v2/snaps" && r.Method == http.MethodGet:
+		s.listSnaps(w)
+	case strings.HasPrefix(r.URL.Path, "/v2/snaps/") && r.Method == http.MethodGet:
+		s.getSnap(w, strings.TrimPrefix(r.URL.Path, "/v2/snaps/"))
+	case strings.HasPrefix(r.URL.Path, "/v2/snaps/") && r.Method == http.MethodPost:
+		s.snapAction(w, r, strings.TrimPrefix(r.URL.Path, "/v2/snaps/"))
+	case r.URL.Path == "/v2/find" && r.Method == http.MethodGet:
+		s.find(w, r)
+	case r.URL.Path == "/v2/changes" && r.Method == http.MethodGet:
+		s.listChanges(w)
+	case strings.HasPrefix(r.URL.Path, "/v2/changes/") && r.Method == http.MethodGet:
+		s.getChange(w, strings.TrimPrefix(r.URL.Path, "/v2/changes/"))
+	case r.URL.Path == "/v2/interfaces" && r.Method == http.MethodGet:
+		s.listConnections(w)
+	case r.URL.Path == "/v2/interfaces" && r.Method == http.MethodPost:
+		s.interfaceAction(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "", "not found")
+	}
+}
+
+// matchFailure returns the scripted failure for a request, if any. The caller must
+// hold the lock.
+func (s *Server) matchFailure(r *http.Request) *failure {
+	for i, f := range s.failures {
+		if f.method != r.Method || f.path != r.URL.Path {
+			continue
+		}
+
+		if f.times > 0 {
+			f.times--
+			if f.times == 0 {
+				s.failures = slices.Delete(s.failures, i, i+1)
+			}
+		}
+		return f
+	}
+	return nil
+}
+
+func (s *Server) listSnaps(w http.ResponseWriter) {
+	s.mu.Lock()
+	snaps := []snapd.Snap{}
+	for _, snap := range s.installed {
+		snaps = append(snaps, snap)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(snaps, func(a, b snapd.Snap) int { return strings.Compare(a.Name, b.Name) })
+	writeSync(w, snaps)
+}
+
+func (s *Server) getSnap(w http.ResponseWriter, name string) {
+	snap, ok := s.Installed(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, "snap-not-found", fmt.Sprintf("snap %q is not installed", name))
+		return
+	}
+	writeSync(w, snap)
+}
+
+func (s *Server) find(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+
+	s.mu.Lock()
+	snap, ok := s.store[name]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "snap-not-found", "snap not found")
+		return
+	}
+	writeSync(w, []snapd.Snap{snap})
+}
+
+// snapAction performs an install, refresh or remove, completing it immediately.
+func (s *Server) snapAction(w http.ResponseWriter, r *http.Request, name string) {
+	var req struct {
+		Action  string `json:"action"`
+		Channel string `json:"channel"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "", "cannot decode request body")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Action {
+	case "install", "refresh":
+		snap, ok := s.store[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "snap-not-found", "snap not found")
+			return
+		}
+
+		channel := req.Channel
+		if channel == "" {
+			channel = "latest/stable"
+		}
+
+		snap.Status = snapd.StatusActive
+		snap.TrackingChannel = channel
+		snap.Channel = channel
+		if info, ok := snap.Channels[channel]; ok {
+			snap.Version = info.Version
+			snap.Revision = info.Revision
+			snap.Confinement = info.Confinement
+		}
+		s.installed[name] = snap
+	case "remove":
+		if _, ok := s.installed[name]; !ok {
+			writeError(w, http.StatusBadRequest, "snap-not-installed", fmt.Sprintf("snap %q is not installed", name))
+			return
+		}
+		delete(s.installed, name)
+	default:
+		writeError(w, http.StatusBadRequest, "", fmt.Sprintf("unsupported action %q", req.Action))
+		return
+	}
+
+	writeAsync(w, s.addChange(req.Action+"-snap", fmt.Sprintf("%s %q snap", req.Action, name)))
+}
+
+// interfaceAction connects or disconnects a plug and slot, completing it immediately.
+func (s *Server) interfaceAction(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Action string `json:"action"`
+		Plugs  []struct {
+			Snap string `json:"snap"`
+			Plug string `json:"plug"`
+		} `json:"plugs"`
+		Slots []struct {
+			Snap string `json:"snap"`
+			Slot string `json:"slot"`
+		} `json:"slots"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Plugs) != 1 || len(req.Slots) != 1 {
+		writeError(w, http.StatusBadRequest, "", "cannot decode request body")
+		return
+	}
+
+	conn := Connection{
+		Plug: req.Plugs[0].Snap + ":" + req.Plugs[0].Plug,
+		Slot: req.Slots[0].Snap + ":" + req.Slots[0].Slot,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Action {
+	case "connect":
+		if !slices.Contains(s.connections, conn) {
+			s.connections = append(s.connections, conn)
+		}
+	case "disconnect":
+		s.connections = slices.DeleteFunc(s.connections, func(c Connection) bool { return c == conn })
+	default:
+		writeError(w, http.StatusBadRequest, "", fmt.Sprintf("unsupported action %q", req.Action))
+		return
+	}
+
+	writeAsync(w, s.addChange(req.Action+"-snap", fmt.Sprintf("%s %s to %s", req.Action, conn.Plug, conn.Slot)))
+}
+
+func (s *Server) listConnections(w http.ResponseWriter) {
+	s.mu.Lock()
+	conns := slices.Clone(s.connections)
+	s.mu.Unlock()
+
+	writeSync(w, map[string]any{"established": conns})
+}
+
+func (s *Server) listChanges(w http.ResponseWriter) {
+	s.mu.Lock()
+	changes := []*Change{}
+	for _, c := range s.changes {
+		changes = append(changes, c)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(changes, func(a, b *Change) int {
+		x, _ := strconv.Atoi(a.ID)
+		y, _ := strconv.Atoi(b.ID)
+		return x - y
+	})
+	writeSync(w, changes)
+}
+
+func (s *Server) getChange(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	c, ok := s.changes[id]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "", fmt.Sprintf("cannot find change with id %q", id))
+		return
+	}
+	writeSync(w, c)
+}
+
+// addChange records a completed change. The caller must hold the lock.
+func (s *Server) addChange(kind, summary string) *Change {
+	c := &Change{
+		ID:      strconv.Itoa(len(s.changes) + 1),
+		Kind:    kind,
+		Summary: summary,
+		Status:  "Done",
+		Ready:   true,
+	}
+	s.changes[c.ID] = c
+	return c
+}
+
+// writeSync writes a synchronous snapd response.
+func writeSync(w http.ResponseWriter, result any) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"type":        "sync",
+		"status-code": http.StatusOK,
+		"status":      "OK",
+		"result":      result,
+	})
+}
+
+// writeAsync writes an asynchronous snapd response for a change.
+func writeAsync(w http.ResponseWriter, c *Change) {
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"type":        "async",
+		"status-code": http.StatusAccepted,
+		"status":      "Accepted",
+		"change":      c.ID,
+	})
+}
+
+// writeError writes a snapd error response.
+func writeError(w http.ResponseWriter, status int, kind, message string) {
+	result := map[string]any{"message": message}
+	if kind != "" {
+		result["kind"] = kind
+	}
+
+	writeJSON(w, status, map[string]any{
+		"type":        "error",
+		"status-code": status,
+		"status":      http.StatusText(status),
+		"result":      result,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body) // The client going away is not actionable
+}
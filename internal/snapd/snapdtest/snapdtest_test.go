@@ -0,0 +1,140 @@
+package snapdtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/snapd"
+)
+
+func TestServerServesClient(t *testing.T) {
+	server := NewServer(t)
+	server.AddStoreSnap(snapd.Snap{Name: "jhack", Confinement: "strict"})
+	server.AddInstalledSnap(snapd.Snap{Name: "lxd", TrackingChannel: "5.21/stable"})
+
+	client := snapd.NewClient(&snapd.Config{Socket: server.Socket()})
+
+	snap, err := client.Snap(context.Background(), "lxd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Status != snapd.StatusActive || snap.TrackingChannel != "5.21/stable" {
+		t.Fatalf("unexpected installed snap: %+v", snap)
+	}
+
+	_, err = client.Snap(context.Background(), "jhack")
+	if err == nil || !strings.Contains(err.Error(), "snap not installed") {
+		t.Fatalf("expected snap not installed error, got: %v", err)
+	}
+
+	found, err := client.FindOne(context.Background(), "jhack")
+	if err != nil || found.Name != "jhack" {
+		t.Fatalf("unexpected store snap: %+v, %v", found, err)
+	}
+
+	_, err = client.FindOne(context.Background(), "nonexistent")
+	if err == nil || !strings.Contains(err.Error(), "snap not found") {
+		t.Fatalf("expected snap not found error, got: %v", err)
+	}
+}
+
+func TestServerScriptedFailures(t *testing.T) {
+	server := NewServer(t)
+	server.AddStoreSnap(snapd.Snap{Name: "jhack"})
+	server.Fail(http.MethodGet, "/v2/find", http.StatusInternalServerError, "", 1)
+
+	client := snapd.NewClient(&snapd.Config{Socket: server.Socket()})
+
+	_, err := client.FindOne(context.Background(), "jhack")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected scripted failure, got: %v", err)
+	}
+
+	_, err = client.FindOne(context.Background(), "jhack")
+	if err != nil {
+		t.Fatalf("expected failure to apply only once, got: %v", err)
+	}
+
+	if len(server.Requests()) != 2 {
+		t.Fatalf("expected 2 requests, got: %v", server.Requests())
+	}
+}
+
+func TestServerLatency(t *testing.T) {
+	server := NewServer(t)
+	server.AddInstalledSnap(snapd.Snap{Name: "lxd"})
+	server.SetLatency(time.Second)
+
+	client := snapd.NewClient(&snapd.Config{Socket: server.Socket()})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := client.Snap(ctx, "lxd")
+	if err == nil {
+		t.Fatal("expected request to time out")
+	}
+}
+
+func TestServerSnapActions(t *testing.T) {
+	server := NewServer(t)
+	server.AddStoreSnap(snapd.Snap{
+		Name: "jhack",
+		Channels: map[string]snapd.ChannelInfo{
+			"latest/edge": {Version: "1.1", Revision: "42", Confinement: "strict"},
+		},
+	})
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", server.Socket())
+			},
+		},
+	}
+
+	body, _ := json.Marshal(map[string]string{"action": "install", "channel": "latest/edge"})
+	resp, err := httpClient.Post("http://localhost/v2/snaps/jhack", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var async struct {
+		Type   string `json:"type"`
+		Change string `json:"change"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&async); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusAccepted || async.Type != "async" || async.Change == "" {
+		t.Fatalf("unexpected response to install: %d %+v", resp.StatusCode, async)
+	}
+
+	snap, ok := server.Installed("jhack")
+	if !ok || snap.TrackingChannel != "latest/edge" || snap.Revision != "42" {
+		t.Fatalf("unexpected installed snap: %+v", snap)
+	}
+
+	changeResp, err := httpClient.Get("http://localhost/v2/changes/" + async.Change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = changeResp.Body.Close() }()
+
+	var change struct {
+		Result Change `json:"result"`
+	}
+	if err := json.NewDecoder(changeResp.Body).Decode(&change); err != nil {
+		t.Fatal(err)
+	}
+	if !change.Result.Ready || change.Result.Status != "Done" {
+		t.Fatalf("expected change to be complete, got: %+v", change.Result)
+	}
+}
@@ -0,0 +1,211 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"time"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+// historyFileName is the name of the run history within the state directory.
+const historyFileName = "history.yaml"
+
+// MaxHistory is the number of runs kept in the history. Older runs are discarded.
+const MaxHistory = 100
+
+// ErrRunNotFound is returned when there is no run with the requested ID.
+var ErrRunNotFound = errors.New("no such run in history")
+
+// Run outcomes.
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// Run records a single 'prepare' or 'restore' run.
+type Run struct {
+	// ID identifies the run within the history. IDs increase with each run.
+	ID int `yaml:"id" json:"id"`
+	// Action is the action performed; either "prepare" or "restore".
+	Action string `yaml:"action" json:"action"`
+	// User is the name of the real user on whose behalf concierge ran.
+	User string `yaml:"user" json:"user"`
+	// RanAs is the name of the user that concierge itself ran as, usually root.
+	RanAs string `yaml:"ran-as" json:"ran-as"`
+	// ConciergeVersion is the version of concierge that performed the run.
+	ConciergeVersion string `yaml:"concierge-version,omitempty" json:"concierge-version,omitempty"`
+	// ConfigSource is where the configuration for the run came from.
+	ConfigSource string `yaml:"config-source,omitempty" json:"config-source,omitempty"`
+	// ConfigHash is a digest of the effective configuration, such that runs with the
+	// same configuration can be identified.
+	ConfigHash string `yaml:"config-hash,omitempty" json:"config-hash,omitempty"`
+	// Started and Finished are the times at which the run started and finished.
+	Started  time.Time `yaml:"started" json:"started"`
+	Finished time.Time `yaml:"finished" json:"finished"`
+	// Outcome is one of OutcomeSucceeded or OutcomeFailed.
+	Outcome string `yaml:"outcome" json:"outcome"`
+	// Error is the error that the run failed with, if any.
+	Error string `yaml:"error,omitempty" json:"error,omitempty"`
+	// Steps records the result of each step of the run.
+	Steps []*Component `yaml:"steps,omitempty" json:"steps"`
+}
+
+// Duration returns how long the run took.
+func (r *Run) Duration() time.Duration { return r.Finished.Sub(r.Started) }
+
+// Apply updates the run's steps according to a progress event.
+func (r *Run) Apply(e progress.Event) {
+	r.Steps = applyEvent(r.Steps, e)
+}
+
+// ConfigHash returns a digest of the effective configuration. Fields recorded at
+// runtime, such as the status and snapshot, are excluded so that the digest depends
+// only on what concierge was asked to do.
+func ConfigHash(conf *config.Config) (string, error) {
+	effective := *conf
+	effective.Status = config.Provisioning
+	effective.Snapshot = nil
+	effective.Source = ""
+	effective.Provenance = nil
+
+	content, err := yaml.Marshal(&effective)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config as yaml: %w", err)
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// historyDocument is the on-disk format of the run history.
+type historyDocument struct {
+	Version int    `yaml:"version"`
+	Runs    []*Run `yaml:"runs"`
+}
+
+// historyPath returns the path of the run history file.
+func (s *Store) historyPath() string { return path.Join(s.dir, historyFileName) }
+
+// AppendRun adds a run to the history, assigning its ID. Only the most recent
+// MaxHistory runs are kept.
+func (s *Store) AppendRun(run *Run) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	doc, err := s.readHistory()
+	if err != nil {
+		return err
+	}
+
+	run.ID = 1
+	if len(doc.Runs) > 0 {
+		run.ID = doc.Runs[len(doc.Runs)-1].ID + 1
+	}
+
+	doc.Runs = append(doc.Runs, run)
+	if len(doc.Runs) > MaxHistory {
+		doc.Runs = doc.Runs[len(doc.Runs)-MaxHistory:]
+	}
+
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history as yaml: %w", err)
+	}
+
+	err = s.system.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create state directory '%s': %w", s.dir, err)
+	}
+
+	err = system.WriteFileAtomic(s.system, s.historyPath(), content, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+
+	slog.Debug("Run recorded in history", "path", s.historyPath(), "id", run.ID, "action", run.Action)
+	return nil
+}
+
+// History returns the recorded runs, oldest first.
+func (s *Store) History() ([]*Run, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	doc, err := s.readHistory()
+	if err != nil {
+		return nil, err
+	}
+	return doc.Runs, nil
+}
+
+// Run returns the run with the specified ID. If there is none, ErrRunNotFound is
+// returned.
+func (s *Store) Run(id int) (*Run, error) {
+	runs, err := s.History()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range runs {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, ErrRunNotFound
+}
+
+// readHistory parses the run history. A missing history yields an empty document.
+// The history is informational only, so a corrupt history is moved aside and a new
+// one started, rather than preventing concierge from running.
+func (s *Store) readHistory() (*historyDocument, error) {
+	doc := &historyDocument{Version: SchemaVersion, Runs: []*Run{}}
+
+	if !s.system.PathExists(s.historyPath()) {
+		return doc, nil
+	}
+
+	content, err := s.system.ReadFile(s.historyPath())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return doc, nil
+		}
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+
+	err = yaml.Unmarshal(content, doc)
+	if err == nil && doc.Version == 0 {
+		err = fmt.Errorf("missing schema version")
+	}
+	if err != nil {
+		corruptPath := fmt.Sprintf("%s.corrupt-%d", s.historyPath(), time.Now().Unix())
+		slog.Warn("History file is corrupt, starting afresh", "path", s.historyPath(), "moved_to", corruptPath, "error", err)
+
+		err = s.system.Rename(s.historyPath(), corruptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to move corrupt history file aside: %w", err)
+		}
+		return &historyDocument{Version: SchemaVersion, Runs: []*Run{}}, nil
+	}
+
+	if doc.Version > SchemaVersion {
+		return nil, fmt.Errorf("history file '%s' has schema version %d, but this version of concierge only supports up to %d",
+			s.historyPath(), doc.Version, SchemaVersion)
+	}
+
+	return doc, nil
+}
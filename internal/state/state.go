@@ -0,0 +1,450 @@
+// Package state persists concierge's runtime state in a system-wide store, such that
+// the status of a machine can be determined regardless of which user prepared it, or
+// which user is asking.
+//
+// The store is a single YAML document holding one record per target user: the real
+// user on whose behalf concierge ran, as resolved from SUDO_USER. The document carries
+// a schema version so that future versions of concierge can migrate it, and is always
+// written atomically, under a lock file shared by every concierge process. The last
+// known-good copy is kept alongside it, so that a corrupt store can be recovered. State
+// that belongs to the machine as a whole, such as its firewall, is kept in a single
+// machine-scoped record alongside the user records.
+//
+// Alongside the store, a history of every 'prepare' and 'restore' run is kept, such
+// that what happened to a long-lived machine can be reconstructed after the fact.
+package state
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/system"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultDir is the directory in which the system-wide state store is kept.
+const DefaultDir = "/var/lib/concierge"
+
+// SchemaVersion is the version of the state store format written by this version
+// of concierge.
+const SchemaVersion = 1
+
+// stateFileName is the name of the state store within its directory.
+const stateFileName = "state.yaml"
+
+// lockFileName is the name of the file locked while the store is read or updated,
+// such that concurrent runs of concierge do not lose each other's records.
+const lockFileName = "state.lock"
+
+// ErrNotFound is returned when there is no record for the requested user.
+var ErrNotFound = errors.New("no concierge state recorded")
+
+// Component states.
+const (
+	ComponentRunning   = "running"
+	ComponentSucceeded = "succeeded"
+	ComponentFailed    = "failed"
+)
+
+// Record is the state recorded for a single user.
+type Record struct {
+	// User is the name of the real user on whose behalf concierge ran.
+	User string `yaml:"user"`
+	// Updated is the time at which the record was last written.
+	Updated time.Time `yaml:"updated"`
+	// ConciergeVersion is the version of concierge that wrote the record.
+	ConciergeVersion string `yaml:"concierge-version,omitempty"`
+	// PID is the process ID of the concierge process that wrote the record.
+	PID int `yaml:"pid,omitempty"`
+	// Started is the time at which the most recent 'prepare' started.
+	Started time.Time `yaml:"started,omitempty"`
+	// Finished is the time at which the most recent 'prepare' finished.
+	Finished time.Time `yaml:"finished,omitempty"`
+	// Error is the error that the most recent 'prepare' failed with, if any.
+	Error string `yaml:"error,omitempty"`
+	// Components records the state of each step of the most recent 'prepare'.
+	Components []*Component `yaml:"components,omitempty"`
+	// Config is the merged runtime configuration, including the provisioning status.
+	Config *config.Config `yaml:"config"`
+}
+
+// Component is the state of a single step, such as a snap, deb, provider or Juju
+// controller.
+type Component struct {
+	// ID is the stable identifier of the step, such as `snap:jhack`.
+	ID       string    `yaml:"id" json:"id"`
+	Kind     string    `yaml:"kind" json:"kind"`
+	Name     string    `yaml:"name" json:"name"`
+	State    string    `yaml:"state" json:"state"`
+	Started  time.Time `yaml:"started" json:"started"`
+	Finished time.Time `yaml:"finished,omitempty" json:"finished,omitzero"`
+	Error    string    `yaml:"error,omitempty" json:"error,omitempty"`
+}
+
+// Apply updates the record's components according to a progress event.
+func (r *Record) Apply(e progress.Event) {
+	r.Components = applyEvent(r.Components, e)
+}
+
+// applyEvent updates the component a progress event relates to, adding it if it is
+// not yet known, and returns the resulting components. Events other than the start and
+// end of a step are ignored.
+func applyEvent(components []*Component, e progress.Event) []*Component {
+	if e.Type != progress.EventStart && e.Type != progress.EventEnd {
+		return components
+	}
+
+	var c *Component
+	for _, existing := range components {
+		if existing.ID == e.Step {
+			c = existing
+			break
+		}
+	}
+
+	if c == nil {
+		c = &Component{ID: e.Step, Kind: e.Kind(), Name: e.Name()}
+		components = append(components, c)
+	}
+
+	switch e.Type {
+	case progress.EventStart:
+		c.State = ComponentRunning
+		c.Started = e.Time.UTC()
+		c.Finished = time.Time{}
+		c.Error = ""
+	case progress.EventEnd:
+		c.Finished = e.Time.UTC()
+		if e.Err != nil {
+			c.State = ComponentFailed
+			c.Error = e.Err.Error()
+		} else {
+			c.State = ComponentSucceeded
+		}
+	}
+
+	return components
+}
+
+// Machine is the state recorded for the machine as a whole, regardless of which user
+// concierge ran on behalf of.
+type Machine struct {
+	// Updated is the time at which the record was last written.
+	Updated time.Time `yaml:"updated"`
+	// Firewall records the FORWARD chain before it was first deconflicted for LXD.
+	Firewall *config.FirewallSnapshot `yaml:"firewall,omitempty"`
+}
+
+// document is the on-disk format of the state store.
+type document struct {
+	Version int                `yaml:"version"`
+	Machine *Machine           `yaml:"machine,omitempty"`
+	Users   map[string]*Record `yaml:"users"`
+}
+
+// NewStore constructs a Store which keeps its state in the specified directory.
+func NewStore(w system.Worker, dir string) *Store {
+	return &Store{system: w, dir: dir}
+}
+
+// Store is a system-wide store of concierge runtime state, keyed by user.
+type Store struct {
+	system system.Worker
+	dir    string
+
+	// Guards the read-modify-write cycle in Save within the process. Across processes,
+	// the lock file is used where the Worker supports it.
+	mu sync.Mutex
+}
+
+// lock takes the store's lock for the duration of a read, or a read-modify-write
+// cycle, returning a function that releases it.
+func (s *Store) lock() (unlock func(), err error) {
+	s.mu.Lock()
+
+	locker, ok := s.system.(system.Locker)
+	if !ok {
+		return s.mu.Unlock, nil
+	}
+
+	err = s.system.MkdirAll(s.dir, 0755)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to create state directory '%s': %w", s.dir, err)
+	}
+
+	release, err := locker.Lock(path.Join(s.dir, lockFileName))
+	if err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to lock state store: %w", err)
+	}
+
+	return func() {
+		release()
+		s.mu.Unlock()
+	}, nil
+}
+
+// Path returns the path of the state store file.
+func (s *Store) Path() string { return path.Join(s.dir, stateFileName) }
+
+// backupPath returns the path of the last known-good copy of the state store file.
+func (s *Store) backupPath() string { return s.Path() + ".bak" }
+
+// Save writes the record for its user, replacing any existing record for that user
+// and leaving the records of other users untouched.
+func (s *Store) Save(record *Record) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	doc, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	record.Updated = time.Now().UTC()
+	doc.Users[record.User] = record
+
+	err = s.write(doc)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Runtime state saved", "path", s.Path(), "user", record.User)
+	return nil
+}
+
+// SaveMachine writes the machine-scoped record, replacing any existing one. A nil
+// record clears it.
+func (s *Store) SaveMachine(machine *Machine) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	doc, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if machine != nil {
+		machine.Updated = time.Now().UTC()
+	}
+	doc.Machine = machine
+
+	err = s.write(doc)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Machine state saved", "path", s.Path())
+	return nil
+}
+
+// LoadMachine returns the machine-scoped record, or an empty record if there is none.
+func (s *Store) LoadMachine() (*Machine, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	doc, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Machine == nil {
+		return &Machine{}, nil
+	}
+
+	return doc.Machine, nil
+}
+
+// write replaces the state store with the document, keeping the previous store as the
+// last known-good copy.
+func (s *Store) write(doc *document) error {
+	content, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state as yaml: %w", err)
+	}
+
+	err = s.system.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create state directory '%s': %w", s.dir, err)
+	}
+
+	// Keep the current store as the known-good copy before replacing it. The store
+	// is copied rather than moved, so that readers never find it missing. It may not
+	// exist yet, in which case there is nothing to keep.
+	if s.system.PathExists(s.Path()) {
+		previous, err := s.system.ReadFile(s.Path())
+		if err != nil {
+			return fmt.Errorf("failed to read state file for backup: %w", err)
+		}
+
+		err = system.WriteFileAtomic(s.system, s.backupPath(), previous, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to back up state file: %w", err)
+		}
+	}
+
+	// The store holds the full configuration, which may include credentials for
+	// image registries, so it is only readable by root.
+	err = system.WriteFileAtomic(s.system, s.Path(), content, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return nil
+}
+
+// Load returns the record for the specified user. If there is none, ErrNotFound
+// is returned.
+func (s *Store) Load(user string) (*Record, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	doc, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	record, ok := doc.Users[user]
+	if !ok || record.Config == nil {
+		return nil, ErrNotFound
+	}
+
+	return record, nil
+}
+
+// List returns the records for all users, most recently updated first.
+func (s *Store) List() ([]*Record, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	doc, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*Record{}
+	for _, r := range doc.Users {
+		if r.Config != nil {
+			records = append(records, r)
+		}
+	}
+
+	slices.SortFunc(records, func(a, b *Record) int {
+		if c := b.Updated.Compare(a.Updated); c != 0 {
+			return c
+		}
+		return strings.Compare(a.User, b.User)
+	})
+
+	return records, nil
+}
+
+// read parses the state store. If the store is missing, the last known-good copy is
+// used instead, and if there is none either, an empty document. If the store is
+// corrupt, it is moved aside and the last known-good copy is used instead.
+func (s *Store) read() (*document, error) {
+	if !s.system.PathExists(s.Path()) {
+		return s.parse(s.backupPath())
+	}
+
+	doc, err := s.parse(s.Path())
+	if err == nil {
+		return doc, nil
+	}
+
+	var corruptErr *corruptError
+	if !errors.As(err, &corruptErr) {
+		return nil, err
+	}
+
+	corruptPath := fmt.Sprintf("%s.corrupt-%d", s.Path(), time.Now().Unix())
+	slog.Warn("State file is corrupt, recovering from backup", "path", s.Path(), "moved_to", corruptPath, "error", err)
+
+	err = s.system.Rename(s.Path(), corruptPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move corrupt state file aside: %w", err)
+	}
+
+	doc, err = s.parse(s.backupPath())
+	if err != nil {
+		slog.Warn("State backup is unusable, starting afresh", "path", s.backupPath(), "error", err)
+		return newDocument(), nil
+	}
+
+	return doc, nil
+}
+
+// parse reads and validates the state store at the specified path.
+func (s *Store) parse(filePath string) (*document, error) {
+	if !s.system.PathExists(filePath) {
+		return newDocument(), nil
+	}
+
+	content, err := s.system.ReadFile(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return newDocument(), nil
+		}
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	doc := newDocument()
+	err = yaml.Unmarshal(content, doc)
+	if err != nil {
+		return nil, &corruptError{err: err}
+	}
+
+	if doc.Version == 0 {
+		return nil, &corruptError{err: fmt.Errorf("missing schema version")}
+	}
+
+	if doc.Version > SchemaVersion {
+		return nil, fmt.Errorf("state file '%s' has schema version %d, but this version of concierge only supports up to %d",
+			filePath, doc.Version, SchemaVersion)
+	}
+
+	if doc.Users == nil {
+		doc.Users = map[string]*Record{}
+	}
+
+	return doc, nil
+}
+
+// newDocument returns an empty state store at the current schema version.
+func newDocument() *document {
+	return &document{Version: SchemaVersion, Users: map[string]*Record{}}
+}
+
+// corruptError indicates that the state store could not be parsed.
+type corruptError struct {
+	err error
+}
+
+func (e *corruptError) Error() string { return fmt.Sprintf("state file is corrupt: %s", e.err) }
+
+func (e *corruptError) Unwrap() error { return e.err }
@@ -0,0 +1,277 @@
+package state
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/system"
+)
+
+func TestStoreSaveAndLoad(t *testing.T) {
+	sys := system.NewMockSystem()
+	store := NewStore(sys, "/var/lib/concierge")
+
+	conf := &config.Config{Status: config.Succeeded}
+	conf.Providers.LXD.Enable = true
+
+	if err := store.Save(&Record{User: "ubuntu", Config: conf}); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := store.Load("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.User != "ubuntu" || record.Config.Status != config.Succeeded || !record.Config.Providers.LXD.Enable {
+		t.Fatalf("unexpected record loaded: %+v", record)
+	}
+
+	if _, ok := sys.CreatedFiles["/var/lib/concierge/state.yaml.tmp"]; ok {
+		t.Fatal("temporary file was left behind after an atomic write")
+	}
+
+	if !strings.HasPrefix(sys.CreatedFiles["/var/lib/concierge/state.yaml"], "version: 1\n") {
+		t.Fatalf("expected state file to carry a schema version, got: %s", sys.CreatedFiles["/var/lib/concierge/state.yaml"])
+	}
+}
+
+func TestStoreKeepsOtherUsers(t *testing.T) {
+	sys := system.NewMockSystem()
+	store := NewStore(sys, "/var/lib/concierge")
+
+	if err := store.Save(&Record{User: "root", Config: &config.Config{Status: config.Succeeded}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(&Record{User: "ubuntu", Config: &config.Config{Status: config.Failed}}); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+
+	// The most recently updated record comes first.
+	if records[0].User != "ubuntu" || records[1].User != "root" {
+		t.Fatalf("unexpected record order: %s, %s", records[0].User, records[1].User)
+	}
+}
+
+func TestStoreLoadMissingUser(t *testing.T) {
+	sys := system.NewMockSystem()
+	store := NewStore(sys, "/var/lib/concierge")
+
+	_, err := store.Load("ubuntu")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestStoreRecoversFromCorruptFile(t *testing.T) {
+	sys := system.NewMockSystem()
+	store := NewStore(sys, "/var/lib/concierge")
+
+	if err := store.Save(&Record{User: "ubuntu", Config: &config.Config{Status: config.Succeeded}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(&Record{User: "ubuntu", Config: &config.Config{Status: config.Failed}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the current store; the backup holds the first save.
+	sys.CreatedFiles["/var/lib/concierge/state.yaml"] = "version: [1\n"
+
+	record, err := store.Load("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Config.Status != config.Succeeded {
+		t.Fatalf("expected record to be recovered from backup, got status: %s", record.Config.Status)
+	}
+
+	corruptMoved := false
+	for p := range sys.CreatedFiles {
+		if strings.HasPrefix(p, "/var/lib/concierge/state.yaml.corrupt-") {
+			corruptMoved = true
+		}
+	}
+	if !corruptMoved {
+		t.Fatalf("expected corrupt state file to be moved aside, got: %v", sys.CreatedFiles)
+	}
+}
+
+func TestStoreFallsBackToBackupWhenMissing(t *testing.T) {
+	sys := system.NewMockSystem()
+	store := NewStore(sys, "/var/lib/concierge")
+
+	if err := store.Save(&Record{User: "ubuntu", Config: &config.Config{Status: config.Succeeded}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(&Record{User: "ubuntu", Config: &config.Config{Status: config.Failed}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := sys.CreatedFiles["/var/lib/concierge/state.yaml.bak"]; !ok {
+		t.Fatalf("expected the previous store to be kept as a backup, got: %v", sys.CreatedFiles)
+	}
+
+	// Lose the current store; the backup holds the first save.
+	delete(sys.CreatedFiles, "/var/lib/concierge/state.yaml")
+
+	record, err := store.Load("ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Config.Status != config.Succeeded {
+		t.Fatalf("expected record to be read from backup, got status: %s", record.Config.Status)
+	}
+}
+
+func TestStoreMachine(t *testing.T) {
+	sys := system.NewMockSystem()
+	store := NewStore(sys, "/var/lib/concierge")
+
+	machine, err := store.LoadMachine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if machine.Firewall != nil {
+		t.Fatalf("expected no machine state, got: %v", machine)
+	}
+
+	fw := &config.FirewallSnapshot{Backend: config.FirewallIptables, Rules: "-P FORWARD DROP\n"}
+	if err := store.SaveMachine(&Machine{Firewall: fw}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(&Record{User: "ubuntu", Config: &config.Config{Status: config.Succeeded}}); err != nil {
+		t.Fatal(err)
+	}
+
+	machine, err = store.LoadMachine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fw, machine.Firewall) {
+		t.Fatalf("expected: %v, got: %v", fw, machine.Firewall)
+	}
+
+	if err := store.SaveMachine(nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sys.CreatedFiles["/var/lib/concierge/state.yaml"], "machine:") {
+		t.Fatalf("expected machine state to be cleared, got:\n%s", sys.CreatedFiles["/var/lib/concierge/state.yaml"])
+	}
+}
+
+func TestStoreRejectsNewerSchema(t *testing.T) {
+	sys := system.NewMockSystem()
+	sys.MockFile("/var/lib/concierge/state.yaml", []byte("version: 99\nusers: {}\n"))
+	store := NewStore(sys, "/var/lib/concierge")
+
+	_, err := store.Load("ubuntu")
+	if err == nil || !strings.Contains(err.Error(), "schema version 99") {
+		t.Fatalf("expected schema version error, got: %v", err)
+	}
+}
+
+func TestRecordApply(t *testing.T) {
+	record := &Record{User: "ubuntu"}
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	record.Apply(progress.Event{Type: progress.EventStart, Step: "snap:jhack", Time: start})
+	record.Apply(progress.Event{Type: progress.EventStart, Step: "provider:lxd", Time: start})
+	record.Apply(progress.Event{Type: progress.EventEnd, Step: "snap:jhack", Time: start.Add(time.Minute)})
+	record.Apply(progress.Event{Type: progress.EventEnd, Step: "provider:lxd", Time: start.Add(time.Minute), Err: errors.New("boom")})
+
+	if len(record.Components) != 2 {
+		t.Fatalf("expected 2 components, got %d", len(record.Components))
+	}
+
+	snap := record.Components[0]
+	if snap.Kind != "snap" || snap.Name != "jhack" || snap.State != ComponentSucceeded || !snap.Finished.Equal(start.Add(time.Minute)) {
+		t.Fatalf("unexpected snap component: %+v", snap)
+	}
+
+	lxd := record.Components[1]
+	if lxd.State != ComponentFailed || lxd.Error != "boom" {
+		t.Fatalf("unexpected provider component: %+v", lxd)
+	}
+}
+
+func TestStoreHistory(t *testing.T) {
+	sys := system.NewMockSystem()
+	store := NewStore(sys, "/var/lib/concierge")
+
+	for range MaxHistory + 2 {
+		if err := store.AppendRun(&Run{Action: "prepare", Outcome: OutcomeSucceeded}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runs, err := store.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(runs) != MaxHistory {
+		t.Fatalf("expected history to be capped at %d runs, got %d", MaxHistory, len(runs))
+	}
+
+	if runs[0].ID != 3 || runs[len(runs)-1].ID != MaxHistory+2 {
+		t.Fatalf("expected the oldest runs to be discarded, got IDs %d to %d", runs[0].ID, runs[len(runs)-1].ID)
+	}
+
+	if _, err := store.Run(1); !errors.Is(err, ErrRunNotFound) {
+		t.Fatalf("expected ErrRunNotFound, got: %v", err)
+	}
+
+	if _, ok := sys.CreatedFiles["/var/lib/concierge/state.yaml"]; ok {
+		t.Fatal("recording history should not touch the state store")
+	}
+}
+
+func TestConfigHashIgnoresRuntimeFields(t *testing.T) {
+	conf := &config.Config{}
+	conf.Providers.LXD.Enable = true
+
+	before, err := ConfigHash(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.Status = config.Failed
+	conf.Source = "preset:dev"
+	conf.Snapshot = config.NewSnapshot()
+	conf.Snapshot.RecordGroup("lxd", false)
+
+	after, err := ConfigHash(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before != after {
+		t.Fatal("expected runtime fields not to affect the config hash")
+	}
+
+	conf.Providers.K8s.Enable = true
+	changed, err := ConfigHash(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if changed == before {
+		t.Fatal("expected config changes to affect the config hash")
+	}
+}
@@ -0,0 +1,402 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CassetteVersion is the version of the cassette format written by RecordingWorker.
+const CassetteVersion = 1
+
+// Operations recorded in a cassette, one for each Worker method that interacts with
+// the system.
+const (
+	OpRun          = "run"
+	OpReadFile     = "read-file"
+	OpWriteFile    = "write-file"
+	OpSnapInfo     = "snap-info"
+	OpSnapChannels = "snap-channels"
+	OpRename       = "rename"
+	OpPathExists   = "path-exists"
+	OpRemovePath   = "remove-path"
+	OpMkdirAll     = "mkdir-all"
+	OpChownAll     = "chown-all"
+)
+
+// Cassette is a recording of every interaction a Worker had with the system, such that
+// it can be replayed later without touching the system.
+type Cassette struct {
+	Version      int            `yaml:"version"`
+	User         CassetteUser   `yaml:"user"`
+	Interactions []*Interaction `yaml:"interactions"`
+}
+
+// CassetteUser records the real user the recorded Worker ran commands on behalf of.
+type CassetteUser struct {
+	Username string `yaml:"username"`
+	Uid      string `yaml:"uid"`
+	Gid      string `yaml:"gid"`
+	HomeDir  string `yaml:"home"`
+}
+
+// Interaction is a single recorded call to a Worker method, and its result.
+type Interaction struct {
+	// Op is the operation, such as OpRun or OpReadFile.
+	Op string `yaml:"op"`
+	// Key identifies the call within its operation: the command string for OpRun,
+	// the path for file operations, or the snap name for snap operations.
+	Key string `yaml:"key"`
+	// Output is the combined output of a command, the contents of a file read, or the
+	// contents of a file written.
+	Output string `yaml:"output,omitempty"`
+	// Exists is the result of OpPathExists.
+	Exists bool `yaml:"exists,omitempty"`
+	// SnapInfo is the result of OpSnapInfo.
+	SnapInfo *CassetteSnapInfo `yaml:"snap-info,omitempty"`
+	// Channels is the result of OpSnapChannels.
+	Channels []string `yaml:"channels,omitempty"`
+	// Error is the message of the error returned, if any.
+	Error string `yaml:"error,omitempty"`
+	// ErrorKind records the sentinel the error wrapped, if any, such that callers
+	// inspecting it with errors.Is behave the same on replay.
+	ErrorKind string `yaml:"error-kind,omitempty"`
+}
+
+// RedactedContent replaces secrets in a cassette: the output of sensitive commands, and
+// the contents of files known to hold credentials. It is a comment in both YAML and
+// TOML, so that redacted files still parse as empty documents on replay.
+const RedactedContent = "# redacted by concierge\n"
+
+// sensitivePaths are the suffixes of paths to files which hold credentials, such as
+// Juju's credentials, kubeconfigs, containerd registry configuration with registry
+// passwords, and concierge's own record of its configuration.
+var sensitivePaths = []string{
+	"/.local/share/juju/credentials.yaml",
+	"/.kube/config",
+	"/hosts.toml",
+	"/.cache/concierge/concierge.yaml",
+	"/concierge/state.yaml",
+	"/concierge/state.yaml.bak",
+}
+
+// CassetteSnapInfo is the recorded form of SnapInfo.
+type CassetteSnapInfo struct {
+	Installed       bool   `yaml:"installed"`
+	Active          bool   `yaml:"active"`
+	Classic         bool   `yaml:"classic"`
+	TrackingChannel string `yaml:"tracking-channel,omitempty"`
+	Version         string `yaml:"version,omitempty"`
+}
+
+// Error kinds recorded for errors that wrap a well-known sentinel.
+const (
+	errorKindNotExist     = "not-exist"
+	errorKindNotInstalled = "not-installed"
+)
+
+// LoadCassette reads a cassette from a file on the local filesystem.
+func LoadCassette(filePath string) (*Cassette, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+
+	cassette := &Cassette{}
+	err = yaml.Unmarshal(content, cassette)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cassette '%s': %w", filePath, err)
+	}
+
+	if cassette.Version > CassetteVersion {
+		return nil, fmt.Errorf("cassette '%s' has version %d, but only up to %d is supported", filePath, cassette.Version, CassetteVersion)
+	}
+
+	return cassette, nil
+}
+
+// Save writes the cassette to a file on the local filesystem.
+func (c *Cassette) Save(filePath string) error {
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette as yaml: %w", err)
+	}
+
+	err = os.WriteFile(filePath, content, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// recordError records an error in an interaction.
+func (i *Interaction) recordError(err error) {
+	if err == nil {
+		return
+	}
+
+	i.Error = err.Error()
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		i.ErrorKind = errorKindNotExist
+	case errors.Is(err, ErrNotInstalled):
+		i.ErrorKind = errorKindNotInstalled
+	}
+}
+
+// err reconstructs the error recorded in an interaction, if any.
+func (i *Interaction) err() error {
+	if i.Error == "" {
+		return nil
+	}
+	return &replayedError{message: i.Error, kind: i.ErrorKind}
+}
+
+// replayedError is an error reconstructed from a cassette. It matches the sentinel
+// the original error wrapped, if any.
+type replayedError struct {
+	message string
+	kind    string
+}
+
+func (e *replayedError) Error() string { return e.message }
+
+func (e *replayedError) Is(target error) bool {
+	switch e.kind {
+	case errorKindNotExist:
+		return target == os.ErrNotExist
+	case errorKindNotInstalled:
+		return target == ErrNotInstalled
+	}
+	return false
+}
+
+// NewRecordingWorker constructs a Worker that delegates to another Worker, recording
+// every interaction and its result into a cassette.
+func NewRecordingWorker(w Worker) *RecordingWorker {
+	u := w.User()
+	return &RecordingWorker{
+		worker: w,
+		recording: &recording{
+			cassette: &Cassette{
+				Version: CassetteVersion,
+				User:    CassetteUser{Username: u.Username, Uid: u.Uid, Gid: u.Gid, HomeDir: u.HomeDir},
+			},
+		},
+	}
+}
+
+// RecordingWorker is a Worker that records every interaction with the system, such
+// that it can be replayed later with ReplayWorker. Secrets are redacted as they are
+// recorded, so that cassettes can be shared and committed.
+type RecordingWorker struct {
+	worker Worker
+	*recording
+}
+
+// recording holds the state of a RecordingWorker, which is shared with the Workers it
+// returns for each step.
+type recording struct {
+	cassette *Cassette
+
+	// redactedPaths are paths to files holding credentials, beyond the well-known
+	// sensitivePaths, such as a credentials file named in the configuration.
+	redactedPaths []string
+
+	// Guards the cassette, since handlers run concurrently.
+	mu sync.Mutex
+}
+
+// Cassette returns the interactions recorded so far.
+func (r *RecordingWorker) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := *r.cassette
+	c.Interactions = append([]*Interaction{}, r.cassette.Interactions...)
+	return &c
+}
+
+// Save writes the interactions recorded so far to a cassette file.
+func (r *RecordingWorker) Save(filePath string) error {
+	return r.Cassette().Save(filePath)
+}
+
+// RedactPath marks a file as holding credentials, such that its contents are not
+// recorded.
+func (r *RecordingWorker) RedactPath(filePath string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redactedPaths = append(r.redactedPaths, filePath)
+}
+
+// redacted returns the contents of a file as they should be recorded.
+func (r *RecordingWorker) redacted(filePath string, contents []byte) string {
+	if len(contents) == 0 {
+		return ""
+	}
+
+	if sensitivePath(filePath) {
+		return RedactedContent
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.redactedPaths {
+		if strings.TrimSuffix(filePath, ".tmp") == p {
+			return RedactedContent
+		}
+	}
+	return string(contents)
+}
+
+// sensitivePath reports whether the file at the path is known to hold credentials.
+func sensitivePath(filePath string) bool {
+	// Files are often written to a temporary path alongside, then moved into place.
+	filePath = strings.TrimSuffix(filePath, ".tmp")
+
+	for _, suffix := range sensitivePaths {
+		if strings.HasSuffix(filePath, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// record appends an interaction to the cassette.
+func (r *RecordingWorker) record(i *Interaction, err error) {
+	i.recordError(err)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, i)
+}
+
+// User returns the real user of the wrapped Worker.
+func (r *RecordingWorker) User() *user.User { return r.worker.User() }
+
+// WithStep returns a RecordingWorker that records into the same cassette, with the
+// wrapped Worker reporting its work against the step.
+func (r *RecordingWorker) WithStep(step string) Worker {
+	return &RecordingWorker{worker: r.worker.WithStep(step), recording: r.recording}
+}
+
+// Run runs the command with the wrapped Worker and records its output.
+func (r *RecordingWorker) Run(c *Command) ([]byte, error) {
+	output, err := r.worker.Run(c)
+
+	recorded := string(output)
+	if c.Sensitive && len(output) > 0 {
+		recorded = RedactedContent
+	}
+
+	r.record(&Interaction{Op: OpRun, Key: c.CommandString(), Output: recorded}, err)
+	return output, err
+}
+
+// ReadFile reads the file with the wrapped Worker and records its contents.
+func (r *RecordingWorker) ReadFile(filePath string) ([]byte, error) {
+	contents, err := r.worker.ReadFile(filePath)
+	r.record(&Interaction{Op: OpReadFile, Key: filePath, Output: r.redacted(filePath, contents)}, err)
+	return contents, err
+}
+
+// WriteFile writes the file with the wrapped Worker and records its contents.
+func (r *RecordingWorker) WriteFile(filePath string, contents []byte, perm os.FileMode) error {
+	err := r.worker.WriteFile(filePath, contents, perm)
+	r.record(&Interaction{Op: OpWriteFile, Key: filePath, Output: r.redacted(filePath, contents)}, err)
+	return err
+}
+
+// SnapInfo looks up the snap with the wrapped Worker and records the result.
+func (r *RecordingWorker) SnapInfo(snap string, channel string) (*SnapInfo, error) {
+	info, err := r.worker.SnapInfo(snap, channel)
+
+	i := &Interaction{Op: OpSnapInfo, Key: snapInfoKey(snap, channel)}
+	if info != nil {
+		i.SnapInfo = &CassetteSnapInfo{
+			Installed:       info.Installed,
+			Active:          info.Active,
+			Classic:         info.Classic,
+			TrackingChannel: info.TrackingChannel,
+			Version:         info.Version,
+		}
+	}
+	r.record(i, err)
+
+	return info, err
+}
+
+// SnapChannels looks up the snap's channels with the wrapped Worker and records them.
+func (r *RecordingWorker) SnapChannels(snap string) ([]string, error) {
+	channels, err := r.worker.SnapChannels(snap)
+	r.record(&Interaction{Op: OpSnapChannels, Key: snap, Channels: channels}, err)
+	return channels, err
+}
+
+// Rename moves the file with the wrapped Worker and records the result.
+func (r *RecordingWorker) Rename(oldPath string, newPath string) error {
+	err := r.worker.Rename(oldPath, newPath)
+	r.record(&Interaction{Op: OpRename, Key: renameKey(oldPath, newPath)}, err)
+	return err
+}
+
+// Lock takes the lock with the wrapped Worker, if it supports locking. Locks do not
+// change the system, so they are not recorded.
+func (r *RecordingWorker) Lock(path string) (func(), error) {
+	locker, ok := r.worker.(Locker)
+	if !ok {
+		return func() {}, nil
+	}
+	return locker.Lock(path)
+}
+
+// PathExists checks the path with the wrapped Worker and records the result.
+func (r *RecordingWorker) PathExists(path string) bool {
+	exists := r.worker.PathExists(path)
+	r.record(&Interaction{Op: OpPathExists, Key: path, Exists: exists}, nil)
+	return exists
+}
+
+// RemovePath removes the path with the wrapped Worker and records the result.
+func (r *RecordingWorker) RemovePath(path string) error {
+	err := r.worker.RemovePath(path)
+	r.record(&Interaction{Op: OpRemovePath, Key: path}, err)
+	return err
+}
+
+// MkdirAll creates the directory with the wrapped Worker and records the result.
+func (r *RecordingWorker) MkdirAll(path string, perm os.FileMode) error {
+	err := r.worker.MkdirAll(path, perm)
+	r.record(&Interaction{Op: OpMkdirAll, Key: path}, err)
+	return err
+}
+
+// ChownAll changes ownership with the wrapped Worker and records the result.
+func (r *RecordingWorker) ChownAll(path string, user *user.User) error {
+	err := r.worker.ChownAll(path, user)
+	r.record(&Interaction{Op: OpChownAll, Key: chownKey(path, user)}, err)
+	return err
+}
+
+// snapInfoKey returns the interaction key for a snap info lookup.
+func snapInfoKey(snap, channel string) string {
+	if channel == "" {
+		return snap
+	}
+	return snap + "/" + channel
+}
+
+// renameKey returns the interaction key for a rename.
+func renameKey(oldPath, newPath string) string { return oldPath + " -> " + newPath }
+
+// chownKey returns the interaction key for an ownership change.
+func chownKey(path string, user *user.User) string {
+	return path + " " + user.Uid + ":" + user.Gid
+}
@@ -0,0 +1,148 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	mock := NewMockSystem()
+	mock.MockCommandReturn("snap list", []byte("Name  Version\n"), nil)
+	mock.MockCommandReturn("lxc version", []byte("not found"), fmt.Errorf("exit status 1"))
+	mock.MockFile("/etc/hosts", []byte("127.0.0.1 localhost\n"))
+	mock.MockSnapStoreLookup("jhack", "latest/stable", false, true)
+	mock.MockSnapChannels("jhack", []string{"latest/stable", "latest/edge"})
+	mock.MockPath("/snap/jhack")
+
+	recorder := NewRecordingWorker(mock)
+	_, _ = recorder.Run(NewCommand("snap", []string{"list"}))
+	_, _ = recorder.Run(NewCommand("lxc", []string{"version"}))
+	_, _ = recorder.ReadFile("/etc/hosts")
+	_, _ = recorder.ReadFile("/etc/missing")
+	_ = recorder.WriteFile("/etc/concierge", []byte("hello"), 0644)
+	_, _ = recorder.SnapInfo("jhack", "latest/stable")
+	_, _ = recorder.SnapChannels("jhack")
+	_ = recorder.PathExists("/snap/jhack")
+
+	cassettePath := filepath.Join(t.TempDir(), "cassette.yaml")
+	if err := recorder.Save(cassettePath); err != nil {
+		t.Fatal(err)
+	}
+
+	cassette, err := LoadCassette(cassettePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replay := NewReplayWorker(cassette)
+
+	if replay.User().Username != "test-user" {
+		t.Fatalf("expected recorded user, got: %s", replay.User().Username)
+	}
+
+	output, err := replay.Run(NewCommand("snap", []string{"list"}))
+	if err != nil || string(output) != "Name  Version\n" {
+		t.Fatalf("unexpected replayed output: %q, %v", output, err)
+	}
+
+	output, err = replay.Run(NewCommand("lxc", []string{"version"}))
+	if err == nil || err.Error() != "exit status 1" || string(output) != "not found" {
+		t.Fatalf("expected recorded failure, got: %q, %v", output, err)
+	}
+
+	contents, err := replay.ReadFile("/etc/hosts")
+	if err != nil || string(contents) != "127.0.0.1 localhost\n" {
+		t.Fatalf("unexpected replayed file contents: %q, %v", contents, err)
+	}
+
+	_, err = replay.ReadFile("/etc/missing")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected replayed error to match os.ErrNotExist, got: %v", err)
+	}
+
+	if err := replay.WriteFile("/etc/concierge", []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := replay.SnapInfo("jhack", "latest/stable")
+	if err != nil || !info.Installed || info.TrackingChannel != "latest/stable" {
+		t.Fatalf("unexpected replayed snap info: %+v, %v", info, err)
+	}
+
+	channels, err := replay.SnapChannels("jhack")
+	if err != nil || len(channels) != 2 {
+		t.Fatalf("unexpected replayed channels: %v, %v", channels, err)
+	}
+
+	if !replay.PathExists("/snap/jhack") {
+		t.Fatal("expected path to be replayed as existing")
+	}
+
+	// Results are repeated once used up, to allow for polling.
+	if !replay.PathExists("/snap/jhack") {
+		t.Fatal("expected last result to be repeated")
+	}
+
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Fatalf("expected all interactions to be replayed, got: %+v", unused)
+	}
+}
+
+func TestReplayUnexpectedCall(t *testing.T) {
+	replay := NewReplayWorker(&Cassette{Version: CassetteVersion})
+
+	_, err := replay.Run(NewCommand("snap", []string{"install", "jhack"}))
+	if err == nil {
+		t.Fatal("expected unexpected command to fail")
+	}
+
+	if replay.PathExists("/snap/jhack") {
+		t.Fatal("expected unrecorded path to be reported missing")
+	}
+
+	if len(replay.Unexpected) != 2 {
+		t.Fatalf("expected 2 unexpected calls, got: %v", replay.Unexpected)
+	}
+}
+
+func TestRecordingWorkerRedactsSecrets(t *testing.T) {
+	mock := NewMockSystem()
+	mock.MockCommandReturn("microk8s config", []byte("client-key-data: c2VjcmV0\n"), nil)
+	mock.MockCommandReturn("snap list", []byte("Name  Version\n"), nil)
+	mock.MockFile("/home/ubuntu/.local/share/juju/credentials.yaml", []byte("credentials: {secret: s3cret}\n"))
+	mock.MockFile("/home/ubuntu/gcp.json", []byte("private_key: s3cret\n"))
+
+	recorder := NewRecordingWorker(mock)
+	recorder.RedactPath("/home/ubuntu/gcp.json")
+
+	cmd := NewCommand("microk8s", []string{"config"})
+	cmd.Sensitive = true
+	output, _ := recorder.Run(cmd)
+	if string(output) != "client-key-data: c2VjcmV0\n" {
+		t.Fatalf("expected sensitive output to be returned unchanged, got: %q", output)
+	}
+
+	_, _ = recorder.Run(NewCommand("snap", []string{"list"}))
+	_, _ = recorder.ReadFile("/home/ubuntu/.local/share/juju/credentials.yaml")
+	_, _ = recorder.ReadFile("/home/ubuntu/gcp.json")
+	_ = recorder.WriteFile("/etc/containerd/hosts.d/docker.io/hosts.toml.tmp", []byte("Authorization = [\"Basic c2VjcmV0\"]\n"), 0600)
+	_ = recorder.WriteFile("/home/ubuntu/.kube/config", []byte("client-key-data: c2VjcmV0\n"), 0644)
+
+	expected := map[string]string{
+		"microk8s config": RedactedContent,
+		"snap list":       "Name  Version\n",
+		"/home/ubuntu/.local/share/juju/credentials.yaml":  RedactedContent,
+		"/home/ubuntu/gcp.json":                            RedactedContent,
+		"/etc/containerd/hosts.d/docker.io/hosts.toml.tmp": RedactedContent,
+		"/home/ubuntu/.kube/config":                        RedactedContent,
+	}
+
+	for _, i := range recorder.Cassette().Interactions {
+		if i.Output != expected[i.Key] {
+			t.Fatalf("expected %s %s to be recorded as %q, got: %q", i.Op, i.Key, expected[i.Key], i.Output)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package system
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Outcomes of a health check.
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// Check is the outcome of a health check of something concierge provisioned, such as
+// whether a provider is ready or a Juju controller responds.
+type Check struct {
+	// Component is the name of what was checked, such as "lxd" or "juju".
+	Component string `json:"component"`
+	// Name identifies the check within the component, such as "socket".
+	Name string `json:"name"`
+	// Status is one of CheckPass, CheckWarn or CheckFail.
+	Status string `json:"status"`
+	// Detail describes what was found.
+	Detail string `json:"detail"`
+	// Remedy describes how to fix a check that did not pass.
+	Remedy string `json:"remedy,omitempty"`
+}
+
+// GroupID returns the ID of the named POSIX group, according to the system's group
+// database.
+func GroupID(w Worker, group string) (string, error) {
+	cmd := NewCommand("getent", []string{"group", group})
+	cmd.ReadOnly = true
+	output, err := w.Run(cmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up group '%s': %w", group, err)
+	}
+
+	// Entries are of the form `lxd:x:999:ubuntu`.
+	fields := strings.Split(strings.TrimSpace(string(output)), ":")
+	if len(fields) < 3 || fields[2] == "" {
+		return "", fmt.Errorf("failed to look up group '%s': unexpected entry '%s'", group, output)
+	}
+	return fields[2], nil
+}
+
+// SessionGroupIDs returns the IDs of the groups held by the real user's session, being
+// those of the nearest process above concierge that acts as them, such as the shell
+// from which concierge was run with sudo. Groups the user was added to after logging in
+// are not held by their session until they log in again. If no such process is found,
+// such as when concierge was run by root directly, ok is false.
+func SessionGroupIDs(w Worker) (gids []string, ok bool) {
+	uid := w.User().Uid
+	if uid == "0" {
+		// root needs no group membership to use the providers.
+		return nil, false
+	}
+	statusPath := "/proc/self/status"
+
+	// Bound the walk, in case the process tree changes beneath it.
+	for range 32 {
+		status, err := w.ReadFile(statusPath)
+		if err != nil {
+			return nil, false
+		}
+
+		// The Uid field holds the real, effective, saved and filesystem uids. sudo
+		// keeps the real uid of the user who ran it, so the effective uid is compared.
+		fields := procStatusFields(status)
+		if ids := strings.Fields(fields["Uid"]); len(ids) > 1 && ids[1] == uid && statusPath != "/proc/self/status" {
+			return strings.Fields(fields["Groups"]), true
+		}
+
+		ppid := strings.TrimSpace(fields["PPid"])
+		if ppid == "" || ppid == "0" {
+			return nil, false
+		}
+		statusPath = fmt.Sprintf("/proc/%s/status", ppid)
+	}
+	return nil, false
+}
+
+// procStatusFields parses the `Name:\tvalue` lines of /proc/<pid>/status.
+func procStatusFields(status []byte) map[string]string {
+	fields := map[string]string{}
+	for line := range strings.SplitSeq(string(status), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if ok {
+			fields[name] = strings.TrimSpace(value)
+		}
+	}
+	return fields
+}
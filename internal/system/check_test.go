@@ -0,0 +1,49 @@
+package system
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestGroupID(t *testing.T) {
+	system := NewMockSystem()
+	system.MockCommandReturn("getent group lxd", []byte("lxd:x:999:test-user\n"), nil)
+	system.MockCommandReturn("getent group missing", []byte{}, fmt.Errorf("exit status 2"))
+
+	gid, err := GroupID(system, "lxd")
+	if err != nil || gid != "999" {
+		t.Fatalf("expected gid 999, got: %q, %v", gid, err)
+	}
+
+	if _, err := GroupID(system, "missing"); err == nil {
+		t.Fatal("expected an error for a missing group")
+	}
+}
+
+func TestSessionGroupIDs(t *testing.T) {
+	system := NewMockSystem()
+	// concierge runs as root under sudo, which was run from the test user's shell.
+	system.MockFile("/proc/self/status", []byte("Name:\tconcierge\nPPid:\t200\nUid:\t0\t0\t0\t0\nGroups:\t0\n"))
+	system.MockFile("/proc/200/status", []byte("Name:\tsudo\nPPid:\t100\nUid:\t666\t0\t0\t0\nGroups:\t0\n"))
+	system.MockFile("/proc/100/status", []byte("Name:\tbash\nPPid:\t1\nUid:\t666\t666\t666\t666\nGroups:\t4 24 666\n"))
+
+	gids, ok := SessionGroupIDs(system)
+	if !ok {
+		t.Fatal("expected to find the session of the test user")
+	}
+	if !reflect.DeepEqual(gids, []string{"4", "24", "666"}) {
+		t.Fatalf("expected the groups of the user's shell, got: %v", gids)
+	}
+}
+
+func TestSessionGroupIDsWithoutSession(t *testing.T) {
+	system := NewMockSystem()
+	// concierge runs as root, from a shell run by root.
+	system.MockFile("/proc/self/status", []byte("Name:\tconcierge\nPPid:\t100\nUid:\t0\t0\t0\t0\nGroups:\t0\n"))
+	system.MockFile("/proc/100/status", []byte("Name:\tbash\nPPid:\t0\nUid:\t0\t0\t0\t0\nGroups:\t0\n"))
+
+	if gids, ok := SessionGroupIDs(system); ok {
+		t.Fatalf("expected no session to be found, got: %v", gids)
+	}
+}
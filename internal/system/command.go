@@ -30,6 +30,13 @@ type Command struct {
 	// executable, which works both for plain shell invocations and for commands
 	// run via `sudo`.
 	Env []string
+	// Sensitive indicates that the output of the command contains secrets, such as a
+	// kubeconfig, so it is never traced, emitted as progress, nor kept in recordings of
+	// the run.
+	Sensitive bool
+	// Stdin, if set, is written to the standard input of the command. This is used to
+	// pass content to commands without writing it to a temporary file.
+	Stdin []byte
 }
 
 // NewCommand constructs a command to be run as the current user/group.
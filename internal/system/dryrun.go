@@ -1,12 +1,17 @@
 package system
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/user"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/canonical/x-go/strutil/shlex"
 )
 
 // ErrNotInstalled is returned by DryRunWorker when a read-only command's
@@ -15,18 +20,84 @@ var ErrNotInstalled = errors.New("command not installed")
 
 // DryRunWorker is a Worker implementation that outputs what would be done
 // without actually executing any commands or making any changes.
+//
+// The changes that would have been made are tracked in a simulated model of the
+// machine, such that later checks in the same run see the snaps, controllers and
+// files that earlier steps would have created.
 type DryRunWorker struct {
 	realSystem Worker
 	out        io.Writer
+	sim        *simulation
+
+	// script indicates that output is a runnable shell script, rather than a
+	// human-readable list of commands.
+	script bool
+	// offline indicates that the real machine must not be queried for snaps or
+	// the state of the tools concierge manages, such that no snapd or store access
+	// is needed. Anything not in the simulated model is assumed absent.
+	offline bool
+}
+
+// DryRunOption configures a DryRunWorker.
+type DryRunOption func(*DryRunWorker)
+
+// WithScript configures the DryRunWorker to output a bash script that reproduces the
+// plan: commands are printed exactly as they would be run, including any `sudo`
+// wrapping and environment, and files are written with heredocs. Commands are run in
+// a predictable order, so that the script can be reviewed and run as-is.
+func WithScript() DryRunOption {
+	return func(d *DryRunWorker) { d.script = true }
+}
+
+// WithOffline configures the DryRunWorker to plan as if for a fresh machine, without
+// querying snapd, the snap store, or the tools concierge manages.
+func WithOffline() DryRunOption {
+	return func(d *DryRunWorker) { d.offline = true }
+}
+
+// WithOutput configures the DryRunWorker to write its output to w, rather than stdout.
+func WithOutput(w io.Writer) DryRunOption {
+	return func(d *DryRunWorker) { d.out = w }
 }
 
 // NewDryRunWorker constructs a new DryRunWorker that wraps a real System
 // for read operations while skipping execution operations.
-func NewDryRunWorker(realSystem Worker) *DryRunWorker {
-	return &DryRunWorker{
+func NewDryRunWorker(realSystem Worker, opts ...DryRunOption) *DryRunWorker {
+	d := &DryRunWorker{
 		realSystem: realSystem,
 		out:        os.Stdout,
+		sim:        &simulation{},
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if d.script {
+		d.writeScriptHeader()
 	}
+	return d
+}
+
+// writeScriptHeader prints the preamble of a dry-run script.
+func (d *DryRunWorker) writeScriptHeader() {
+	_, _ = fmt.Fprintln(d.out, "#!/usr/bin/env bash")
+	_, _ = fmt.Fprintln(d.out, "# Generated by 'concierge --dry-run --dry-run-format script'.")
+	_, _ = fmt.Fprintln(d.out, "# Commands that only inspect the machine were checked while generating the script,")
+	_, _ = fmt.Fprintln(d.out, "# and are shown as comments; the decisions they informed are reflected below.")
+	_, _ = fmt.Fprintln(d.out, "set -euo pipefail")
+}
+
+// Sequential reports whether commands must be run in a predictable order, which is
+// the case when the output is a script.
+func (d *DryRunWorker) Sequential() bool { return d.script }
+
+// Comment prints a comment in the output, such as to mark the start of a step. In a
+// script, each section is separated by a blank line.
+func (d *DryRunWorker) Comment(text string) {
+	if d.script && strings.HasPrefix(text, "---") {
+		_, _ = fmt.Fprintln(d.out)
+	}
+	_, _ = fmt.Fprintf(d.out, "# %s\n", text)
 }
 
 // User returns the real user - delegates to real system.
@@ -34,9 +105,30 @@ func (d *DryRunWorker) User() *user.User {
 	return d.realSystem.User()
 }
 
-// runReadOnly delegates a read-only command to the real system if the binary
-// is available. If the binary is not installed, it returns ErrNotInstalled.
+// WithStep returns a DryRunWorker that shares the simulated model and output, with
+// the real system reporting its work against the step.
+func (d *DryRunWorker) WithStep(step string) Worker {
+	stepped := *d
+	stepped.realSystem = d.realSystem.WithStep(step)
+	return &stepped
+}
+
+// runReadOnly answers a read-only command from the simulated model where it can,
+// and otherwise delegates it to the real system if the binary is available. If the
+// binary is not installed, it returns ErrNotInstalled.
 func (d *DryRunWorker) runReadOnly(c *Command) ([]byte, error) {
+	if output, err, known := d.sim.probe(c); known {
+		return output, err
+	}
+
+	if c.Executable == "id" && len(c.Args) == 2 && c.Args[0] == "-nG" {
+		return d.userGroups(c)
+	}
+
+	if d.offline {
+		return nil, ErrNotInstalled
+	}
+
 	_, err := exec.LookPath(c.Executable)
 	if err != nil {
 		return nil, ErrNotInstalled
@@ -44,53 +136,175 @@ func (d *DryRunWorker) runReadOnly(c *Command) ([]byte, error) {
 	return d.realSystem.Run(c)
 }
 
+// userGroups answers `id -nG <user>` with the user's groups, adjusted for any changes
+// made to their membership during the run. Offline, the user is assumed to be a member
+// of only their own group.
+func (d *DryRunWorker) userGroups(c *Command) ([]byte, error) {
+	groups := []string{c.Args[1]}
+	if !d.offline {
+		output, err := d.realSystem.Run(c)
+		if err != nil {
+			return output, err
+		}
+		groups = strings.Fields(string(output))
+	}
+
+	return []byte(strings.Join(d.sim.userGroups(groups), " ") + "\n"), nil
+}
+
 // Run prints the command that would be executed and returns success.
 // Read-only commands are delegated to the real system for accurate results.
 // Note: Fprintln write errors are intentionally ignored throughout DryRunWorker
 // because dry-run output is best-effort and failures are not actionable.
 func (d *DryRunWorker) Run(c *Command) ([]byte, error) {
 	if c.ReadOnly {
+		if d.script {
+			_, _ = fmt.Fprintln(d.out, "# Checked:", c.CommandString())
+		}
 		return d.runReadOnly(c)
 	}
-	_, _ = fmt.Fprintln(d.out, c.CommandString())
+	if d.script && c.Stdin != nil {
+		_, _ = fmt.Fprint(d.out, feed(c.CommandString(), c.Stdin))
+	} else {
+		_, _ = fmt.Fprintln(d.out, c.CommandString())
+	}
+	d.sim.apply(c)
 	return []byte{}, nil
 }
 
-// WriteFile prints what file would be written and returns success.
+// WriteFile prints what file would be written and returns success. When the output
+// is a script, the contents are written with a heredoc.
 func (d *DryRunWorker) WriteFile(filePath string, contents []byte, perm os.FileMode) error {
-	_, _ = fmt.Fprintln(d.out, "# Write file:", filePath)
+	d.sim.writeFile(filePath, contents)
+
+	if !d.script {
+		_, _ = fmt.Fprintln(d.out, "# Write file:", filePath)
+		return nil
+	}
+
+	// Credentials are not printed, so that the script can be shared; a placeholder is
+	// written in their place for the reader to fill in.
+	if sensitivePath(filePath) && len(contents) > 0 {
+		_, _ = fmt.Fprintf(d.out, "# %s holds credentials, which are not shown; replace the placeholder below.\n", filePath)
+		contents = []byte(RedactedContent)
+	}
+
+	_, _ = fmt.Fprint(d.out, heredoc(filePath, contents))
+	_, _ = fmt.Fprintf(d.out, "chmod %04o %s\n", perm.Perm(), shlex.Quote(filePath))
 	return nil
 }
 
-// ReadFile delegates to real system for accurate conditional logic.
+// ReadFile returns files written earlier in the run from the simulated model, and
+// otherwise delegates to real system for accurate conditional logic.
 func (d *DryRunWorker) ReadFile(filePath string) ([]byte, error) {
+	if contents, err, known := d.sim.readFile(filePath); known {
+		return contents, err
+	}
 	return d.realSystem.ReadFile(filePath)
 }
 
-// SnapInfo delegates to real system for accurate conditional logic.
+// SnapInfo delegates to real system for accurate conditional logic, adjusting the
+// result for snaps installed or removed earlier in the run. Offline, snaps are assumed
+// to be strictly confined and not installed, unless installed earlier in the run.
 func (d *DryRunWorker) SnapInfo(snap string, channel string) (*SnapInfo, error) {
-	return d.realSystem.SnapInfo(snap, channel)
+	info := &SnapInfo{}
+	if !d.offline {
+		var err error
+		info, err = d.realSystem.SnapInfo(snap, channel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d.sim.snapInfo(snap, info), nil
 }
 
-// SnapChannels delegates to real system for accurate conditional logic.
+// SnapChannels delegates to real system for accurate conditional logic. Offline, the
+// channels are not available.
 func (d *DryRunWorker) SnapChannels(snap string) ([]string, error) {
+	if d.offline {
+		return nil, fmt.Errorf("cannot list channels for snap '%s' offline", snap)
+	}
 	return d.realSystem.SnapChannels(snap)
 }
 
+// Rename prints what file would be moved and returns success.
+func (d *DryRunWorker) Rename(oldPath string, newPath string) error {
+	d.printCommand("mv", oldPath, newPath)
+	d.sim.rename(oldPath, newPath)
+	return nil
+}
+
+// PathExists answers from the simulated model for paths created or removed earlier in
+// the run, and otherwise delegates to real system for accurate conditional logic.
+func (d *DryRunWorker) PathExists(path string) bool {
+	if exists, known := d.sim.pathExists(path); known {
+		return exists
+	}
+	return d.realSystem.PathExists(path)
+}
+
 // RemovePath prints what path would be removed and returns success.
 func (d *DryRunWorker) RemovePath(path string) error {
-	_, _ = fmt.Fprintln(d.out, "rm -rf", path)
+	d.printCommand("rm", "-rf", path)
+	d.sim.remove(path)
 	return nil
 }
 
 // MkdirAll prints what directory would be created and returns success.
 func (d *DryRunWorker) MkdirAll(path string, perm os.FileMode) error {
-	_, _ = fmt.Fprintln(d.out, "mkdir -p", path)
+	d.printCommand("mkdir", "-p", path)
+	d.sim.mkdirAll(path)
 	return nil
 }
 
 // ChownAll prints what ownership change would occur and returns success.
 func (d *DryRunWorker) ChownAll(path string, user *user.User) error {
-	_, _ = fmt.Fprintln(d.out, "chown -R", user.Uid+":"+user.Gid, path)
+	d.printCommand("chown", "-R", user.Uid+":"+user.Gid, path)
 	return nil
 }
+
+// printCommand prints a shell command, quoting its arguments if the output is a script.
+func (d *DryRunWorker) printCommand(args ...string) {
+	if d.script {
+		_, _ = fmt.Fprintln(d.out, shlex.Join(args))
+		return
+	}
+	_, _ = fmt.Fprintln(d.out, strings.Join(args, " "))
+}
+
+// heredocDelimiter terminates the heredocs used to write files in dry-run scripts.
+const heredocDelimiter = "CONCIERGE_EOF"
+
+// heredoc returns a shell snippet that writes the contents to the file. Text is written
+// verbatim with a quoted heredoc, such that nothing in it is expanded by the shell.
+// Contents that cannot be represented that way, such as binary data or text without a
+// trailing newline, are base64 encoded.
+func heredoc(filePath string, contents []byte) string {
+	if len(contents) == 0 {
+		return fmt.Sprintf(": > %s\n", shlex.Quote(filePath))
+	}
+
+	if verbatim(contents) {
+		return fmt.Sprintf("cat > %s <<'%s'\n%s%s\n", shlex.Quote(filePath), heredocDelimiter, contents, heredocDelimiter)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(contents)
+	return fmt.Sprintf("base64 -d > %s <<'%s'\n%s\n%s\n", shlex.Quote(filePath), heredocDelimiter, encoded, heredocDelimiter)
+}
+
+// feed returns a shell snippet that runs the command with the contents on its standard
+// input, written with a heredoc in the same way as files.
+func feed(command string, contents []byte) string {
+	if verbatim(contents) {
+		return fmt.Sprintf("%s <<'%s'\n%s%s\n", command, heredocDelimiter, contents, heredocDelimiter)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(contents)
+	return fmt.Sprintf("base64 -d <<'%s' | %s\n%s\n%s\n", heredocDelimiter, command, encoded, heredocDelimiter)
+}
+
+// verbatim reports whether the contents can be written verbatim with a quoted heredoc.
+func verbatim(contents []byte) bool {
+	text := string(contents)
+	return utf8.Valid(contents) && strings.HasSuffix(text, "\n") && !strings.Contains(text, "\n"+heredocDelimiter+"\n") && !strings.HasPrefix(text, heredocDelimiter+"\n")
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"os"
 	"os/user"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -12,6 +14,7 @@ func TestDryRunWorkerAutoPrintsCommands(t *testing.T) {
 	var buf bytes.Buffer
 	drw := &DryRunWorker{
 		realSystem: nil,
+		sim:        &simulation{},
 		out:        &buf,
 	}
 
@@ -40,6 +43,7 @@ func TestDryRunWorkerAutoPrintsFileOperations(t *testing.T) {
 
 	drw := &DryRunWorker{
 		realSystem: mock,
+		sim:        &simulation{},
 		out:        &buf,
 	}
 
@@ -93,6 +97,7 @@ func TestDryRunWorkerReadOnlyCommandsDelegateToRealSystem(t *testing.T) {
 	var buf bytes.Buffer
 	drw := &DryRunWorker{
 		realSystem: mock,
+		sim:        &simulation{},
 		out:        &buf,
 	}
 
@@ -117,6 +122,7 @@ func TestDryRunWorkerReadOnlyReturnsErrNotInstalled(t *testing.T) {
 	var buf bytes.Buffer
 	drw := &DryRunWorker{
 		realSystem: mock,
+		sim:        &simulation{},
 		out:        &buf,
 	}
 
@@ -143,6 +149,7 @@ func TestDryRunWorkerDelegatesReadOperations(t *testing.T) {
 	var buf bytes.Buffer
 	drw := &DryRunWorker{
 		realSystem: mock,
+		sim:        &simulation{},
 		out:        &buf,
 	}
 
@@ -179,3 +186,239 @@ func TestDryRunWorkerDelegatesReadOperations(t *testing.T) {
 		t.Fatalf("SnapChannels should return mock channels, got: %v", channels)
 	}
 }
+
+func TestDryRunScriptWorker(t *testing.T) {
+	var buf bytes.Buffer
+	drw := &DryRunWorker{
+		realSystem: NewMockSystem(),
+		sim:        &simulation{},
+		out:        &buf,
+		script:     true,
+	}
+
+	drw.Comment("--- snap:jhack ---")
+
+	cmd := NewCommandAs("test-user", "snap_microk8s", "juju", []string{"bootstrap", "microk8s", "--config", "a b"})
+	cmd.Env = []string{"FOO=bar"}
+	if _, err := drw.Run(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := drw.WriteFile("/etc/my file", []byte("key: $VALUE\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := drw.WriteFile("/etc/binary", []byte{0xff, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := drw.RemovePath("/tmp/a b"); err != nil {
+		t.Fatal(err)
+	}
+
+	load := NewCommand("nft", []string{"-f", "-"})
+	load.Stdin = []byte("table ip filter {}\n")
+	if _, err := drw.Run(load); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# --- snap:jhack ---
+` + cmd.CommandString() + `
+cat > '/etc/my file' <<'CONCIERGE_EOF'
+key: $VALUE
+CONCIERGE_EOF
+chmod 0600 '/etc/my file'
+base64 -d > /etc/binary <<'CONCIERGE_EOF'
+/wA=
+CONCIERGE_EOF
+chmod 0644 /etc/binary
+rm -rf '/tmp/a b'
+nft -f - <<'CONCIERGE_EOF'
+table ip filter {}
+CONCIERGE_EOF
+`
+	if buf.String() != expected {
+		t.Fatalf("unexpected script output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+
+	if !strings.Contains(cmd.CommandString(), "sudo -u test-user -g snap_microk8s") || !strings.Contains(cmd.CommandString(), "FOO=bar") {
+		t.Fatalf("expected command to carry sudo wrapping and environment, got: %s", cmd.CommandString())
+	}
+}
+
+func TestDryRunScriptWorkerHidesCredentials(t *testing.T) {
+	var buf bytes.Buffer
+	drw := &DryRunWorker{
+		realSystem: NewMockSystem(),
+		sim:        &simulation{},
+		out:        &buf,
+		script:     true,
+	}
+
+	credentials := "/home/test-user/.local/share/juju/credentials.yaml"
+	if err := drw.WriteFile(credentials, []byte("password: hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Fatalf("expected credentials to be left out of the script, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), RedactedContent) {
+		t.Fatalf("expected a placeholder in the script, got:\n%s", buf.String())
+	}
+}
+
+func TestDryRunWorkerComment(t *testing.T) {
+	var buf bytes.Buffer
+	drw := &DryRunWorker{out: &buf}
+
+	drw.Comment("--- snap:jhack ---")
+	if buf.String() != "# --- snap:jhack ---\n" {
+		t.Fatalf("expected comment in text output, got: %q", buf.String())
+	}
+}
+
+func TestGroupIsSequentialForScripts(t *testing.T) {
+	drw := &DryRunWorker{out: &bytes.Buffer{}, script: true}
+
+	g := NewGroup(drw)
+	order := []int{}
+	for i := range 5 {
+		g.Go(func() error {
+			order = append(order, i)
+			if i == 2 {
+				return errors.New("boom")
+			}
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err == nil || err.Error() != "boom" {
+		t.Fatalf("expected the first error to be returned, got: %v", err)
+	}
+
+	if !slices.Equal(order, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("expected functions to run in order, got: %v", order)
+	}
+
+	if NewGroup(&DryRunWorker{}).sequential || NewGroup(NewMockSystem()).sequential {
+		t.Fatal("expected groups to be concurrent unless writing a script")
+	}
+}
+
+func TestDryRunWorkerSimulatesStateChanges(t *testing.T) {
+	mock := NewMockSystem()
+	mock.MockCommandReturn("id -nG test-user", []byte("test-user adm\n"), nil)
+
+	drw := NewDryRunWorker(mock, WithOutput(&bytes.Buffer{}))
+
+	run := func(executable string, args ...string) {
+		t.Helper()
+		if _, err := drw.Run(NewCommand(executable, args)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	probe := func(executable string, args ...string) (string, error) {
+		cmd := NewCommand(executable, args)
+		cmd.ReadOnly = true
+		output, err := drw.Run(cmd)
+		return string(output), err
+	}
+
+	run("snap", "install", "juju", "--channel", "3.6/stable")
+
+	info, err := drw.SnapInfo("juju", "3.6/stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Installed || info.TrackingChannel != "3.6/stable" {
+		t.Fatalf("expected juju to be installed from the simulated state, got: %+v", info)
+	}
+
+	// A freshly installed Juju knows of no controllers, until one is bootstrapped.
+	if output, err := probe("juju", "show-controller", "concierge-lxd"); err == nil || !strings.Contains(output, "controller concierge-lxd not found") {
+		t.Fatalf("expected controller not to be found, got: %q, %v", output, err)
+	}
+	run("juju", "bootstrap", "localhost", "concierge-lxd", "--verbose")
+	if _, err := probe("juju", "show-controller", "concierge-lxd"); err != nil {
+		t.Fatalf("expected bootstrapped controller to be found, got: %v", err)
+	}
+	run("juju", "kill-controller", "--verbose", "--no-prompt", "concierge-lxd")
+	if _, err := probe("juju", "show-controller", "concierge-lxd"); err == nil {
+		t.Fatal("expected killed controller not to be found")
+	}
+
+	run("snap", "remove", "juju", "--purge")
+	if _, err := probe("juju", "show-controller", "concierge-lxd"); !errors.Is(err, ErrNotInstalled) {
+		t.Fatalf("expected removed juju not to be installed, got: %v", err)
+	}
+
+	run("apt-get", "-y", "install", "-o", "Dpkg::Options::=--force-confdef", "iptables")
+	if output, err := probe("which", "iptables"); err != nil || output == "" {
+		t.Fatalf("expected installed deb to be found, got: %q, %v", output, err)
+	}
+
+	run("usermod", "-a", "-G", "lxd", "test-user")
+	if output, _ := probe("id", "-nG", "test-user"); output != "test-user adm lxd\n" {
+		t.Fatalf("expected group membership to include lxd, got: %q", output)
+	}
+
+	if err := drw.WriteFile("/etc/concierge/test", []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if contents, err := drw.ReadFile("/etc/concierge/test"); err != nil || string(contents) != "hello" {
+		t.Fatalf("expected written file to be read back, got: %q, %v", contents, err)
+	}
+	if err := drw.RemovePath("/etc/concierge"); err != nil {
+		t.Fatal(err)
+	}
+	if drw.PathExists("/etc/concierge/test") {
+		t.Fatal("expected removed file not to exist")
+	}
+	if _, err := drw.ReadFile("/etc/concierge/test"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected removed file not to be found, got: %v", err)
+	}
+}
+
+func TestDryRunWorkerOffline(t *testing.T) {
+	mock := NewMockSystem()
+	mock.MockSnapStoreLookup("k8s", "1.32-classic/stable", true, true)
+	mock.MockSnapChannels("microk8s", []string{"1.31-strict/stable"})
+
+	drw := NewDryRunWorker(mock, WithOutput(&bytes.Buffer{}), WithOffline())
+
+	info, err := drw.SnapInfo("k8s", "1.32-classic/stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Installed {
+		t.Fatal("expected snaps not to be installed offline")
+	}
+
+	if _, err := drw.SnapChannels("microk8s"); err == nil {
+		t.Fatal("expected snap channels not to be available offline")
+	}
+
+	status := NewCommand("k8s", []string{"status"})
+	status.ReadOnly = true
+	if _, err := drw.Run(status); !errors.Is(err, ErrNotInstalled) {
+		t.Fatalf("expected k8s not to be installed offline, got: %v", err)
+	}
+
+	if _, err := drw.Run(NewCommand("snap", []string{"install", "k8s", "--classic"})); err != nil {
+		t.Fatal(err)
+	}
+	if output, err := drw.Run(status); err == nil || !strings.Contains(string(output), "not part of a Kubernetes cluster") {
+		t.Fatalf("expected fresh k8s not to be bootstrapped, got: %q, %v", output, err)
+	}
+
+	if _, err := drw.Run(NewCommand("k8s", []string{"bootstrap"})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := drw.Run(status); err != nil {
+		t.Fatalf("expected k8s to be bootstrapped, got: %v", err)
+	}
+
+	if len(mock.ExecutedCommands) != 0 {
+		t.Fatalf("expected no commands to reach the machine offline, got: %v", mock.ExecutedCommands)
+	}
+}
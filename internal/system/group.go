@@ -0,0 +1,48 @@
+package system
+
+import "golang.org/x/sync/errgroup"
+
+// sequencer is implemented by Workers whose output depends on the order in which
+// commands are run, such as a DryRunWorker writing a script.
+type sequencer interface {
+	Sequential() bool
+}
+
+// Group runs a set of functions concurrently, in the manner of errgroup.Group, unless
+// the Worker requires commands to be run in a predictable order, in which case each
+// function is run to completion when it is added.
+type Group struct {
+	eg         errgroup.Group
+	sequential bool
+	err        error
+}
+
+// NewGroup constructs a Group for work performed with the specified Worker.
+func NewGroup(w Worker) *Group {
+	s, ok := w.(sequencer)
+	return &Group{sequential: ok && s.Sequential()}
+}
+
+// Go runs the function, concurrently unless the group is sequential.
+func (g *Group) Go(f func() error) {
+	if !g.sequential {
+		g.eg.Go(f)
+		return
+	}
+
+	if err := f(); err != nil && g.err == nil {
+		g.err = err
+	}
+}
+
+// Wait blocks until all functions have returned, and returns the first error
+// encountered, if any.
+func (g *Group) Wait() error {
+	if !g.sequential {
+		return g.eg.Wait()
+	}
+
+	err := g.err
+	g.err = nil
+	return err
+}
@@ -10,6 +10,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/canonical/concierge/internal/progress"
 	"github.com/sethvargo/go-retry"
 )
 
@@ -40,9 +41,8 @@ func RunExclusive(w Worker, c *Command) ([]byte, error) {
 func RunWithRetries(w Worker, c *Command, maxDuration time.Duration) ([]byte, error) {
 	backoff := retry.NewExponential(1 * time.Second)
 	backoff = retry.WithMaxDuration(maxDuration, backoff)
-	ctx := context.Background()
 
-	return retry.DoValue(ctx, backoff, func(ctx context.Context) ([]byte, error) {
+	return retryWithEvents(Context(w), backoff, stepOf(w), c.CommandString(), func(ctx context.Context, attempt int) ([]byte, error) {
 		output, err := w.Run(c)
 		if err != nil {
 			if errors.Is(err, ErrNotInstalled) {
@@ -55,6 +55,59 @@ func RunWithRetries(w Worker, c *Command, maxDuration time.Duration) ([]byte, er
 	})
 }
 
+// retryWithEvents calls f, numbering each attempt from 1, until it succeeds, returns an
+// error not marked with retry.RetryableError, the backoff gives up, or ctx is done. A
+// retry event is emitted for each failed attempt that is to be retried, against the
+// step, with the operation describing f; the final attempt is not reported as a retry.
+func retryWithEvents[T any](ctx context.Context, backoff retry.Backoff, step string, operation string, f func(ctx context.Context, attempt int) (T, error)) (T, error) {
+	attempt := 0
+	var lastErr error
+
+	// go-retry only consults the backoff after an attempt fails with a retryable error,
+	// and stops without waiting if the backoff gives up.
+	reporting := retry.BackoffFunc(func() (time.Duration, bool) {
+		next, stop := backoff.Next()
+		if !stop {
+			progress.Retry(step, operation, attempt, errors.Unwrap(lastErr))
+		}
+		return next, stop
+	})
+
+	return retry.DoValue(ctx, reporting, func(ctx context.Context) (T, error) {
+		attempt++
+		v, err := f(ctx, attempt)
+		lastErr = err
+		return v, err
+	})
+}
+
+// Context returns the context that stops the Worker's commands and retries once done,
+// such that loops retrying work through the Worker can stop with it.
+func Context(w Worker) context.Context {
+	switch w := w.(type) {
+	case *System:
+		return w.context()
+	case *DryRunWorker:
+		return Context(w.realSystem)
+	case *RecordingWorker:
+		return Context(w.worker)
+	}
+	return context.Background()
+}
+
+// stepOf returns the step that the Worker reports its work against, if any.
+func stepOf(w Worker) string {
+	switch w := w.(type) {
+	case *System:
+		return w.step
+	case *DryRunWorker:
+		return stepOf(w.realSystem)
+	case *RecordingWorker:
+		return stepOf(w.worker)
+	}
+	return ""
+}
+
 // RunMany takes multiple commands and runs them in sequence via the Worker,
 // returning an error on the first error encountered.
 func RunMany(w Worker, commands ...*Command) error {
@@ -67,6 +120,26 @@ func RunMany(w Worker, commands ...*Command) error {
 	return nil
 }
 
+// WriteFileAtomic writes contents to the specified path such that readers never see
+// a partially written file. The contents are written to a temporary file alongside
+// the destination and flushed to disk, then renamed over it.
+func WriteFileAtomic(w Worker, filePath string, contents []byte, perm os.FileMode) error {
+	tmpPath := filePath + ".tmp"
+
+	err := w.WriteFile(tmpPath, contents, perm)
+	if err != nil {
+		return fmt.Errorf("failed to write file '%s': %w", tmpPath, err)
+	}
+
+	err = w.Rename(tmpPath, filePath)
+	if err != nil {
+		_ = w.RemovePath(tmpPath) // Best-effort cleanup; the rename error is more useful
+		return fmt.Errorf("failed to move '%s' into place: %w", filePath, err)
+	}
+
+	return nil
+}
+
 // ReadHomeDirFile reads a file at a path relative to the real user's home directory.
 func ReadHomeDirFile(w Worker, filePath string) ([]byte, error) {
 	homePath := path.Join(w.User().HomeDir, filePath)
@@ -76,11 +149,12 @@ func ReadHomeDirFile(w Worker, filePath string) ([]byte, error) {
 // WriteHomeDirFile writes contents to a path relative to the real user's home directory,
 // creating parent directories and adjusting ownership as needed.
 func WriteHomeDirFile(w Worker, filePath string, contents []byte) error {
-	dir := path.Dir(filePath)
-
-	err := MkHomeSubdirectory(w, dir)
-	if err != nil {
-		return err
+	// Files directly in the home directory need no directory created, and must not
+	// change the ownership of everything in the home directory.
+	if dir := path.Dir(filePath); dir != "." {
+		if err := MkHomeSubdirectory(w, dir); err != nil {
+			return err
+		}
 	}
 
 	absPath := path.Join(w.User().HomeDir, filePath)
@@ -89,8 +163,7 @@ func WriteHomeDirFile(w Worker, filePath string, contents []byte) error {
 		return fmt.Errorf("failed to write file '%s': %w", absPath, err)
 	}
 
-	err = w.ChownAll(absPath, w.User())
-	if err != nil {
+	if err := w.ChownAll(absPath, w.User()); err != nil {
 		return fmt.Errorf("failed to change ownership of file '%s': %w", absPath, err)
 	}
 
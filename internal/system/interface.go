@@ -21,10 +21,27 @@ type Worker interface {
 	SnapInfo(snap string, channel string) (*SnapInfo, error)
 	// SnapChannels returns the list of channels available for a given snap.
 	SnapChannels(snap string) ([]string, error)
+	// Rename moves a file from one path to another, replacing any existing file.
+	Rename(oldPath string, newPath string) error
+	// PathExists reports whether a path exists on the filesystem.
+	PathExists(path string) bool
 	// RemovePath recursively removes a path from the filesystem.
 	RemovePath(path string) error
 	// MkdirAll creates a directory and all parent directories with the specified permissions.
 	MkdirAll(path string, perm os.FileMode) error
 	// ChownAll recursively changes the ownership of a path to the specified user.
 	ChownAll(path string, user *user.User) error
+	// WithStep returns a Worker that does the same work as part of the specified step,
+	// such as `snap:jhack`, so that the commands it runs and the retries it makes are
+	// reported against that step.
+	WithStep(step string) Worker
+}
+
+// Locker is implemented by Workers that can take a lock shared with other processes on
+// the machine, such that concurrent runs of concierge do not interleave updates to the
+// same file.
+type Locker interface {
+	// Lock blocks until it holds an exclusive lock on the lock file at the specified
+	// path, creating it if needed. The returned function releases the lock.
+	Lock(path string) (unlock func(), err error)
 }
@@ -0,0 +1,67 @@
+package system
+
+import (
+	"slices"
+	"strings"
+)
+
+// Kinds of state that concierge changes during 'prepare', which may be left behind
+// on the machine after 'restore'.
+const (
+	LeftoverSnap     = "snap"
+	LeftoverDeb      = "deb"
+	LeftoverPath     = "path"
+	LeftoverNetwork  = "network"
+	LeftoverGroup    = "group"
+	LeftoverFirewall = "firewall"
+)
+
+// Leftover describes a piece of state that concierge created or changed during
+// 'prepare', and which still exists on the machine.
+type Leftover struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// PathLeftovers returns a Leftover of the given kind for each of the paths that
+// still exist on the system.
+func PathLeftovers(w Worker, kind string, paths ...string) []Leftover {
+	leftovers := []Leftover{}
+	for _, p := range paths {
+		if w.PathExists(p) {
+			leftovers = append(leftovers, Leftover{Kind: kind, Name: p})
+		}
+	}
+	return leftovers
+}
+
+// UserGroups returns the names of the POSIX groups the specified user is a member of,
+// according to the system's group database.
+func UserGroups(w Worker, username string) ([]string, error) {
+	cmd := NewCommand("id", []string{"-nG", username})
+	cmd.ReadOnly = true
+	output, err := w.Run(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// GroupLeftovers returns a Leftover for each of the specified groups that the user
+// is still a member of.
+func GroupLeftovers(w Worker, username string, groups ...string) []Leftover {
+	leftovers := []Leftover{}
+
+	memberOf, err := UserGroups(w, username)
+	if err != nil {
+		return leftovers
+	}
+
+	for _, g := range groups {
+		if g != "" && slices.Contains(memberOf, g) {
+			leftovers = append(leftovers, Leftover{Kind: LeftoverGroup, Name: g, Detail: "user '" + username + "' is a member"})
+		}
+	}
+	return leftovers
+}
@@ -5,12 +5,16 @@ import (
 	"os"
 	"os/user"
 	"sync"
+	"time"
+
+	"github.com/canonical/concierge/internal/snapd"
 )
 
 // NewMockSystem constructs a new mock command
 func NewMockSystem() *MockSystem {
 	return &MockSystem{
 		CreatedFiles:     map[string]string{},
+		CommandInputs:    map[string]string{},
 		mockReturns:      map[string]MockCommandReturn{},
 		mockFiles:        map[string][]byte{},
 		mockSnapInfo:     map[string]*SnapInfo{},
@@ -28,6 +32,7 @@ type MockCommandReturn struct {
 // MockSystem represents a struct that can emulate running commands.
 type MockSystem struct {
 	ExecutedCommands   []string
+	CommandInputs      map[string]string
 	CreatedFiles       map[string]string
 	CreatedDirectories []string
 	Deleted            []string
@@ -39,6 +44,9 @@ type MockSystem struct {
 	mockSnapChannels map[string][]string
 	mockPaths        map[string]bool
 
+	// If set, snap lookups are served by this System's snapd client.
+	snapd *System
+
 	// Used to guard access to the ExecutedCommands list
 	cmdMutex sync.Mutex
 }
@@ -69,6 +77,31 @@ func (r *MockSystem) MockSnapStoreLookup(name, channel string, classic, installe
 	return &Snap{Name: name, Channel: channel}
 }
 
+// MockSnapVersion marks a snap as installed at the specified version.
+func (r *MockSystem) MockSnapVersion(name, version string) {
+	info, ok := r.mockSnapInfo[name]
+	if !ok {
+		info = &SnapInfo{Installed: true, Active: true}
+		r.mockSnapInfo[name] = info
+	}
+	info.Version = version
+}
+
+// MockSnapd serves snap lookups from the snapd API at the specified socket, such as
+// a fake from package snapdtest, rather than from mocked snap details. Commands are
+// still mocked.
+func (r *MockSystem) MockSnapd(socket string) {
+	r.snapd = &System{
+		snapd:        snapd.NewClient(&snapd.Config{Socket: socket}),
+		retryBackoff: time.Millisecond,
+	}
+}
+
+// MockPath marks a path as existing on the mocked filesystem.
+func (r *MockSystem) MockPath(path string) {
+	r.mockPaths[path] = true
+}
+
 // MockSnapChannels mocks the set of available channels for a snap in the store.
 func (r *MockSystem) MockSnapChannels(snap string, channels []string) {
 	r.mockSnapChannels[snap] = channels
@@ -84,6 +117,9 @@ func (r *MockSystem) User() *user.User {
 	}
 }
 
+// WithStep returns the MockSystem itself, since it reports no progress.
+func (r *MockSystem) WithStep(step string) Worker { return r }
+
 // Run executes the command, returning the stdout/stderr where appropriate.
 func (r *MockSystem) Run(c *Command) ([]byte, error) {
 	r.cmdMutex.Lock()
@@ -97,6 +133,9 @@ func (r *MockSystem) Run(c *Command) ([]byte, error) {
 	cmd := c.CommandString()
 
 	r.ExecutedCommands = append(r.ExecutedCommands, cmd)
+	if c.Stdin != nil {
+		r.CommandInputs[cmd] = string(c.Stdin)
+	}
 	r.cmdMutex.Unlock()
 
 	val, ok := r.mockReturns[cmd]
@@ -108,9 +147,13 @@ func (r *MockSystem) Run(c *Command) ([]byte, error) {
 
 // ReadFile takes a path and reads the content from the specified file.
 func (r *MockSystem) ReadFile(filePath string) ([]byte, error) {
+	if contents, ok := r.CreatedFiles[filePath]; ok {
+		return []byte(contents), nil
+	}
+
 	val, ok := r.mockFiles[filePath]
 	if !ok {
-		return nil, fmt.Errorf("file not found")
+		return nil, fmt.Errorf("file '%s' not found: %w", filePath, os.ErrNotExist)
 	}
 	return val, nil
 }
@@ -124,6 +167,10 @@ func (r *MockSystem) WriteFile(filePath string, contents []byte, perm os.FileMod
 // SnapInfo returns information about a given snap, looking up details in the snap
 // store using the snapd client API where necessary.
 func (r *MockSystem) SnapInfo(snap string, channel string) (*SnapInfo, error) {
+	if r.snapd != nil {
+		return r.snapd.SnapInfo(snap, channel)
+	}
+
 	snapInfo, ok := r.mockSnapInfo[snap]
 	if ok {
 		return snapInfo, nil
@@ -137,6 +184,10 @@ func (r *MockSystem) SnapInfo(snap string, channel string) (*SnapInfo, error) {
 
 // SnapChannels returns the list of channels available for a given snap.
 func (r *MockSystem) SnapChannels(snap string) ([]string, error) {
+	if r.snapd != nil {
+		return r.snapd.SnapChannels(snap)
+	}
+
 	val, ok := r.mockSnapChannels[snap]
 	if ok {
 		return val, nil
@@ -145,6 +196,33 @@ func (r *MockSystem) SnapChannels(snap string) ([]string, error) {
 	return nil, fmt.Errorf("channels for snap '%s' not found", snap)
 }
 
+// Rename moves a file on the mocked filesystem, whether it was mocked or created.
+func (r *MockSystem) Rename(oldPath string, newPath string) error {
+	if contents, ok := r.CreatedFiles[oldPath]; ok {
+		r.CreatedFiles[newPath] = contents
+		delete(r.CreatedFiles, oldPath)
+		return nil
+	}
+	if contents, ok := r.mockFiles[oldPath]; ok {
+		r.mockFiles[newPath] = contents
+		delete(r.mockFiles, oldPath)
+		return nil
+	}
+	return fmt.Errorf("file '%s' not found: %w", oldPath, os.ErrNotExist)
+}
+
+// PathExists reports whether a path exists on the mocked filesystem, either because
+// it was mocked, or because it was created during the test.
+func (r *MockSystem) PathExists(path string) bool {
+	if _, ok := r.CreatedFiles[path]; ok {
+		return true
+	}
+	if _, ok := r.mockFiles[path]; ok {
+		return true
+	}
+	return r.mockPaths[path]
+}
+
 // RemovePath recursively removes a path from the filesystem (mocked).
 func (r *MockSystem) RemovePath(path string) error {
 	r.RemovedPaths = append(r.RemovedPaths, path)
@@ -0,0 +1,222 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"sync"
+)
+
+// NewReplayWorker constructs a Worker that serves the results recorded in a cassette,
+// rather than interacting with the system.
+func NewReplayWorker(cassette *Cassette) *ReplayWorker {
+	r := &ReplayWorker{
+		user: &user.User{
+			Username: cassette.User.Username,
+			Uid:      cassette.User.Uid,
+			Gid:      cassette.User.Gid,
+			HomeDir:  cassette.User.HomeDir,
+		},
+		queues:       map[string][]*Interaction{},
+		last:         map[string]*Interaction{},
+		WrittenFiles: map[string]string{},
+	}
+
+	for _, i := range cassette.Interactions {
+		k := replayKey(i.Op, i.Key)
+		r.queues[k] = append(r.queues[k], i)
+	}
+
+	return r
+}
+
+// ReplayWorker is a Worker that replays a cassette recorded with RecordingWorker.
+//
+// Since concierge installs packages and providers concurrently, the order of calls
+// differs between runs. Interactions are therefore matched by their operation and
+// key, such as the command string, and served in the order recorded for that key.
+// Once the recorded results for a key are used up, the last is repeated, which allows
+// for polling loops that run a different number of times. A call with no recorded
+// result at all is unexpected: it fails, and is listed in Unexpected.
+type ReplayWorker struct {
+	// ExecutedCommands lists the commands run, in the order they were run.
+	ExecutedCommands []string
+	// WrittenFiles maps the path of each file written to its contents.
+	WrittenFiles map[string]string
+	// Unexpected lists the calls for which nothing was recorded.
+	Unexpected []string
+
+	user   *user.User
+	queues map[string][]*Interaction
+	last   map[string]*Interaction
+
+	// Guards all of the above, since handlers run concurrently.
+	mu sync.Mutex
+}
+
+// next returns the next recorded result for the specified call.
+func (r *ReplayWorker) next(op, key string) (*Interaction, error) {
+	k := replayKey(op, key)
+
+	queue := r.queues[k]
+	if len(queue) > 0 {
+		r.queues[k] = queue[1:]
+		r.last[k] = queue[0]
+		return queue[0], nil
+	}
+
+	if i, ok := r.last[k]; ok {
+		return i, nil
+	}
+
+	err := fmt.Errorf("unexpected %s in replay: %s", op, key)
+	r.Unexpected = append(r.Unexpected, err.Error())
+	return nil, err
+}
+
+// Unused returns the recorded interactions that were never replayed, which indicates
+// that the code under test no longer does something it used to.
+func (r *ReplayWorker) Unused() []*Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	unused := []*Interaction{}
+	for _, queue := range r.queues {
+		unused = append(unused, queue...)
+	}
+	return unused
+}
+
+// User returns the real user recorded in the cassette.
+func (r *ReplayWorker) User() *user.User { return r.user }
+
+// WithStep returns the ReplayWorker itself, since it reports no progress.
+func (r *ReplayWorker) WithStep(step string) Worker { return r }
+
+// Run returns the recorded output of the command.
+func (r *ReplayWorker) Run(c *Command) ([]byte, error) {
+	cmd := c.CommandString()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ExecutedCommands = append(r.ExecutedCommands, cmd)
+
+	i, err := r.next(OpRun, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(i.Output), i.err()
+}
+
+// ReadFile returns the recorded contents of the file.
+func (r *ReplayWorker) ReadFile(filePath string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.next(OpReadFile, filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.err(); err != nil {
+		return nil, err
+	}
+	return []byte(i.Output), nil
+}
+
+// WriteFile records the contents written, and returns the recorded result.
+func (r *ReplayWorker) WriteFile(filePath string, contents []byte, perm os.FileMode) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.WrittenFiles[filePath] = string(contents)
+
+	i, err := r.next(OpWriteFile, filePath)
+	if err != nil {
+		return err
+	}
+	return i.err()
+}
+
+// SnapInfo returns the recorded information about the snap.
+func (r *ReplayWorker) SnapInfo(snap string, channel string) (*SnapInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.next(OpSnapInfo, snapInfoKey(snap, channel))
+	if err != nil {
+		return nil, err
+	}
+	if i.SnapInfo == nil {
+		return nil, i.err()
+	}
+
+	return &SnapInfo{
+		Installed:       i.SnapInfo.Installed,
+		Active:          i.SnapInfo.Active,
+		Classic:         i.SnapInfo.Classic,
+		TrackingChannel: i.SnapInfo.TrackingChannel,
+		Version:         i.SnapInfo.Version,
+	}, i.err()
+}
+
+// SnapChannels returns the recorded channels of the snap.
+func (r *ReplayWorker) SnapChannels(snap string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.next(OpSnapChannels, snap)
+	if err != nil {
+		return nil, err
+	}
+	return i.Channels, i.err()
+}
+
+// Rename returns the recorded result of moving the file.
+func (r *ReplayWorker) Rename(oldPath string, newPath string) error {
+	return r.replayResult(OpRename, renameKey(oldPath, newPath))
+}
+
+// PathExists returns whether the path was recorded as existing. Since PathExists
+// cannot fail, a path that was not checked during recording is reported as missing,
+// and listed in Unexpected.
+func (r *ReplayWorker) PathExists(path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.next(OpPathExists, path)
+	if err != nil {
+		return false
+	}
+	return i.Exists
+}
+
+// RemovePath returns the recorded result of removing the path.
+func (r *ReplayWorker) RemovePath(path string) error {
+	return r.replayResult(OpRemovePath, path)
+}
+
+// MkdirAll returns the recorded result of creating the directory.
+func (r *ReplayWorker) MkdirAll(path string, perm os.FileMode) error {
+	return r.replayResult(OpMkdirAll, path)
+}
+
+// ChownAll returns the recorded result of changing ownership.
+func (r *ReplayWorker) ChownAll(path string, user *user.User) error {
+	return r.replayResult(OpChownAll, chownKey(path, user))
+}
+
+// replayResult returns the recorded error, if any, for an operation with no output.
+func (r *ReplayWorker) replayResult(op, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i, err := r.next(op, key)
+	if err != nil {
+		return err
+	}
+	return i.err()
+}
+
+// replayKey returns the key under which interactions are matched.
+func replayKey(op, key string) string { return op + "\x00" + key }
@@ -1,9 +1,11 @@
 package system
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -11,36 +13,125 @@ import (
 	"os/user"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/canonical/concierge/internal/progress"
 	"github.com/canonical/concierge/internal/securitylog"
 	"github.com/canonical/concierge/internal/snapd"
+	"github.com/canonical/concierge/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sys/unix"
 )
 
+// Option configures a System.
+type Option func(*systemOptions)
+
+// systemOptions holds the settings applied by each Option.
+type systemOptions struct {
+	snapdSocket string
+	traceOutput io.Writer
+	ctx         context.Context
+	user        *user.User
+}
+
+// WithSnapdSocket configures the System to talk to snapd over the specified socket,
+// rather than the default `/run/snapd.socket`.
+func WithSnapdSocket(socket string) Option {
+	return func(o *systemOptions) { o.snapdSocket = socket }
+}
+
+// WithTraceOutput configures the System to write command traces to w, rather than
+// to stdout, which is reserved for the event stream when concierge emits JSON.
+func WithTraceOutput(w io.Writer) Option {
+	return func(o *systemOptions) { o.traceOutput = w }
+}
+
+// WithContext configures the System to stop the commands it runs, and the retries it
+// makes, once ctx is done.
+func WithContext(ctx context.Context) Option {
+	return func(o *systemOptions) { o.ctx = ctx }
+}
+
+// WithUser configures the System to execute commands on behalf of u, rather than the
+// user that ran concierge with `sudo`.
+func WithUser(u *user.User) Option {
+	return func(o *systemOptions) { o.user = u }
+}
+
 // NewSystem constructs a new command system.
-func NewSystem(trace bool) (*System, error) {
-	realUser, err := realUser()
-	if err != nil {
-		return nil, fmt.Errorf("failed to lookup effective user details: %w", err)
+func NewSystem(trace bool, opts ...Option) (*System, error) {
+	options := &systemOptions{traceOutput: os.Stdout, ctx: context.Background()}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	u := options.user
+	if u == nil {
+		var err error
+		u, err = realUser()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lookup effective user details: %w", err)
+		}
 	}
 	return &System{
-		trace: trace,
-		user:  realUser,
-		snapd: snapd.NewClient(nil),
+		trace:        trace,
+		traceOutput:  options.traceOutput,
+		user:         u,
+		snapd:        newSnapdClient(options.snapdSocket),
+		retryBackoff: 1 * time.Second,
+		ctx:          options.ctx,
 	}, nil
 }
 
+// newSnapdClient constructs a client for snapd listening on the socket, or on the
+// default socket if empty, that records a span for each request while tracing.
+func newSnapdClient(socket string) *snapd.Client {
+	if socket == "" {
+		socket = snapd.DefaultSocket
+	}
+	return snapd.NewClient(&snapd.Config{
+		Socket:    socket,
+		Transport: telemetry.Transport("snapd", snapd.SocketTransport(socket)),
+	})
+}
+
 // System represents a struct that can run commands.
 type System struct {
-	trace bool
-	user  *user.User
-	snapd *snapd.Client
+	trace       bool
+	traceOutput io.Writer
+	user        *user.User
+	snapd       *snapd.Client
+
+	// retryBackoff is the initial delay between retries of snapd API requests.
+	retryBackoff time.Duration
+
+	// step is the step of the run that commands are reported against, if any.
+	step string
+
+	// ctx, if set, stops commands that are running and retries once done.
+	ctx context.Context
 }
 
 // User returns a user struct containing details of the "real" user, which
 // may differ from the current user when concierge is executed with `sudo`.
 func (s *System) User() *user.User { return s.user }
 
+// WithStep returns a copy of the System that reports its work against the step.
+func (s *System) WithStep(step string) Worker {
+	stepped := *s
+	stepped.step = step
+	return &stepped
+}
+
+// context returns the context that stops the System's commands and retries.
+func (s *System) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
 // Run executes the command, returning the stdout/stderr where appropriate.
 func (s *System) Run(c *Command) ([]byte, error) {
 	return s.runOnce(c)
@@ -62,20 +153,45 @@ func (s *System) runOnce(c *Command) ([]byte, error) {
 	}
 
 	commandString := c.CommandString()
-	cmd := exec.CommandContext(context.Background(), shell, "-c", commandString) //nolint:gosec // G204: concierge is a CLI tool designed to execute user-provided commands
+	cmd := exec.CommandContext(s.context(), shell, "-c", commandString) //nolint:gosec // G204: concierge is a CLI tool designed to execute user-provided commands
 
 	logger.Debug("Starting command", "command", commandString)
 
+	// Output is collected as it is written, so that progress can be shown while a
+	// long-running command, such as a bootstrap, is still going.
+	// The output of sensitive commands is never emitted, so that it cannot reach the
+	// logs, log groups or anything else observing the run.
+	out := &outputWriter{step: s.step, command: commandString, lines: progress.WantsOutput() && !c.Sensitive}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if c.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(c.Stdin)
+	}
+
+	end := telemetry.StartCommand(s.step, c.Executable, commandString,
+		attribute.String("concierge.command.user", c.User),
+		attribute.String("concierge.command.group", c.Group),
+		attribute.Bool("concierge.command.read_only", c.ReadOnly),
+	)
+
 	start := time.Now()
-	output, err := cmd.CombinedOutput()
+	err = cmd.Run()
+	end(err)
+	output := out.buf.Bytes()
 
 	elapsed := time.Since(start)
 	logger.Debug("Finished command", "command", commandString, "elapsed", elapsed)
 
 	if s.trace || (err != nil && !c.IsExpectedError(output)) {
-		fmt.Print(generateTraceMessage(commandString, output))
+		traced := output
+		if c.Sensitive && len(output) > 0 {
+			traced = []byte(RedactedContent)
+		}
+		fmt.Fprint(s.traceOutput, generateTraceMessage(commandString, traced))
 	}
 
+	progress.Command(s.step, commandString, elapsed, err)
+
 	s.logPrivilegedCommand(c, commandString, output, err, elapsed)
 
 	return output, err
@@ -115,8 +231,20 @@ func (s *System) ReadFile(filePath string) ([]byte, error) {
 }
 
 // WriteFile writes the given contents to the specified file path with the given permissions.
+// The contents are flushed to disk before returning, such that a file which is then
+// renamed into place is never found empty after a crash.
 func (s *System) WriteFile(filePath string, contents []byte, perm os.FileMode) error {
-	return os.WriteFile(filePath, contents, perm)
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(contents)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	return errors.Join(err, f.Close())
 }
 
 // ChownAll recursively changes the ownership of a path to the specified user.
@@ -159,6 +287,50 @@ func (s *System) ChownAll(path string, user *user.User) error {
 	return err
 }
 
+// Rename moves a file from one path to another, replacing any existing file. The
+// directory holding the new path is flushed to disk, such that the rename survives
+// a crash.
+func (s *System) Rename(oldPath string, newPath string) error {
+	err := os.Rename(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(newPath))
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(dir.Sync(), dir.Close())
+}
+
+// Lock blocks until it holds an exclusive lock on the lock file at the specified path,
+// creating it if needed. The lock is released when the returned function is called, or
+// when the process exits.
+func (s *System) Lock(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		_ = f.Close()
+	}, nil
+}
+
+// PathExists reports whether a path exists on the filesystem.
+func (s *System) PathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 // RemovePath recursively removes a path from the filesystem.
 func (s *System) RemovePath(path string) error {
 	return os.RemoveAll(path)
@@ -168,3 +340,36 @@ func (s *System) RemovePath(path string) error {
 func (s *System) MkdirAll(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
+
+// outputWriter collects the combined output of a command and, if lines is set, emits
+// each line as a progress event as soon as it is complete. Carriage returns, as used by
+// progress bars, also complete a line.
+type outputWriter struct {
+	step    string
+	command string
+	lines   bool
+	buf     bytes.Buffer
+	partial []byte
+}
+
+// Write records p, emitting any lines it completes.
+func (w *outputWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	if !w.lines {
+		return len(p), nil
+	}
+	w.partial = append(w.partial, p...)
+
+	for {
+		i := bytes.IndexAny(w.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(w.partial[:i])); line != "" {
+			progress.Output(w.step, w.command, line)
+		}
+		w.partial = w.partial[i+1:]
+	}
+
+	return len(p), nil
+}
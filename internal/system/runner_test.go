@@ -0,0 +1,130 @@
+package system
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+func TestSystemReportsCommandsAgainstStep(t *testing.T) {
+	s := &System{}
+
+	events := []progress.Event{}
+	unsubscribe := progress.SubscribeWithOutput(func(e progress.Event) {
+		if e.Type == progress.EventCommand || e.Type == progress.EventOutput {
+			events = append(events, e)
+		}
+	})
+	defer unsubscribe()
+
+	cmd := NewCommand("echo", []string{"installed"})
+	cmd.ReadOnly = true
+	if _, err := s.WithStep("snap:jhack").Run(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected an output and a command event, got: %+v", events)
+	}
+	for _, e := range events {
+		if e.Step != "snap:jhack" {
+			t.Fatalf("expected the event to carry the step, got: %+v", e)
+		}
+	}
+
+	if s.step != "" {
+		t.Fatal("expected the original System to be left without a step")
+	}
+}
+
+func TestSystemNeverShowsSensitiveOutput(t *testing.T) {
+	trace := &strings.Builder{}
+	s := &System{trace: true, traceOutput: trace}
+
+	events := []progress.Event{}
+	unsubscribe := progress.SubscribeWithOutput(func(e progress.Event) {
+		if e.Type == progress.EventOutput {
+			events = append(events, e)
+		}
+	})
+	defer unsubscribe()
+
+	cmd := NewCommand("printf", []string{"%s%s", "client-key-data: sec", "ret"})
+	cmd.ReadOnly = true
+	cmd.Sensitive = true
+	output, err := s.WithStep("provider:k8s").Run(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(output), "secret") {
+		t.Fatalf("expected the output to be returned to the caller, got: %q", output)
+	}
+	if len(events) != 0 {
+		t.Fatalf("expected no output events, got: %+v", events)
+	}
+	if strings.Contains(trace.String(), "secret") {
+		t.Fatalf("expected the trace to be redacted, got: %s", trace)
+	}
+}
+
+func TestSystemStopsCommandsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &System{ctx: ctx, traceOutput: io.Discard}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := RunWithRetries(s, NewCommand("sleep", []string{"10"}), time.Minute)
+	if err == nil {
+		t.Fatal("expected the command to be stopped")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the command and its retries to stop with the context, took %s", elapsed)
+	}
+}
+
+func TestSystemLock(t *testing.T) {
+	s := &System{}
+	lockPath := filepath.Join(t.TempDir(), "state.lock")
+
+	unlock, err := s.Lock(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second lock on the same file, as taken by another process, must wait for the
+	// first to be released.
+	acquired := make(chan func())
+	go func() {
+		unlockSecond, err := s.Lock(lockPath)
+		if err != nil {
+			t.Error(err)
+			close(acquired)
+			return
+		}
+		acquired <- unlockSecond
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("expected the second lock to wait for the first to be released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+	case unlockSecond, ok := <-acquired:
+		if ok {
+			unlockSecond()
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the second lock to be taken once the first was released")
+	}
+}
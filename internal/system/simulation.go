@@ -0,0 +1,402 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// errSimulated is returned for read-only commands that the simulated machine answers
+// with a failure, in the same way a real command would exit non-zero.
+var errSimulated = errors.New("exit status 1")
+
+// simulation models the changes a DryRunWorker would have made to the machine, so that
+// later checks in the same run see the machine as it would be, rather than as it is.
+// Anything the model knows nothing about is left to the real machine, or, in offline
+// mode, assumed absent.
+type simulation struct {
+	mu sync.Mutex
+
+	// snaps holds the snaps installed or removed during the run. A nil entry marks a
+	// removed snap.
+	snaps map[string]*SnapInfo
+	// fresh holds the snaps that were newly installed, rather than refreshed, during
+	// the run, and so cannot have any prior state.
+	fresh map[string]bool
+	// debs holds the debs installed (true) or removed (false) during the run.
+	debs map[string]bool
+	// controllers holds the Juju controllers bootstrapped (true) or destroyed (false).
+	controllers map[string]bool
+	// k8sBootstrapped is set once the k8s snap's cluster has been bootstrapped.
+	k8sBootstrapped bool
+	// files holds the contents of files written during the run, and dirs the
+	// directories created. removed holds paths that were removed.
+	files   map[string][]byte
+	dirs    map[string]bool
+	removed map[string]bool
+	// groups holds the groups the user was added to (true) or removed from (false).
+	groups map[string]bool
+}
+
+// init lazily allocates the model's maps. It must be called with mu held.
+func (s *simulation) init() {
+	if s.snaps != nil {
+		return
+	}
+	s.snaps = map[string]*SnapInfo{}
+	s.fresh = map[string]bool{}
+	s.debs = map[string]bool{}
+	s.controllers = map[string]bool{}
+	s.files = map[string][]byte{}
+	s.dirs = map[string]bool{}
+	s.removed = map[string]bool{}
+	s.groups = map[string]bool{}
+}
+
+// apply updates the model with the effect of a command that would have been run.
+func (s *simulation) apply(c *Command) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	args := c.Args
+	switch c.Executable {
+	case "snap":
+		if len(args) < 2 {
+			return
+		}
+		name := args[1]
+		switch args[0] {
+		case "install", "refresh":
+			info := &SnapInfo{Installed: true, Active: true, Classic: slices.Contains(args, "--classic")}
+			if i := slices.Index(args, "--channel"); i >= 0 && i+1 < len(args) {
+				info.TrackingChannel = args[i+1]
+			} else if existing := s.snaps[name]; existing != nil {
+				info.TrackingChannel = existing.TrackingChannel
+			}
+			if args[0] == "install" {
+				s.fresh[name] = true
+			}
+			s.snaps[name] = info
+			s.unremove(path.Join("/snap", name), path.Join("/var/snap", name))
+		case "enable":
+			if info := s.snaps[name]; info != nil {
+				info.Active = true
+			}
+		case "remove":
+			s.snaps[name] = nil
+			delete(s.fresh, name)
+			s.removePath(path.Join("/snap", name))
+			s.removePath(path.Join("/var/snap", name))
+			if name == "k8s" {
+				s.k8sBootstrapped = false
+			}
+		}
+
+	case "apt-get":
+		i := slices.IndexFunc(args, func(a string) bool { return a == "install" || a == "remove" })
+		if i < 0 {
+			return
+		}
+		for _, deb := range aptPackages(args[i+1:]) {
+			s.debs[deb] = args[i] == "install"
+		}
+
+	case "juju":
+		if len(args) == 0 {
+			return
+		}
+		switch args[0] {
+		case "bootstrap":
+			if len(args) > 2 {
+				s.controllers[args[2]] = true
+			}
+		case "kill-controller", "destroy-controller":
+			if name := lastPositional(args[1:]); name != "" {
+				s.controllers[name] = false
+			}
+		}
+
+	case "k8s":
+		if len(args) > 0 && args[0] == "bootstrap" {
+			s.k8sBootstrapped = true
+		}
+
+	case "usermod":
+		// usermod -a -G <group> <user>
+		if i := slices.Index(args, "-G"); i >= 0 && i+1 < len(args) {
+			s.groups[args[i+1]] = true
+		}
+
+	case "gpasswd":
+		// gpasswd -d <user> <group>
+		if len(args) == 3 && args[0] == "-d" {
+			s.groups[args[2]] = false
+		}
+	}
+}
+
+// probe answers a read-only command from the model. The final result reports whether
+// the model knew the answer; if not, the caller falls back to the real machine.
+func (s *simulation) probe(c *Command) ([]byte, error, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	args := c.Args
+	if installed, known := s.executableInstalled(c.Executable); known && !installed {
+		return nil, ErrNotInstalled, true
+	}
+
+	switch {
+	case c.Executable == "juju" && len(args) == 2 && args[0] == "show-controller":
+		exists, known := s.controllers[args[1]]
+		if !known {
+			// Juju was installed during the run, so it cannot know of any controllers.
+			if s.fresh["juju"] {
+				exists, known = false, true
+			}
+		}
+		if !known {
+			return nil, nil, false
+		}
+		if !exists {
+			return []byte(fmt.Sprintf("ERROR controller %s not found", args[1])), errSimulated, true
+		}
+		return []byte(args[1] + ":\n"), nil, true
+
+	case c.Executable == "k8s" && len(args) == 1 && args[0] == "status":
+		if s.k8sBootstrapped {
+			return []byte("cluster status: ready\n"), nil, true
+		}
+		if s.fresh["k8s"] {
+			return []byte("Error: The node is not part of a Kubernetes cluster.\n"), errSimulated, true
+		}
+
+	case c.Executable == "which" && len(args) == 1:
+		installed, known := s.executableInstalled(args[0])
+		if !known {
+			return nil, nil, false
+		}
+		if !installed {
+			return nil, errSimulated, true
+		}
+		return []byte(path.Join("/usr/bin", args[0]) + "\n"), nil, true
+
+	case c.Executable == "dpkg-query" && len(args) > 0:
+		deb := args[len(args)-1]
+		installed, known := s.debs[deb]
+		if !known {
+			return nil, nil, false
+		}
+		if !installed {
+			return []byte("dpkg-query: no packages found matching " + deb + "\n"), errSimulated, true
+		}
+		return []byte("install ok installed"), nil, true
+	}
+
+	return nil, nil, false
+}
+
+// executableInstalled reports whether the model installed or removed the snap or deb
+// of the same name as the executable. The second result is false if it did neither.
+// It must be called with mu held.
+func (s *simulation) executableInstalled(executable string) (installed bool, known bool) {
+	if info, ok := s.snaps[executable]; ok {
+		return info != nil, true
+	}
+	if installed, ok := s.debs[executable]; ok {
+		return installed, true
+	}
+	return false, false
+}
+
+// userGroups adjusts a user's group membership, as reported by `id -nG`, for the
+// changes made during the run.
+func (s *simulation) userGroups(groups []string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	for _, group := range slices.Sorted(maps.Keys(s.groups)) {
+		member := s.groups[group]
+		groups = slices.DeleteFunc(groups, func(g string) bool { return g == group })
+		if member {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// snapInfo adjusts the information about a snap for the changes made during the run.
+func (s *simulation) snapInfo(name string, info *SnapInfo) *SnapInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	simulated, known := s.snaps[name]
+	if !known {
+		return info
+	}
+
+	result := &SnapInfo{Classic: info.Classic}
+	if simulated != nil {
+		result.Installed = true
+		result.Active = simulated.Active
+		result.Classic = info.Classic || simulated.Classic
+		result.TrackingChannel = simulated.TrackingChannel
+	}
+	return result
+}
+
+// writeFile records that a file was written.
+func (s *simulation) writeFile(filePath string, contents []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	s.files[filePath] = slices.Clone(contents)
+	s.unremove(filePath)
+}
+
+// readFile returns the contents of a file as of the changes made during the run. The
+// final result is false if the model knows nothing of the file.
+func (s *simulation) readFile(filePath string) ([]byte, error, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	if contents, ok := s.files[filePath]; ok {
+		return slices.Clone(contents), nil, true
+	}
+	if s.isRemoved(filePath) {
+		return nil, fmt.Errorf("file '%s' not found: %w", filePath, os.ErrNotExist), true
+	}
+	return nil, nil, false
+}
+
+// pathExists reports whether a path exists as of the changes made during the run. The
+// final result is false if the model knows nothing of the path.
+func (s *simulation) pathExists(filePath string) (exists bool, known bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	if _, ok := s.files[filePath]; ok {
+		return true, true
+	}
+	if s.dirs[filePath] {
+		return true, true
+	}
+	if s.isRemoved(filePath) {
+		return false, true
+	}
+	return false, false
+}
+
+// mkdirAll records that a directory, and any missing parents, were created.
+func (s *simulation) mkdirAll(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	for d := path.Clean(dir); d != "/" && d != "."; d = path.Dir(d) {
+		s.dirs[d] = true
+		s.unremove(d)
+	}
+}
+
+// rename records that a file was moved.
+func (s *simulation) rename(oldPath, newPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	if contents, ok := s.files[oldPath]; ok {
+		s.files[newPath] = contents
+		s.unremove(newPath)
+	}
+	s.removePath(oldPath)
+}
+
+// remove records that a path, and anything beneath it, was removed.
+func (s *simulation) remove(filePath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+
+	s.removePath(filePath)
+}
+
+// removePath forgets a path and anything beneath it. It must be called with mu held.
+func (s *simulation) removePath(filePath string) {
+	filePath = path.Clean(filePath)
+	for p := range s.files {
+		if isWithin(p, filePath) {
+			delete(s.files, p)
+		}
+	}
+	for p := range s.dirs {
+		if isWithin(p, filePath) {
+			delete(s.dirs, p)
+		}
+	}
+	s.removed[filePath] = true
+}
+
+// unremove forgets that the paths were removed, since they have been recreated. It
+// must be called with mu held.
+func (s *simulation) unremove(paths ...string) {
+	for _, p := range paths {
+		delete(s.removed, path.Clean(p))
+	}
+}
+
+// isRemoved reports whether a path, or one of its parents, was removed. It must be
+// called with mu held.
+func (s *simulation) isRemoved(filePath string) bool {
+	for p := path.Clean(filePath); ; p = path.Dir(p) {
+		if s.removed[p] {
+			return true
+		}
+		if p == "/" || p == "." {
+			return false
+		}
+	}
+}
+
+// isWithin reports whether the path is dir, or beneath it.
+func isWithin(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// aptPackages returns the package names from the arguments that follow an apt-get
+// action, skipping any options.
+func aptPackages(args []string) []string {
+	packages := []string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "-o":
+			i++
+		case strings.HasPrefix(args[i], "-"):
+		default:
+			packages = append(packages, args[i])
+		}
+	}
+	return packages
+}
+
+// lastPositional returns the last argument that is not a flag.
+func lastPositional(args []string) string {
+	for i := len(args) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(args[i], "-") {
+			return args[i]
+		}
+	}
+	return ""
+}
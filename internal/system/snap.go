@@ -8,9 +8,9 @@ import (
 	"os"
 	"slices"
 	"strings"
-	"time"
 
 	"github.com/canonical/concierge/internal/snapd"
+	"github.com/canonical/concierge/internal/telemetry"
 	retry "github.com/sethvargo/go-retry"
 )
 
@@ -20,6 +20,8 @@ type SnapInfo struct {
 	Active          bool
 	Classic         bool
 	TrackingChannel string
+	// Version is the version of the installed snap, such as "3.6.4", if it is installed.
+	Version string
 }
 
 // Snap represents a given snap on a given channel.
@@ -54,20 +56,21 @@ func (s *System) SnapInfo(snap string, channel string) (*SnapInfo, error) {
 		return nil, err
 	}
 
-	installed, active, trackingChannel := s.snapInstalledInfo(snap)
+	info := s.snapInstalledInfo(snap)
+	info.Classic = classic
 
-	slog.Debug("Queried snapd API", "snap", snap, "installed", installed, "active", active, "classic", classic, "tracking", trackingChannel)
-	return &SnapInfo{Installed: installed, Active: active, Classic: classic, TrackingChannel: trackingChannel}, nil
+	slog.Debug("Queried snapd API", "snap", snap, "installed", info.Installed, "active", info.Active, "classic", classic, "tracking", info.TrackingChannel, "version", info.Version)
+	return info, nil
 }
 
 // SnapChannels returns the list of channels available for a given snap.
 func (s *System) SnapChannels(snap string) ([]string, error) {
 	// Fetch the channels from
-	if _, err := os.Stat("/run/snapd.socket"); errors.Is(err, os.ErrNotExist) {
+	if _, err := os.Stat(s.snapd.Socket()); errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
 
-	snapInfo, err := s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
+	snapInfo, err := s.withRetry("snapd: find "+snap, func(ctx context.Context) (*snapd.Snap, error) {
 		snap, err := s.snapd.FindOne(ctx, snap)
 		if err != nil {
 			if strings.Contains(err.Error(), "snap not found") {
@@ -97,11 +100,11 @@ func (s *System) SnapChannels(snap string) ([]string, error) {
 }
 
 // snapInstalledInfo is a helper that reports if the snap is currently installed
-// and returns its tracking channel. The tracking channel is the channel the snap
-// is currently following (e.g., "latest/stable"). Returns empty string if the
-// snap is not installed or if the tracking channel cannot be determined.
-func (s *System) snapInstalledInfo(name string) (installed bool, active bool, trackingChannel string) {
-	snap, err := s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
+// and returns its tracking channel and version. The tracking channel is the channel the
+// snap is currently following (e.g., "latest/stable"). Both are empty if the snap is not
+// installed or if they cannot be determined.
+func (s *System) snapInstalledInfo(name string) *SnapInfo {
+	snap, err := s.withRetry("snapd: get "+name, func(ctx context.Context) (*snapd.Snap, error) {
 		snap, err := s.snapd.Snap(ctx, name)
 		if err != nil && strings.Contains(err.Error(), "snap not installed") {
 			return snap, nil
@@ -111,7 +114,7 @@ func (s *System) snapInstalledInfo(name string) (installed bool, active bool, tr
 		return snap, nil
 	})
 	if err != nil || snap == nil {
-		return false, false, ""
+		return &SnapInfo{}
 	}
 
 	if snap.Status == snapd.StatusActive || snap.Status == snapd.StatusInstalled {
@@ -119,16 +122,16 @@ func (s *System) snapInstalledInfo(name string) (installed bool, active bool, tr
 		if tc == "" {
 			tc = snap.Channel
 		}
-		return true, snap.Status == snapd.StatusActive, tc
+		return &SnapInfo{Installed: true, Active: snap.Status == snapd.StatusActive, TrackingChannel: tc, Version: snap.Version}
 	}
 
-	return false, false, ""
+	return &SnapInfo{}
 }
 
 // snapIsClassic reports whether or not the snap at the tip of the specified channel uses
 // Classic confinement or not.
 func (s *System) snapIsClassic(name, channel string) (bool, error) {
-	snap, err := s.withRetry(func(ctx context.Context) (*snapd.Snap, error) {
+	snap, err := s.withRetry("snapd: find "+name, func(ctx context.Context) (*snapd.Snap, error) {
 		snap, err := s.snapd.FindOne(ctx, name)
 		if err != nil {
 			if strings.Contains(err.Error(), "snap not found") {
@@ -150,9 +153,17 @@ func (s *System) snapIsClassic(name, channel string) (bool, error) {
 	return snap.Confinement == "classic", nil
 }
 
-func (s *System) withRetry(f func(ctx context.Context) (*snapd.Snap, error)) (*snapd.Snap, error) {
-	backoff := retry.NewExponential(1 * time.Second)
+// withRetry calls f until it succeeds, returns an error not marked with
+// retry.RetryableError, or has been retried ten times. The operation describes f in the
+// retry events emitted for each failure that is retried.
+func (s *System) withRetry(operation string, f func(ctx context.Context) (*snapd.Snap, error)) (*snapd.Snap, error) {
+	backoff := retry.NewExponential(s.retryBackoff)
 	backoff = retry.WithMaxRetries(10, backoff)
-	ctx := context.Background()
-	return retry.DoValue(ctx, backoff, f)
+
+	return retryWithEvents(s.context(), backoff, s.step, operation, func(ctx context.Context, attempt int) (*snapd.Snap, error) {
+		ctx, end := telemetry.StartOperation(s.step, operation, attempt)
+		snap, err := f(ctx)
+		end(err)
+		return snap, err
+	})
 }
@@ -1,7 +1,17 @@
 package system
 
 import (
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/snapd"
+	"github.com/canonical/concierge/internal/snapd/snapdtest"
 )
 
 func TestNewSnapFromString(t *testing.T) {
@@ -27,3 +37,146 @@ func TestNewSnapFromString(t *testing.T) {
 		}
 	}
 }
+
+func newFakeSnapdSystem(t *testing.T) (*System, *snapdtest.Server) {
+	t.Helper()
+
+	server := snapdtest.NewServer(t)
+	sys, err := NewSystem(false, WithSnapdSocket(server.Socket()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.retryBackoff = time.Millisecond
+
+	return sys, server
+}
+
+func TestSystemSnapInfo(t *testing.T) {
+	sys, server := newFakeSnapdSystem(t)
+	server.AddStoreSnap(snapd.Snap{
+		Name:        "microk8s",
+		Confinement: "classic",
+		Channels: map[string]snapd.ChannelInfo{
+			"1.31-strict/stable": {Confinement: "strict"},
+			"1.31/stable":        {Confinement: "classic"},
+		},
+	})
+	server.AddInstalledSnap(snapd.Snap{Name: "microk8s", Status: snapd.StatusInstalled, Channel: "1.31/stable", Version: "v1.31.3"})
+
+	info, err := sys.SnapInfo("microk8s", "1.31-strict/stable")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Classic {
+		t.Fatal("expected confinement of the requested channel to be used")
+	}
+
+	if !info.Installed || info.Active || info.TrackingChannel != "1.31/stable" || info.Version != "v1.31.3" {
+		t.Fatalf("unexpected installed state: %+v", info)
+	}
+
+	info, err = sys.SnapInfo("microk8s", "latest/edge")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.Classic {
+		t.Fatal("expected the snap's confinement for an unknown channel")
+	}
+}
+
+func TestSystemSnapInfoRetriesTransientErrors(t *testing.T) {
+	sys, server := newFakeSnapdSystem(t)
+	server.AddStoreSnap(snapd.Snap{Name: "jhack", Confinement: "strict"})
+	server.Fail(http.MethodGet, "/v2/find", http.StatusInternalServerError, "", 2)
+
+	var mu sync.Mutex
+	attempts := []int{}
+	unsubscribe := progress.Subscribe(func(e progress.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == progress.EventRetry && e.Command == "snapd: find jhack" && e.Step == "snap:jhack" {
+			attempts = append(attempts, e.Attempt)
+		}
+	})
+	defer unsubscribe()
+
+	info, err := sys.WithStep("snap:jhack").SnapInfo("jhack", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Fatalf("expected retry events for attempts 1 and 2, got: %v", attempts)
+	}
+
+	if info.Installed || info.Classic {
+		t.Fatalf("unexpected snap info: %+v", info)
+	}
+
+	finds := 0
+	for _, r := range server.Requests() {
+		if strings.HasPrefix(r, "GET /v2/find") {
+			finds++
+		}
+	}
+	if finds != 3 {
+		t.Fatalf("expected 2 failed requests and 1 retry, got %d", finds)
+	}
+}
+
+func TestSystemSnapInfoReportsNoRetryAfterLastAttempt(t *testing.T) {
+	sys, server := newFakeSnapdSystem(t)
+	server.Fail(http.MethodGet, "/v2/find", http.StatusInternalServerError, "", 100)
+
+	var mu sync.Mutex
+	attempts := []int{}
+	unsubscribe := progress.Subscribe(func(e progress.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if e.Type == progress.EventRetry && e.Command == "snapd: find jhack" {
+			attempts = append(attempts, e.Attempt)
+		}
+	})
+	defer unsubscribe()
+
+	if _, err := sys.SnapInfo("jhack", ""); err == nil {
+		t.Fatal("expected an error once the retries were used up")
+	}
+
+	// Eleven attempts are made, of which the first ten are retried.
+	if len(attempts) != 10 || attempts[len(attempts)-1] != 10 {
+		t.Fatalf("expected retry events for attempts 1 to 10, got: %v", attempts)
+	}
+}
+
+func TestSystemSnapInfoNotInStore(t *testing.T) {
+	sys, _ := newFakeSnapdSystem(t)
+
+	_, err := sys.SnapInfo("nonexistent", "")
+	if err == nil || !strings.Contains(err.Error(), "snap not found") {
+		t.Fatalf("expected snap not found error, got: %v", err)
+	}
+}
+
+func TestSystemSnapChannels(t *testing.T) {
+	sys, server := newFakeSnapdSystem(t)
+	server.AddStoreSnap(snapd.Snap{
+		Name: "juju",
+		Channels: map[string]snapd.ChannelInfo{
+			"3.5/stable": {},
+			"3.6/stable": {},
+			"2.9/stable": {},
+		},
+	})
+
+	channels, err := sys.SnapChannels("juju")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(channels, []string{"3.6/stable", "3.5/stable", "2.9/stable"}) {
+		t.Fatalf("expected channels sorted in descending order, got: %v", channels)
+	}
+}
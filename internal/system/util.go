@@ -31,6 +31,22 @@ var useColor = sync.OnceValue(func() bool {
 	return err == nil
 })
 
+// Interactive reports whether concierge is writing to a terminal on stdout, with
+// NO_COLOR unset, such that it can draw a live view of its progress.
+func Interactive() bool {
+	return useColor()
+}
+
+// TerminalWidth returns the width of the terminal on stdout, in columns, or 80 if it
+// cannot be determined.
+func TerminalWidth() int {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 80
+	}
+	return int(ws.Col)
+}
+
 // ansi wraps s in the given ANSI escape sequence when colour is enabled, else
 // returns s.
 func ansi(s, code string) string {
@@ -98,6 +114,23 @@ func realUser() (*user.User, error) {
 	return nil, err
 }
 
+// LookupUserID looks up a user by their numeric ID, such as the user at the other end
+// of a socket, falling back to `getent` for users not listed in /etc/passwd.
+func LookupUserID(uid string) (*user.User, error) {
+	u, err := user.LookupId(uid)
+	if err == nil {
+		return u, nil
+	}
+
+	var unknownUserIDErr user.UnknownUserIdError
+	if errors.As(err, &unknownUserIDErr) {
+		// getent accepts a user ID in place of a name.
+		return lookupUserGetent(uid)
+	}
+
+	return nil, err
+}
+
 // getentBinary is the name of the `getent` binary to invoke. It is a variable
 // so that tests can replace it to exercise failure modes (e.g. binary missing).
 var getentBinary = "getent"
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"os/exec"
 	"os/user"
+	"reflect"
 	"testing"
+
+	"github.com/canonical/concierge/internal/progress"
 )
 
 func TestLookupUserGetent(t *testing.T) {
@@ -41,6 +44,21 @@ func TestLookupUserGetent(t *testing.T) {
 	}
 }
 
+func TestLookupUserID(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Fatalf("user.Current() failed: %v", err)
+	}
+
+	got, err := LookupUserID(current.Uid)
+	if err != nil {
+		t.Fatalf("LookupUserID(%q) failed: %v", current.Uid, err)
+	}
+	if got.Username != current.Username || got.HomeDir != current.HomeDir {
+		t.Errorf("got %+v, want %+v", got, current)
+	}
+}
+
 func TestLookupUserGetentUnknownUser(t *testing.T) {
 	if _, err := exec.LookPath("getent"); err != nil {
 		t.Skip("getent not available on this system")
@@ -68,3 +86,26 @@ func TestLookupUserGetentBinaryMissing(t *testing.T) {
 		t.Fatalf("missing binary should not surface as UnknownUserError, got %v", err)
 	}
 }
+
+func TestOutputWriterEmitsLines(t *testing.T) {
+	lines := []string{}
+	unsubscribe := progress.SubscribeWithOutput(func(e progress.Event) {
+		if e.Type == progress.EventOutput && e.Command == "snap install jhack" {
+			lines = append(lines, e.Message)
+		}
+	})
+	defer unsubscribe()
+
+	w := &outputWriter{command: "snap install jhack", lines: true}
+	_, _ = w.Write([]byte("Ensure prerequisites\rDownload"))
+	_, _ = w.Write([]byte("ing snap\n\njhack installed"))
+
+	expected := []string{"Ensure prerequisites", "Downloading snap"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("expected lines %v, got %v", expected, lines)
+	}
+
+	if w.buf.String() != "Ensure prerequisites\rDownloading snap\n\njhack installed" {
+		t.Fatalf("unexpected collected output: %q", w.buf.String())
+	}
+}
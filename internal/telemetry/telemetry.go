@@ -0,0 +1,363 @@
+// Package telemetry traces concierge's provisioning runs with OpenTelemetry, so that
+// the time spent in each step can be compared across many runs.
+//
+// Tracing is enabled by the standard `OTEL_EXPORTER_OTLP_*` environment variables: if
+// neither `OTEL_EXPORTER_OTLP_ENDPOINT` nor `OTEL_EXPORTER_OTLP_TRACES_ENDPOINT` is set,
+// or `OTEL_SDK_DISABLED` is true, nothing is recorded and each function returns after
+// checking a single flag.
+//
+// Spans form a tree beneath the span of a run: the handlers for snaps, debs and Juju,
+// each step reported by package progress, such as `snap:jhack` or `provider:lxd`, and
+// the commands and snapd requests made on behalf of each step. Like the progress
+// emitter, the tracer is process-wide, since concierge performs a single run per
+// invocation and its handlers do not carry a context.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// instrumentationName identifies the tracer that records concierge's spans.
+const instrumentationName = "github.com/canonical/concierge"
+
+// shutdownTimeout bounds how long exporting the remaining spans may delay exit.
+const shutdownTimeout = 5 * time.Second
+
+var (
+	// enabled is checked before any other work, so that tracing costs nothing when
+	// it is not configured.
+	enabled atomic.Bool
+
+	mu     sync.Mutex
+	tracer trace.Tracer = noop.NewTracerProvider().Tracer(instrumentationName)
+	// run is the context of the span of the current run, and handlers the context of
+	// the span of each handler running, keyed by the kind of step it performs.
+	run      context.Context
+	handlers = map[string]context.Context{}
+	// steps holds the span of each running step.
+	steps = map[string]trace.Span{}
+	// stepAttributes, if set, returns attributes describing a step, such as the
+	// channel of a snap.
+	stepAttributes func(step string) []attribute.KeyValue
+)
+
+// Setup enables tracing if an OTLP endpoint is configured in the environment. The
+// returned function exports any remaining spans and disables tracing; it must be called
+// before concierge exits.
+func Setup(version string) (shutdown func(), err error) {
+	if !configured() {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+	exporter, err := newExporter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	// Attributes from OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take priority.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			attribute.String("service.name", "concierge"),
+			attribute.String("service.version", version),
+		),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+
+	mu.Lock()
+	tracer = tp.Tracer(instrumentationName)
+	mu.Unlock()
+
+	unsubscribe := progress.Subscribe(handleEvent)
+	enabled.Store(true)
+
+	return func() {
+		enabled.Store(false)
+		unsubscribe()
+
+		mu.Lock()
+		tracer = noop.NewTracerProvider().Tracer(instrumentationName)
+		mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		// Failing to export traces must never fail the run itself.
+		_ = tp.Shutdown(ctx)
+	}, nil
+}
+
+// configured reports whether the environment configures an OTLP endpoint for traces.
+func configured() bool {
+	if disabled, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED")); disabled {
+		return false
+	}
+	if os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// newExporter constructs an OTLP exporter over http/protobuf, the default protocol as
+// per the specification, and the only one supported. The exporter reads the remaining
+// `OTEL_EXPORTER_OTLP_*` variables itself.
+func newExporter(ctx context.Context) (*otlptrace.Exporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	if protocol != "" && protocol != "http/protobuf" {
+		return nil, fmt.Errorf("unsupported OTLP protocol '%s': only http/protobuf is supported", protocol)
+	}
+	return otlptracehttp.New(ctx)
+}
+
+// StartRun starts the span of a run of the specified action, beneath which all other
+// spans are recorded. The attributes function, if not nil, describes each step of the
+// run. The returned function ends the span, recording err if non-nil.
+func StartRun(action string, attributes func(step string) []attribute.KeyValue) (end func(err error)) {
+	if !enabled.Load() {
+		return func(error) {}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ctx, span := tracer.Start(context.Background(), "concierge "+action,
+		trace.WithAttributes(attribute.String("concierge.action", action)))
+	run = ctx
+	stepAttributes = attributes
+
+	return func(err error) {
+		finish(span, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		run = nil
+		stepAttributes = nil
+	}
+}
+
+// StartHandler starts the span of a handler performing steps of the specified kind,
+// such as progress.KindSnap, with a name such as "SnapHandler.Prepare". The returned
+// function ends the span, recording err if non-nil.
+func StartHandler(kind, name string) (end func(err error)) {
+	if !enabled.Load() {
+		return func(error) {}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	ctx, span := tracer.Start(runContext(), name, trace.WithAttributes(attribute.String("concierge.handler", kind)))
+	handlers[kind] = ctx
+
+	return func(err error) {
+		finish(span, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if handlers[kind] == ctx {
+			delete(handlers, kind)
+		}
+	}
+}
+
+// Enabled reports whether tracing is enabled.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// StartCommand starts the span of a command run on the machine, named for its
+// executable, beneath the span of the step that runs it, if any. The returned function
+// ends the span, recording err if non-nil.
+func StartCommand(step, executable, command string, attributes ...attribute.KeyValue) (end func(err error)) {
+	if !enabled.Load() {
+		return func(error) {}
+	}
+
+	mu.Lock()
+	parent := contextFor(step)
+	tr := tracer
+	mu.Unlock()
+
+	_, span := tr.Start(parent, executable,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(attributes, attribute.String("concierge.command", command))...))
+
+	return func(err error) { finish(span, err) }
+}
+
+// StartOperation starts the span of an attempt at an operation other than a command,
+// such as a request to snapd, beneath the span of the step that makes it, if any. The
+// returned context carries the span, such that requests made with it are recorded
+// beneath it; see Transport. The returned function ends the span, recording err if
+// non-nil.
+func StartOperation(step, operation string, attempt int) (ctx context.Context, end func(err error)) {
+	if !enabled.Load() {
+		return context.Background(), func(error) {}
+	}
+
+	mu.Lock()
+	parent := contextFor(step)
+	tr := tracer
+	mu.Unlock()
+
+	ctx, span := tr.Start(parent, operation, trace.WithAttributes(
+		attribute.String("concierge.operation", operation),
+		attribute.Int("concierge.attempt", attempt),
+	))
+	return ctx, func(err error) { finish(span, err) }
+}
+
+// Transport wraps an HTTP transport such that each request made through it, such as
+// to snapd, is recorded as a span named for the service, beneath the span in the
+// request's context, while tracing is enabled.
+func Transport(service string, next http.RoundTripper) http.RoundTripper {
+	return &tracingTransport{service: service, next: next}
+}
+
+// tracingTransport records a span for each request made through it.
+type tracingTransport struct {
+	service string
+	next    http.RoundTripper
+}
+
+// RoundTrip makes the request, recording its method, path and response status.
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !enabled.Load() {
+		return t.next.RoundTrip(req)
+	}
+
+	mu.Lock()
+	tr := tracer
+	mu.Unlock()
+
+	ctx, span := tr.Start(req.Context(), t.service+" "+req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+			attribute.String("url.query", req.URL.RawQuery),
+		))
+	defer span.End()
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
+
+// handleEvent records the span of each step, and each retry as an event on the span
+// of the step it belongs to.
+func handleEvent(e progress.Event) {
+	if !enabled.Load() {
+		return
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	switch e.Type {
+	case progress.EventStart:
+		attributes := []attribute.KeyValue{
+			attribute.String("concierge.step", e.Step),
+			attribute.String("concierge.step.kind", e.Kind()),
+			attribute.String("concierge.step.name", e.Name()),
+		}
+		if stepAttributes != nil {
+			attributes = append(attributes, stepAttributes(e.Step)...)
+		}
+
+		parent, ok := handlers[e.Kind()]
+		if !ok {
+			parent = runContext()
+		}
+		_, span := tracer.Start(parent, e.Step, trace.WithTimestamp(e.Time), trace.WithAttributes(attributes...))
+		steps[e.Step] = span
+
+	case progress.EventEnd:
+		span, ok := steps[e.Step]
+		if !ok {
+			return
+		}
+		delete(steps, e.Step)
+		if e.Err != nil {
+			span.RecordError(e.Err)
+			span.SetStatus(codes.Error, e.Err.Error())
+		}
+		span.End(trace.WithTimestamp(e.Time))
+
+	case progress.EventRetry:
+		span, ok := steps[e.Step]
+		if !ok {
+			return
+		}
+		attributes := []attribute.KeyValue{
+			attribute.String("concierge.operation", e.Command),
+			attribute.Int("concierge.attempt", e.Attempt),
+		}
+		if e.Err != nil {
+			attributes = append(attributes, attribute.String("error", e.Err.Error()))
+		}
+		span.AddEvent("retry", trace.WithTimestamp(e.Time), trace.WithAttributes(attributes...))
+	}
+}
+
+// contextFor returns the context of the span of the step, or of the run if the step is
+// not running. It must be called with mu held.
+func contextFor(step string) context.Context {
+	if span, ok := steps[step]; ok {
+		return trace.ContextWithSpan(context.Background(), span)
+	}
+	return runContext()
+}
+
+// runContext returns the context of the span of the current run, or an empty context
+// if no run is in progress. It must be called with mu held.
+func runContext() context.Context {
+	if run == nil {
+		return context.Background()
+	}
+	return run
+}
+
+// finish ends a span, recording err if non-nil.
+func finish(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
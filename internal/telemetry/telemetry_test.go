@@ -0,0 +1,255 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/canonical/concierge/internal/progress"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// receiver is an in-process OTLP/HTTP receiver, which collects the spans exported to it.
+type receiver struct {
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || req.URL.Path != "/v1/traces" {
+		http.NotFound(w, req)
+		return
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var export coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	for _, rs := range export.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			r.spans = append(r.spans, ss.Spans...)
+		}
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	content, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	_, _ = w.Write(content)
+}
+
+// span returns the received span with the specified name, failing the test if there
+// is none.
+func (r *receiver) span(t *testing.T, name string) *tracepb.Span {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no span named %q received", name)
+	return nil
+}
+
+// spanAttribute returns the string form of a span's attribute, or "" if it is not set.
+func spanAttribute(s *tracepb.Span, key string) string {
+	for _, a := range s.Attributes {
+		if a.Key != key {
+			continue
+		}
+		if _, ok := a.Value.Value.(*commonpb.AnyValue_IntValue); ok {
+			return strconv.FormatInt(a.Value.GetIntValue(), 10)
+		}
+		return a.Value.GetStringValue()
+	}
+	return ""
+}
+
+func TestTracingExportsSpanTree(t *testing.T) {
+	r := &receiver{}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+
+	shutdown, err := Setup("1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !Enabled() {
+		t.Fatal("expected tracing to be enabled")
+	}
+
+	endRun := StartRun("prepare", func(step string) []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.String("snap.channel", "latest/edge")}
+	})
+	endHandler := StartHandler(progress.KindSnap, "SnapHandler.Prepare")
+
+	progress.Start("snap:jhack")
+	progress.Retry("snap:jhack", "snap install jhack", 1, errors.New("exit status 1"))
+	// Spans are placed beneath the step given, whatever the command or operation names.
+	endCommand := StartCommand("snap:jhack", "snap", "snap install jhack --channel latest/edge")
+	endCommand(nil)
+	ctx, endOperation := StartOperation("snap:jhack", "snapd: find", 2)
+	request(t, ctx, Transport("snapd", okTransport{}))
+	endOperation(nil)
+	progress.End("snap:jhack", errors.New("failed to install"))
+
+	endHandler(nil)
+	endRun(nil)
+	shutdown()
+
+	if Enabled() {
+		t.Fatal("expected tracing to be disabled after shutdown")
+	}
+
+	run := r.span(t, "concierge prepare")
+	handler := r.span(t, "SnapHandler.Prepare")
+	step := r.span(t, "snap:jhack")
+	command := r.span(t, "snap")
+	operation := r.span(t, "snapd: find")
+	request := r.span(t, "snapd GET /v2/find")
+
+	parents := map[*tracepb.Span]*tracepb.Span{
+		handler:   run,
+		step:      handler,
+		command:   step,
+		operation: step,
+		request:   operation,
+	}
+	for child, parent := range parents {
+		if string(child.ParentSpanId) != string(parent.SpanId) || string(child.TraceId) != string(run.TraceId) {
+			t.Fatalf("expected span %q to be a child of %q", child.Name, parent.Name)
+		}
+	}
+
+	if spanAttribute(step, "snap.channel") != "latest/edge" || spanAttribute(step, "concierge.step.kind") != progress.KindSnap {
+		t.Fatalf("unexpected step attributes: %v", step.Attributes)
+	}
+	if step.Status.Code != tracepb.Status_STATUS_CODE_ERROR {
+		t.Fatalf("expected failed step to have error status, got: %v", step.Status)
+	}
+	if len(step.Events) == 0 || step.Events[0].Name != "retry" || spanAttribute(&tracepb.Span{Attributes: step.Events[0].Attributes}, "concierge.attempt") != "1" {
+		t.Fatalf("expected a retry event on the step, got: %v", step.Events)
+	}
+	if spanAttribute(operation, "concierge.attempt") != "2" {
+		t.Fatalf("unexpected operation attributes: %v", operation.Attributes)
+	}
+}
+
+func TestTracingShutdownWhileCommandsStart(t *testing.T) {
+	server := httptest.NewServer(&receiver{})
+	defer server.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", server.URL)
+
+	shutdown, err := Setup("1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Run with -race: the tracer must not be read while shutdown replaces it.
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; Enabled(); n++ {
+				StartCommand("snap:jhack", "snap", "snap install jhack")(nil)
+				_, end := StartOperation("snap:jhack", "snapd: find", 1)
+				end(nil)
+				if i == 0 && n == 10 {
+					close(started)
+				}
+			}
+		}()
+	}
+	<-started
+	shutdown()
+	wg.Wait()
+}
+
+func TestTracingDisabledWithoutEndpoint(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	shutdown, err := Setup("1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+
+	if Enabled() {
+		t.Fatal("expected tracing to be disabled")
+	}
+
+	// Without tracing, nothing is recorded, and the span in the context is not valid.
+	ctx, end := StartOperation("snap:jhack", "snapd: find jhack", 1)
+	end(nil)
+	if trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		t.Fatal("expected no span to be recorded")
+	}
+	request(t, ctx, Transport("snapd", okTransport{}))
+}
+
+func TestTracingOnlySupportsHTTP(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")
+
+	if _, err := newExporter(context.Background()); err == nil {
+		t.Fatal("expected the grpc protocol to be rejected")
+	}
+}
+
+// okTransport answers every request with an empty 200 response.
+type okTransport struct{}
+
+func (okTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+}
+
+// request makes a request to snapd's find endpoint through the transport.
+func request(t *testing.T, ctx context.Context, transport http.RoundTripper) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/v2/find", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+}
+
+func TestTracingDisabledBySDKDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+
+	if configured() {
+		t.Fatal("expected tracing not to be configured")
+	}
+}
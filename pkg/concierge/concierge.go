@@ -0,0 +1,279 @@
+// Package concierge is the supported Go API for embedding concierge in other programs,
+// such as test harnesses and provisioning agents, which would otherwise shell out to
+// the concierge binary and scrape its output.
+//
+// A Concierge is constructed from a configuration, in the same format as
+// 'concierge.yaml' or one of the built-in presets, and can then prepare the machine,
+// restore it, report its status and describe what it would do:
+//
+//	c, err := concierge.NewFromPreset("dev", concierge.WithEventHandler(
+//		concierge.EventHandlerFunc(func(e concierge.Event) {
+//			if e.Type == concierge.EventEnd {
+//				log.Printf("%s finished in %s", e.Step, e.Duration)
+//			}
+//		})))
+//	if err != nil {
+//		return err
+//	}
+//	result, err := c.Prepare(ctx)
+//
+// Like the concierge binary, preparing and restoring a machine requires root
+// privileges. Only one run of concierge may be underway in a process at a time, since
+// its progress is reported process-wide; concurrent calls wait for the run underway to
+// finish. Cancelling the context of a run stops the commands it is running and fails
+// the run, leaving the machine partly prepared or restored.
+package concierge
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/progress"
+	"github.com/canonical/concierge/internal/report"
+)
+
+// Actions performed by a run, as recorded in a Result.
+const (
+	PrepareAction = concierge.PrepareAction
+	RestoreAction = concierge.RestoreAction
+)
+
+// Statuses of a run, or of a step of a run, in a Result.
+const (
+	StatusSucceeded = report.StatusSucceeded
+	StatusFailed    = report.StatusFailed
+	StatusRunning   = report.StatusRunning
+)
+
+// StatusProvisioning is the status in a StatusReport of a 'prepare' still underway.
+const StatusProvisioning = "provisioning"
+
+// runs serializes runs of concierge within the process, since progress events are
+// emitted process-wide. It holds a value while a run is underway.
+var runs = make(chan struct{}, 1)
+
+// Concierge prepares and restores a machine according to a configuration.
+type Concierge struct {
+	config  *config.Config
+	handler EventHandler
+	output  io.Writer
+	dryRun  bool
+
+	skipPreflight bool
+}
+
+// Option configures a Concierge.
+type Option func(*Concierge)
+
+// WithEventHandler registers a handler to observe the progress of each run.
+func WithEventHandler(h EventHandler) Option {
+	return func(c *Concierge) { c.handler = h }
+}
+
+// WithOutput sets where concierge prints anything other than logs, such as the
+// commands it would run in dry-run mode. By default, such output is discarded.
+func WithOutput(w io.Writer) Option {
+	return func(c *Concierge) { c.output = w }
+}
+
+// WithDryRun makes Prepare and Restore report what they would do, without making
+// changes to the machine.
+func WithDryRun() Option {
+	return func(c *Concierge) { c.dryRun = true }
+}
+
+// WithSkipPreflight makes Prepare skip its checks of the machine's resources, OS and
+// conflicting services before making changes.
+func WithSkipPreflight() Option {
+	return func(c *Concierge) { c.skipPreflight = true }
+}
+
+// New constructs a Concierge from a configuration. The configuration is copied, and
+// not modified by later runs.
+func New(cfg *Config, opts ...Option) (*Concierge, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("concierge configuration must not be nil")
+	}
+
+	conf := cfg.internalConfig()
+	conf.Source = "api"
+	return newConcierge(conf, opts...), nil
+}
+
+// newConcierge constructs a Concierge from concierge's own configuration.
+func newConcierge(conf *config.Config, opts ...Option) *Concierge {
+	c := &Concierge{config: conf, output: io.Discard}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// NewFromYAML constructs a Concierge from a configuration in the format of
+// 'concierge.yaml'. Environment variables in the values that support them are expanded.
+func NewFromYAML(data []byte, opts ...Option) (*Concierge, error) {
+	conf, err := config.Parse(data, "api")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse configuration: %w", err)
+	}
+	conf.Source = "api"
+	return newConcierge(conf, opts...), nil
+}
+
+// NewFromPreset constructs a Concierge from one of the built-in presets, such as "dev".
+func NewFromPreset(name string, opts ...Option) (*Concierge, error) {
+	conf, err := config.Preset(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration preset: %w", err)
+	}
+	conf.Source = "preset:" + name
+	return newConcierge(conf, opts...), nil
+}
+
+// Presets returns the names of the built-in presets.
+func Presets() []string {
+	return config.ValidPresets()
+}
+
+// Prepare provisions the machine according to the configuration. The result describes
+// each step of the run, and is returned even if the run failed. If the context is done
+// before the run starts, including while waiting for another run in the process to
+// finish, the run does not start; if it is done while the run is underway, the commands
+// running are stopped and the run fails with an error wrapping the context's.
+func (c *Concierge) Prepare(ctx context.Context) (*Result, error) {
+	return c.run(ctx, concierge.PrepareAction)
+}
+
+// Restore reverses the provisioning performed by the most recent Prepare on the
+// machine, by concierge or through this package, using the configuration it recorded.
+// Like Prepare, the result is returned even if the run failed.
+func (c *Concierge) Restore(ctx context.Context) (*Result, error) {
+	return c.run(ctx, concierge.RestoreAction)
+}
+
+// Status reports the outcome of the most recent 'prepare' on the machine. If concierge
+// has not prepared the machine, an error satisfying IsNotPrepared is returned.
+func (c *Concierge) Status(ctx context.Context) (*StatusReport, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mgr, err := c.manager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := mgr.Status()
+	if err != nil {
+		return nil, err
+	}
+	return newStatusReport(status), nil
+}
+
+// Plan describes what Prepare would install and configure, without changing the
+// machine. An error is returned if the configuration could not be prepared.
+func (c *Concierge) Plan(ctx context.Context) (*PlanDescription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mgr, err := c.manager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	description, err := mgr.Describe()
+	if err != nil {
+		return nil, err
+	}
+	return newPlanDescription(description), nil
+}
+
+// History returns the 'prepare' and 'restore' runs recorded on the machine, oldest
+// first.
+func (c *Concierge) History(ctx context.Context) ([]*Run, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mgr, err := c.manager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	runs, err := mgr.History()
+	if err != nil {
+		return nil, err
+	}
+	return newRuns(runs), nil
+}
+
+// IsNotPrepared reports whether the error indicates that concierge has not prepared the
+// machine.
+func IsNotPrepared(err error) bool {
+	return concierge.ErrNotPrepared(err)
+}
+
+// run performs an action, reporting its progress to the event handler, if any.
+func (c *Concierge) run(ctx context.Context, action string) (*Result, error) {
+	if err := lock(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { <-runs }()
+
+	mgr, err := c.manager(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.handler != nil {
+		unsubscribe := progress.SubscribeWithOutput(func(e progress.Event) {
+			c.handler.HandleEvent(newEvent(e))
+		})
+		defer unsubscribe()
+	}
+
+	if action == concierge.PrepareAction {
+		err = mgr.Prepare()
+	} else {
+		err = mgr.Restore()
+	}
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
+	return newResult(mgr.Result()), err
+}
+
+// manager constructs a manager for a single call, from a copy of the configuration,
+// since a run records its outcome in the configuration it is given. The commands the
+// manager runs are stopped once ctx is done.
+func (c *Concierge) manager(ctx context.Context) (*concierge.Manager, error) {
+	conf := *c.config
+	conf.DryRun = c.dryRun
+	conf.SkipPreflight = conf.SkipPreflight || c.skipPreflight
+	conf.Console = c.output
+
+	mgr, err := concierge.NewManager(&conf, concierge.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialise concierge: %w", err)
+	}
+	return mgr, nil
+}
+
+// lock waits for any other run in the process to finish, giving up if the context is
+// done first.
+func lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case runs <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
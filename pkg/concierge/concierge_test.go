@@ -0,0 +1,172 @@
+package concierge
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+func TestNewFromYAML(t *testing.T) {
+	t.Setenv("TEST_REGISTRY_PASSWORD", "secret")
+
+	c, err := NewFromYAML([]byte(`
+providers:
+  k8s:
+    enable: true
+    image-registry:
+      url: https://registry.example.com
+      password: ${TEST_REGISTRY_PASSWORD}
+host:
+  snaps:
+    jhack:
+      channel: latest/edge
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.config.Source != "api" || c.config.Host.Snaps["jhack"].Channel != "latest/edge" ||
+		c.config.Providers.K8s.ImageRegistry.Password != "secret" {
+		t.Fatalf("unexpected config: %+v", c.config)
+	}
+
+	if _, err := NewFromYAML([]byte("providers: [")); err == nil {
+		t.Fatal("expected invalid YAML to be rejected")
+	}
+}
+
+func TestNew(t *testing.T) {
+	retries := 5
+	cfg := &Config{
+		Providers: ProvidersConfig{K8s: K8sConfig{
+			Enable:   true,
+			Features: map[string]map[string]string{"load-balancer": {"l2-mode": "true"}},
+		}},
+		Host:   HostConfig{Snaps: map[string]SnapConfig{"jhack": {Channel: "latest/edge", Connections: []string{"jhack:dot-local-share-juju"}}}},
+		Notify: NotifyConfig{Webhooks: []WebhookConfig{{URL: "https://example.com/hook", Retries: &retries}}},
+	}
+
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.config.Source != "api" || !c.config.Providers.K8s.Enable ||
+		c.config.Providers.K8s.Features["load-balancer"]["l2-mode"] != "true" ||
+		c.config.Host.Snaps["jhack"].Connections[0] != "jhack:dot-local-share-juju" ||
+		*c.config.Notify.Webhooks[0].Retries != 5 {
+		t.Fatalf("unexpected config: %+v", c.config)
+	}
+
+	// Later changes to the configuration do not affect the Concierge.
+	cfg.Providers.K8s.Features["load-balancer"]["l2-mode"] = "false"
+	cfg.Host.Snaps["jhack"].Connections[0] = "jhack:ssh-read"
+	retries = 1
+	if c.config.Providers.K8s.Features["load-balancer"]["l2-mode"] != "true" ||
+		c.config.Host.Snaps["jhack"].Connections[0] != "jhack:dot-local-share-juju" ||
+		*c.config.Notify.Webhooks[0].Retries != 5 {
+		t.Fatalf("expected the configuration to be copied, got: %+v", c.config)
+	}
+
+	if _, err := New(nil); err == nil {
+		t.Fatal("expected a nil configuration to be rejected")
+	}
+}
+
+func TestNewFromPreset(t *testing.T) {
+	c, err := NewFromPreset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.config.Source != "preset:dev" {
+		t.Fatalf("unexpected source: %s", c.config.Source)
+	}
+
+	if _, err := NewFromPreset("bogus"); err == nil || !strings.Contains(err.Error(), "unknown preset") {
+		t.Fatalf("expected unknown preset to be rejected, got: %v", err)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	c, err := NewFromPreset("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := c.Plan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Juju || len(d.Providers) != 2 || len(d.Environment.Controllers) != 2 {
+		t.Fatalf("unexpected plan: %+v", d)
+	}
+
+	invalid, err := NewFromYAML([]byte(`
+providers:
+  k8s:
+    enable: true
+  microk8s:
+    enable: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invalid.Plan(context.Background()); err == nil {
+		t.Fatal("expected a plan with two local kubernetes providers to be rejected")
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	c, err := NewFromPreset("dev", WithDryRun())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.Prepare(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected prepare not to start, got: %v", err)
+	}
+	if _, err := c.Plan(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected plan not to start, got: %v", err)
+	}
+
+	// A run waiting for another to finish gives up when its context is done.
+	runs <- struct{}{}
+	defer func() { <-runs }()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Restore(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected restore to give up waiting, got: %v", err)
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	now := time.Now()
+	attrs := map[string]any{"snap": "jhack"}
+
+	e := newEvent(progress.Event{
+		Type:     progress.EventEnd,
+		Step:     "snap:jhack",
+		Time:     now,
+		Duration: time.Second,
+		Err:      errors.New("boom"),
+		Attrs:    attrs,
+	})
+
+	if e.Type != EventEnd || e.Step != "snap:jhack" || !e.Time.Equal(now) || e.Duration != time.Second || e.Err.Error() != "boom" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	// Handlers may keep the attributes of an event without them changing beneath them.
+	attrs["snap"] = "lxd"
+	if e.Attrs["snap"] != "jhack" {
+		t.Fatalf("expected attributes to be copied, got: %v", e.Attrs)
+	}
+}
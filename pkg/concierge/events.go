@@ -0,0 +1,100 @@
+package concierge
+
+import (
+	"encoding/json"
+	"maps"
+	"time"
+
+	"github.com/canonical/concierge/internal/progress"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+// Event types.
+const (
+	// EventStart is emitted when a step begins.
+	EventStart EventType = progress.EventStart
+	// EventEnd is emitted when a step finishes, successfully or otherwise.
+	EventEnd EventType = progress.EventEnd
+	// EventCommand is emitted when a command has been run on the machine.
+	EventCommand EventType = progress.EventCommand
+	// EventRetry is emitted when an operation failed and is about to be retried.
+	EventRetry EventType = progress.EventRetry
+	// EventWarning is emitted when concierge logs a warning.
+	EventWarning EventType = progress.EventWarning
+	// EventOutput is emitted for each line of output from a command as it runs.
+	EventOutput EventType = progress.EventOutput
+)
+
+// Event describes something that happened during a run, such as a step starting or a
+// command being run. Steps have stable identifiers made up of their kind and name, such
+// as `snap:jhack`, `deb:make`, `provider:k8s` or `juju:bootstrap:lxd`.
+type Event struct {
+	// Type is the type of event, such as EventStart or EventEnd.
+	Type EventType
+	// Step is the identifier of the step, if the event relates to one.
+	Step string
+	// Time is the time at which the event occurred.
+	Time time.Time
+	// Duration is the time since the step started, or the time a command took. It is
+	// only set on EventEnd and EventCommand.
+	Duration time.Duration
+	// Err is the error the step or command failed with, if any. It is only set on
+	// EventEnd, EventCommand and EventRetry.
+	Err error
+	// Command is the command that was run, or the operation being retried. It is only
+	// set on EventCommand, EventRetry and EventOutput.
+	Command string
+	// Attempt is the number of the attempt that failed. It is only set on EventRetry.
+	Attempt int
+	// Message is the message logged with a warning, or the line of output from a
+	// command. It is only set on EventWarning and EventOutput. Attrs holds the
+	// attributes of a warning.
+	Message string
+	Attrs   map[string]any
+}
+
+// EventHandler observes the progress of a run. HandleEvent may be called concurrently
+// from multiple goroutines, and should return promptly since the run waits for it.
+type EventHandler interface {
+	HandleEvent(Event)
+}
+
+// EventHandlerFunc adapts a function to an EventHandler.
+type EventHandlerFunc func(Event)
+
+// HandleEvent calls f(e).
+func (f EventHandlerFunc) HandleEvent(e Event) {
+	f(e)
+}
+
+// MarshalJSON encodes the event in the format of concierge's '--output json' stream.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(progress.Event{
+		Type:     string(e.Type),
+		Step:     e.Step,
+		Time:     e.Time,
+		Duration: e.Duration,
+		Err:      e.Err,
+		Command:  e.Command,
+		Attempt:  e.Attempt,
+		Message:  e.Message,
+		Attrs:    e.Attrs,
+	})
+}
+
+// newEvent converts a progress event into its public form.
+func newEvent(e progress.Event) Event {
+	return Event{
+		Type:     EventType(e.Type),
+		Step:     e.Step,
+		Time:     e.Time,
+		Duration: e.Duration,
+		Err:      e.Err,
+		Command:  e.Command,
+		Attempt:  e.Attempt,
+		Message:  e.Message,
+		Attrs:    maps.Clone(e.Attrs),
+	}
+}
@@ -0,0 +1,499 @@
+package concierge
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/canonical/concierge/internal/concierge"
+	"github.com/canonical/concierge/internal/config"
+	"github.com/canonical/concierge/internal/report"
+	"github.com/canonical/concierge/internal/state"
+)
+
+// Config is the configuration of concierge, in the format of 'concierge.yaml'.
+type Config struct {
+	Juju      JujuConfig      `yaml:"juju"`
+	Providers ProvidersConfig `yaml:"providers"`
+	Host      HostConfig      `yaml:"host"`
+	Notify    NotifyConfig    `yaml:"notify"`
+}
+
+// JujuConfig configures the installation of Juju, and how controllers are
+// bootstrapped.
+type JujuConfig struct {
+	// Disable skips the installation of Juju.
+	Disable bool `yaml:"disable"`
+	// Channel is the Snap Store channel from which to install Juju.
+	Channel string `yaml:"channel"`
+	// Revision is the Snap Store revision of Juju to install. If both Channel and
+	// Revision are set, the channel is only used for tracking after install.
+	Revision string `yaml:"revision"`
+	// AgentVersion is the Juju agent version to use during bootstrap.
+	AgentVersion string `yaml:"agent-version"`
+	// ModelDefaults are passed to Juju during bootstrap.
+	ModelDefaults map[string]string `yaml:"model-defaults"`
+	// BootstrapConstraints are passed to Juju during bootstrap.
+	BootstrapConstraints map[string]string `yaml:"bootstrap-constraints"`
+	// ExtraBootstrapArgs are appended to the bootstrap command.
+	ExtraBootstrapArgs string `yaml:"extra-bootstrap-args"`
+}
+
+// ProvidersConfig configures the providers to prepare and bootstrap.
+type ProvidersConfig struct {
+	K8s      K8sConfig      `yaml:"k8s"`
+	LXD      LXDConfig      `yaml:"lxd"`
+	Google   GoogleConfig   `yaml:"google"`
+	MicroK8s MicroK8sConfig `yaml:"microk8s"`
+}
+
+// LXDConfig configures LXD on the machine.
+type LXDConfig struct {
+	Enable               bool              `yaml:"enable"`
+	Bootstrap            bool              `yaml:"bootstrap"`
+	Channel              string            `yaml:"channel"`
+	ModelDefaults        map[string]string `yaml:"model-defaults"`
+	BootstrapConstraints map[string]string `yaml:"bootstrap-constraints"`
+}
+
+// GoogleConfig configures Juju for use with Google Cloud.
+type GoogleConfig struct {
+	Enable               bool              `yaml:"enable"`
+	Bootstrap            bool              `yaml:"bootstrap"`
+	CredentialsFile      string            `yaml:"credentials-file"`
+	ModelDefaults        map[string]string `yaml:"model-defaults"`
+	BootstrapConstraints map[string]string `yaml:"bootstrap-constraints"`
+}
+
+// ImageRegistryConfig configures an image registry mirror.
+type ImageRegistryConfig struct {
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// MicroK8sConfig configures MicroK8s on the machine.
+type MicroK8sConfig struct {
+	Enable               bool                `yaml:"enable"`
+	Bootstrap            bool                `yaml:"bootstrap"`
+	Channel              string              `yaml:"channel"`
+	Addons               []string            `yaml:"addons"`
+	ImageRegistry        ImageRegistryConfig `yaml:"image-registry"`
+	ModelDefaults        map[string]string   `yaml:"model-defaults"`
+	BootstrapConstraints map[string]string   `yaml:"bootstrap-constraints"`
+}
+
+// K8sConfig configures Canonical Kubernetes on the machine.
+type K8sConfig struct {
+	Enable               bool                         `yaml:"enable"`
+	Bootstrap            bool                         `yaml:"bootstrap"`
+	Channel              string                       `yaml:"channel"`
+	Features             map[string]map[string]string `yaml:"features"`
+	ImageRegistry        ImageRegistryConfig          `yaml:"image-registry"`
+	ModelDefaults        map[string]string            `yaml:"model-defaults"`
+	BootstrapConstraints map[string]string            `yaml:"bootstrap-constraints"`
+}
+
+// HostConfig configures the packages installed on the machine.
+type HostConfig struct {
+	// Packages are apt packages to install from the archive.
+	Packages []string `yaml:"packages"`
+	// Snaps are the snaps to install, by name.
+	Snaps map[string]SnapConfig `yaml:"snaps"`
+}
+
+// SnapConfig configures a snap to install.
+type SnapConfig struct {
+	// Channel is the channel from which to install the snap. If omitted, the default
+	// behaviour is decided by snapd.
+	Channel string `yaml:"channel"`
+	// Connections are the snap connections to form.
+	Connections []string `yaml:"connections"`
+}
+
+// NotifyConfig configures who is notified when a run completes.
+type NotifyConfig struct {
+	// Webhooks are the HTTP endpoints to which the outcome of each run is posted.
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// WebhookConfig configures an HTTP endpoint to which the outcome of each run is posted
+// as JSON.
+type WebhookConfig struct {
+	// URL is the address to which the outcome is posted.
+	URL string `yaml:"url"`
+	// Headers are additional HTTP headers sent with each request, such as
+	// Authorization.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Timeout bounds each attempt to deliver the notification. If omitted, 10 seconds.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Retries is the number of times a failed delivery is retried. If omitted, 3.
+	Retries *int `yaml:"retries,omitempty"`
+}
+
+// internalConfig converts the configuration into concierge's own, which shares none of
+// its maps or slices.
+func (c *Config) internalConfig() *config.Config {
+	conf := &config.Config{}
+
+	conf.Juju.Disable = c.Juju.Disable
+	conf.Juju.Channel = c.Juju.Channel
+	conf.Juju.Revision = c.Juju.Revision
+	conf.Juju.AgentVersion = c.Juju.AgentVersion
+	conf.Juju.ModelDefaults = maps.Clone(c.Juju.ModelDefaults)
+	conf.Juju.BootstrapConstraints = maps.Clone(c.Juju.BootstrapConstraints)
+	conf.Juju.ExtraBootstrapArgs = c.Juju.ExtraBootstrapArgs
+
+	k8s := c.Providers.K8s
+	conf.Providers.K8s.Enable = k8s.Enable
+	conf.Providers.K8s.Bootstrap = k8s.Bootstrap
+	conf.Providers.K8s.Channel = k8s.Channel
+	if k8s.Features != nil {
+		conf.Providers.K8s.Features = map[string]map[string]string{}
+		for name, settings := range k8s.Features {
+			conf.Providers.K8s.Features[name] = maps.Clone(settings)
+		}
+	}
+	conf.Providers.K8s.ImageRegistry = config.ImageRegistryConfig(k8s.ImageRegistry)
+	conf.Providers.K8s.ModelDefaults = maps.Clone(k8s.ModelDefaults)
+	conf.Providers.K8s.BootstrapConstraints = maps.Clone(k8s.BootstrapConstraints)
+
+	lxd := c.Providers.LXD
+	conf.Providers.LXD.Enable = lxd.Enable
+	conf.Providers.LXD.Bootstrap = lxd.Bootstrap
+	conf.Providers.LXD.Channel = lxd.Channel
+	conf.Providers.LXD.ModelDefaults = maps.Clone(lxd.ModelDefaults)
+	conf.Providers.LXD.BootstrapConstraints = maps.Clone(lxd.BootstrapConstraints)
+
+	google := c.Providers.Google
+	conf.Providers.Google.Enable = google.Enable
+	conf.Providers.Google.Bootstrap = google.Bootstrap
+	conf.Providers.Google.CredentialsFile = google.CredentialsFile
+	conf.Providers.Google.ModelDefaults = maps.Clone(google.ModelDefaults)
+	conf.Providers.Google.BootstrapConstraints = maps.Clone(google.BootstrapConstraints)
+
+	microk8s := c.Providers.MicroK8s
+	conf.Providers.MicroK8s.Enable = microk8s.Enable
+	conf.Providers.MicroK8s.Bootstrap = microk8s.Bootstrap
+	conf.Providers.MicroK8s.Channel = microk8s.Channel
+	conf.Providers.MicroK8s.Addons = slices.Clone(microk8s.Addons)
+	conf.Providers.MicroK8s.ImageRegistry = config.ImageRegistryConfig(microk8s.ImageRegistry)
+	conf.Providers.MicroK8s.ModelDefaults = maps.Clone(microk8s.ModelDefaults)
+	conf.Providers.MicroK8s.BootstrapConstraints = maps.Clone(microk8s.BootstrapConstraints)
+
+	conf.Host.Packages = slices.Clone(c.Host.Packages)
+	if c.Host.Snaps != nil {
+		conf.Host.Snaps = map[string]config.SnapConfig{}
+		for name, snap := range c.Host.Snaps {
+			conf.Host.Snaps[name] = config.SnapConfig{Channel: snap.Channel, Connections: slices.Clone(snap.Connections)}
+		}
+	}
+
+	for _, w := range c.Notify.Webhooks {
+		webhook := config.WebhookConfig{URL: w.URL, Headers: maps.Clone(w.Headers), Timeout: w.Timeout}
+		if w.Retries != nil {
+			retries := *w.Retries
+			webhook.Retries = &retries
+		}
+		conf.Notify.Webhooks = append(conf.Notify.Webhooks, webhook)
+	}
+
+	return conf
+}
+
+// Result describes the outcome of a 'prepare' or 'restore', step by step. It is
+// encoded as JSON in the same format as the report written by '--report'.
+type Result struct {
+	// Action is the action performed; either PrepareAction or RestoreAction.
+	Action string `json:"action"`
+	// ConciergeVersion is the version of concierge that performed the run.
+	ConciergeVersion string `json:"concierge-version"`
+	// DryRun is set if the run only reported what would be done.
+	DryRun bool `json:"dry-run"`
+	// Started and Finished are the times at which the run started and finished.
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// DurationMS is how long the run took, in milliseconds.
+	DurationMS int64 `json:"duration-ms"`
+	// Status is one of StatusSucceeded or StatusFailed.
+	Status string `json:"status"`
+	// Error is the error that the run failed with, if any.
+	Error string `json:"error,omitempty"`
+	// Steps holds each step of the run, in the order they started.
+	Steps []*Step `json:"steps"`
+}
+
+// Step describes the outcome of a single step of a run, such as `snap:jhack`.
+type Step struct {
+	// ID is the stable identifier of the step, and Kind and Name its parts.
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Status is one of StatusSucceeded or StatusFailed, or StatusRunning if the run
+	// ended before the step did.
+	Status string `json:"status"`
+	// Started and Finished are the times at which the step started and finished.
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// DurationMS is how long the step took, in milliseconds.
+	DurationMS int64 `json:"duration-ms"`
+	// Retries is the number of times the step retried a failed command or request.
+	Retries int `json:"retries"`
+	// RetriesByCommand is the number of retries of each command or request retried.
+	RetriesByCommand map[string]int `json:"retries-by-command,omitempty"`
+	// Commands is the number of commands the step ran.
+	Commands int `json:"commands"`
+	// Error is the error that the step failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// newResult converts the report of a run into its public form.
+func newResult(r *report.Report) *Result {
+	if r == nil {
+		return nil
+	}
+
+	result := &Result{
+		Action:           r.Action,
+		ConciergeVersion: r.ConciergeVersion,
+		DryRun:           r.DryRun,
+		Started:          r.Started,
+		Finished:         r.Finished,
+		DurationMS:       r.DurationMS,
+		Status:           r.Status,
+		Error:            r.Error,
+		Steps:            []*Step{},
+	}
+	for _, s := range r.Steps {
+		step := &Step{
+			ID:               s.ID,
+			Kind:             s.Kind,
+			Name:             s.Name,
+			Status:           s.Status,
+			Started:          s.Started,
+			DurationMS:       s.DurationMS,
+			Retries:          s.Retries,
+			RetriesByCommand: maps.Clone(s.RetriesByCommand),
+			Commands:         s.Commands,
+			Error:            s.Error,
+		}
+		if s.Finished != nil {
+			finished := *s.Finished
+			step.Finished = &finished
+		}
+		result.Steps = append(result.Steps, step)
+	}
+	return result
+}
+
+// StatusReport describes the outcome of the most recent 'prepare' on the machine.
+type StatusReport struct {
+	// User is the name of the real user on whose behalf concierge ran.
+	User string `json:"user"`
+	// Status is one of StatusProvisioning, StatusSucceeded or StatusFailed.
+	Status           string `json:"status"`
+	ConciergeVersion string `json:"concierge-version,omitempty"`
+	// ConfigSource is where the configuration for the run came from.
+	ConfigSource string    `json:"config-source,omitempty"`
+	Started      time.Time `json:"started,omitzero"`
+	Finished     time.Time `json:"finished,omitzero"`
+	// Updated is the time at which the state of the run was last recorded.
+	Updated time.Time `json:"updated,omitzero"`
+	// Error is the error that the run failed with, if any.
+	Error string `json:"error,omitempty"`
+	// Components holds the state of each step of the run.
+	Components []*Component `json:"components"`
+}
+
+// Component describes the state of a step of a recorded run, such as `snap:jhack`.
+type Component struct {
+	// ID is the stable identifier of the step, and Kind and Name its parts.
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// State is one of StatusRunning, StatusSucceeded or StatusFailed.
+	State    string    `json:"state"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// newStatusReport converts a status report into its public form.
+func newStatusReport(r *concierge.StatusReport) *StatusReport {
+	return &StatusReport{
+		User:             r.User,
+		Status:           r.Status.String(),
+		ConciergeVersion: r.ConciergeVersion,
+		ConfigSource:     r.ConfigSource,
+		Started:          r.Started,
+		Finished:         r.Finished,
+		Updated:          r.Updated,
+		Error:            r.Error,
+		Components:       newComponents(r.Components),
+	}
+}
+
+// newComponents converts recorded steps into their public form.
+func newComponents(components []*state.Component) []*Component {
+	result := []*Component{}
+	for _, c := range components {
+		result = append(result, &Component{
+			ID:       c.ID,
+			Kind:     c.Kind,
+			Name:     c.Name,
+			State:    c.State,
+			Started:  c.Started,
+			Finished: c.Finished,
+			Error:    c.Error,
+		})
+	}
+	return result
+}
+
+// PlanDescription describes what 'prepare' would install and configure.
+type PlanDescription struct {
+	// Snaps holds every snap the plan installs, whether listed in the configuration or
+	// installed by a provider or by Juju.
+	Snaps []*PlannedSnap `json:"snaps"`
+	// Debs holds every deb the plan installs.
+	Debs []*PlannedDeb `json:"debs"`
+	// Providers holds each provider the plan prepares, in the order they are prepared.
+	Providers []*PlannedProvider `json:"providers"`
+	// Juju reports whether the plan installs Juju and bootstraps controllers.
+	Juju bool `json:"juju"`
+	// Environment describes what the plan provisions, once prepared.
+	Environment *Environment `json:"environment"`
+}
+
+// PlannedSnap describes a snap installed by the plan.
+type PlannedSnap struct {
+	Name     string `json:"name"`
+	Channel  string `json:"channel,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// Reasons holds the configuration values that caused the snap to be installed.
+	Reasons []string `json:"reasons"`
+}
+
+// PlannedDeb describes a deb installed by the plan.
+type PlannedDeb struct {
+	Name    string   `json:"name"`
+	Reasons []string `json:"reasons"`
+}
+
+// PlannedProvider describes a provider prepared by the plan.
+type PlannedProvider struct {
+	Name      string   `json:"name"`
+	Bootstrap bool     `json:"bootstrap"`
+	Reasons   []string `json:"reasons"`
+}
+
+// Environment describes what concierge provisions on the machine.
+type Environment struct {
+	// Controllers holds each Juju controller bootstrapped, in provider order.
+	Controllers []*Controller `json:"controllers"`
+	// Kubeconfig is the path of the kubeconfig file, if a Kubernetes provider is
+	// prepared.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// JujuData is the path of Juju's data directory, if Juju is installed.
+	JujuData string `json:"juju-data,omitempty"`
+	// Versions holds the version of each snap installed, by name.
+	Versions map[string]string `json:"versions"`
+}
+
+// Controller describes a Juju controller bootstrapped by concierge.
+type Controller struct {
+	// Provider is the name of the provider the controller is bootstrapped on.
+	Provider string `json:"provider"`
+	// Name is the name of the controller, such as "concierge-lxd".
+	Name string `json:"name"`
+	// Model is the qualified name of the model added for testing, such as
+	// "concierge-lxd:testing".
+	Model string `json:"model"`
+}
+
+// newPlanDescription converts a description of a plan into its public form.
+func newPlanDescription(d *concierge.PlanDescription) *PlanDescription {
+	description := &PlanDescription{
+		Snaps:     []*PlannedSnap{},
+		Debs:      []*PlannedDeb{},
+		Providers: []*PlannedProvider{},
+		Juju:      d.Juju,
+	}
+	for _, s := range d.Snaps {
+		description.Snaps = append(description.Snaps, &PlannedSnap{
+			Name: s.Name, Channel: s.Channel, Revision: s.Revision, Reasons: slices.Clone(s.Reasons),
+		})
+	}
+	for _, deb := range d.Debs {
+		description.Debs = append(description.Debs, &PlannedDeb{Name: deb.Name, Reasons: slices.Clone(deb.Reasons)})
+	}
+	for _, p := range d.Providers {
+		description.Providers = append(description.Providers, &PlannedProvider{
+			Name: p.Name, Bootstrap: p.Bootstrap, Reasons: slices.Clone(p.Reasons),
+		})
+	}
+
+	if env := d.Environment; env != nil {
+		description.Environment = &Environment{
+			Controllers: []*Controller{},
+			Kubeconfig:  env.Kubeconfig,
+			JujuData:    env.JujuData,
+			Versions:    maps.Clone(env.Versions),
+		}
+		for _, c := range env.Controllers {
+			description.Environment.Controllers = append(description.Environment.Controllers,
+				&Controller{Provider: c.Provider, Name: c.Name, Model: c.Model})
+		}
+	}
+	return description
+}
+
+// Run is the record of a 'prepare' or 'restore' in the machine's history.
+type Run struct {
+	// ID identifies the run within the history. IDs increase with each run.
+	ID int `json:"id"`
+	// Action is the action performed; either PrepareAction or RestoreAction.
+	Action string `json:"action"`
+	// User is the name of the real user on whose behalf concierge ran.
+	User string `json:"user"`
+	// RanAs is the name of the user that concierge itself ran as, usually root.
+	RanAs string `json:"ran-as"`
+	// ConciergeVersion is the version of concierge that performed the run.
+	ConciergeVersion string `json:"concierge-version,omitempty"`
+	// ConfigSource is where the configuration for the run came from.
+	ConfigSource string `json:"config-source,omitempty"`
+	// ConfigHash is a digest of the effective configuration, such that runs with the
+	// same configuration can be identified.
+	ConfigHash string `json:"config-hash,omitempty"`
+	// Started and Finished are the times at which the run started and finished.
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// Outcome is one of StatusSucceeded or StatusFailed.
+	Outcome string `json:"outcome"`
+	// Error is the error that the run failed with, if any.
+	Error string `json:"error,omitempty"`
+	// Steps records the state of each step of the run.
+	Steps []*Component `json:"steps"`
+}
+
+// newRuns converts the runs recorded in the machine's history into their public form.
+func newRuns(runs []*state.Run) []*Run {
+	result := []*Run{}
+	for _, r := range runs {
+		result = append(result, &Run{
+			ID:               r.ID,
+			Action:           r.Action,
+			User:             r.User,
+			RanAs:            r.RanAs,
+			ConciergeVersion: r.ConciergeVersion,
+			ConfigSource:     r.ConfigSource,
+			ConfigHash:       r.ConfigHash,
+			Started:          r.Started,
+			Finished:         r.Finished,
+			Outcome:          r.Outcome,
+			Error:            r.Error,
+			Steps:            newComponents(r.Steps),
+		})
+	}
+	return result
+}